@@ -3,6 +3,7 @@ package config
 import (
 	"doppler/iprange"
 	"errors"
+	"prometheus_exporter"
 	"time"
 
 	"github.com/cloudfoundry/gosteno"
@@ -17,11 +18,13 @@ type Config struct {
 	EtcdMaxConcurrentRequests     int
 	Index                         uint
 	DropsondeIncomingMessagesPort uint32
+	DropsondeIncomingTcpPort      uint32
 	OutgoingPort                  uint32
 	LogFilePath                   string
 	MaxRetainedLogMessages        uint32
 	WSMessageBufferSize           uint
 	SharedSecret                  string
+	AdditionalSharedSecrets       []string
 	SkipCertVerify                bool
 	BlackListIps                  []iprange.IPRange
 	JobName                       string
@@ -29,6 +32,44 @@ type Config struct {
 	ContainerMetricTTLSeconds     int
 	SinkInactivityTimeoutSeconds  int
 	UnmarshallerCount             int
+	PrometheusMetricsPort         uint32
+	PrometheusMetricsHost         string
+	RecentLogsStoreDirectory      string
+	RecentLogsStoreMaxBytesPerApp int64
+	RecentLogsStoreRetentionHours int
+
+	HttpsDrainBatchMaxBytes                  int
+	HttpsDrainBatchMaxMessages               int
+	HttpsDrainBatchFlushIntervalMilliseconds int
+	HttpsDrainGzip                           bool
+
+	DrainCACertFile     string
+	DrainClientCertFile string
+	DrainClientKeyFile  string
+
+	DropsondeTLSCertFile   string
+	DropsondeTLSKeyFile    string
+	DropsondeTLSCACertFile string
+
+	SyslogRetryQueueDirectory        string
+	SyslogRetryQueueMaxBytesPerDrain int64
+	SyslogRetryQueueMaxAgeMinutes    int
+
+	AppRateLimitMessagesPerSecond     int
+	AppRateLimitBurst                 int
+	AppRateLimitNoticeIntervalSeconds int
+}
+
+// SharedSecrets returns the secrets messages may be signed with. Metron signs
+// with SharedSecret, the additional secrets are only accepted to rotate it.
+func (c *Config) SharedSecrets() []string {
+	secrets := []string{c.SharedSecret}
+	for _, secret := range c.AdditionalSharedSecrets {
+		if secret != "" && secret != c.SharedSecret {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
 }
 
 func (c *Config) Validate(logger *gosteno.Logger) (err error) {
@@ -47,6 +88,52 @@ func (c *Config) Validate(logger *gosteno.Logger) (err error) {
 		c.UnmarshallerCount = 1
 	}
 
+	if (c.DrainClientCertFile == "") != (c.DrainClientKeyFile == "") {
+		return errors.New("Need both DrainClientCertFile and DrainClientKeyFile for drain client certificates")
+	}
+
+	if (c.DropsondeTLSCertFile == "") != (c.DropsondeTLSKeyFile == "") {
+		return errors.New("Need both DropsondeTLSCertFile and DropsondeTLSKeyFile for the TLS listener")
+	}
+
+	if c.PrometheusMetricsHost == "" {
+		c.PrometheusMetricsHost = prometheus_exporter.DefaultHost
+	}
+
+	if c.RecentLogsStoreDirectory != "" {
+		if c.RecentLogsStoreMaxBytesPerApp == 0 {
+			c.RecentLogsStoreMaxBytesPerApp = 10 * 1024 * 1024
+		}
+
+		if c.RecentLogsStoreRetentionHours == 0 {
+			c.RecentLogsStoreRetentionHours = 24
+		}
+	}
+
+	if c.SyslogRetryQueueDirectory != "" {
+		if c.SyslogRetryQueueMaxBytesPerDrain == 0 {
+			c.SyslogRetryQueueMaxBytesPerDrain = 50 * 1024 * 1024
+		}
+
+		if c.SyslogRetryQueueMaxAgeMinutes == 0 {
+			c.SyslogRetryQueueMaxAgeMinutes = 60
+		}
+	}
+
+	if c.AppRateLimitMessagesPerSecond > 0 {
+		if c.AppRateLimitBurst == 0 {
+			c.AppRateLimitBurst = c.AppRateLimitMessagesPerSecond
+		}
+
+		if c.AppRateLimitNoticeIntervalSeconds == 0 {
+			c.AppRateLimitNoticeIntervalSeconds = 10
+		}
+
+		if c.AppRateLimitNoticeIntervalSeconds < 0 {
+			return errors.New("Need a positive AppRateLimitNoticeIntervalSeconds")
+		}
+	}
+
 	err = c.Config.Validate(logger)
 	return
 }
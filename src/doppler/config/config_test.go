@@ -0,0 +1,53 @@
+package config_test
+
+import (
+	"doppler/config"
+
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	var conf *config.Config
+
+	BeforeEach(func() {
+		conf = &config.Config{}
+		err := cfcomponent.ReadConfigInto(conf, "../test_assets/minimal_doppler.json")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Describe("Validate", func() {
+		It("accepts the minimal config", func() {
+			Expect(conf.Validate(loggertesthelper.Logger())).To(Succeed())
+		})
+
+		Context("with an app rate limit", func() {
+			BeforeEach(func() {
+				conf.AppRateLimitMessagesPerSecond = 100
+			})
+
+			It("defaults the burst and the notice interval", func() {
+				Expect(conf.Validate(loggertesthelper.Logger())).To(Succeed())
+
+				Expect(conf.AppRateLimitBurst).To(Equal(100))
+				Expect(conf.AppRateLimitNoticeIntervalSeconds).To(Equal(10))
+			})
+
+			It("rejects a negative notice interval", func() {
+				conf.AppRateLimitNoticeIntervalSeconds = -1
+
+				err := conf.Validate(loggertesthelper.Logger())
+				Expect(err).To(MatchError("Need a positive AppRateLimitNoticeIntervalSeconds"))
+			})
+		})
+
+		It("ignores the notice interval without an app rate limit", func() {
+			conf.AppRateLimitNoticeIntervalSeconds = -1
+
+			Expect(conf.Validate(loggertesthelper.Logger())).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,488 @@
+package diskqueue
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/events"
+	"github.com/gogo/protobuf/proto"
+)
+
+const (
+	segmentsPerQueue = 4
+	segmentExtension = ".seg"
+	cursorFileName   = "cursor"
+)
+
+var ErrClosed = errors.New("disk queue is closed")
+
+var errDamagedRecord = errors.New("record length exceeds its segment")
+
+// Queue is a FIFO of envelopes kept in segment files on disk. Every record is
+// a 4 byte big endian length followed by a marshalled envelope. The position
+// of the reader is persisted on Close, so a queue opened again on the same
+// directory continues where it stopped. Whole segments are dropped from the
+// head once the queue exceeds maxBytes, and records older than maxAge are
+// skipped when read.
+type Queue struct {
+	dir         string
+	maxBytes    int64
+	segmentSize int64
+	maxAge      time.Duration
+	metrics     *drainMetrics
+	onClose     func(*Queue)
+
+	segments   []*segment
+	nextId     uint64
+	readOffset int64
+	length     int
+	dropped    int64
+
+	writer *os.File
+	reader *os.File
+
+	peeked     *events.Envelope
+	peekedSize int64
+
+	removeOnClose bool
+	closed        bool
+	lock          sync.Mutex
+}
+
+type segment struct {
+	id        uint64
+	path      string
+	size      int64
+	remaining int
+}
+
+func open(dir string, maxBytes int64, maxAge time.Duration, metrics *drainMetrics) (*Queue, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	segmentSize := maxBytes / segmentsPerQueue
+	if segmentSize == 0 {
+		segmentSize = 1
+	}
+
+	q := &Queue{
+		dir:         dir,
+		maxBytes:    maxBytes,
+		segmentSize: segmentSize,
+		maxAge:      maxAge,
+		metrics:     metrics,
+	}
+
+	err = q.load()
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.queued(int64(q.length))
+	return q, nil
+}
+
+func (q *Queue) Push(envelope *events.Envelope) error {
+	data, err := proto.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		return ErrClosed
+	}
+
+	// segments loaded from disk are never appended to, they might end in a
+	// partially written record
+	last := q.last()
+	if last == nil || last.size >= q.segmentSize || q.writer == nil {
+		last, err = q.newSegment()
+		if err != nil {
+			return err
+		}
+	}
+
+	record := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(record, uint32(len(data)))
+	copy(record[4:], data)
+
+	n, err := q.writer.Write(record)
+	last.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	last.remaining++
+	q.length++
+	q.metrics.queued(1)
+	q.metrics.spilled(int64(n))
+
+	for len(q.segments) > 1 && q.size() > q.maxBytes {
+		q.dropHead()
+	}
+	return nil
+}
+
+// Peek returns the oldest envelope without removing it, or nil when the queue
+// is empty. It returns the same envelope until Pop is called.
+func (q *Queue) Peek() (*events.Envelope, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		return nil, ErrClosed
+	}
+
+	for q.peeked == nil {
+		if q.length == 0 {
+			return nil, nil
+		}
+
+		head := q.segments[0]
+		if head.remaining == 0 {
+			q.removeHead()
+			continue
+		}
+
+		envelope, size, err := q.readRecord(head)
+		if err != nil {
+			// the rest of a damaged segment can not be framed anymore
+			q.dropHead()
+			continue
+		}
+
+		if envelope == nil || q.expired(envelope) {
+			q.advance(size)
+			q.dropped++
+			q.metrics.dropped(1)
+			continue
+		}
+
+		q.peeked = envelope
+		q.peekedSize = size
+	}
+
+	return q.peeked, nil
+}
+
+// Pop removes the envelope returned by the last call to Peek.
+func (q *Queue) Pop() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed || q.peeked == nil {
+		return
+	}
+
+	q.advance(q.peekedSize)
+	q.peeked = nil
+}
+
+func (q *Queue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.length
+}
+
+// DroppedMessageCount returns the number of messages dropped since the last
+// call, either because the queue was full or because they were too old.
+func (q *Queue) DroppedMessageCount() int64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	dropped := q.dropped
+	q.dropped = 0
+	return dropped
+}
+
+func (q *Queue) Close() error {
+	err := q.close()
+	if q.onClose != nil {
+		q.onClose(q)
+	}
+	return err
+}
+
+func (q *Queue) close() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+
+	if q.writer != nil {
+		q.writer.Close()
+	}
+	if q.reader != nil {
+		q.reader.Close()
+	}
+
+	q.metrics.queued(-int64(q.length))
+
+	if q.removeOnClose {
+		return os.RemoveAll(q.dir)
+	}
+
+	cursorPath := filepath.Join(q.dir, cursorFileName)
+	if len(q.segments) == 0 {
+		err := os.Remove(cursorPath)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	cursor := fmt.Sprintf("%d %d", q.segments[0].id, q.readOffset)
+	return ioutil.WriteFile(cursorPath, []byte(cursor), 0644)
+}
+
+// markForRemoval makes Close delete the queue instead of persisting it. It
+// returns false when the queue is already closed.
+func (q *Queue) markForRemoval() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		return false
+	}
+	q.removeOnClose = true
+	return true
+}
+
+func (q *Queue) load() error {
+	entries, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+
+	var cursorId uint64
+	var cursorOffset int64
+	cursor, err := ioutil.ReadFile(filepath.Join(q.dir, cursorFileName))
+	if err == nil {
+		fmt.Sscanf(string(cursor), "%d %d", &cursorId, &cursorOffset)
+	}
+
+	cutoff := time.Now().Add(-q.maxAge)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExtension) {
+			continue
+		}
+
+		var id uint64
+		_, err := fmt.Sscanf(strings.TrimSuffix(name, segmentExtension), "%d", &id)
+		if err != nil {
+			continue
+		}
+
+		if id >= q.nextId {
+			q.nextId = id + 1
+		}
+
+		path := filepath.Join(q.dir, name)
+		if id < cursorId || (q.maxAge > 0 && entry.ModTime().Before(cutoff)) {
+			os.Remove(path)
+			continue
+		}
+
+		q.segments = append(q.segments, &segment{id: id, path: path, size: entry.Size()})
+	}
+
+	sort.Sort(byId(q.segments))
+
+	if len(q.segments) > 0 && q.segments[0].id == cursorId {
+		q.readOffset = cursorOffset
+	}
+
+	for i, seg := range q.segments {
+		offset := int64(0)
+		if i == 0 {
+			offset = q.readOffset
+		}
+
+		seg.remaining, seg.size, err = countRecords(seg.path, offset)
+		if err != nil {
+			return err
+		}
+		q.length += seg.remaining
+	}
+
+	return nil
+}
+
+func (q *Queue) last() *segment {
+	if len(q.segments) == 0 {
+		return nil
+	}
+	return q.segments[len(q.segments)-1]
+}
+
+func (q *Queue) newSegment() (*segment, error) {
+	if q.writer != nil {
+		q.writer.Close()
+		q.writer = nil
+	}
+
+	seg := &segment{
+		id:   q.nextId,
+		path: filepath.Join(q.dir, fmt.Sprintf("%020d%s", q.nextId, segmentExtension)),
+	}
+
+	writer, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	q.writer = writer
+	q.nextId++
+	q.segments = append(q.segments, seg)
+	return seg, nil
+}
+
+func (q *Queue) size() int64 {
+	var total int64
+	for _, seg := range q.segments {
+		total += seg.size
+	}
+	return total - q.readOffset
+}
+
+func (q *Queue) readRecord(head *segment) (*events.Envelope, int64, error) {
+	if q.reader == nil {
+		reader, err := os.Open(head.path)
+		if err != nil {
+			return nil, 0, err
+		}
+		q.reader = reader
+	}
+
+	header := make([]byte, 4)
+	_, err := q.reader.ReadAt(header, q.readOffset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// the length of a damaged record must not make us allocate more than is
+	// left in the segment
+	length := int64(binary.BigEndian.Uint32(header))
+	if length > head.size-q.readOffset-4 {
+		return nil, 0, errDamagedRecord
+	}
+
+	data := make([]byte, length)
+	_, err = q.reader.ReadAt(data, q.readOffset+4)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	envelope := &events.Envelope{}
+	if proto.Unmarshal(data, envelope) != nil {
+		return nil, int64(4 + len(data)), nil
+	}
+	return envelope, int64(4 + len(data)), nil
+}
+
+func (q *Queue) expired(envelope *events.Envelope) bool {
+	return q.maxAge > 0 && envelope.GetTimestamp() < time.Now().Add(-q.maxAge).UnixNano()
+}
+
+func (q *Queue) advance(size int64) {
+	head := q.segments[0]
+	q.readOffset += size
+	head.remaining--
+	q.length--
+	q.metrics.queued(-1)
+
+	if head.remaining == 0 && len(q.segments) > 1 {
+		q.removeHead()
+	}
+}
+
+func (q *Queue) dropHead() {
+	head := q.segments[0]
+	q.length -= head.remaining
+	q.dropped += int64(head.remaining)
+	q.metrics.queued(-int64(head.remaining))
+	q.metrics.dropped(int64(head.remaining))
+	head.remaining = 0
+
+	if len(q.segments) == 1 {
+		// the damaged segment is the one being written, start a new one
+		q.newSegment()
+	}
+	q.removeHead()
+}
+
+func (q *Queue) removeHead() {
+	if len(q.segments) == 1 {
+		return
+	}
+
+	head := q.segments[0]
+	q.segments = q.segments[1:]
+	q.readOffset = 0
+	q.peeked = nil
+
+	if q.reader != nil {
+		q.reader.Close()
+		q.reader = nil
+	}
+	os.Remove(head.path)
+}
+
+func countRecords(path string, offset int64) (int, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	count := 0
+	header := make([]byte, 4)
+	for {
+		_, err := file.ReadAt(header, offset)
+		if err == io.EOF {
+			// a partially written record at the end of a segment is ignored
+			return count, offset, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+
+		next := offset + 4 + int64(binary.BigEndian.Uint32(header))
+		if next > info.Size() {
+			return count, offset, nil
+		}
+
+		count++
+		offset = next
+	}
+}
+
+type byId []*segment
+
+func (s byId) Len() int           { return len(s) }
+func (s byId) Less(i, j int) bool { return s[i].id < s[j].id }
+func (s byId) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,13 @@
+package diskqueue_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestDiskqueue(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "DiskQueue Suite")
+}
@@ -0,0 +1,131 @@
+package diskqueue
+
+import (
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+)
+
+// Metrics emits the totals of all queues, followed by the metrics of every
+// open queue tagged with its app and drain url.
+type Metrics struct {
+	lock   sync.Mutex
+	drains map[*drainMetrics]struct{}
+
+	// counters of queues that have been closed, so the totals don't drop
+	retired drainMetrics
+}
+
+type drainMetrics struct {
+	appId    string
+	drainUrl string
+
+	queuedMessages  int64
+	spilledBytes    int64
+	droppedMessages int64
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{drains: make(map[*drainMetrics]struct{})}
+}
+
+func (m *Metrics) Emit() instrumentation.Context {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	drains := make([]*drainMetrics, 0, len(m.drains))
+	queued := atomic.LoadInt64(&m.retired.queuedMessages)
+	spilled := atomic.LoadInt64(&m.retired.spilledBytes)
+	dropped := atomic.LoadInt64(&m.retired.droppedMessages)
+	for d := range m.drains {
+		drains = append(drains, d)
+		queued += atomic.LoadInt64(&d.queuedMessages)
+		spilled += atomic.LoadInt64(&d.spilledBytes)
+		dropped += atomic.LoadInt64(&d.droppedMessages)
+	}
+	sort.Sort(byDrain(drains))
+
+	data := []instrumentation.Metric{
+		instrumentation.Metric{Name: "queuedMessages", Value: queued},
+		instrumentation.Metric{Name: "spilledBytes", Value: spilled},
+		instrumentation.Metric{Name: "droppedMessages", Value: dropped},
+	}
+
+	for _, d := range drains {
+		tags := map[string]interface{}{"appId": d.appId, "drainUrl": redactDrainUrl(d.drainUrl)}
+		data = append(data,
+			instrumentation.Metric{Name: "drainQueuedMessages", Value: atomic.LoadInt64(&d.queuedMessages), Tags: tags},
+			instrumentation.Metric{Name: "drainSpilledBytes", Value: atomic.LoadInt64(&d.spilledBytes), Tags: tags},
+			instrumentation.Metric{Name: "drainDroppedMessages", Value: atomic.LoadInt64(&d.droppedMessages), Tags: tags},
+		)
+	}
+
+	return instrumentation.Context{
+		Name:    "syslogRetryQueue",
+		Metrics: data,
+	}
+}
+
+func (m *Metrics) register(appId string, drainUrl string) *drainMetrics {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	d := &drainMetrics{appId: appId, drainUrl: drainUrl}
+	m.drains[d] = struct{}{}
+	return d
+}
+
+func (m *Metrics) unregister(d *drainMetrics) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.drains[d]; !ok {
+		return
+	}
+	delete(m.drains, d)
+
+	m.retired.queued(atomic.LoadInt64(&d.queuedMessages))
+	m.retired.spilled(atomic.LoadInt64(&d.spilledBytes))
+	m.retired.dropped(atomic.LoadInt64(&d.droppedMessages))
+}
+
+func (d *drainMetrics) queued(delta int64) {
+	atomic.AddInt64(&d.queuedMessages, delta)
+}
+
+func (d *drainMetrics) spilled(bytes int64) {
+	atomic.AddInt64(&d.spilledBytes, bytes)
+}
+
+func (d *drainMetrics) dropped(count int64) {
+	atomic.AddInt64(&d.droppedMessages, count)
+}
+
+// redactDrainUrl leaves out the credentials and query of a drain url, which
+// often hold tokens.
+func redactDrainUrl(drainUrl string) string {
+	parsed, err := url.Parse(drainUrl)
+	if err != nil {
+		return ""
+	}
+
+	parsed.User = nil
+	parsed.RawQuery = ""
+	parsed.Fragment = ""
+	return parsed.String()
+}
+
+type byDrain []*drainMetrics
+
+func (d byDrain) Len() int      { return len(d) }
+func (d byDrain) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+
+func (d byDrain) Less(i, j int) bool {
+	if d[i].appId != d[j].appId {
+		return d[i].appId < d[j].appId
+	}
+	return d[i].drainUrl < d[j].drainUrl
+}
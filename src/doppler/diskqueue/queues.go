@@ -0,0 +1,110 @@
+package diskqueue
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Queues hands out one Queue per syslog drain below a common directory.
+type Queues struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	metrics  *Metrics
+
+	open map[string]*Queue
+	lock sync.Mutex
+}
+
+func NewQueues(dir string, maxBytesPerQueue int64, maxAge time.Duration) (*Queues, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Queues{
+		dir:      dir,
+		maxBytes: maxBytesPerQueue,
+		maxAge:   maxAge,
+		metrics:  newMetrics(),
+		open:     make(map[string]*Queue),
+	}, nil
+}
+
+// Open returns the queue of the drain, including anything left in it by a
+// previous run.
+func (qs *Queues) Open(appId string, drainUrl string) (*Queue, error) {
+	qs.lock.Lock()
+	defer qs.lock.Unlock()
+
+	dir := qs.queueDir(appId, drainUrl)
+	if _, ok := qs.open[dir]; ok {
+		return nil, fmt.Errorf("queue for drain %s of app %s is still in use", drainUrl, appId)
+	}
+
+	metrics := qs.metrics.register(appId, drainUrl)
+	q, err := open(dir, qs.maxBytes, qs.maxAge, metrics)
+	if err != nil {
+		qs.metrics.unregister(metrics)
+		return nil, err
+	}
+
+	q.onClose = qs.closed
+	qs.open[dir] = q
+	return q, nil
+}
+
+// Remove deletes the queue of a drain that is no longer bound. A queue that
+// is still in use is deleted once it is closed.
+func (qs *Queues) Remove(appId string, drainUrl string) error {
+	qs.lock.Lock()
+	defer qs.lock.Unlock()
+
+	dir := qs.queueDir(appId, drainUrl)
+	if q, ok := qs.open[dir]; ok && q.markForRemoval() {
+		return nil
+	}
+
+	delete(qs.open, dir)
+	return os.RemoveAll(dir)
+}
+
+// Close closes every open queue, persisting how far each was read so that a
+// restart does not deliver the same messages again.
+func (qs *Queues) Close() {
+	qs.lock.Lock()
+	open := make([]*Queue, 0, len(qs.open))
+	for _, q := range qs.open {
+		open = append(open, q)
+	}
+	qs.lock.Unlock()
+
+	// Close calls back into qs.closed, which takes qs.lock
+	for _, q := range open {
+		q.Close()
+	}
+}
+
+func (qs *Queues) Metrics() *Metrics {
+	return qs.metrics
+}
+
+func (qs *Queues) closed(q *Queue) {
+	qs.lock.Lock()
+	defer qs.lock.Unlock()
+
+	qs.metrics.unregister(q.metrics)
+
+	if qs.open[q.dir] == q {
+		delete(qs.open, q.dir)
+	}
+}
+
+func (qs *Queues) queueDir(appId string, drainUrl string) string {
+	return filepath.Join(qs.dir, url.QueryEscape(appId), fmt.Sprintf("%x", sha1.Sum([]byte(drainUrl))))
+}
@@ -1,18 +1,26 @@
 package main
 
 import (
+	"crypto/tls"
 	"doppler/config"
+	"doppler/diskqueue"
+	"doppler/logstore"
+	"doppler/signatureverifier"
+	"doppler/sinks/dump"
+	"doppler/sinks/syslogwriter"
 	"doppler/sinkserver"
 	"doppler/sinkserver/blacklist"
+	"doppler/sinkserver/ratelimiter"
 	"doppler/sinkserver/sinkmanager"
 	"doppler/sinkserver/websocketserver"
+	"doppler/tcplistener"
+	"dropsonde_batch"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/cloudfoundry/dropsonde/dropsonde_unmarshaller"
 	"github.com/cloudfoundry/dropsonde/events"
-	"github.com/cloudfoundry/dropsonde/signature"
 	"github.com/cloudfoundry/gosteno"
 	"github.com/cloudfoundry/loggregatorlib/agentlistener"
 	"github.com/cloudfoundry/loggregatorlib/appservice"
@@ -29,16 +37,22 @@ type Doppler struct {
 
 	errChan           chan error
 	dropsondeListener agentlistener.AgentListener
+	tcpListener       *tcplistener.TcpListener
 	sinkManager       *sinkmanager.SinkManager
 	messageRouter     *sinkserver.MessageRouter
 	websocketServer   *websocketserver.WebsocketServer
+	recentLogStore    *logstore.LogStore
+	retryQueues       *diskqueue.Queues
+	rateLimiter       *ratelimiter.RateLimiter
 
 	dropsondeUnmarshallerCollection dropsonde_unmarshaller.DropsondeUnmarshallerCollection
 	dropsondeBytesChan              <-chan []byte
 	dropsondeVerifiedBytesChan      chan []byte
+	dropsondeUnpackedBytesChan      chan []byte
+	batchUnpacker                   *dropsonde_batch.Unpacker
 	envelopeChan                    chan *events.Envelope
 	wrappedEnvelopeChan             chan *events.Envelope
-	signatureVerifier               signature.SignatureVerifier
+	signatureVerifier               *signatureverifier.SignatureVerifier
 
 	storeAdapter storeadapter.StoreAdapter
 
@@ -56,21 +70,96 @@ func New(host string, config *config.Config, logger *gosteno.Logger, storeAdapte
 
 	dropsondeListener, dropsondeBytesChan := agentlistener.NewAgentListener(fmt.Sprintf("%s:%d", host, config.DropsondeIncomingMessagesPort), logger, "dropsondeListener")
 
-	signatureVerifier := signature.NewSignatureVerifier(logger, config.SharedSecret)
+	var tcpListener *tcplistener.TcpListener
+	if config.DropsondeIncomingTcpPort != 0 {
+		var tlsConfig *tls.Config
+		if config.DropsondeTLSCertFile != "" {
+			var err error
+			tlsConfig, err = tcplistener.NewTLSConfig(config.DropsondeTLSCertFile, config.DropsondeTLSKeyFile, config.DropsondeTLSCACertFile)
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		var tcpBytesChan <-chan []byte
+		tcpListener, tcpBytesChan = tcplistener.New(fmt.Sprintf("%s:%d", host, config.DropsondeIncomingTcpPort), tlsConfig, logger)
+		dropsondeBytesChan = mergeBytes(dropsondeBytesChan, tcpBytesChan)
+	}
+
+	signatureVerifier := signatureverifier.New(logger, config.SharedSecrets())
 
 	unmarshallerCollection := dropsonde_unmarshaller.NewDropsondeUnmarshallerCollection(logger, config.UnmarshallerCount)
 
 	blacklist := blacklist.New(config.BlackListIps)
 	metricTTL := time.Duration(config.ContainerMetricTTLSeconds) * time.Second
 	sinkTimeout := time.Duration(config.SinkInactivityTimeoutSeconds) * time.Second
-	sinkManager := sinkmanager.New(config.MaxRetainedLogMessages, config.SkipCertVerify, blacklist, logger, dropsondeOrigin, sinkTimeout, metricTTL)
+
+	var recentLogStore *logstore.LogStore
+	var sinkStore dump.Store
+	if config.RecentLogsStoreDirectory != "" {
+		var err error
+		retention := time.Duration(config.RecentLogsStoreRetentionHours) * time.Hour
+		recentLogStore, err = logstore.New(config.RecentLogsStoreDirectory, config.RecentLogsStoreMaxBytesPerApp, retention, logger)
+		if err != nil {
+			panic(err)
+		}
+		sinkStore = recentLogStore
+	}
+
+	writerOptions := syslogwriter.WriterOptions{
+		SkipCertVerify: config.SkipCertVerify,
+		HttpsBatch: syslogwriter.HttpsBatchOptions{
+			MaxBytes:      config.HttpsDrainBatchMaxBytes,
+			MaxMessages:   config.HttpsDrainBatchMaxMessages,
+			FlushInterval: time.Duration(config.HttpsDrainBatchFlushIntervalMilliseconds) * time.Millisecond,
+			Gzip:          config.HttpsDrainGzip,
+		},
+	}
+
+	if config.DrainCACertFile != "" {
+		var err error
+		writerOptions.RootCAs, err = syslogwriter.LoadCertPool(config.DrainCACertFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if config.DrainClientCertFile != "" {
+		clientCert, err := tls.LoadX509KeyPair(config.DrainClientCertFile, config.DrainClientKeyFile)
+		if err != nil {
+			panic(err)
+		}
+		writerOptions.ClientCertificates = []tls.Certificate{clientCert}
+	}
+
+	var retryQueues *diskqueue.Queues
+	if config.SyslogRetryQueueDirectory != "" {
+		var err error
+		maxAge := time.Duration(config.SyslogRetryQueueMaxAgeMinutes) * time.Minute
+		retryQueues, err = diskqueue.NewQueues(config.SyslogRetryQueueDirectory, config.SyslogRetryQueueMaxBytesPerDrain, maxAge)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	var rateLimiter *ratelimiter.RateLimiter
+	if config.AppRateLimitMessagesPerSecond > 0 {
+		noticeInterval := time.Duration(config.AppRateLimitNoticeIntervalSeconds) * time.Second
+		rateLimiter = ratelimiter.New(config.AppRateLimitMessagesPerSecond, config.AppRateLimitBurst, noticeInterval)
+	}
+
+	sinkManager := sinkmanager.New(config.MaxRetainedLogMessages, writerOptions, blacklist, logger, dropsondeOrigin, sinkTimeout, metricTTL, sinkStore, retryQueues)
 
 	return &Doppler{
 		Logger:                          logger,
 		dropsondeListener:               dropsondeListener,
+		tcpListener:                     tcpListener,
 		sinkManager:                     sinkManager,
-		messageRouter:                   sinkserver.NewMessageRouter(sinkManager, logger),
+		messageRouter:                   sinkserver.NewMessageRouter(sinkManager, rateLimiter, dropsondeOrigin, logger),
+		rateLimiter:                     rateLimiter,
 		websocketServer:                 websocketserver.New(fmt.Sprintf("%s:%d", host, config.OutgoingPort), sinkManager, keepAliveInterval, config.WSMessageBufferSize, dropsondeOrigin, logger),
+		recentLogStore:                  recentLogStore,
+		retryQueues:                     retryQueues,
 		newAppServiceChan:               newAppServiceChan,
 		deletedAppServiceChan:           deletedAppServiceChan,
 		appStoreWatcher:                 appStoreWatcher,
@@ -81,6 +170,8 @@ func New(host string, config *config.Config, logger *gosteno.Logger, storeAdapte
 		wrappedEnvelopeChan:             make(chan *events.Envelope),
 		signatureVerifier:               signatureVerifier,
 		dropsondeVerifiedBytesChan:      make(chan []byte),
+		dropsondeUnpackedBytesChan:      make(chan []byte),
+		batchUnpacker:                   dropsonde_batch.NewUnpacker(logger),
 	}
 }
 
@@ -89,7 +180,7 @@ func (doppler *Doppler) Start() {
 	doppler.errChan = make(chan error)
 	doppler.Unlock()
 
-	doppler.Add(6 + doppler.dropsondeUnmarshallerCollection.Size())
+	doppler.Add(7 + doppler.dropsondeUnmarshallerCollection.Size())
 
 	go func() {
 		defer doppler.Done()
@@ -101,7 +192,13 @@ func (doppler *Doppler) Start() {
 		doppler.dropsondeListener.Start()
 	}()
 
-	doppler.dropsondeUnmarshallerCollection.Run(doppler.dropsondeVerifiedBytesChan, doppler.envelopeChan, &doppler.WaitGroup)
+	doppler.dropsondeUnmarshallerCollection.Run(doppler.dropsondeUnpackedBytesChan, doppler.envelopeChan, &doppler.WaitGroup)
+
+	go func() {
+		defer doppler.Done()
+		defer close(doppler.dropsondeUnpackedBytesChan)
+		doppler.batchUnpacker.Run(doppler.dropsondeVerifiedBytesChan, doppler.dropsondeUnpackedBytesChan)
+	}()
 
 	go func() {
 		defer doppler.Done()
@@ -125,6 +222,25 @@ func (doppler *Doppler) Start() {
 		doppler.websocketServer.Start()
 	}()
 
+	if doppler.tcpListener != nil {
+		doppler.Add(1)
+		go func() {
+			defer doppler.Done()
+			err := doppler.tcpListener.Start()
+			if err != nil {
+				doppler.Errorf("Failed to start the TCP listener: %s", err)
+			}
+		}()
+	}
+
+	if doppler.recentLogStore != nil {
+		doppler.Add(1)
+		go func() {
+			defer doppler.Done()
+			doppler.recentLogStore.Run()
+		}()
+	}
+
 	for err := range doppler.errChan {
 		doppler.Errorf("Got error %s", err)
 	}
@@ -134,9 +250,18 @@ func (l *Doppler) Stop() {
 	l.Lock()
 	defer l.Unlock()
 	l.dropsondeListener.Stop()
+	if l.tcpListener != nil {
+		l.tcpListener.Stop()
+	}
 	l.sinkManager.Stop()
+	if l.retryQueues != nil {
+		l.retryQueues.Close()
+	}
 	l.messageRouter.Stop()
 	l.websocketServer.Stop()
+	if l.recentLogStore != nil {
+		l.recentLogStore.Stop()
+	}
 	l.storeAdapter.Disconnect()
 
 	l.Wait()
@@ -144,11 +269,49 @@ func (l *Doppler) Stop() {
 }
 
 func (l *Doppler) Emitters() []instrumentation.Instrumentable {
-	return []instrumentation.Instrumentable{
+	emitters := []instrumentation.Instrumentable{
 		l.dropsondeListener,
 		l.messageRouter,
 		l.sinkManager,
 		l.dropsondeUnmarshallerCollection,
 		l.signatureVerifier,
+		l.batchUnpacker,
+	}
+
+	if l.tcpListener != nil {
+		emitters = append(emitters, l.tcpListener)
+	}
+
+	if l.retryQueues != nil {
+		emitters = append(emitters, l.retryQueues.Metrics())
 	}
+
+	if l.rateLimiter != nil {
+		emitters = append(emitters, l.rateLimiter)
+	}
+	return emitters
+}
+
+// mergeBytes forwards the messages of both listeners to the signature
+// verifier and closes the returned channel once both are stopped.
+func mergeBytes(sources ...<-chan []byte) <-chan []byte {
+	merged := make(chan []byte)
+
+	var wg sync.WaitGroup
+	wg.Add(len(sources))
+	for _, source := range sources {
+		go func(source <-chan []byte) {
+			defer wg.Done()
+			for message := range source {
+				merged <- message
+			}
+		}(source)
+	}
+
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+
+	return merged
 }
@@ -36,7 +36,7 @@ var _ = Describe("GroupedSink", func() {
 
 				groupedSinks.CloseAndDeleteFirehose(firehoseSink)
 
-				appSink := syslog.NewSyslogSink("123", "url", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64))
+				appSink := syslog.NewSyslogSink("123", "url", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64), nil)
 				appSinkInputChan := make(chan *events.Envelope)
 				groupedSinks.RegisterAppSink(appSinkInputChan, appSink)
 
@@ -49,12 +49,12 @@ var _ = Describe("GroupedSink", func() {
 
 		It("sends message to all registered sinks that match the appId", func(done Done) {
 			appId := "123"
-			appSink := syslog.NewSyslogSink("123", "url", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64))
+			appSink := syslog.NewSyslogSink("123", "url", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64), nil)
 			otherInputChan := make(chan *events.Envelope)
 			groupedSinks.RegisterAppSink(otherInputChan, appSink)
 
 			appId = "789"
-			appSink = syslog.NewSyslogSink(appId, "url", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64))
+			appSink = syslog.NewSyslogSink(appId, "url", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64), nil)
 
 			groupedSinks.RegisterAppSink(inputChan, appSink)
 
@@ -128,12 +128,12 @@ var _ = Describe("GroupedSink", func() {
 	Describe("BroadcastError", func() {
 		It("sends message to all registered sinks that match the appId", func(done Done) {
 			appId := "123"
-			appSink := dump.NewDumpSink(appId, 10, loggertesthelper.Logger(), time.Second, make(chan int64))
+			appSink := dump.NewDumpSink(appId, 10, loggertesthelper.Logger(), time.Second, make(chan int64), nil)
 			otherInputChan := make(chan *events.Envelope)
 			groupedSinks.RegisterAppSink(otherInputChan, appSink)
 
 			appId = "789"
-			appSink = dump.NewDumpSink(appId, 10, loggertesthelper.Logger(), time.Second, make(chan int64))
+			appSink = dump.NewDumpSink(appId, 10, loggertesthelper.Logger(), time.Second, make(chan int64), nil)
 
 			groupedSinks.RegisterAppSink(inputChan, appSink)
 			msg, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "error message", appId, "App"), "origin")
@@ -163,8 +163,8 @@ var _ = Describe("GroupedSink", func() {
 		It("does not send to sinks that don't want errors", func(done Done) {
 			appId := "789"
 
-			sink1 := dump.NewDumpSink(appId, 10, loggertesthelper.Logger(), time.Second, make(chan int64))
-			sink2 := syslog.NewSyslogSink(appId, "url", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64))
+			sink1 := dump.NewDumpSink(appId, 10, loggertesthelper.Logger(), time.Second, make(chan int64), nil)
+			sink2 := syslog.NewSyslogSink(appId, "url", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64), nil)
 
 			groupedSinks.RegisterAppSink(inputChan, sink1)
 			groupedSinks.RegisterAppSink(inputChan, sink2)
@@ -179,21 +179,21 @@ var _ = Describe("GroupedSink", func() {
 	Describe("Register", func() {
 		It("returns false for empty app ids", func() {
 			appId := ""
-			appSink := syslog.NewSyslogSink(appId, "url", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64))
+			appSink := syslog.NewSyslogSink(appId, "url", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64), nil)
 			result := groupedSinks.RegisterAppSink(inputChan, appSink)
 			Expect(result).To(BeFalse())
 		})
 
 		It("returns false for empty identifiers", func() {
 			appId := "appId"
-			appSink := syslog.NewSyslogSink(appId, "", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64))
+			appSink := syslog.NewSyslogSink(appId, "", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64), nil)
 			result := groupedSinks.RegisterAppSink(inputChan, appSink)
 			Expect(result).To(BeFalse())
 		})
 
 		It("returns false when registering a duplicate", func() {
 			appId := "789"
-			appSink := syslog.NewSyslogSink(appId, "url", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64))
+			appSink := syslog.NewSyslogSink(appId, "url", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64), nil)
 			groupedSinks.RegisterAppSink(inputChan, appSink)
 			result := groupedSinks.RegisterAppSink(inputChan, appSink)
 			Expect(result).To(BeFalse())
@@ -203,14 +203,14 @@ var _ = Describe("GroupedSink", func() {
 	Describe("RegisterFirehose", func() {
 		It("returns false for empty subscription ids", func() {
 			subscriptionId := ""
-			firehoseSink := syslog.NewSyslogSink(subscriptionId, "url", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64))
+			firehoseSink := syslog.NewSyslogSink(subscriptionId, "url", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64), nil)
 			result := groupedSinks.RegisterFirehoseSink(inputChan, firehoseSink)
 			Expect(result).To(BeFalse())
 		})
 
 		It("returns true if a subscription id is present", func() {
 			subscriptionId := "firehose-subscription-a"
-			firehoseSink := syslog.NewSyslogSink(subscriptionId, "url", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64))
+			firehoseSink := syslog.NewSyslogSink(subscriptionId, "url", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64), nil)
 			result := groupedSinks.RegisterFirehoseSink(inputChan, firehoseSink)
 			Expect(result).To(BeTrue())
 		})
@@ -220,8 +220,8 @@ var _ = Describe("GroupedSink", func() {
 		It("only deletes a specific sink", func() {
 			target := "789"
 
-			sink1 := syslog.NewSyslogSink(target, "url1", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64))
-			sink2 := syslog.NewSyslogSink(target, "url2", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64))
+			sink1 := syslog.NewSyslogSink(target, "url1", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64), nil)
+			sink2 := syslog.NewSyslogSink(target, "url2", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64), nil)
 
 			groupedSinks.RegisterAppSink(inputChan, sink1)
 			groupedSinks.RegisterAppSink(inputChan, sink2)
@@ -234,7 +234,7 @@ var _ = Describe("GroupedSink", func() {
 		It("handle deletes for non-existing appIds", func() {
 			target := "789"
 
-			sink1 := syslog.NewSyslogSink(target, "url1", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64))
+			sink1 := syslog.NewSyslogSink(target, "url1", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64), nil)
 
 			ok := groupedSinks.CloseAndDelete(sink1)
 			Expect(ok).To(BeFalse())
@@ -245,8 +245,8 @@ var _ = Describe("GroupedSink", func() {
 		It("handle deletes for existing appIds but unregistered drain URLs", func() {
 			target := "789"
 
-			sink1 := syslog.NewSyslogSink(target, "url1", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64))
-			sink2 := syslog.NewSyslogSink(target, "url2", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64))
+			sink1 := syslog.NewSyslogSink(target, "url1", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64), nil)
+			sink2 := syslog.NewSyslogSink(target, "url2", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64), nil)
 
 			groupedSinks.RegisterAppSink(inputChan, sink1)
 
@@ -259,7 +259,7 @@ var _ = Describe("GroupedSink", func() {
 		It("closes the inputChan", func() {
 			target := "789"
 
-			sink := syslog.NewSyslogSink(target, "url1", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64))
+			sink := syslog.NewSyslogSink(target, "url1", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64), nil)
 			groupedSinks.RegisterAppSink(inputChan, sink)
 			groupedSinks.CloseAndDelete(sink)
 			Expect(inputChan).To(BeClosed())
@@ -334,8 +334,8 @@ var _ = Describe("GroupedSink", func() {
 		It("does not return dump sinks", func() {
 			target := "789"
 
-			sink1 := dump.NewDumpSink(target, 10, loggertesthelper.Logger(), time.Second, make(chan int64))
-			sink2 := syslog.NewSyslogSink(target, "url", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64))
+			sink1 := dump.NewDumpSink(target, 10, loggertesthelper.Logger(), time.Second, make(chan int64), nil)
+			sink2 := syslog.NewSyslogSink(target, "url", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64), nil)
 
 			groupedSinks.RegisterAppSink(inputChan, sink1)
 			groupedSinks.RegisterAppSink(inputChan, sink2)
@@ -350,8 +350,8 @@ var _ = Describe("GroupedSink", func() {
 		It("returns only sinks that match the appid and drain URL", func() {
 			target := "789"
 
-			sink1 := syslog.NewSyslogSink(target, "other sink", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64))
-			sink2 := syslog.NewSyslogSink(target, "sink we are searching for", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64))
+			sink1 := syslog.NewSyslogSink(target, "other sink", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64), nil)
+			sink2 := syslog.NewSyslogSink(target, "sink we are searching for", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64), nil)
 
 			groupedSinks.RegisterAppSink(inputChan, sink1)
 			groupedSinks.RegisterAppSink(inputChan, sink2)
@@ -363,7 +363,7 @@ var _ = Describe("GroupedSink", func() {
 		It("returns nil if no drains are registered", func() {
 			target := "789"
 
-			sink := syslog.NewSyslogSink(target, "url2", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64))
+			sink := syslog.NewSyslogSink(target, "url2", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64), nil)
 			groupedSinks.RegisterAppSink(inputChan, sink)
 
 			Expect(groupedSinks.DrainFor(target, "url1")).To(BeNil())
@@ -378,9 +378,9 @@ var _ = Describe("GroupedSink", func() {
 		It("returns only dumps", func() {
 			appId := "789"
 
-			sink1 := syslog.NewSyslogSink(appId, "url1", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64))
-			sink2 := syslog.NewSyslogSink(appId, "url2", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64))
-			sink3 := dump.NewDumpSink(appId, 5, loggertesthelper.Logger(), time.Second, make(chan int64))
+			sink1 := syslog.NewSyslogSink(appId, "url1", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64), nil)
+			sink2 := syslog.NewSyslogSink(appId, "url2", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64), nil)
+			sink3 := dump.NewDumpSink(appId, 5, loggertesthelper.Logger(), time.Second, make(chan int64), nil)
 
 			groupedSinks.RegisterAppSink(inputChan, sink1)
 			groupedSinks.RegisterAppSink(inputChan, sink2)
@@ -393,8 +393,8 @@ var _ = Describe("GroupedSink", func() {
 			appId := "789"
 			otherAppId := "790"
 
-			sink1 := dump.NewDumpSink(appId, 5, loggertesthelper.Logger(), time.Second, make(chan int64))
-			sink2 := dump.NewDumpSink(otherAppId, 5, loggertesthelper.Logger(), time.Second, make(chan int64))
+			sink1 := dump.NewDumpSink(appId, 5, loggertesthelper.Logger(), time.Second, make(chan int64), nil)
+			sink2 := dump.NewDumpSink(otherAppId, 5, loggertesthelper.Logger(), time.Second, make(chan int64), nil)
 
 			groupedSinks.RegisterAppSink(inputChan, sink1)
 			groupedSinks.RegisterAppSink(inputChan, sink2)
@@ -405,7 +405,7 @@ var _ = Describe("GroupedSink", func() {
 		It("returns nil if no dumps are registered", func() {
 			target := "789"
 
-			sink1 := syslog.NewSyslogSink(target, "url1", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64))
+			sink1 := syslog.NewSyslogSink(target, "url1", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64), nil)
 
 			groupedSinks.RegisterAppSink(inputChan, sink1)
 
@@ -422,7 +422,7 @@ var _ = Describe("GroupedSink", func() {
 			appId := "456"
 
 			sink1 := containermetric.NewContainerMetricSink(appId, 1*time.Second, time.Second, make(chan int64))
-			sink2 := dump.NewDumpSink(appId, 5, loggertesthelper.Logger(), time.Second, make(chan int64))
+			sink2 := dump.NewDumpSink(appId, 5, loggertesthelper.Logger(), time.Second, make(chan int64), nil)
 
 			groupedSinks.RegisterAppSink(inputChan, sink1)
 			groupedSinks.RegisterAppSink(inputChan, sink2)
@@ -445,7 +445,7 @@ var _ = Describe("GroupedSink", func() {
 
 		It("returns nil if no container metrics sinks are registered", func() {
 			appId := "1234"
-			sink2 := dump.NewDumpSink(appId, 5, loggertesthelper.Logger(), time.Second, make(chan int64))
+			sink2 := dump.NewDumpSink(appId, 5, loggertesthelper.Logger(), time.Second, make(chan int64), nil)
 			groupedSinks.RegisterAppSink(inputChan, sink2)
 
 			Expect(groupedSinks.ContainerMetricsFor(appId)).To(BeNil())
@@ -464,9 +464,9 @@ var _ = Describe("GroupedSink", func() {
 			fakeWriter1 := fakeMessageWriter{RemoteAddress: "1"}
 			fakeWriter2 := fakeMessageWriter{RemoteAddress: "2"}
 
-			sink1 := syslog.NewSyslogSink(appId, "url1", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64))
-			sink2 := websocket.NewWebsocketSink(appId, loggertesthelper.Logger(), &fakeWriter1, 100, "origin", make(chan int64))
-			sink3 := websocket.NewWebsocketSink(appId, loggertesthelper.Logger(), &fakeWriter2, 100, "origin", make(chan int64))
+			sink1 := syslog.NewSyslogSink(appId, "url1", loggertesthelper.Logger(), DummySyslogWriter{}, dummyErrorHandler, "dropsonde-origin", make(chan int64), nil)
+			sink2 := websocket.NewWebsocketSink(appId, loggertesthelper.Logger(), &fakeWriter1, 100, "origin", make(chan int64), nil)
+			sink3 := websocket.NewWebsocketSink(appId, loggertesthelper.Logger(), &fakeWriter2, 100, "origin", make(chan int64), nil)
 
 			groupedSinks.RegisterAppSink(inputChan, sink1)
 			groupedSinks.RegisterAppSink(inputChan, sink2)
@@ -481,8 +481,8 @@ var _ = Describe("GroupedSink", func() {
 
 			fakeWriter := fakeMessageWriter{RemoteAddress: "1"}
 
-			sink1 := websocket.NewWebsocketSink(appId, loggertesthelper.Logger(), &fakeWriter, 100, "origin", make(chan int64))
-			sink2 := websocket.NewWebsocketSink(otherAppId, loggertesthelper.Logger(), &fakeWriter, 100, "origin", make(chan int64))
+			sink1 := websocket.NewWebsocketSink(appId, loggertesthelper.Logger(), &fakeWriter, 100, "origin", make(chan int64), nil)
+			sink2 := websocket.NewWebsocketSink(otherAppId, loggertesthelper.Logger(), &fakeWriter, 100, "origin", make(chan int64), nil)
 
 			groupedSinks.RegisterAppSink(inputChan, sink1)
 			groupedSinks.RegisterAppSink(inputChan, sink2)
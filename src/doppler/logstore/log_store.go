@@ -0,0 +1,461 @@
+package logstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/events"
+	"github.com/cloudfoundry/gosteno"
+	"github.com/gogo/protobuf/proto"
+)
+
+const (
+	segmentsPerApp   = 4
+	segmentExtension = ".seg"
+	pruneInterval    = time.Minute
+)
+
+// LogStore keeps an append-only set of segment files per app on disk.
+// Every record is a 4 byte big endian length followed by a marshalled envelope.
+// The oldest segment of an app is removed once the app exceeds maxBytesPerApp,
+// and any segment that has not been written to within the retention period is
+// removed on the next append, read or prune. The segment an app is appending
+// to stays open until it is rotated or the app has been idle for a prune
+// interval. Segments left behind by a previous run are only read, appends
+// start a new segment, as the last one may end in a partly written record.
+type LogStore struct {
+	dir            string
+	maxBytesPerApp int64
+	segmentSize    int64
+	retention      time.Duration
+	logger         *gosteno.Logger
+
+	apps     map[string]*appLog
+	lock     sync.Mutex
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+type appLog struct {
+	dir      string
+	segments []*segment
+	nextId   uint64
+	file     *os.File
+
+	// dead is set once Prune removed the directory of the app, so appends
+	// holding on to it start over with a new appLog
+	dead bool
+	sync.Mutex
+}
+
+type segment struct {
+	path     string
+	size     int64
+	modified time.Time
+
+	// loaded is set for segments written by a previous run
+	loaded bool
+}
+
+func New(dir string, maxBytesPerApp int64, retention time.Duration, logger *gosteno.Logger) (*LogStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	segmentSize := maxBytesPerApp / segmentsPerApp
+	if segmentSize == 0 {
+		segmentSize = 1
+	}
+
+	return &LogStore{
+		dir:            dir,
+		maxBytesPerApp: maxBytesPerApp,
+		segmentSize:    segmentSize,
+		retention:      retention,
+		logger:         logger,
+		apps:           make(map[string]*appLog),
+		done:           make(chan struct{}),
+	}, nil
+}
+
+func (s *LogStore) Run() {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.Prune()
+		}
+	}
+}
+
+func (s *LogStore) Stop() {
+	s.stopOnce.Do(func() { close(s.done) })
+
+	s.lock.Lock()
+	apps := make([]*appLog, 0, len(s.apps))
+	for _, app := range s.apps {
+		apps = append(apps, app)
+	}
+	s.lock.Unlock()
+
+	for _, app := range apps {
+		app.Lock()
+		app.closeFile(s.logger)
+		app.Unlock()
+	}
+}
+
+func (s *LogStore) Append(appId string, envelope *events.Envelope) error {
+	data, err := proto.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	app, err := s.lockedAppLogFor(appId)
+	if err != nil {
+		return err
+	}
+	defer app.Unlock()
+
+	app.expire(time.Now().Add(-s.retention), s.logger)
+
+	current := app.current()
+	if current == nil || current.loaded || current.size >= s.segmentSize {
+		app.closeFile(s.logger)
+		current = app.newSegment()
+	}
+
+	if app.file == nil {
+		app.file, err = os.OpenFile(current.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	record := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(record, uint32(len(data)))
+	copy(record[4:], data)
+
+	n, err := app.file.Write(record)
+	current.size += int64(n)
+	current.modified = time.Now()
+	if err != nil {
+		return err
+	}
+
+	app.truncate(s.maxBytesPerApp, s.logger)
+	return nil
+}
+
+func (s *LogStore) Read(appId string) ([]*events.Envelope, error) {
+	s.lock.Lock()
+	app, ok := s.apps[appId]
+	s.lock.Unlock()
+
+	if !ok {
+		if _, err := os.Stat(s.appDir(appId)); os.IsNotExist(err) {
+			return []*events.Envelope{}, nil
+		}
+
+		var err error
+		app, err = s.appLogFor(appId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	app.Lock()
+	defer app.Unlock()
+
+	if app.dead {
+		return []*events.Envelope{}, nil
+	}
+
+	cutoff := time.Now().Add(-s.retention)
+	app.expire(cutoff, s.logger)
+
+	envelopes := []*events.Envelope{}
+	for _, seg := range app.segments {
+		segmentEnvelopes, err := readSegment(seg.path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, envelope := range segmentEnvelopes {
+			if envelope.GetTimestamp() < cutoff.UnixNano() {
+				continue
+			}
+			envelopes = append(envelopes, envelope)
+		}
+	}
+
+	return envelopes, nil
+}
+
+func (s *LogStore) Prune() {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		s.logger.Warnf("LogStore: Error listing %s: %v", s.dir, err)
+		return
+	}
+
+	cutoff := time.Now().Add(-s.retention)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		appId, err := url.QueryUnescape(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		app, err := s.appLogFor(appId)
+		if err != nil {
+			s.logger.Warnf("LogStore: Error loading segments for app %s: %v", appId, err)
+			continue
+		}
+
+		s.pruneApp(appId, app, cutoff)
+	}
+}
+
+// pruneApp holds the lock of the app while removing it, so an append can't
+// recreate files in a directory that is being removed.
+func (s *LogStore) pruneApp(appId string, app *appLog, cutoff time.Time) {
+	app.Lock()
+	defer app.Unlock()
+
+	app.expire(cutoff, s.logger)
+
+	current := app.current()
+	if current != nil && time.Since(current.modified) > pruneInterval {
+		app.closeFile(s.logger)
+	}
+
+	if len(app.segments) > 0 {
+		return
+	}
+
+	app.closeFile(s.logger)
+	app.dead = true
+
+	s.lock.Lock()
+	if s.apps[appId] == app {
+		delete(s.apps, appId)
+	}
+	s.lock.Unlock()
+
+	os.Remove(app.dir)
+}
+
+func (s *LogStore) appDir(appId string) string {
+	return filepath.Join(s.dir, url.QueryEscape(appId))
+}
+
+// lockedAppLogFor returns the locked appLog of an app that was not pruned.
+func (s *LogStore) lockedAppLogFor(appId string) (*appLog, error) {
+	for {
+		app, err := s.appLogFor(appId)
+		if err != nil {
+			return nil, err
+		}
+
+		app.Lock()
+		if !app.dead {
+			return app, nil
+		}
+		app.Unlock()
+	}
+}
+
+func (s *LogStore) appLogFor(appId string) (*appLog, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if app, ok := s.apps[appId]; ok {
+		return app, nil
+	}
+
+	app, err := loadAppLog(s.appDir(appId))
+	if err != nil {
+		return nil, err
+	}
+
+	s.apps[appId] = app
+	return app, nil
+}
+
+func loadAppLog(dir string) (*appLog, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	app := &appLog{dir: dir}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExtension) {
+			continue
+		}
+
+		var id uint64
+		_, err := fmt.Sscanf(strings.TrimSuffix(name, segmentExtension), "%d", &id)
+		if err != nil {
+			continue
+		}
+
+		if id >= app.nextId {
+			app.nextId = id + 1
+		}
+
+		app.segments = append(app.segments, &segment{
+			path:     filepath.Join(dir, name),
+			size:     entry.Size(),
+			modified: entry.ModTime(),
+			loaded:   true,
+		})
+	}
+
+	sort.Sort(byPath(app.segments))
+	return app, nil
+}
+
+func (a *appLog) current() *segment {
+	if len(a.segments) == 0 {
+		return nil
+	}
+	return a.segments[len(a.segments)-1]
+}
+
+func (a *appLog) newSegment() *segment {
+	seg := &segment{
+		path:     filepath.Join(a.dir, fmt.Sprintf("%020d%s", a.nextId, segmentExtension)),
+		modified: time.Now(),
+	}
+	a.nextId++
+	a.segments = append(a.segments, seg)
+	return seg
+}
+
+func (a *appLog) size() int64 {
+	var total int64
+	for _, seg := range a.segments {
+		total += seg.size
+	}
+	return total
+}
+
+func (a *appLog) truncate(maxBytes int64, logger *gosteno.Logger) {
+	for len(a.segments) > 1 && a.size() > maxBytes {
+		a.removeOldest(logger)
+	}
+}
+
+func (a *appLog) expire(cutoff time.Time, logger *gosteno.Logger) {
+	for len(a.segments) > 0 && a.segments[0].modified.Before(cutoff) {
+		a.removeOldest(logger)
+	}
+}
+
+func (a *appLog) closeFile(logger *gosteno.Logger) {
+	if a.file == nil {
+		return
+	}
+
+	err := a.file.Close()
+	if err != nil {
+		logger.Warnf("LogStore: Error closing segment of %s: %v", a.dir, err)
+	}
+	a.file = nil
+}
+
+func (a *appLog) removeOldest(logger *gosteno.Logger) {
+	oldest := a.segments[0]
+	a.segments = a.segments[1:]
+	if len(a.segments) == 0 {
+		// the oldest segment was the one being appended to
+		a.closeFile(logger)
+	}
+
+	err := os.Remove(oldest.path)
+	if err != nil && !os.IsNotExist(err) {
+		logger.Warnf("LogStore: Error removing segment %s: %v", oldest.path, err)
+	}
+}
+
+func readSegment(path string) ([]*events.Envelope, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(file)
+	envelopes := []*events.Envelope{}
+	header := make([]byte, 4)
+	remaining := info.Size()
+	for {
+		_, err := io.ReadFull(reader, header)
+		if err != nil {
+			// a partially written header at the end of a segment is treated as the end of the segment
+			return envelopes, nil
+		}
+		remaining -= int64(len(header))
+
+		// a length beyond the end of the segment belongs to a partly
+		// written or damaged record, nothing after it can be trusted
+		length := int64(binary.BigEndian.Uint32(header))
+		if length > remaining {
+			return envelopes, nil
+		}
+
+		data := make([]byte, length)
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			return envelopes, nil
+		}
+		remaining -= length
+
+		envelope := &events.Envelope{}
+		err = proto.Unmarshal(data, envelope)
+		if err != nil {
+			continue
+		}
+		envelopes = append(envelopes, envelope)
+	}
+}
+
+type byPath []*segment
+
+func (s byPath) Len() int           { return len(s) }
+func (s byPath) Less(i, j int) bool { return s[i].path < s[j].path }
+func (s byPath) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,201 @@
+package logstore_test
+
+import (
+	"doppler/logstore"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/emitter"
+	"github.com/cloudfoundry/dropsonde/events"
+	"github.com/cloudfoundry/dropsonde/factories"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+	"github.com/gogo/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LogStore", func() {
+	var (
+		dir   string
+		store *logstore.LogStore
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "logstore")
+		Expect(err).NotTo(HaveOccurred())
+
+		store, err = logstore.New(dir, 1024*1024, time.Hour, loggertesthelper.Logger())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("returns an empty list for unknown apps", func() {
+		envelopes, err := store.Read("unknown")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(envelopes).To(BeEmpty())
+	})
+
+	It("returns appended messages in order", func() {
+		for i := 0; i < 3; i++ {
+			Expect(store.Append("myApp", logEnvelope(strconv.Itoa(i)))).To(Succeed())
+		}
+
+		envelopes, err := store.Read("myApp")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(envelopes).To(HaveLen(3))
+		Expect(string(envelopes[0].GetLogMessage().GetMessage())).To(Equal("0"))
+		Expect(string(envelopes[2].GetLogMessage().GetMessage())).To(Equal("2"))
+	})
+
+	It("keeps messages for different apps apart", func() {
+		Expect(store.Append("app1", logEnvelope("one"))).To(Succeed())
+		Expect(store.Append("app2", logEnvelope("two"))).To(Succeed())
+
+		envelopes, err := store.Read("app2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(envelopes).To(HaveLen(1))
+		Expect(string(envelopes[0].GetLogMessage().GetMessage())).To(Equal("two"))
+	})
+
+	It("survives being reopened", func() {
+		Expect(store.Append("myApp", logEnvelope("persisted"))).To(Succeed())
+
+		reopened, err := logstore.New(dir, 1024*1024, time.Hour, loggertesthelper.Logger())
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(reopened.Append("myApp", logEnvelope("after restart"))).To(Succeed())
+
+		envelopes, err := reopened.Read("myApp")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(envelopes).To(HaveLen(2))
+		Expect(string(envelopes[0].GetLogMessage().GetMessage())).To(Equal("persisted"))
+		Expect(string(envelopes[1].GetLogMessage().GetMessage())).To(Equal("after restart"))
+	})
+
+	It("appends behind a partly written record of a previous run", func() {
+		Expect(store.Append("myApp", logEnvelope("first"))).To(Succeed())
+		Expect(store.Append("myApp", logEnvelope("torn"))).To(Succeed())
+		store.Stop()
+
+		files, _ := filepath.Glob(filepath.Join(dir, "myApp", "*.seg"))
+		Expect(files).To(HaveLen(1))
+		info, err := os.Stat(files[0])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Truncate(files[0], info.Size()-3)).To(Succeed())
+
+		reopened, err := logstore.New(dir, 1024*1024, time.Hour, loggertesthelper.Logger())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reopened.Append("myApp", logEnvelope("after restart"))).To(Succeed())
+
+		envelopes, err := reopened.Read("myApp")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(envelopes).To(HaveLen(2))
+		Expect(string(envelopes[0].GetLogMessage().GetMessage())).To(Equal("first"))
+		Expect(string(envelopes[1].GetLogMessage().GetMessage())).To(Equal("after restart"))
+	})
+
+	It("stops reading a segment at a record longer than the segment", func() {
+		Expect(store.Append("myApp", logEnvelope("first"))).To(Succeed())
+		store.Stop()
+
+		files, _ := filepath.Glob(filepath.Join(dir, "myApp", "*.seg"))
+		file, err := os.OpenFile(files[0], os.O_WRONLY|os.O_APPEND, 0644)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = file.Write([]byte{0xff, 0xff, 0xff, 0xff, 1, 2, 3})
+		Expect(err).NotTo(HaveOccurred())
+		file.Close()
+
+		reopened, err := logstore.New(dir, 1024*1024, time.Hour, loggertesthelper.Logger())
+		Expect(err).NotTo(HaveOccurred())
+
+		envelopes, err := reopened.Read("myApp")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(envelopes).To(HaveLen(1))
+		Expect(string(envelopes[0].GetLogMessage().GetMessage())).To(Equal("first"))
+	})
+
+	It("drops the oldest segments once an app exceeds its byte cap", func() {
+		var err error
+		store, err = logstore.New(dir, 2048, time.Hour, loggertesthelper.Logger())
+		Expect(err).NotTo(HaveOccurred())
+
+		for i := 0; i < 1000; i++ {
+			Expect(store.Append("myApp", logEnvelope(strconv.Itoa(i)))).To(Succeed())
+		}
+
+		envelopes, err := store.Read("myApp")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(len(envelopes)).To(BeNumerically("<", 1000))
+		Expect(string(envelopes[len(envelopes)-1].GetLogMessage().GetMessage())).To(Equal("999"))
+
+		var total int64
+		files, _ := filepath.Glob(filepath.Join(dir, "myApp", "*.seg"))
+		for _, file := range files {
+			info, _ := os.Stat(file)
+			total += info.Size()
+		}
+		Expect(total).To(BeNumerically("<=", 2048))
+	})
+
+	It("does not return messages older than the retention period", func() {
+		old := logEnvelope("old")
+		old.Timestamp = proto.Int64(time.Now().Add(-2 * time.Hour).UnixNano())
+		Expect(store.Append("myApp", old)).To(Succeed())
+		Expect(store.Append("myApp", logEnvelope("new"))).To(Succeed())
+
+		envelopes, err := store.Read("myApp")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(envelopes).To(HaveLen(1))
+		Expect(string(envelopes[0].GetLogMessage().GetMessage())).To(Equal("new"))
+	})
+
+	It("removes expired apps when pruning", func() {
+		var err error
+		store, err = logstore.New(dir, 1024*1024, time.Millisecond, loggertesthelper.Logger())
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(store.Append("myApp", logEnvelope("expiring"))).To(Succeed())
+		time.Sleep(10 * time.Millisecond)
+
+		store.Prune()
+
+		_, err = os.Stat(filepath.Join(dir, "myApp"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("keeps appending while apps are pruned", func() {
+		var err error
+		store, err = logstore.New(dir, 1024*1024, time.Millisecond, loggertesthelper.Logger())
+		Expect(err).NotTo(HaveOccurred())
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 100; i++ {
+				store.Prune()
+			}
+		}()
+
+		for i := 0; i < 100; i++ {
+			Expect(store.Append("myApp", logEnvelope(strconv.Itoa(i)))).To(Succeed())
+		}
+		<-done
+
+		Expect(store.Append("myApp", logEnvelope("last"))).To(Succeed())
+		files, _ := filepath.Glob(filepath.Join(dir, "myApp", "*.seg"))
+		Expect(files).NotTo(BeEmpty())
+	})
+})
+
+func logEnvelope(message string) *events.Envelope {
+	envelope, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, message, "myApp", "App"), "origin")
+	return envelope
+}
@@ -0,0 +1,13 @@
+package logstore_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestLogstore(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "LogStore Suite")
+}
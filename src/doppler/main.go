@@ -5,13 +5,17 @@ import (
 	"flag"
 	"fmt"
 	"math/rand"
+	"net"
 	"os"
 	"os/signal"
 	"runtime"
 	"runtime/pprof"
+	"strconv"
 	"time"
 
 	"doppler/config"
+	"dropsonde_batch"
+	"prometheus_exporter"
 
 	"github.com/cloudfoundry/gosteno"
 	"github.com/cloudfoundry/gunk/workpool"
@@ -127,6 +131,11 @@ func main() {
 		}
 	}()
 
+	if conf.PrometheusMetricsPort != 0 {
+		exporter := prometheus_exporter.New("doppler", doppler.Emitters())
+		go exporter.ListenAndServe(net.JoinHostPort(conf.PrometheusMetricsHost, strconv.Itoa(int(conf.PrometheusMetricsPort))), logger)
+	}
+
 	go doppler.Start()
 	logger.Info("Startup: doppler server started.")
 
@@ -135,6 +144,7 @@ func main() {
 
 	storeAdapter = NewStoreAdapter(conf.EtcdUrls, conf.EtcdMaxConcurrentRequests)
 	StartHeartbeats(localIp, config.HeartbeatInterval, conf, storeAdapter, logger)
+	AdvertiseFrameFormats(localIp, config.HeartbeatInterval, conf, storeAdapter, logger)
 
 	for {
 		select {
@@ -162,6 +172,22 @@ func ParseConfig(logLevel *bool, configFile, logFilePath *string) (*config.Confi
 }
 
 func StartHeartbeats(localIp string, ttl time.Duration, config *config.Config, storeAdapter storeadapter.StoreAdapter, logger *gosteno.Logger) (stopChan chan (chan bool)) {
+	key := fmt.Sprintf("/healthstatus/doppler/%s/%s/%d", config.Zone, config.JobName, config.Index)
+	return maintainNode(key, "health status", localIp, ttl, config, storeAdapter, logger)
+}
+
+// AdvertiseFrameFormats tells metrons that this doppler accepts batch frames.
+// Metrons only send batches once every doppler advertises them. Metrons don't
+// advertise anything themselves: frames only flow from metron to doppler, so
+// only what dopplers accept decides the format.
+func AdvertiseFrameFormats(localIp string, ttl time.Duration, config *config.Config, storeAdapter storeadapter.StoreAdapter, logger *gosteno.Logger) (stopChan chan (chan bool)) {
+	key := fmt.Sprintf("%s/%s/%s/%d", dropsonde_batch.AdvertisementPath, config.Zone, config.JobName, config.Index)
+	return maintainNode(key, "frame format "+dropsonde_batch.FrameFormat, localIp, ttl, config, storeAdapter, logger)
+}
+
+// maintainNode keeps the key with the local IP in the store until stopChan is
+// used, describing it as what in the logs.
+func maintainNode(key string, what string, localIp string, ttl time.Duration, config *config.Config, storeAdapter storeadapter.StoreAdapter, logger *gosteno.Logger) (stopChan chan (chan bool)) {
 	if len(config.EtcdUrls) == 0 {
 		return
 	}
@@ -170,9 +196,9 @@ func StartHeartbeats(localIp string, ttl time.Duration, config *config.Config, s
 		panic("store adapter is nil")
 	}
 
-	logger.Debugf("Starting Health Status Updates to Store: /healthstatus/doppler/%s/%s/%d", config.Zone, config.JobName, config.Index)
+	logger.Debugf("Maintaining %s in Store: %s", what, key)
 	status, stopChan, err := storeAdapter.MaintainNode(storeadapter.StoreNode{
-		Key:   fmt.Sprintf("/healthstatus/doppler/%s/%s/%d", config.Zone, config.JobName, config.Index),
+		Key:   key,
 		Value: []byte(localIp),
 		TTL:   uint64(ttl.Seconds()),
 	})
@@ -183,7 +209,7 @@ func StartHeartbeats(localIp string, ttl time.Duration, config *config.Config, s
 
 	go func() {
 		for stat := range status {
-			logger.Debugf("Health updates channel pushed %v at time %v", stat, time.Now())
+			logger.Debugf("Updates of %s pushed %v at time %v", what, stat, time.Now())
 		}
 	}()
 
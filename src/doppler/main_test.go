@@ -151,4 +151,34 @@ var _ = Describe("Main", func() {
 		})
 	})
 
+	Describe("AdvertiseFrameFormats", func() {
+		var adapter *fakestoreadapter.FakeStoreAdapter
+		var conf config.Config
+		var localIp string
+
+		BeforeEach(func() {
+			adapter = fakestoreadapter.New()
+			localIp, _ = localip.LocalIP()
+			conf = config.Config{
+				JobName: "doppler_z1",
+				Index:   0,
+				EtcdMaxConcurrentRequests: 10,
+				EtcdUrls:                  []string{"test:123", "test:456"},
+				Zone:                      "z1",
+			}
+		})
+
+		It("advertises the batch frame format in etcd", func() {
+			main.AdvertiseFrameFormats(localIp, time.Second, &conf, adapter, loggertesthelper.Logger())
+			Expect(adapter.GetMaintainedNodeName()).To(Equal("/doppler/frameformats/batch-v1/z1/doppler_z1/0"))
+			Expect(adapter.MaintainedNodeValue).To(Equal([]byte(localIp)))
+		})
+
+		It("does not advertise without a valid ETCD config", func() {
+			conf.EtcdUrls = nil
+			main.AdvertiseFrameFormats(localIp, time.Second, &conf, adapter, loggertesthelper.Logger())
+			Expect(adapter.GetMaintainedNodeName()).To(BeEmpty())
+		})
+	})
+
 })
@@ -0,0 +1,114 @@
+package signatureverifier
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync/atomic"
+
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+)
+
+// SignatureLength is the length of the HMAC-SHA256 signature metron puts in
+// front of every message.
+const SignatureLength = 32
+
+// fingerprintMessage is signed with a secret to tell it apart in metrics
+// without revealing it, see Fingerprint.
+const fingerprintMessage = "loggregator"
+
+// SignatureVerifier accepts messages signed with any of its shared secrets.
+// The secrets are tried in order, so the secret most messages are signed
+// with should come first. Counting the matches per secret shows when an old
+// secret is no longer used and can be removed. The counts are tagged with the
+// fingerprint of their secret, which stays the same when secrets are
+// reordered or removed.
+type SignatureVerifier struct {
+	logger        *gosteno.Logger
+	sharedSecrets [][]byte
+	fingerprints  []string
+
+	missingSignatureErrors uint64
+	invalidSignatureErrors uint64
+	validSignatures        uint64
+	validSignaturesByKey   []uint64
+}
+
+func New(logger *gosteno.Logger, sharedSecrets []string) *SignatureVerifier {
+	secrets := make([][]byte, len(sharedSecrets))
+	fingerprints := make([]string, len(sharedSecrets))
+	for i, secret := range sharedSecrets {
+		secrets[i] = []byte(secret)
+		fingerprints[i] = Fingerprint(secret)
+	}
+
+	return &SignatureVerifier{
+		logger:               logger,
+		sharedSecrets:        secrets,
+		fingerprints:         fingerprints,
+		validSignaturesByKey: make([]uint64, len(secrets)),
+	}
+}
+
+// Fingerprint returns the first 8 hex digits of the HMAC-SHA256 of
+// "loggregator" keyed with the secret.
+func Fingerprint(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fingerprintMessage))
+	return hex.EncodeToString(mac.Sum(nil))[:8]
+}
+
+func (v *SignatureVerifier) Run(inputChan <-chan []byte, outputChan chan<- []byte) {
+	for signedMessage := range inputChan {
+		if len(signedMessage) < SignatureLength {
+			v.logger.Warnf("signatureVerifier: missing signature for message %v", signedMessage)
+			atomic.AddUint64(&v.missingSignatureErrors, 1)
+			continue
+		}
+
+		signature, message := signedMessage[:SignatureLength], signedMessage[SignatureLength:]
+		key, ok := v.verify(message, signature)
+		if !ok {
+			v.logger.Warnf("signatureVerifier: invalid signature for message %v", message)
+			atomic.AddUint64(&v.invalidSignatureErrors, 1)
+			continue
+		}
+
+		atomic.AddUint64(&v.validSignatures, 1)
+		atomic.AddUint64(&v.validSignaturesByKey[key], 1)
+		outputChan <- message
+	}
+}
+
+func (v *SignatureVerifier) Emit() instrumentation.Context {
+	data := []instrumentation.Metric{
+		instrumentation.Metric{Name: "missingSignatureErrors", Value: atomic.LoadUint64(&v.missingSignatureErrors)},
+		instrumentation.Metric{Name: "invalidSignatureErrors", Value: atomic.LoadUint64(&v.invalidSignatureErrors)},
+		instrumentation.Metric{Name: "validSignatures", Value: atomic.LoadUint64(&v.validSignatures)},
+	}
+
+	for key := range v.validSignaturesByKey {
+		data = append(data, instrumentation.Metric{
+			Name:  "validSignaturesByKey",
+			Value: atomic.LoadUint64(&v.validSignaturesByKey[key]),
+			Tags:  map[string]interface{}{"keyFingerprint": v.fingerprints[key]},
+		})
+	}
+
+	return instrumentation.Context{
+		Name:    "signatureVerifier",
+		Metrics: data,
+	}
+}
+
+func (v *SignatureVerifier) verify(message, signature []byte) (int, bool) {
+	for key, secret := range v.sharedSecrets {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(message)
+		if hmac.Equal(signature, mac.Sum(nil)) {
+			return key, true
+		}
+	}
+	return 0, false
+}
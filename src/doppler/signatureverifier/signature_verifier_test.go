@@ -0,0 +1,84 @@
+package signatureverifier_test
+
+import (
+	"doppler/signatureverifier"
+
+	"github.com/cloudfoundry/dropsonde/signature"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SignatureVerifier", func() {
+	var verifier *signatureverifier.SignatureVerifier
+	var inputChan chan []byte
+	var outputChan chan []byte
+
+	BeforeEach(func() {
+		verifier = signatureverifier.New(loggertesthelper.Logger(), []string{"new-secret", "old-secret"})
+		inputChan = make(chan []byte, 10)
+		outputChan = make(chan []byte, 10)
+		go verifier.Run(inputChan, outputChan)
+	})
+
+	AfterEach(func() {
+		close(inputChan)
+	})
+
+	metric := func(name string) interface{} {
+		for _, m := range verifier.Emit().Metrics {
+			if m.Name == name {
+				return m.Value
+			}
+		}
+		return nil
+	}
+
+	validSignaturesByKey := func(secret string) interface{} {
+		for _, m := range verifier.Emit().Metrics {
+			if m.Name == "validSignaturesByKey" && m.Tags["keyFingerprint"] == signatureverifier.Fingerprint(secret) {
+				return m.Value
+			}
+		}
+		return nil
+	}
+
+	It("accepts messages signed with the primary secret", func() {
+		inputChan <- signature.SignMessage([]byte("message"), []byte("new-secret"))
+
+		Eventually(outputChan).Should(Receive(Equal([]byte("message"))))
+		Expect(metric("validSignatures")).To(Equal(uint64(1)))
+		Expect(validSignaturesByKey("new-secret")).To(Equal(uint64(1)))
+		Expect(validSignaturesByKey("old-secret")).To(Equal(uint64(0)))
+	})
+
+	It("accepts messages signed with an older secret", func() {
+		inputChan <- signature.SignMessage([]byte("message"), []byte("old-secret"))
+
+		Eventually(outputChan).Should(Receive(Equal([]byte("message"))))
+		Expect(validSignaturesByKey("new-secret")).To(Equal(uint64(0)))
+		Expect(validSignaturesByKey("old-secret")).To(Equal(uint64(1)))
+	})
+
+	It("fingerprints secrets without revealing them", func() {
+		Expect(signatureverifier.Fingerprint("new-secret")).To(HaveLen(8))
+		Expect(signatureverifier.Fingerprint("new-secret")).To(Equal(signatureverifier.Fingerprint("new-secret")))
+		Expect(signatureverifier.Fingerprint("new-secret")).ToNot(Equal(signatureverifier.Fingerprint("old-secret")))
+		Expect(signatureverifier.Fingerprint("new-secret")).ToNot(ContainSubstring("secret"))
+	})
+
+	It("drops messages signed with an unknown secret", func() {
+		inputChan <- signature.SignMessage([]byte("message"), []byte("other-secret"))
+
+		Eventually(func() interface{} { return metric("invalidSignatureErrors") }).Should(Equal(uint64(1)))
+		Expect(outputChan).ToNot(Receive())
+	})
+
+	It("drops messages without a signature", func() {
+		inputChan <- []byte{1, 2, 3}
+
+		Eventually(func() interface{} { return metric("missingSignatureErrors") }).Should(Equal(uint64(1)))
+		Expect(outputChan).ToNot(Receive())
+	})
+})
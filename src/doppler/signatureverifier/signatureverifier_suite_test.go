@@ -0,0 +1,13 @@
+package signatureverifier_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestSignatureVerifier(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Signature Verifier Suite")
+}
@@ -10,21 +10,28 @@ import (
 	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
 )
 
+type Store interface {
+	Append(appId string, envelope *events.Envelope) error
+	Read(appId string) ([]*events.Envelope, error)
+}
+
 type DumpSink struct {
 	appId               string
 	logger              *gosteno.Logger
 	messageRing         *ring.Ring
+	store               Store
 	inputChan           chan *events.Envelope
 	inactivityDuration  time.Duration
 	metricUpdateChannel chan<- int64
 	sync.RWMutex
 }
 
-func NewDumpSink(appId string, bufferSize uint32, givenLogger *gosteno.Logger, inactivityDuration time.Duration, metricUpdateChannel chan<- int64) *DumpSink {
+func NewDumpSink(appId string, bufferSize uint32, givenLogger *gosteno.Logger, inactivityDuration time.Duration, metricUpdateChannel chan<- int64, store Store) *DumpSink {
 	dumpSink := &DumpSink{
 		appId:               appId,
 		logger:              givenLogger,
 		messageRing:         ring.New(int(bufferSize)),
+		store:               store,
 		inactivityDuration:  inactivityDuration,
 		metricUpdateChannel: metricUpdateChannel,
 	}
@@ -65,12 +72,27 @@ func (d *DumpSink) addMsg(msg *events.Envelope) {
 
 	d.messageRing = d.messageRing.Next()
 	d.messageRing.Value = msg
+
+	if d.store != nil {
+		err := d.store.Append(d.appId, msg)
+		if err != nil {
+			d.logger.Warnf("Dump sink (app id %s): Error writing to recent logs store: %v", d.appId, err)
+		}
+	}
 }
 
 func (d *DumpSink) Dump() []*events.Envelope {
 	d.RLock()
 	defer d.RUnlock()
 
+	if d.store != nil {
+		data, err := d.store.Read(d.appId)
+		if err == nil {
+			return data
+		}
+		d.logger.Warnf("Dump sink (app id %s): Error reading from recent logs store, falling back to memory: %v", d.appId, err)
+	}
+
 	data := make([]*events.Envelope, 0, d.messageRing.Len())
 	d.messageRing.Next().Do(func(value interface{}) {
 		if value == nil {
@@ -2,8 +2,10 @@ package dump_test
 
 import (
 	"doppler/sinks/dump"
+	"errors"
 	"runtime"
 	"strconv"
+	"sync"
 
 	"github.com/cloudfoundry/dropsonde/emitter"
 	"github.com/cloudfoundry/dropsonde/events"
@@ -20,7 +22,7 @@ import (
 var _ = Describe("Dump Sink", func() {
 	It("works with one message", func() {
 
-		testDump := dump.NewDumpSink("myApp", 1, loggertesthelper.Logger(), time.Second, make(chan int64))
+		testDump := dump.NewDumpSink("myApp", 1, loggertesthelper.Logger(), time.Second, make(chan int64), nil)
 
 		dumpRunnerDone := make(chan struct{})
 		inputChan := make(chan *events.Envelope)
@@ -43,7 +45,7 @@ var _ = Describe("Dump Sink", func() {
 
 	It("works with two messages", func() {
 
-		testDump := dump.NewDumpSink("myApp", 2, loggertesthelper.Logger(), time.Second, make(chan int64))
+		testDump := dump.NewDumpSink("myApp", 2, loggertesthelper.Logger(), time.Second, make(chan int64), nil)
 
 		dumpRunnerDone := make(chan struct{})
 		inputChan := make(chan *events.Envelope)
@@ -71,7 +73,7 @@ var _ = Describe("Dump Sink", func() {
 	It("never fills up", func() {
 
 		bufferSize := uint32(3)
-		testDump := dump.NewDumpSink("myApp", bufferSize, loggertesthelper.Logger(), time.Second, make(chan int64))
+		testDump := dump.NewDumpSink("myApp", bufferSize, loggertesthelper.Logger(), time.Second, make(chan int64), nil)
 
 		dumpRunnerDone := make(chan struct{})
 		inputChan := make(chan *events.Envelope)
@@ -93,7 +95,7 @@ var _ = Describe("Dump Sink", func() {
 
 	It("always returns the newest messages", func() {
 
-		testDump := dump.NewDumpSink("myApp", 2, loggertesthelper.Logger(), time.Second, make(chan int64))
+		testDump := dump.NewDumpSink("myApp", 2, loggertesthelper.Logger(), time.Second, make(chan int64), nil)
 
 		dumpRunnerDone := make(chan struct{})
 
@@ -122,7 +124,7 @@ var _ = Describe("Dump Sink", func() {
 
 	It("returns all recent messages to multiple dump requests", func() {
 
-		testDump := dump.NewDumpSink("myApp", 2, loggertesthelper.Logger(), time.Second, make(chan int64))
+		testDump := dump.NewDumpSink("myApp", 2, loggertesthelper.Logger(), time.Second, make(chan int64), nil)
 
 		dumpRunnerDone := make(chan struct{})
 		inputChan := make(chan *events.Envelope)
@@ -154,7 +156,7 @@ var _ = Describe("Dump Sink", func() {
 	})
 
 	It("returns all recent messages to multiple dump requests with messages cloning in in the meantime", func() {
-		testDump := dump.NewDumpSink("myApp", 2, loggertesthelper.Logger(), time.Second, make(chan int64))
+		testDump := dump.NewDumpSink("myApp", 2, loggertesthelper.Logger(), time.Second, make(chan int64), nil)
 
 		dumpRunnerDone := make(chan struct{})
 		inputChan := make(chan *events.Envelope)
@@ -200,7 +202,7 @@ var _ = Describe("Dump Sink", func() {
 	})
 
 	It("works with lots of messages", func() {
-		testDump := dump.NewDumpSink("myApp", 2, loggertesthelper.Logger(), time.Second, make(chan int64))
+		testDump := dump.NewDumpSink("myApp", 2, loggertesthelper.Logger(), time.Second, make(chan int64), nil)
 
 		dumpRunnerDone := make(chan struct{})
 		inputChan := make(chan *events.Envelope)
@@ -251,7 +253,7 @@ var _ = Describe("Dump Sink", func() {
 	})
 
 	It("works with lots of messages and large buffer", func() {
-		testDump := dump.NewDumpSink("myApp", 200, loggertesthelper.Logger(), time.Second, make(chan int64))
+		testDump := dump.NewDumpSink("myApp", 200, loggertesthelper.Logger(), time.Second, make(chan int64), nil)
 
 		dumpRunnerDone := make(chan struct{})
 		inputChan := make(chan *events.Envelope)
@@ -302,7 +304,7 @@ var _ = Describe("Dump Sink", func() {
 	})
 
 	It("works with lots of messages and large buffer2", func() {
-		testDump := dump.NewDumpSink("myApp", 200, loggertesthelper.Logger(), time.Second, make(chan int64))
+		testDump := dump.NewDumpSink("myApp", 200, loggertesthelper.Logger(), time.Second, make(chan int64), nil)
 		dumpRunnerDone := make(chan struct{})
 		inputChan := make(chan *events.Envelope)
 
@@ -368,7 +370,7 @@ var _ = Describe("Dump Sink", func() {
 
 	It("works with lots of dumps", func() {
 		runtime.GOMAXPROCS(runtime.NumCPU())
-		testDump := dump.NewDumpSink("myApp", 5, loggertesthelper.Logger(), time.Second, make(chan int64))
+		testDump := dump.NewDumpSink("myApp", 5, loggertesthelper.Logger(), time.Second, make(chan int64), nil)
 		dumpRunnerDone := make(chan struct{})
 		inputChan := make(chan *events.Envelope)
 
@@ -397,7 +399,7 @@ var _ = Describe("Dump Sink", func() {
 	})
 
 	It("closes itself after period of inactivity", func() {
-		testDump := dump.NewDumpSink("myApp", 5, loggertesthelper.Logger(), 2*time.Microsecond, make(chan int64))
+		testDump := dump.NewDumpSink("myApp", 5, loggertesthelper.Logger(), 2*time.Microsecond, make(chan int64), nil)
 		dumpRunnerDone := make(chan struct{})
 		inputChan := make(chan *events.Envelope)
 
@@ -410,7 +412,7 @@ var _ = Describe("Dump Sink", func() {
 	})
 
 	It("closes after input chan is closed", func() {
-		testDump := dump.NewDumpSink("myApp", 5, loggertesthelper.Logger(), 2*time.Microsecond, make(chan int64))
+		testDump := dump.NewDumpSink("myApp", 5, loggertesthelper.Logger(), 2*time.Microsecond, make(chan int64), nil)
 		dumpRunnerDone := make(chan struct{})
 		inputChan := make(chan *events.Envelope)
 
@@ -426,7 +428,7 @@ var _ = Describe("Dump Sink", func() {
 
 	It("resets the inactivity duration when a metric is received", func() {
 		inactivityDuration := 1 * time.Millisecond
-		testDump := dump.NewDumpSink("myApp", 5, loggertesthelper.Logger(), inactivityDuration, make(chan int64))
+		testDump := dump.NewDumpSink("myApp", 5, loggertesthelper.Logger(), inactivityDuration, make(chan int64), nil)
 		dumpRunnerDone := make(chan struct{})
 		inputChan := make(chan *events.Envelope)
 
@@ -441,7 +443,7 @@ var _ = Describe("Dump Sink", func() {
 	})
 
 	It("only stores log messages", func() {
-		testDump := dump.NewDumpSink("myApp", 5, loggertesthelper.Logger(), 2*time.Second, make(chan int64))
+		testDump := dump.NewDumpSink("myApp", 5, loggertesthelper.Logger(), 2*time.Second, make(chan int64), nil)
 
 		dumpRunnerDone := make(chan struct{})
 		inputChan := make(chan *events.Envelope, 5)
@@ -467,14 +469,91 @@ var _ = Describe("Dump Sink", func() {
 		Expect(testDump.Dump()).To(HaveLen(1))
 	})
 
+	Context("with a store", func() {
+		It("writes log messages through to the store", func() {
+			store := &fakeStore{}
+			testDump := dump.NewDumpSink("myApp", 5, loggertesthelper.Logger(), time.Second, make(chan int64), store)
+
+			dumpRunnerDone := make(chan struct{})
+			inputChan := make(chan *events.Envelope)
+
+			go func() {
+				testDump.Run(inputChan)
+				close(dumpRunnerDone)
+			}()
+
+			logMessage, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "hi", "appId", "App"), "origin")
+			inputChan <- logMessage
+
+			close(inputChan)
+			<-dumpRunnerDone
+
+			Expect(store.appended).To(HaveLen(1))
+			Expect(store.appended[0]).To(Equal(logMessage))
+		})
+
+		It("dumps the contents of the store", func() {
+			stored, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "from disk", "appId", "App"), "origin")
+			store := &fakeStore{appended: []*events.Envelope{stored}}
+			testDump := dump.NewDumpSink("myApp", 5, loggertesthelper.Logger(), time.Second, make(chan int64), store)
+
+			logMessages := testDump.Dump()
+			Expect(logMessages).To(HaveLen(1))
+			Expect(string(logMessages[0].GetLogMessage().GetMessage())).To(Equal("from disk"))
+		})
+
+		It("falls back to the in-memory messages when the store fails", func() {
+			store := &fakeStore{readError: errors.New("disk on fire")}
+			testDump := dump.NewDumpSink("myApp", 5, loggertesthelper.Logger(), time.Second, make(chan int64), store)
+
+			dumpRunnerDone := make(chan struct{})
+			inputChan := make(chan *events.Envelope)
+
+			go func() {
+				testDump.Run(inputChan)
+				close(dumpRunnerDone)
+			}()
+
+			logMessage, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "hi", "appId", "App"), "origin")
+			inputChan <- logMessage
+
+			close(inputChan)
+			<-dumpRunnerDone
+
+			Expect(testDump.Dump()).To(HaveLen(1))
+		})
+	})
+
 	It("creates dropped message count metrics", func() {
 		updateChan := make(chan int64, 1)
-		testDump := dump.NewDumpSink("myApp", 5, loggertesthelper.Logger(), 2*time.Second, updateChan)
+		testDump := dump.NewDumpSink("myApp", 5, loggertesthelper.Logger(), 2*time.Second, updateChan, nil)
 		testDump.UpdateDroppedMessageCount(2)
 		Eventually(updateChan).Should(Receive(Equal(int64(2))))
 	})
 })
 
+type fakeStore struct {
+	appended  []*events.Envelope
+	readError error
+	sync.Mutex
+}
+
+func (s *fakeStore) Append(appId string, envelope *events.Envelope) error {
+	s.Lock()
+	defer s.Unlock()
+	s.appended = append(s.appended, envelope)
+	return nil
+}
+
+func (s *fakeStore) Read(appId string) ([]*events.Envelope, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.readError != nil {
+		return nil, s.readError
+	}
+	return s.appended, nil
+}
+
 func continuouslySend(inputChan chan<- *events.Envelope, message *events.Envelope, duration time.Duration) {
 	timer := time.NewTimer(duration)
 	defer timer.Stop()
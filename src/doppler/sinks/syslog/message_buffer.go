@@ -0,0 +1,166 @@
+package syslog
+
+import (
+	"doppler/truncatingbuffer"
+
+	"github.com/cloudfoundry/dropsonde/events"
+	"github.com/cloudfoundry/gosteno"
+)
+
+type RetryQueue interface {
+	Push(*events.Envelope) error
+	Peek() (*events.Envelope, error)
+	Pop()
+	Len() int
+	DroppedMessageCount() int64
+	Close() error
+}
+
+// messageBuffer holds the messages of a sink until they are sent. next
+// returns the message to send, and keeps returning it until sent is called
+// for buffers that retry failed messages.
+type messageBuffer interface {
+	next(done <-chan struct{}) (*events.Envelope, bool)
+	sent()
+	droppedMessageCount() int64
+}
+
+type truncatingMessageBuffer struct {
+	buffer *truncatingbuffer.TruncatingBuffer
+}
+
+func (b *truncatingMessageBuffer) next(done <-chan struct{}) (*events.Envelope, bool) {
+	select {
+	case <-done:
+		return nil, false
+	case envelope, ok := <-b.buffer.GetOutputChannel():
+		return envelope, ok
+	}
+}
+
+func (b *truncatingMessageBuffer) sent() {}
+
+func (b *truncatingMessageBuffer) droppedMessageCount() int64 {
+	return b.buffer.GetDroppedMessageCount()
+}
+
+// spillingMessageBuffer keeps messages in memory while the drain keeps up and
+// spills them to the retry queue once the memory buffer is full. Everything in
+// memory is older than anything in the queue, so memory is drained first.
+type spillingMessageBuffer struct {
+	memory  chan *events.Envelope
+	queue   RetryQueue
+	spilled chan struct{}
+	logger  *gosteno.Logger
+
+	current          *events.Envelope
+	currentFromQueue bool
+	inputClosed      bool
+}
+
+func newSpillingMessageBuffer(input <-chan *events.Envelope, size uint, queue RetryQueue, done <-chan struct{}, logger *gosteno.Logger) *spillingMessageBuffer {
+	b := &spillingMessageBuffer{
+		memory:  make(chan *events.Envelope, size),
+		queue:   queue,
+		spilled: make(chan struct{}, 1),
+		logger:  logger,
+	}
+
+	go b.run(input, done)
+	return b
+}
+
+// run stops once the sink is done, as the sink closes the queue then.
+func (b *spillingMessageBuffer) run(input <-chan *events.Envelope, done <-chan struct{}) {
+	defer close(b.memory)
+
+	for {
+		var envelope *events.Envelope
+		var ok bool
+		select {
+		case <-done:
+			return
+		case envelope, ok = <-input:
+			if !ok {
+				return
+			}
+		}
+
+		if b.queue.Len() == 0 {
+			select {
+			case b.memory <- envelope:
+				continue
+			default:
+			}
+		}
+
+		err := b.queue.Push(envelope)
+		if err != nil {
+			b.logger.Warnf("Syslog Sink: Error spilling message to the retry queue: %v", err)
+			continue
+		}
+
+		select {
+		case b.spilled <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (b *spillingMessageBuffer) next(done <-chan struct{}) (*events.Envelope, bool) {
+	if b.current != nil {
+		return b.current, true
+	}
+
+	for {
+		if !b.inputClosed {
+			select {
+			case envelope, ok := <-b.memory:
+				if ok {
+					b.current = envelope
+					return envelope, true
+				}
+				b.inputClosed = true
+			default:
+			}
+		}
+
+		envelope, err := b.queue.Peek()
+		if err != nil {
+			return nil, false
+		}
+		if envelope != nil {
+			b.current = envelope
+			b.currentFromQueue = true
+			return envelope, true
+		}
+
+		if b.inputClosed {
+			return nil, false
+		}
+
+		select {
+		case <-done:
+			return nil, false
+		case envelope, ok := <-b.memory:
+			if ok {
+				b.current = envelope
+				return envelope, true
+			}
+			b.inputClosed = true
+		case <-b.spilled:
+		}
+	}
+}
+
+func (b *spillingMessageBuffer) sent() {
+	if b.currentFromQueue {
+		b.queue.Pop()
+	}
+	b.current = nil
+	b.currentFromQueue = false
+}
+
+func (b *spillingMessageBuffer) droppedMessageCount() int64 {
+	return b.queue.DroppedMessageCount()
+}
@@ -16,6 +16,7 @@ const (
 	dial_error_debug_string = "Syslog Sink %s: Error when dialing out. Backing off for %v. Err: %v"
 	dialing_debug_string    = "Syslog Sink %s: Not connected. Trying to connect."
 	starting_loop_debug     = "Syslog Sink %s: Starting loop. Current backoff: %v"
+	certificate_error       = "Syslog Sink %s: TLS certificate verification failed. Err: %v"
 )
 
 type SyslogSink struct {
@@ -31,9 +32,10 @@ type SyslogSink struct {
 	dropsondeOrigin     string
 	disconnectOnce      sync.Once
 	metricUpdateChannel chan<- int64
+	retryQueue          RetryQueue
 }
 
-func NewSyslogSink(appId string, drainUrl string, givenLogger *gosteno.Logger, syslogWriter syslogwriter.Writer, errorHandler func(string, string, string), dropsondeOrigin string, metricUpdateChannel chan<- int64) *SyslogSink {
+func NewSyslogSink(appId string, drainUrl string, givenLogger *gosteno.Logger, syslogWriter syslogwriter.Writer, errorHandler func(string, string, string), dropsondeOrigin string, metricUpdateChannel chan<- int64, retryQueue RetryQueue) *SyslogSink {
 	givenLogger.Debugf("Syslog Sink %s: Created for appId [%s]", drainUrl, appId)
 	return &SyslogSink{
 		appId:               appId,
@@ -44,6 +46,7 @@ func NewSyslogSink(appId string, drainUrl string, givenLogger *gosteno.Logger, s
 		disconnectChannel:   make(chan struct{}),
 		dropsondeOrigin:     dropsondeOrigin,
 		metricUpdateChannel: metricUpdateChannel,
+		retryQueue:          retryQueue,
 	}
 }
 
@@ -73,14 +76,24 @@ func (s *SyslogSink) Run(inputChan <-chan *events.Envelope) {
 					continue
 				}
 
-				filteredChan <- v
+				select {
+				case filteredChan <- v:
+				case <-s.disconnectChannel:
+					return
+				}
 			case <-s.disconnectChannel:
 				return
 			}
 		}
 	}()
 
-	buffer := sinks.RunTruncatingBuffer(filteredChan, 100, s.Logger, s.dropsondeOrigin)
+	var buffer messageBuffer
+	if s.retryQueue != nil {
+		defer s.retryQueue.Close()
+		buffer = newSpillingMessageBuffer(filteredChan, 100, s.retryQueue, s.disconnectChannel, s.Logger)
+	} else {
+		buffer = &truncatingMessageBuffer{buffer: sinks.RunTruncatingBuffer(filteredChan, 100, s.Logger, s.dropsondeOrigin)}
+	}
 	timer := time.NewTimer(backoffStrategy(numberOfTries))
 	connected := false
 	defer timer.Stop()
@@ -100,6 +113,9 @@ func (s *SyslogSink) Run(inputChan <-chan *events.Envelope) {
 			if err != nil {
 				numberOfTries++
 				errorMsg := fmt.Sprintf(dial_error_debug_string, s.drainUrl, backoffStrategy(numberOfTries), err)
+				if syslogwriter.IsCertificateError(err) {
+					errorMsg = fmt.Sprintf(certificate_error, s.drainUrl, err)
+				}
 
 				s.handleSendError(errorMsg, s.appId, s.drainUrl)
 				continue
@@ -111,28 +127,24 @@ func (s *SyslogSink) Run(inputChan <-chan *events.Envelope) {
 
 		s.Debugf("Syslog Sink %s: Waiting for activity\n", s.drainUrl)
 
-		select {
-		case <-s.disconnectChannel:
-			return
-		case messageEnvelope, ok := <-buffer.GetOutputChannel():
-			droppedMessages := buffer.GetDroppedMessageCount()
-			if droppedMessages != 0 {
-				s.UpdateDroppedMessageCount(droppedMessages)
-
-			}
-
-			if !ok {
-				s.Debugf("Syslog Sink %s: Closed listener channel detected. Closing.\n", s.drainUrl)
-				return
-			}
-			s.Debugf("Syslog Sink:Run: Received %s message from %s at %d. Sending data.", messageEnvelope.GetEventType().String(), messageEnvelope.GetOrigin(), messageEnvelope.Timestamp)
+		messageEnvelope, ok := buffer.next(s.disconnectChannel)
+		droppedMessages := buffer.droppedMessageCount()
+		if droppedMessages != 0 {
+			s.UpdateDroppedMessageCount(droppedMessages)
+		}
 
-			connected = s.sendMessage(messageEnvelope)
-			if connected {
-				numberOfTries = 0
-			} else {
-				numberOfTries++
-			}
+		if !ok {
+			s.Debugf("Syslog Sink %s: Closed listener channel detected. Closing.\n", s.drainUrl)
+			return
+		}
+		s.Debugf("Syslog Sink:Run: Received %s message from %s at %d. Sending data.", messageEnvelope.GetEventType().String(), messageEnvelope.GetOrigin(), messageEnvelope.Timestamp)
+
+		connected = s.sendMessage(messageEnvelope)
+		if connected {
+			buffer.sent()
+			numberOfTries = 0
+		} else {
+			numberOfTries++
 		}
 	}
 }
@@ -160,6 +172,9 @@ func (s *SyslogSink) sendMessage(messageEnvelope *events.Envelope) bool {
 
 	if err != nil {
 		s.Debugf("Syslog Sink %s: Error when trying to send data to sink. Backing off. Err: %v\n", s.drainUrl, err)
+		if syslogwriter.IsCertificateError(err) {
+			s.handleSendError(fmt.Sprintf(certificate_error, s.drainUrl, err), s.appId, s.drainUrl)
+		}
 		return false
 	} else {
 		s.Debugf("Syslog Sink %s: Successfully sent data\n", s.drainUrl)
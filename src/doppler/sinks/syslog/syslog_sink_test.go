@@ -1,10 +1,15 @@
 package syslog_test
 
 import (
+	"crypto/x509"
+	"doppler/diskqueue"
 	"doppler/sinks/syslog"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cloudfoundry/dropsonde/emitter"
@@ -61,7 +66,7 @@ var _ = Describe("SyslogSink", func() {
 		}
 
 		updateMetricChan = make(chan int64, 1)
-		syslogSink = syslog.NewSyslogSink("appId", "syslog://using-fake", loggertesthelper.Logger(), sysLogger, errorHandler, "dropsonde-origin", updateMetricChan)
+		syslogSink = syslog.NewSyslogSink("appId", "syslog://using-fake", loggertesthelper.Logger(), sysLogger, errorHandler, "dropsonde-origin", updateMetricChan, nil)
 	})
 
 	AfterEach(func() {
@@ -242,6 +247,120 @@ var _ = Describe("SyslogSink", func() {
 		})
 	})
 
+	Context("when the drain certificate fails verification", func() {
+		BeforeEach(func() {
+			sysLogger.SetWriteError(x509.UnknownAuthorityError{})
+			go func() {
+				syslogSink.Run(inputChan)
+				closeSysLoggerDoneChan()
+			}()
+		})
+
+		It("reports the verification failure to the app", func(done Done) {
+			logMessage, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "test message", "appId", "App"), "origin")
+			inputChan <- logMessage
+
+			errorLog := <-errorChannel
+			errorMsg := string(errorLog.GetLogMessage().GetMessage())
+			Expect(errorMsg).To(ContainSubstring("Syslog Sink syslog://using-fake: TLS certificate verification failed."))
+			Expect(errorLog.GetLogMessage().GetSourceType()).To(Equal("LGR"))
+			close(done)
+		})
+	})
+
+	Context("with a retry queue", func() {
+		var (
+			queueDir string
+			queue    *diskqueue.Queue
+		)
+
+		BeforeEach(func() {
+			var err error
+			queueDir, err = ioutil.TempDir("", "syslog-retry-queue")
+			Expect(err).NotTo(HaveOccurred())
+
+			queues, err := diskqueue.NewQueues(queueDir, 1024*1024, time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			queue, err = queues.Open("appId", "syslog://using-fake")
+			Expect(err).NotTo(HaveOccurred())
+
+			syslogSink = syslog.NewSyslogSink("appId", "syslog://using-fake", loggertesthelper.Logger(), sysLogger, errorHandler, "dropsonde-origin", updateMetricChan, queue)
+			sysLogger.SetDown(true)
+			go func() {
+				syslogSink.Run(inputChan)
+				closeSysLoggerDoneChan()
+			}()
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(queueDir)
+		})
+
+		It("replays every message in order once the drain comes back", func(done Done) {
+			for i := 0; i < 150; i++ {
+				logMessage, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, fmt.Sprintf("message no %v", i), "appId", "App"), "origin")
+				inputChan <- logMessage
+			}
+			close(inputChan)
+
+			sysLogger.SetDown(false)
+			<-sysLoggerDoneChan
+
+			data := sysLogger.ReceivedMessages()
+			Expect(data).To(HaveLen(150))
+			for i := 0; i < 150; i++ {
+				Expect(data[i]).To(MatchRegexp(fmt.Sprintf("<14>1 message no %v ts", i)))
+			}
+			Expect(updateMetricChan).NotTo(Receive())
+			close(done)
+		}, 10)
+
+		It("retries a message that failed to send", func(done Done) {
+			sysLogger.SetDown(false)
+			sysLogger.SetWriteError(errors.New("write failed"))
+
+			logMessage, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "retried message", "appId", "App"), "origin")
+			inputChan <- logMessage
+
+			Consistently(sysLogger.receivedChannel, 100*time.Millisecond).ShouldNot(Receive())
+			sysLogger.SetWriteError(nil)
+
+			Eventually(sysLogger.receivedChannel).Should(Receive(ContainSubstring("retried message")))
+			close(done)
+		}, 10)
+
+		It("stops spilling to the queue once disconnected", func(done Done) {
+			recordingQueue := &closeRecordingQueue{RetryQueue: queue}
+			downLogger := NewSyslogWriterRecorder()
+			downLogger.SetDown(true)
+			sink := syslog.NewSyslogSink("appId", "syslog://using-fake", loggertesthelper.Logger(), downLogger, errorHandler, "dropsonde-origin", updateMetricChan, recordingQueue)
+			input := make(chan *events.Envelope)
+			sinkDone := make(chan struct{})
+			go func() {
+				sink.Run(input)
+				close(sinkDone)
+			}()
+
+			go func() {
+				for {
+					logMessage, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "message", "appId", "App"), "origin")
+					select {
+					case input <- logMessage:
+					case <-sinkDone:
+						return
+					}
+				}
+			}()
+
+			Eventually(recordingQueue.Len).Should(BeNumerically(">", 0))
+			sink.Disconnect()
+			<-sinkDone
+
+			Consistently(recordingQueue.pushesAfterClose).Should(BeZero())
+			close(done)
+		}, 10)
+	})
+
 	Describe("Disconnect", func() {
 		It("is idempotent", func() {
 			syslogSink.Disconnect()
@@ -257,11 +376,34 @@ var _ = Describe("SyslogSink", func() {
 	})
 })
 
+type closeRecordingQueue struct {
+	syslog.RetryQueue
+	closed          int32
+	closedPushCount int32
+}
+
+func (q *closeRecordingQueue) Push(envelope *events.Envelope) error {
+	if atomic.LoadInt32(&q.closed) == 1 {
+		atomic.AddInt32(&q.closedPushCount, 1)
+	}
+	return q.RetryQueue.Push(envelope)
+}
+
+func (q *closeRecordingQueue) Close() error {
+	atomic.StoreInt32(&q.closed, 1)
+	return q.RetryQueue.Close()
+}
+
+func (q *closeRecordingQueue) pushesAfterClose() int32 {
+	return atomic.LoadInt32(&q.closedPushCount)
+}
+
 type SyslogWriterRecorder struct {
 	receivedChannel  chan string
 	receivedMessages []string
 	down             bool
 	connected        bool
+	writeError       error
 	sync.Mutex
 }
 
@@ -292,6 +434,10 @@ func (r *SyslogWriterRecorder) Write(p int, b []byte, source, sourceId string, t
 		return 0, errors.New("Error writing to stdout.")
 	}
 
+	if r.writeError != nil {
+		return 0, r.writeError
+	}
+
 	messageString := fmt.Sprintf("<%d>1 %s ts: %d src: %s srcId: %s", p, string(b), timestamp, source, sourceId)
 	r.receivedMessages = append(r.receivedMessages, messageString)
 	r.receivedChannel <- messageString
@@ -305,6 +451,13 @@ func (r *SyslogWriterRecorder) SetDown(newState bool) {
 	r.down = newState
 }
 
+func (r *SyslogWriterRecorder) SetWriteError(err error) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.writeError = err
+}
+
 func (r *SyslogWriterRecorder) IsConnected() bool {
 	r.Lock()
 	defer r.Unlock()
@@ -0,0 +1,22 @@
+package syslogwriter
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+type framing func(syslogMsg string) []byte
+
+// Octet Counting: https://tools.ietf.org/html/rfc6587#section-3.4.1
+func octetCountingFraming(syslogMsg string) []byte {
+	return []byte(fmt.Sprintf("%d %s", len(syslogMsg), syslogMsg))
+}
+
+// Non-Transparent-Framing: https://tools.ietf.org/html/rfc6587#section-3.4.2
+// The trailing LF ends the frame, so line breaks within the message are
+// replaced with spaces.
+func nonTransparentFraming(syslogMsg string) []byte {
+	syslogMsg = strings.TrimSuffix(syslogMsg, "\n")
+	return append(bytes.Replace([]byte(syslogMsg), newLine, []byte(" "), -1), '\n')
+}
@@ -0,0 +1,78 @@
+package syslogwriter
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultBatchMaxBytes      = 256 * 1024
+	defaultBatchFlushInterval = time.Second
+	maxBatchRetries           = 10
+)
+
+// HttpsBatchOptions control how many RFC 5424 lines an https drain receives
+// per request. With MaxMessages at 1 every message is posted on its own.
+type HttpsBatchOptions struct {
+	MaxBytes      int
+	MaxMessages   int
+	FlushInterval time.Duration
+	Gzip          bool
+}
+
+func (o HttpsBatchOptions) withDefaults() HttpsBatchOptions {
+	if o.MaxBytes <= 0 {
+		o.MaxBytes = defaultBatchMaxBytes
+	}
+	if o.MaxMessages <= 0 {
+		o.MaxMessages = 1
+	}
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = defaultBatchFlushInterval
+	}
+	return o
+}
+
+func (o HttpsBatchOptions) batching() bool {
+	return o.MaxMessages > 1
+}
+
+// parseHttpsBatchOptions overrides the defaults with the drain-batch-max-bytes,
+// drain-batch-max-messages, drain-batch-flush-interval and drain-gzip
+// parameters of a drain url.
+func parseHttpsBatchOptions(values url.Values, defaults HttpsBatchOptions) (HttpsBatchOptions, error) {
+	options := defaults
+	var err error
+
+	if value := values.Get("drain-batch-max-bytes"); value != "" {
+		options.MaxBytes, err = strconv.Atoi(value)
+		if err != nil || options.MaxBytes <= 0 {
+			return options, fmt.Errorf("invalid drain-batch-max-bytes %q", value)
+		}
+	}
+
+	if value := values.Get("drain-batch-max-messages"); value != "" {
+		options.MaxMessages, err = strconv.Atoi(value)
+		if err != nil || options.MaxMessages <= 0 {
+			return options, fmt.Errorf("invalid drain-batch-max-messages %q", value)
+		}
+	}
+
+	if value := values.Get("drain-batch-flush-interval"); value != "" {
+		options.FlushInterval, err = time.ParseDuration(value)
+		if err != nil || options.FlushInterval <= 0 {
+			return options, fmt.Errorf("invalid drain-batch-flush-interval %q", value)
+		}
+	}
+
+	if value := values.Get("drain-gzip"); value != "" {
+		options.Gzip, err = strconv.ParseBool(value)
+		if err != nil {
+			return options, fmt.Errorf("invalid drain-gzip %q", value)
+		}
+	}
+
+	return options.withDefaults(), nil
+}
@@ -6,61 +6,254 @@
 package syslogwriter
 
 import (
+	"bytes"
+	"compress/gzip"
 	"crypto/tls"
+	"doppler/sinks/retrystrategy"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
-	"strings"
 	"sync"
+	"time"
 )
 
 type httpsWriter struct {
-	appId     string
-	outputUrl *url.URL
+	format        *messageFormat
+	outputUrl     *url.URL
+	postUrl       string
+	batch         HttpsBatchOptions
+	retryStrategy retrystrategy.RetryStrategy
 
-	mu sync.Mutex // guards conn
+	mu           sync.Mutex // guards pending, pendingCount, flushErr and done
+	pending      bytes.Buffer
+	pendingCount int
+	flushErr     error
+	done         chan struct{}
 
 	tlsConfig *tls.Config
 	client    *http.Client
 }
 
-func NewHttpsWriter(outputUrl *url.URL, appId string, skipCertVerify bool) (w *httpsWriter, err error) {
+func NewHttpsWriter(outputUrl *url.URL, appId string, options WriterOptions) (w *httpsWriter, err error) {
 	if outputUrl.Scheme != "https" {
 		return nil, errors.New(fmt.Sprintf("Invalid scheme %s, httpsWriter only supports https", outputUrl.Scheme))
 	}
-	tlsConfig := &tls.Config{InsecureSkipVerify: skipCertVerify}
+	batch, err := parseHttpsBatchOptions(outputUrl.Query(), options.HttpsBatch)
+	if err != nil {
+		return nil, err
+	}
+	format, err := newMessageFormat(outputUrl, appId)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := newTLSConfig(outputUrl, options)
+	if err != nil {
+		return nil, err
+	}
 	tr := &http.Transport{TLSClientConfig: tlsConfig}
 	client := &http.Client{Transport: tr}
 	return &httpsWriter{
-		appId:     appId,
-		outputUrl: outputUrl,
-		tlsConfig: tlsConfig,
-		client:    client,
+		format:        format,
+		outputUrl:     outputUrl,
+		postUrl:       withoutDrainParams(outputUrl).String(),
+		batch:         batch,
+		retryStrategy: retrystrategy.NewExponentialRetryStrategy(),
+		tlsConfig:     tlsConfig,
+		client:        client,
 	}, nil
 }
 
 func (w *httpsWriter) Connect() error {
+	if !w.batch.batching() {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.done == nil {
+		w.done = make(chan struct{})
+		go w.flushPeriodically(w.done)
+	}
 	return nil
 }
 
+// Write queues the message into the pending batch and posts the batch once it
+// is full. An error means the message was neither delivered nor queued, so
+// the sink can back off and write it again without duplicating it. A batch
+// whose retries all failed is queued again in front of the pending messages,
+// messages Write accepted are only lost when the writer is closed. Batches
+// are posted without holding w.mu, so retries don't keep messages from being
+// queued.
 func (w *httpsWriter) Write(p int, b []byte, source string, sourceId string, timestamp int64) (int, error) {
-	syslogMsg := createMessage(p, w.appId, source, sourceId, b, timestamp)
-	return w.writeHttp(syslogMsg)
+	syslogMsg := createMessage(p, w.format, source, sourceId, b, timestamp)
+	if !w.batch.batching() {
+		return w.writeHttp(syslogMsg)
+	}
+
+	w.mu.Lock()
+
+	// refuse the message after a failed periodic flush so that the sink
+	// backs off
+	if err := w.flushErr; err != nil {
+		w.flushErr = nil
+		w.mu.Unlock()
+		return 0, err
+	}
+
+	if w.pendingCount > 0 && w.pending.Len()+len(syslogMsg) > w.batch.MaxBytes {
+		full := w.takePending()
+		w.mu.Unlock()
+
+		if err := w.post(full.data, maxBatchRetries); err != nil {
+			w.requeue(full)
+			return 0, err
+		}
+		w.mu.Lock()
+	}
+
+	if w.pendingCount+1 < w.batch.MaxMessages && w.pending.Len()+len(syslogMsg) < w.batch.MaxBytes {
+		w.pending.WriteString(syslogMsg)
+		w.pendingCount++
+		w.mu.Unlock()
+		return len(syslogMsg), nil
+	}
+
+	// the message completes the batch, it is posted along with the pending
+	// messages but only those are queued again when posting fails
+	full := w.takePending()
+	w.mu.Unlock()
+
+	if err := w.post(append(full.data, syslogMsg...), maxBatchRetries); err != nil {
+		w.requeue(full)
+		return 0, err
+	}
+	return len(syslogMsg), nil
 }
 
 func (w *httpsWriter) Close() error {
-	return nil
+	if !w.batch.batching() {
+		return nil
+	}
+
+	w.mu.Lock()
+	if w.done != nil {
+		close(w.done)
+		w.done = nil
+	}
+	full := w.takePending()
+	w.mu.Unlock()
+
+	if full.count == 0 {
+		return nil
+	}
+	return w.post(full.data, 0)
+}
+
+func (w *httpsWriter) flushPeriodically(done <-chan struct{}) {
+	ticker := time.NewTicker(w.batch.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			full := w.takePending()
+			w.mu.Unlock()
+
+			if full.count == 0 {
+				continue
+			}
+
+			if err := w.post(full.data, maxBatchRetries); err != nil {
+				w.requeue(full)
+				w.mu.Lock()
+				w.flushErr = err
+				w.mu.Unlock()
+			}
+		}
+	}
+}
+
+type pendingBatch struct {
+	data  []byte
+	count int
+}
+
+// takePending returns the pending batch and starts a new one. Callers must
+// hold w.mu.
+func (w *httpsWriter) takePending() pendingBatch {
+	full := pendingBatch{data: make([]byte, w.pending.Len()), count: w.pendingCount}
+	copy(full.data, w.pending.Bytes())
+	w.pending.Reset()
+	w.pendingCount = 0
+	return full
+}
+
+// requeue puts a batch that could not be posted back in front of the messages
+// queued meanwhile.
+func (w *httpsWriter) requeue(batch pendingBatch) {
+	if batch.count == 0 {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	queued := w.pending.String()
+	w.pending.Reset()
+	w.pending.Write(batch.data)
+	w.pending.WriteString(queued)
+	w.pendingCount += batch.count
+}
+
+// post posts the batch, retrying it with backoff.
+func (w *httpsWriter) post(batch []byte, retries int) error {
+	for attempt := 0; ; attempt++ {
+		err := w.postOnce(batch)
+		if err == nil || attempt == retries {
+			return err
+		}
+		time.Sleep(w.retryStrategy(attempt + 1))
+	}
 }
 
 func (w *httpsWriter) writeHttp(finalMsg string) (byteCount int, err error) {
-	resp, err := w.client.Post(w.outputUrl.String(), "text/plain", strings.NewReader(finalMsg))
+	err = w.postOnce([]byte(finalMsg))
+	byteCount = len(finalMsg)
+	return byteCount, err
+}
+
+func (w *httpsWriter) postOnce(body []byte) error {
+	var encoding string
+	if w.batch.Gzip {
+		var compressed bytes.Buffer
+		gzipWriter := gzip.NewWriter(&compressed)
+		gzipWriter.Write(body)
+		gzipWriter.Close()
+
+		body = compressed.Bytes()
+		encoding = "gzip"
+	}
+
+	req, err := http.NewRequest("POST", w.postUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+
+	resp, err := w.client.Do(req)
 	if resp != nil {
 		if resp.StatusCode != 200 {
 			err = errors.New("Syslog Writer: Post responded with a non 200 status code")
 		}
 		resp.Body.Close()
 	}
-	byteCount = len(finalMsg)
-	return byteCount, err
+	return err
 }
@@ -1,11 +1,17 @@
 package syslogwriter_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
 	"doppler/sinks/syslogwriter"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"runtime"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -33,7 +39,7 @@ var _ = Describe("HttpsWriter", func() {
 		It("HTTP POSTs each log message to the HTTPS syslog endpoint", func() {
 			outputUrl, _ := url.Parse(server.URL + "/234-bxg-234/")
 
-			w, _ := syslogwriter.NewHttpsWriter(outputUrl, "appId", true)
+			w, _ := syslogwriter.NewHttpsWriter(outputUrl, "appId", syslogwriter.WriterOptions{SkipCertVerify: true})
 			err := w.Connect()
 			Expect(err).ToNot(HaveOccurred())
 
@@ -50,7 +56,7 @@ var _ = Describe("HttpsWriter", func() {
 		It("returns an error when unable to HTTP POST the log message", func() {
 			outputUrl, _ := url.Parse("https://")
 
-			w, _ := syslogwriter.NewHttpsWriter(outputUrl, "appId", true)
+			w, _ := syslogwriter.NewHttpsWriter(outputUrl, "appId", syslogwriter.WriterOptions{SkipCertVerify: true})
 
 			_, err := w.Write(standardErrorPriority, []byte("Message"), "just a test", "TEST", time.Now().UnixNano())
 			Expect(err).To(HaveOccurred())
@@ -59,7 +65,7 @@ var _ = Describe("HttpsWriter", func() {
 		It("should close connections and return an error if status code returned is not 200", func() {
 			outputUrl, _ := url.Parse(server.URL + "/doesnotexist")
 
-			w, _ := syslogwriter.NewHttpsWriter(outputUrl, "appId", true)
+			w, _ := syslogwriter.NewHttpsWriter(outputUrl, "appId", syslogwriter.WriterOptions{SkipCertVerify: true})
 			err := w.Connect()
 			Expect(err).ToNot(HaveOccurred())
 
@@ -76,7 +82,7 @@ var _ = Describe("HttpsWriter", func() {
 		It("should not return error for response 200 status codes", func() {
 			outputUrl, _ := url.Parse(server.URL + "/234-bxg-234/")
 
-			w, _ := syslogwriter.NewHttpsWriter(outputUrl, "appId", true)
+			w, _ := syslogwriter.NewHttpsWriter(outputUrl, "appId", syslogwriter.WriterOptions{SkipCertVerify: true})
 			err := w.Connect()
 			Expect(err).ToNot(HaveOccurred())
 
@@ -88,18 +94,307 @@ var _ = Describe("HttpsWriter", func() {
 
 		It("returns an error for syslog-tls scheme", func() {
 			outputUrl, _ := url.Parse("syslog-tls://localhost")
-			_, err := syslogwriter.NewHttpsWriter(outputUrl, "appId", false)
+			_, err := syslogwriter.NewHttpsWriter(outputUrl, "appId", syslogwriter.WriterOptions{})
 			Expect(err).To(HaveOccurred())
 		})
 
 		It("returns an error for syslog scheme", func() {
 			outputUrl, _ := url.Parse("syslog://localhost")
-			_, err := syslogwriter.NewHttpsWriter(outputUrl, "appId", false)
+			_, err := syslogwriter.NewHttpsWriter(outputUrl, "appId", syslogwriter.WriterOptions{})
 			Expect(err).To(HaveOccurred())
 		})
 	})
 })
 
+var _ = Describe("HttpsWriter batching", func() {
+	var server *httptest.Server
+	var requests chan *receivedRequest
+	var failures int32
+	standardOutPriority := 14
+
+	BeforeEach(func() {
+		requests = make(chan *receivedRequest, 100)
+		atomic.StoreInt32(&failures, 0)
+		server = httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			r.Body.Close()
+			requests <- &receivedRequest{url: r.URL, header: r.Header, body: body}
+
+			if atomic.AddInt32(&failures, -1) >= 0 {
+				rw.WriteHeader(http.StatusInternalServerError)
+			}
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	newWriter := func(query string, defaults syslogwriter.HttpsBatchOptions) syslogwriter.Writer {
+		outputUrl, _ := url.Parse(server.URL + "/drain?" + query)
+		w, err := syslogwriter.NewHttpsWriter(outputUrl, "appId", syslogwriter.WriterOptions{SkipCertVerify: true, HttpsBatch: defaults})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w.Connect()).To(Succeed())
+		return w
+	}
+
+	write := func(w syslogwriter.Writer, message string) error {
+		_, err := w.Write(standardOutPriority, []byte(message), "App", "0", time.Now().UnixNano())
+		return err
+	}
+
+	It("posts a batch once it reaches the message cap", func() {
+		w := newWriter("drain-batch-max-messages=3&drain-batch-flush-interval=1h", syslogwriter.HttpsBatchOptions{})
+		defer w.Close()
+
+		Expect(write(w, "first")).To(Succeed())
+		Expect(write(w, "second")).To(Succeed())
+		Consistently(requests, 100*time.Millisecond).ShouldNot(Receive())
+
+		Expect(write(w, "third")).To(Succeed())
+
+		var request *receivedRequest
+		Eventually(requests).Should(Receive(&request))
+		lines := strings.Split(strings.TrimSuffix(string(request.body), "\n"), "\n")
+		Expect(lines).To(HaveLen(3))
+		Expect(lines[0]).To(HaveSuffix("- - first"))
+		Expect(lines[2]).To(HaveSuffix("- - third"))
+	})
+
+	It("does not forward the batch parameters to the drain", func() {
+		w := newWriter("drain-batch-max-messages=1&token=secret", syslogwriter.HttpsBatchOptions{})
+		defer w.Close()
+
+		Expect(write(w, "message")).To(Succeed())
+
+		var request *receivedRequest
+		Eventually(requests).Should(Receive(&request))
+		Expect(request.url.Query()).To(Equal(url.Values{"token": {"secret"}}))
+	})
+
+	It("does not forward the message format parameters to the drain", func() {
+		w := newWriter("drain-structured-data=true&drain-hostname=org.app&drain-org=my-org&drain-space=my-space&drain-app=my-app&token=secret", syslogwriter.HttpsBatchOptions{})
+		defer w.Close()
+
+		Expect(write(w, "message")).To(Succeed())
+
+		var request *receivedRequest
+		Eventually(requests).Should(Receive(&request))
+		Expect(request.url.Query()).To(Equal(url.Values{"token": {"secret"}}))
+		Expect(string(request.body)).To(ContainSubstring(` my-org.my-app appId `))
+	})
+
+	It("forwards parameters of the drain that look like doppler's", func() {
+		w := newWriter("drain-batch-max-messages=1&gzip=no&app=mine&ca=theirs", syslogwriter.HttpsBatchOptions{})
+		defer w.Close()
+
+		Expect(write(w, "message")).To(Succeed())
+
+		var request *receivedRequest
+		Eventually(requests).Should(Receive(&request))
+		Expect(request.url.Query()).To(Equal(url.Values{"gzip": {"no"}, "app": {"mine"}, "ca": {"theirs"}}))
+	})
+
+	It("posts a batch before it would exceed the byte cap", func() {
+		w := newWriter("drain-batch-max-messages=100&drain-batch-max-bytes=150&drain-batch-flush-interval=1h", syslogwriter.HttpsBatchOptions{})
+		defer w.Close()
+
+		Expect(write(w, "first")).To(Succeed())
+		Expect(write(w, "second")).To(Succeed())
+
+		var request *receivedRequest
+		Eventually(requests).Should(Receive(&request))
+		Expect(string(request.body)).To(HaveSuffix("- - first\n"))
+		Expect(len(request.body)).To(BeNumerically("<=", 150))
+	})
+
+	It("posts partial batches after the flush interval", func() {
+		w := newWriter("drain-batch-flush-interval=50ms", syslogwriter.HttpsBatchOptions{MaxMessages: 100})
+		defer w.Close()
+
+		Expect(write(w, "lonely")).To(Succeed())
+
+		var request *receivedRequest
+		Eventually(requests).Should(Receive(&request))
+		Expect(string(request.body)).To(HaveSuffix("- - lonely\n"))
+	})
+
+	It("posts pending messages on close", func() {
+		w := newWriter("drain-batch-max-messages=10&drain-batch-flush-interval=1h", syslogwriter.HttpsBatchOptions{})
+
+		Expect(write(w, "pending")).To(Succeed())
+		Expect(w.Close()).To(Succeed())
+
+		var request *receivedRequest
+		Eventually(requests).Should(Receive(&request))
+		Expect(string(request.body)).To(HaveSuffix("- - pending\n"))
+	})
+
+	It("gzips batches when asked to", func() {
+		w := newWriter("drain-batch-max-messages=2&drain-gzip=true", syslogwriter.HttpsBatchOptions{})
+		defer w.Close()
+
+		Expect(write(w, "first")).To(Succeed())
+		Expect(write(w, "second")).To(Succeed())
+
+		var request *receivedRequest
+		Eventually(requests).Should(Receive(&request))
+		Expect(request.header.Get("Content-Encoding")).To(Equal("gzip"))
+
+		reader, err := gzip.NewReader(bytes.NewReader(request.body))
+		Expect(err).ToNot(HaveOccurred())
+		body, err := ioutil.ReadAll(reader)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(body)).To(ContainSubstring("- - first\n"))
+		Expect(string(body)).To(ContainSubstring("- - second\n"))
+	})
+
+	It("retries the whole batch when the drain fails", func() {
+		atomic.StoreInt32(&failures, 2)
+		w := newWriter("drain-batch-max-messages=2", syslogwriter.HttpsBatchOptions{})
+		defer w.Close()
+
+		Expect(write(w, "first")).To(Succeed())
+		Expect(write(w, "second")).To(Succeed())
+
+		var bodies []string
+		for i := 0; i < 3; i++ {
+			var request *receivedRequest
+			Eventually(requests).Should(Receive(&request))
+			bodies = append(bodies, string(request.body))
+		}
+		Expect(bodies[1]).To(Equal(bodies[0]))
+		Expect(bodies[2]).To(Equal(bodies[0]))
+	})
+
+	It("keeps queueing messages while a periodic flush is retried", func() {
+		atomic.StoreInt32(&failures, 1000)
+		w := newWriter("drain-batch-flush-interval=20ms", syslogwriter.HttpsBatchOptions{MaxMessages: 100})
+		defer w.Close()
+
+		Expect(write(w, "first")).To(Succeed())
+		Eventually(requests).Should(Receive())
+
+		start := time.Now()
+		Expect(write(w, "second")).To(Succeed())
+		Expect(time.Since(start)).To(BeNumerically("<", 100*time.Millisecond))
+	})
+
+	It("returns an error once all retries of a batch failed", func() {
+		atomic.StoreInt32(&failures, 1000)
+		w := newWriter("drain-batch-max-messages=1", syslogwriter.HttpsBatchOptions{MaxMessages: 2})
+		defer w.Close()
+
+		Expect(write(w, "first")).To(HaveOccurred())
+	})
+
+	It("keeps the pending messages of a batch whose retries all failed", func() {
+		atomic.StoreInt32(&failures, 11)
+		w := newWriter("drain-batch-max-messages=2&drain-batch-flush-interval=1h", syslogwriter.HttpsBatchOptions{})
+		defer w.Close()
+
+		Expect(write(w, "first")).To(Succeed())
+		Expect(write(w, "second")).To(HaveOccurred())
+		for i := 0; i < 11; i++ {
+			Eventually(requests).Should(Receive())
+		}
+
+		Expect(write(w, "second")).To(Succeed())
+
+		var request *receivedRequest
+		Eventually(requests).Should(Receive(&request))
+		lines := strings.Split(strings.TrimSuffix(string(request.body), "\n"), "\n")
+		Expect(lines).To(HaveLen(2))
+		Expect(lines[0]).To(HaveSuffix("- - first"))
+		Expect(lines[1]).To(HaveSuffix("- - second"))
+	})
+
+	It("uses the defaults when the drain url does not override them", func() {
+		w := newWriter("", syslogwriter.HttpsBatchOptions{MaxMessages: 2, FlushInterval: time.Hour})
+		defer w.Close()
+
+		Expect(write(w, "first")).To(Succeed())
+		Consistently(requests, 100*time.Millisecond).ShouldNot(Receive())
+		Expect(write(w, "second")).To(Succeed())
+		Eventually(requests).Should(Receive())
+	})
+
+	It("rejects invalid batch parameters", func() {
+		for _, query := range []string{"drain-batch-max-messages=0", "drain-batch-max-bytes=lots", "drain-batch-flush-interval=5", "drain-gzip=maybe"} {
+			outputUrl, _ := url.Parse(server.URL + "/drain?" + query)
+			_, err := syslogwriter.NewHttpsWriter(outputUrl, "appId", syslogwriter.WriterOptions{})
+			Expect(err).To(HaveOccurred(), query)
+		}
+	})
+})
+
+var _ = Describe("HttpsWriter with a private CA", func() {
+	var server *httptest.Server
+	var requestChan chan []byte
+	standardOutPriority := 14
+
+	BeforeEach(func() {
+		requestChan = make(chan []byte, 10)
+		serverCert, err := tls.LoadX509KeyPair("fixtures/server.crt", "fixtures/server.key")
+		Expect(err).ToNot(HaveOccurred())
+
+		server = httptest.NewUnstartedServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			r.Body.Close()
+			requestChan <- body
+		}))
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{serverCert}}
+		server.StartTLS()
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("posts to drains signed by a trusted CA", func() {
+		caPool, err := syslogwriter.LoadCertPool("fixtures/ca.crt")
+		Expect(err).ToNot(HaveOccurred())
+		outputUrl, _ := url.Parse(server.URL + "/drain")
+
+		w, err := syslogwriter.NewHttpsWriter(outputUrl, "appId", syslogwriter.WriterOptions{RootCAs: caPool})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = w.Write(standardOutPriority, []byte("trusted"), "App", "0", time.Now().UnixNano())
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(requestChan).Should(Receive(ContainSubstring("trusted")))
+	})
+
+	It("trusts the CA given in the drain url", func() {
+		caPEM, _ := ioutil.ReadFile("fixtures/ca.crt")
+		outputUrl, _ := url.Parse(server.URL + "/drain?drain-ca=" + url.QueryEscape(string(caPEM)))
+
+		w, err := syslogwriter.NewHttpsWriter(outputUrl, "appId", syslogwriter.WriterOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = w.Write(standardOutPriority, []byte("trusted"), "App", "0", time.Now().UnixNano())
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(requestChan).Should(Receive(ContainSubstring("trusted")))
+	})
+
+	It("returns a certificate error when the CA is not trusted", func() {
+		outputUrl, _ := url.Parse(server.URL + "/drain")
+
+		w, err := syslogwriter.NewHttpsWriter(outputUrl, "appId", syslogwriter.WriterOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = w.Write(standardOutPriority, []byte("untrusted"), "App", "0", time.Now().UnixNano())
+		Expect(err).To(HaveOccurred())
+		Expect(syslogwriter.IsCertificateError(err)).To(BeTrue())
+		Consistently(requestChan).ShouldNot(Receive())
+	})
+})
+
+type receivedRequest struct {
+	url    *url.URL
+	header http.Header
+	body   []byte
+}
+
 func ServeHTTP(requestChan chan []byte) *httptest.Server {
 	handler := func(_ http.ResponseWriter, r *http.Request) {
 		bytes := make([]byte, 1024)
@@ -0,0 +1,159 @@
+package syslogwriter
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultHostname   = "loggregator"
+	maxHostnameLength = 255
+
+	// structuredDataId uses the private enterprise number of the Cloud Foundry
+	// Foundation, see https://tools.ietf.org/html/rfc5424#section-7.2.2
+	structuredDataId = "tags@47450"
+)
+
+var hostnameTokens = map[string]bool{
+	"org":   true,
+	"space": true,
+	"app":   true,
+	"guid":  true,
+}
+
+// messageFormat holds the per drain settings of the syslog header. The drain
+// url selects them with the drain-hostname and drain-structured-data
+// parameters.
+//
+// The org, space and app names come from the drain-org, drain-space and
+// drain-app parameters, which the syslog drain binder sets to the names the
+// Cloud Controller knows the app by. It removes the ones given by whoever
+// bound the drain, names missing because the binder could not look them up
+// are left out.
+//
+// The hostname template is a dot separated list of the tokens org, space, app
+// and guid, e.g. drain-hostname=org.space.app. Tokens whose name is unknown
+// are left out.
+type messageFormat struct {
+	appId          string
+	hostname       string
+	structuredData bool
+	org            string
+	space          string
+	app            string
+}
+
+func newMessageFormat(outputUrl *url.URL, appId string) (*messageFormat, error) {
+	values := outputUrl.Query()
+	format := &messageFormat{
+		appId:    appId,
+		hostname: defaultHostname,
+		org:      values.Get("drain-org"),
+		space:    values.Get("drain-space"),
+		app:      values.Get("drain-app"),
+	}
+
+	if value := values.Get("drain-structured-data"); value != "" {
+		var err error
+		format.structuredData, err = strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid drain-structured-data %q", value)
+		}
+	}
+
+	if template := values.Get("drain-hostname"); template != "" {
+		hostname, err := format.expandHostname(template)
+		if err != nil {
+			return nil, err
+		}
+		format.hostname = hostname
+	}
+
+	return format, nil
+}
+
+func (f *messageFormat) expandHostname(template string) (string, error) {
+	var parts []string
+	for _, token := range strings.Split(template, ".") {
+		if !hostnameTokens[token] {
+			return "", fmt.Errorf("invalid drain-hostname %q, must be a dot separated list of org, space, app and guid", template)
+		}
+
+		name := f.tokenValue(token)
+		if name != "" {
+			parts = append(parts, sanitizeHostname(name))
+		}
+	}
+
+	hostname := strings.Join(parts, ".")
+	if hostname == "" {
+		return defaultHostname, nil
+	}
+	if len(hostname) > maxHostnameLength {
+		hostname = hostname[:maxHostnameLength]
+	}
+	return hostname, nil
+}
+
+func (f *messageFormat) tokenValue(token string) string {
+	switch token {
+	case "org":
+		return f.org
+	case "space":
+		return f.space
+	case "app":
+		return f.app
+	default:
+		return f.appId
+	}
+}
+
+// sanitizeHostname replaces everything but letters, digits, '-' and '_' as
+// the hostname may only contain printable characters without spaces and the
+// dots separate the tokens.
+func sanitizeHostname(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '-'
+	}, name)
+}
+
+// structuredDataFor only names the instance for messages of the app itself,
+// the source id of other sources is not an app instance index.
+func (f *messageFormat) structuredDataFor(source, sourceId string) string {
+	if !f.structuredData {
+		return "-"
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString("[" + structuredDataId)
+	writeParam := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&buffer, ` %s="%s"`, name, escapeParamValue(value))
+		}
+	}
+	writeParam("org", f.org)
+	writeParam("space", f.space)
+	writeParam("app", f.app)
+	writeParam("app_id", f.appId)
+	if source == "App" {
+		writeParam("instance", sourceId)
+	}
+	buffer.WriteString("]")
+
+	return buffer.String()
+}
+
+var paramValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// escapeParamValue escapes the characters that are not allowed unescaped in
+// a PARAM-VALUE, see https://tools.ietf.org/html/rfc5424#section-6.3.3
+func escapeParamValue(value string) string {
+	return paramValueEscaper.Replace(value)
+}
@@ -0,0 +1,100 @@
+package syslogwriter_test
+
+import (
+	"doppler/sinks/syslogwriter"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+)
+
+var _ = Describe("Message format", func() {
+	var syslogServerSession *gexec.Session
+	var writer syslogwriter.Writer
+	standardOutPriority := 14
+
+	BeforeEach(func() {
+		writer = nil
+		syslogServerSession = startUdpServer("127.0.0.1:9996")
+	})
+
+	AfterEach(func() {
+		if writer != nil {
+			writer.Close()
+		}
+		syslogServerSession.Kill().Wait()
+	})
+
+	connect := func(drainUrl string) {
+		outputURL, _ := url.Parse(drainUrl)
+		var err error
+		writer, err = syslogwriter.NewWriter(outputURL, "appId", syslogwriter.WriterOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(writer.Connect()).To(Succeed())
+	}
+
+	It("writes the names of the app as structured data", func(done Done) {
+		connect("syslog-udp://127.0.0.1:9996?drain-structured-data=true&drain-org=my-org&drain-space=my-space&drain-app=my-app")
+
+		_, err := writer.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano())
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(syslogServerSession, 5).Should(gbytes.Say(`^<14>1 \S+ loggregator appId \[App/2\] - \[tags@47450 org="my-org" space="my-space" app="my-app" app_id="appId" instance="2"\] just a test\n`))
+		close(done)
+	}, 10)
+
+	It("only names the instance for app messages", func(done Done) {
+		connect("syslog-udp://127.0.0.1:9996?drain-structured-data=true")
+
+		_, err := writer.Write(standardOutPriority, []byte("just a test"), "RTR", "0", time.Now().UnixNano())
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(syslogServerSession, 5).Should(gbytes.Say(`^<14>1 \S+ loggregator appId \[RTR\] - \[tags@47450 app_id="appId"\] just a test\n`))
+		close(done)
+	}, 10)
+
+	It("escapes the structured data parameter values", func(done Done) {
+		connect(`syslog-udp://127.0.0.1:9996?drain-structured-data=true&drain-app=` + url.QueryEscape(`my "app"]\`))
+
+		_, err := writer.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano())
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(syslogServerSession, 5).Should(gbytes.Say(`app="my \\"app\\"\\]\\\\"`))
+		close(done)
+	}, 10)
+
+	It("expands the hostname template", func(done Done) {
+		connect("syslog-udp://127.0.0.1:9996?drain-hostname=org.space.app&drain-org=my-org&drain-space=my%20space&drain-app=my-app")
+
+		_, err := writer.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano())
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(syslogServerSession, 5).Should(gbytes.Say(`^<14>1 \S+ my-org\.my-space\.my-app appId \[App/2\] - - just a test\n`))
+		close(done)
+	}, 10)
+
+	It("leaves unknown names out of the hostname", func(done Done) {
+		connect("syslog-udp://127.0.0.1:9996?drain-hostname=org.app.guid&drain-app=my-app")
+
+		_, err := writer.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano())
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(syslogServerSession, 5).Should(gbytes.Say(`^<14>1 \S+ my-app\.appId appId \[App/2\] - - just a test\n`))
+		close(done)
+	}, 10)
+
+	It("returns an error for an invalid hostname template", func() {
+		outputURL, _ := url.Parse("syslog-udp://127.0.0.1:9996?drain-hostname=org.region")
+		_, err := syslogwriter.NewWriter(outputURL, "appId", syslogwriter.WriterOptions{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("returns an error for an invalid drain-structured-data parameter", func() {
+		outputURL, _ := url.Parse("syslog-udp://127.0.0.1:9996?drain-structured-data=maybe")
+		_, err := syslogwriter.NewWriter(outputURL, "appId", syslogwriter.WriterOptions{})
+		Expect(err).To(HaveOccurred())
+	})
+})
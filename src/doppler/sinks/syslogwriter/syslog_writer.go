@@ -15,20 +15,32 @@ import (
 )
 
 type syslogWriter struct {
-	appId string
-	host  string
+	format  *messageFormat
+	host    string
+	framing framing
 
 	mu   sync.Mutex // guards conn
 	conn net.Conn
 }
 
 func NewSyslogWriter(outputUrl *url.URL, appId string) (w *syslogWriter, err error) {
-	if outputUrl.Scheme != "syslog" {
-		return nil, errors.New(fmt.Sprintf("Invalid scheme %s, syslogWriter only supports syslog", outputUrl.Scheme))
+	var frame framing
+	switch outputUrl.Scheme {
+	case "syslog", "syslog+octet":
+		frame = octetCountingFraming
+	case "syslog+nontransparent":
+		frame = nonTransparentFraming
+	default:
+		return nil, errors.New(fmt.Sprintf("Invalid scheme %s, syslogWriter only supports syslog, syslog+octet and syslog+nontransparent", outputUrl.Scheme))
+	}
+	format, err := newMessageFormat(outputUrl, appId)
+	if err != nil {
+		return nil, err
 	}
 	return &syslogWriter{
-		appId: appId,
-		host:  outputUrl.Host,
+		format:  format,
+		host:    outputUrl.Host,
+		framing: frame,
 	}, nil
 }
 
@@ -49,9 +61,8 @@ func (w *syslogWriter) Connect() error {
 }
 
 func (w *syslogWriter) Write(p int, b []byte, source string, sourceId string, timestamp int64) (byteCount int, err error) {
-	syslogMsg := createMessage(p, w.appId, source, sourceId, b, timestamp)
-	// Frame msg with Octet Counting: https://tools.ietf.org/html/rfc6587#section-3.4.1
-	finalMsg := []byte(fmt.Sprintf("%d %s", len(syslogMsg), syslogMsg))
+	syslogMsg := createMessage(p, w.format, source, sourceId, b, timestamp)
+	finalMsg := w.framing(syslogMsg)
 
 	w.mu.Lock()
 	defer w.mu.Unlock()
@@ -115,6 +115,54 @@ var _ = Describe("SyslogWriter", func() {
 	})
 })
 
+var _ = Describe("SyslogWriter framing", func() {
+	var sysLogWriter syslogwriter.Writer
+	var syslogServerSession *gexec.Session
+	standardOutPriority := 14
+
+	connect := func(drainUrl string) {
+		outputURL, _ := url.Parse(drainUrl)
+		syslogServerSession = startSyslogServer("127.0.0.1:9999")
+		var err error
+		sysLogWriter, err = syslogwriter.NewSyslogWriter(outputURL, "appId")
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(sysLogWriter.Connect, 5, 1).ShouldNot(HaveOccurred())
+	}
+
+	AfterEach(func() {
+		sysLogWriter.Close()
+		syslogServerSession.Kill().Wait()
+	})
+
+	It("frames messages with octet counting for syslog+octet", func(done Done) {
+		connect("syslog+octet://127.0.0.1:9999")
+
+		sysLogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano())
+
+		Eventually(syslogServerSession, 5).Should(gbytes.Say(`^\d+ <14>1 \S+ loggregator appId \[App/2\] - - just a test\n`))
+		close(done)
+	}, 10)
+
+	It("frames messages with a trailing LF for syslog+nontransparent", func(done Done) {
+		connect("syslog+nontransparent://127.0.0.1:9999")
+
+		sysLogWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano())
+
+		Eventually(syslogServerSession, 5).Should(gbytes.Say(`^<14>1 \S+ loggregator appId \[App/2\] - - just a test\n`))
+		close(done)
+	}, 10)
+
+	It("replaces embedded line breaks for syslog+nontransparent", func(done Done) {
+		connect("syslog+nontransparent://127.0.0.1:9999")
+
+		sysLogWriter.Write(standardOutPriority, []byte("first line\nsecond line\n"), "App", "2", time.Now().UnixNano())
+
+		Eventually(syslogServerSession, 5).Should(gbytes.Say(`- - first line second line\n`))
+		close(done)
+	}, 10)
+})
+
 func startSyslogServer(syslogDrainAddress string) *gexec.Session {
 	command := exec.Command(pathToTCPEchoServer, "-address", syslogDrainAddress)
 	drainSession, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
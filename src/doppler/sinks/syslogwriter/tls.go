@@ -0,0 +1,77 @@
+package syslogwriter
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+func LoadCertPool(caCertFile string) (*x509.CertPool, error) {
+	pemCerts, err := ioutil.ReadFile(caCertFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemCerts) {
+		return nil, fmt.Errorf("no certificates found in %s", caCertFile)
+	}
+	return pool, nil
+}
+
+// IsCertificateError tells whether err was caused by a certificate that
+// failed verification on either side of the connection.
+func IsCertificateError(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+
+	switch err.(type) {
+	case x509.UnknownAuthorityError, x509.CertificateInvalidError, x509.HostnameError:
+		return true
+	case *x509.UnknownAuthorityError, *x509.CertificateInvalidError, *x509.HostnameError:
+		return true
+	}
+
+	return err != nil && strings.Contains(err.Error(), "bad certificate")
+}
+
+// newTLSConfig trusts the CA bundle of the drain url's drain-ca parameter instead of
+// the configured pool. The bundle is PEM, optionally base64 encoded.
+func newTLSConfig(outputUrl *url.URL, options WriterOptions) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: options.SkipCertVerify,
+		RootCAs:            options.RootCAs,
+		Certificates:       options.ClientCertificates,
+	}
+
+	ca := outputUrl.Query().Get("drain-ca")
+	if ca == "" {
+		return config, nil
+	}
+
+	pemCerts := []byte(ca)
+	if !strings.HasPrefix(ca, "-----BEGIN") {
+		decoded, err := base64.StdEncoding.DecodeString(ca)
+		if err != nil {
+			decoded, err = base64.URLEncoding.DecodeString(ca)
+		}
+		if err != nil {
+			return nil, errors.New("invalid drain-ca, must be a PEM encoded certificate bundle")
+		}
+		pemCerts = decoded
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemCerts) {
+		return nil, errors.New("invalid drain-ca, no certificates found")
+	}
+	config.RootCAs = pool
+
+	return config, nil
+}
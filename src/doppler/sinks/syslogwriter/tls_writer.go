@@ -16,8 +16,8 @@ import (
 )
 
 type tlsWriter struct {
-	appId string
-	host  string
+	format *messageFormat
+	host   string
 
 	mu   sync.Mutex // guards conn
 	conn net.Conn
@@ -25,13 +25,20 @@ type tlsWriter struct {
 	tlsConfig *tls.Config
 }
 
-func NewTlsWriter(outputUrl *url.URL, appId string, skipCertVerify bool) (w *tlsWriter, err error) {
+func NewTlsWriter(outputUrl *url.URL, appId string, options WriterOptions) (w *tlsWriter, err error) {
 	if outputUrl.Scheme != "syslog-tls" {
 		return nil, errors.New(fmt.Sprintf("Invalid scheme %s, tlsWriter only supports syslog-tls", outputUrl.Scheme))
 	}
-	tlsConfig := &tls.Config{InsecureSkipVerify: skipCertVerify}
+	format, err := newMessageFormat(outputUrl, appId)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := newTLSConfig(outputUrl, options)
+	if err != nil {
+		return nil, err
+	}
 	return &tlsWriter{
-		appId:     appId,
+		format:    format,
 		host:      outputUrl.Host,
 		tlsConfig: tlsConfig,
 	}, nil
@@ -56,9 +63,8 @@ func (w *tlsWriter) Connect() error {
 }
 
 func (w *tlsWriter) Write(p int, b []byte, source string, sourceId string, timestamp int64) (byteCount int, err error) {
-	syslogMsg := createMessage(p, w.appId, source, sourceId, b, timestamp)
-	// Frame msg with Octet Counting: https://tools.ietf.org/html/rfc6587#section-3.4.1
-	finalMsg := []byte(fmt.Sprintf("%d %s", len(syslogMsg), syslogMsg))
+	syslogMsg := createMessage(p, w.format, source, sourceId, b, timestamp)
+	finalMsg := octetCountingFraming(syslogMsg)
 
 	w.mu.Lock()
 	defer w.mu.Unlock()
@@ -1,7 +1,11 @@
 package syslogwriter_test
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"doppler/sinks/syslogwriter"
+	"encoding/base64"
+	"io/ioutil"
 	"net/url"
 	"os/exec"
 	"time"
@@ -21,7 +25,7 @@ var _ = Describe("TLSWriter", func() {
 		BeforeEach(func(done Done) {
 			syslogServerSession = startEncryptedTCPServer("127.0.0.1:9998")
 			outputURL, _ := url.Parse("syslog-tls://127.0.0.1:9998")
-			syslogWriter, _ = syslogwriter.NewTlsWriter(outputURL, "appId", true)
+			syslogWriter, _ = syslogwriter.NewTlsWriter(outputURL, "appId", syslogwriter.WriterOptions{SkipCertVerify: true})
 			close(done)
 		}, 5)
 
@@ -68,7 +72,7 @@ var _ = Describe("TLSWriter", func() {
 		syslogServerSession = startEncryptedTCPServer("127.0.0.1:9998")
 		outputURL, _ := url.Parse("syslog-tls://localhost:9998")
 
-		syslogWriter, _ = syslogwriter.NewTlsWriter(outputURL, "appId", false)
+		syslogWriter, _ = syslogwriter.NewTlsWriter(outputURL, "appId", syslogwriter.WriterOptions{})
 		err := syslogWriter.Connect()
 		Expect(err).To(HaveOccurred())
 
@@ -77,15 +81,106 @@ var _ = Describe("TLSWriter", func() {
 		close(done)
 	}, 5)
 
+	Context("with a drain using a private CA", func() {
+		var caPool *x509.CertPool
+
+		BeforeEach(func() {
+			var err error
+			caPool, err = syslogwriter.LoadCertPool("fixtures/ca.crt")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			syslogServerSession.Kill().Wait()
+			syslogWriter.Close()
+		})
+
+		It("connects when the CA is trusted", func(done Done) {
+			syslogServerSession = startCASignedTCPServer("127.0.0.1:9998", "")
+			outputURL, _ := url.Parse("syslog-tls://127.0.0.1:9998")
+
+			syslogWriter, _ = syslogwriter.NewTlsWriter(outputURL, "appId", syslogwriter.WriterOptions{RootCAs: caPool})
+			Expect(syslogWriter.Connect()).To(Succeed())
+
+			_, err := syslogWriter.Write(standardOutPriority, []byte("just a test"), "test", "", time.Now().UnixNano())
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(syslogServerSession, 3).Should(gbytes.Say("just a test"))
+			close(done)
+		}, 10)
+
+		It("reports a certificate error when the CA is not trusted", func(done Done) {
+			syslogServerSession = startCASignedTCPServer("127.0.0.1:9998", "")
+			outputURL, _ := url.Parse("syslog-tls://127.0.0.1:9998")
+
+			syslogWriter, _ = syslogwriter.NewTlsWriter(outputURL, "appId", syslogwriter.WriterOptions{})
+			err := syslogWriter.Connect()
+			Expect(err).To(HaveOccurred())
+			Expect(syslogwriter.IsCertificateError(err)).To(BeTrue())
+			close(done)
+		}, 10)
+
+		It("trusts the CA given in the drain url", func(done Done) {
+			syslogServerSession = startCASignedTCPServer("127.0.0.1:9998", "")
+			caPEM, _ := ioutil.ReadFile("fixtures/ca.crt")
+			outputURL, _ := url.Parse("syslog-tls://127.0.0.1:9998?drain-ca=" + url.QueryEscape(base64.StdEncoding.EncodeToString(caPEM)))
+
+			syslogWriter, _ = syslogwriter.NewTlsWriter(outputURL, "appId", syslogwriter.WriterOptions{})
+			Expect(syslogWriter.Connect()).To(Succeed())
+			close(done)
+		}, 10)
+
+		It("presents the client certificate to drains requiring one", func(done Done) {
+			syslogServerSession = startCASignedTCPServer("127.0.0.1:9998", "fixtures/ca.crt")
+			outputURL, _ := url.Parse("syslog-tls://127.0.0.1:9998")
+			clientCert, err := tls.LoadX509KeyPair("fixtures/client.crt", "fixtures/client.key")
+			Expect(err).ToNot(HaveOccurred())
+
+			syslogWriter, _ = syslogwriter.NewTlsWriter(outputURL, "appId", syslogwriter.WriterOptions{
+				RootCAs:            caPool,
+				ClientCertificates: []tls.Certificate{clientCert},
+			})
+			Expect(syslogWriter.Connect()).To(Succeed())
+
+			_, err = syslogWriter.Write(standardOutPriority, []byte("mutual test"), "test", "", time.Now().UnixNano())
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(syslogServerSession, 3).Should(gbytes.Say("mutual test"))
+			close(done)
+		}, 10)
+
+		It("fails without a client certificate when the drain requires one", func(done Done) {
+			syslogServerSession = startCASignedTCPServer("127.0.0.1:9998", "fixtures/ca.crt")
+			outputURL, _ := url.Parse("syslog-tls://127.0.0.1:9998")
+
+			syslogWriter, _ = syslogwriter.NewTlsWriter(outputURL, "appId", syslogwriter.WriterOptions{RootCAs: caPool})
+			Eventually(func() error {
+				err := syslogWriter.Connect()
+				if err != nil {
+					return err
+				}
+				_, err = syslogWriter.Write(standardOutPriority, []byte("just a test"), "test", "", time.Now().UnixNano())
+				return err
+			}, 5).Should(HaveOccurred())
+			Expect(syslogServerSession).ToNot(gbytes.Say("just a test"))
+			close(done)
+		}, 10)
+
+	})
+
+	It("rejects an invalid CA in the drain url", func() {
+		outputURL, _ := url.Parse("syslog-tls://127.0.0.1:9998?drain-ca=not-a-cert")
+		_, err := syslogwriter.NewTlsWriter(outputURL, "appId", syslogwriter.WriterOptions{})
+		Expect(err).To(HaveOccurred())
+	})
+
 	It("returns an error for syslog scheme", func() {
 		outputURL, _ := url.Parse("syslog://localhost")
-		_, err := syslogwriter.NewTlsWriter(outputURL, "appId", false)
+		_, err := syslogwriter.NewTlsWriter(outputURL, "appId", syslogwriter.WriterOptions{})
 		Expect(err).To(HaveOccurred())
 	})
 
 	It("returns an error for https scheme", func() {
 		outputURL, _ := url.Parse("https://localhost")
-		_, err := syslogwriter.NewTlsWriter(outputURL, "appId", false)
+		_, err := syslogwriter.NewTlsWriter(outputURL, "appId", syslogwriter.WriterOptions{})
 		Expect(err).To(HaveOccurred())
 	})
 })
@@ -98,3 +193,16 @@ func startEncryptedTCPServer(syslogDrainAddress string) *gexec.Session {
 
 	return drainSession
 }
+
+func startCASignedTCPServer(syslogDrainAddress string, clientCA string) *gexec.Session {
+	args := []string{"-address", syslogDrainAddress, "-ssl", "-cert", "fixtures/server.crt", "-key", "fixtures/server.key"}
+	if clientCA != "" {
+		args = append(args, "-client-ca", clientCA)
+	}
+	command := exec.Command(pathToTCPEchoServer, args...)
+	drainSession, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+	Expect(err).NotTo(HaveOccurred())
+	Eventually(drainSession.Err, 10).Should(gbytes.Say("Startup: tcp echo server listening"))
+
+	return drainSession
+}
@@ -0,0 +1,76 @@
+package syslogwriter
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// udpWriter sends every message in its own datagram as described in
+// https://tools.ietf.org/html/rfc5426, so no framing is needed.
+type udpWriter struct {
+	format *messageFormat
+	host   string
+
+	mu   sync.Mutex // guards conn
+	conn net.Conn
+}
+
+func NewUdpWriter(outputUrl *url.URL, appId string) (w *udpWriter, err error) {
+	if outputUrl.Scheme != "syslog-udp" {
+		return nil, errors.New(fmt.Sprintf("Invalid scheme %s, udpWriter only supports syslog-udp", outputUrl.Scheme))
+	}
+	format, err := newMessageFormat(outputUrl, appId)
+	if err != nil {
+		return nil, err
+	}
+	return &udpWriter{
+		format: format,
+		host:   outputUrl.Host,
+	}, nil
+}
+
+func (w *udpWriter) Connect() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn != nil {
+		// ignore err from close, it makes sense to continue anyway
+		w.conn.Close()
+		w.conn = nil
+	}
+	c, err := net.Dial("udp", w.host)
+	if err == nil {
+		w.conn = c
+	}
+	return err
+}
+
+func (w *udpWriter) Write(p int, b []byte, source string, sourceId string, timestamp int64) (byteCount int, err error) {
+	syslogMsg := createMessage(p, w.format, source, sourceId, b, timestamp)
+	finalMsg := []byte(strings.TrimSuffix(syslogMsg, "\n"))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn != nil {
+		byteCount, err = w.conn.Write(finalMsg)
+	} else {
+		return 0, errors.New("Connection to syslog-udp sink lost")
+	}
+	return byteCount, err
+}
+
+func (w *udpWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn != nil {
+		err := w.conn.Close()
+		w.conn = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package syslogwriter_test
+
+import (
+	"doppler/sinks/syslogwriter"
+	"net/url"
+	"os/exec"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+)
+
+var _ = Describe("UdpWriter", func() {
+	var syslogServerSession *gexec.Session
+	var udpWriter syslogwriter.Writer
+	standardOutPriority := 14
+
+	BeforeEach(func(done Done) {
+		syslogServerSession = startUdpServer("127.0.0.1:9997")
+		outputURL, _ := url.Parse("syslog-udp://127.0.0.1:9997")
+		udpWriter, _ = syslogwriter.NewUdpWriter(outputURL, "appId")
+
+		Expect(udpWriter.Connect()).To(Succeed())
+		close(done)
+	}, 15)
+
+	AfterEach(func() {
+		udpWriter.Close()
+		syslogServerSession.Kill().Wait()
+	})
+
+	It("sends each message unframed in its own datagram", func(done Done) {
+		_, err := udpWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano())
+		Expect(err).ToNot(HaveOccurred())
+		_, err = udpWriter.Write(standardOutPriority, []byte("another test"), "App", "2", time.Now().UnixNano())
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(syslogServerSession, 5).Should(gbytes.Say(`^<14>1 \d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{1,6}([-+]\d{2}:\d{2}) loggregator appId \[App/2\] - - just a test\n`))
+		Eventually(syslogServerSession, 5).Should(gbytes.Say(`^<14>1 \S+ loggregator appId \[App/2\] - - another test\n`))
+		close(done)
+	}, 10)
+
+	It("returns an error if not connected", func() {
+		udpWriter.Close()
+		_, err := udpWriter.Write(standardOutPriority, []byte("just a test"), "App", "2", time.Now().UnixNano())
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("returns an error for syslog scheme", func() {
+		outputURL, _ := url.Parse("syslog://localhost")
+		_, err := syslogwriter.NewUdpWriter(outputURL, "appId")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func startUdpServer(syslogDrainAddress string) *gexec.Session {
+	command := exec.Command(pathToTCPEchoServer, "-udp", "-address", syslogDrainAddress)
+	drainSession, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+	Expect(err).NotTo(HaveOccurred())
+	Eventually(drainSession.Err, 10).Should(gbytes.Say("Startup: udp echo server listening"))
+
+	return drainSession
+}
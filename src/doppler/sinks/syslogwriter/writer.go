@@ -2,15 +2,21 @@ package syslogwriter
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"net/url"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
 	rfc5424 = "2006-01-02T15:04:05.999999Z07:00"
+
+	drainParamPrefix = "drain-"
 )
 
 var badBytes = []byte("\000")
@@ -23,24 +29,117 @@ type Writer interface {
 	Close() error
 }
 
-func NewWriter(outputUrl *url.URL, appId string, skipCertVerify bool) (Writer, error) {
-	switch outputUrl.Scheme {
-	case "https":
-		return NewHttpsWriter(outputUrl, appId, skipCertVerify)
-	case "syslog":
-		return NewSyslogWriter(outputUrl, appId)
-	case "syslog-tls":
-		return NewTlsWriter(outputUrl, appId, skipCertVerify)
-	default:
-		return nil, errors.New(fmt.Sprintf("Invalid scheme type %s, must be https, syslog-tls or syslog", outputUrl.Scheme))
+type WriterOptions struct {
+	SkipCertVerify     bool
+	RootCAs            *x509.CertPool
+	ClientCertificates []tls.Certificate
+	HttpsBatch         HttpsBatchOptions
+}
+
+type WriterConstructor func(outputUrl *url.URL, appId string, options WriterOptions) (Writer, error)
+
+var (
+	writerConstructors     = make(map[string]WriterConstructor)
+	writerConstructorsLock sync.RWMutex
+)
+
+func init() {
+	RegisterWriter("https", func(outputUrl *url.URL, appId string, options WriterOptions) (Writer, error) {
+		w, err := NewHttpsWriter(outputUrl, appId, options)
+		if err != nil {
+			return nil, err
+		}
+		return w, nil
+	})
+
+	newSyslogWriter := func(outputUrl *url.URL, appId string, options WriterOptions) (Writer, error) {
+		w, err := NewSyslogWriter(outputUrl, appId)
+		if err != nil {
+			return nil, err
+		}
+		return w, nil
+	}
+	RegisterWriter("syslog", newSyslogWriter)
+	RegisterWriter("syslog+octet", newSyslogWriter)
+	RegisterWriter("syslog+nontransparent", newSyslogWriter)
+
+	RegisterWriter("syslog-tls", func(outputUrl *url.URL, appId string, options WriterOptions) (Writer, error) {
+		w, err := NewTlsWriter(outputUrl, appId, options)
+		if err != nil {
+			return nil, err
+		}
+		return w, nil
+	})
+
+	RegisterWriter("syslog-udp", func(outputUrl *url.URL, appId string, options WriterOptions) (Writer, error) {
+		w, err := NewUdpWriter(outputUrl, appId)
+		if err != nil {
+			return nil, err
+		}
+		return w, nil
+	})
+}
+
+// RegisterWriter makes a writer available to NewWriter for drain urls with the
+// given scheme, replacing any writer previously registered for it.
+func RegisterWriter(scheme string, constructor WriterConstructor) {
+	writerConstructorsLock.Lock()
+	defer writerConstructorsLock.Unlock()
+
+	writerConstructors[scheme] = constructor
+}
+
+// UnregisterWriter removes the writer registered for the given scheme.
+func UnregisterWriter(scheme string) {
+	writerConstructorsLock.Lock()
+	defer writerConstructorsLock.Unlock()
+
+	delete(writerConstructors, scheme)
+}
+
+func RegisteredSchemes() []string {
+	writerConstructorsLock.RLock()
+	defer writerConstructorsLock.RUnlock()
+
+	schemes := make([]string, 0, len(writerConstructors))
+	for scheme := range writerConstructors {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+func NewWriter(outputUrl *url.URL, appId string, options WriterOptions) (Writer, error) {
+	writerConstructorsLock.RLock()
+	constructor, ok := writerConstructors[outputUrl.Scheme]
+	writerConstructorsLock.RUnlock()
+
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Invalid scheme type %s, must be one of %s", outputUrl.Scheme, strings.Join(RegisteredSchemes(), ", ")))
+	}
+	return constructor(outputUrl, appId, options)
+}
+
+// withoutDrainParams returns the drain url without the parameters that are
+// only meant for doppler, which all start with drainParamPrefix. All other
+// parameters belong to the drain.
+func withoutDrainParams(outputUrl *url.URL) *url.URL {
+	stripped := *outputUrl
+	values := stripped.Query()
+	for param := range values {
+		if strings.HasPrefix(param, drainParamPrefix) {
+			values.Del(param)
+		}
 	}
+	stripped.RawQuery = values.Encode()
+	return &stripped
 }
 
 func clean(in []byte) []byte {
 	return bytes.Replace(in, badBytes, emptyBytes, -1)
 }
 
-func createMessage(p int, appId string, source string, sourceId string, msg []byte, timestamp int64) string {
+func createMessage(p int, format *messageFormat, source string, sourceId string, msg []byte, timestamp int64) string {
 	// ensure it ends in a \n
 	nl := ""
 	if !bytes.HasSuffix(msg, newLine) {
@@ -59,5 +158,5 @@ func createMessage(p int, appId string, source string, sourceId string, msg []by
 	}
 
 	// syslog format https://tools.ietf.org/html/rfc5424#section-6
-	return fmt.Sprintf("<%d>1 %s %s %s %s - - %s%s", p, timeString, "loggregator", appId, formattedSource, msg, nl)
+	return fmt.Sprintf("<%d>1 %s %s %s %s - %s %s%s", p, timeString, format.hostname, format.appId, formattedSource, format.structuredDataFor(source, sourceId), msg, nl)
 }
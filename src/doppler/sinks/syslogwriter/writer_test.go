@@ -14,7 +14,7 @@ var _ = Describe("Writer", func() {
 
 	It("returns an syslogWriter for syslog scheme", func() {
 		outputUrl, _ := url.Parse("syslog://localhost:9999")
-		w, err := syslogwriter.NewWriter(outputUrl, "appId", false)
+		w, err := syslogwriter.NewWriter(outputUrl, "appId", syslogwriter.WriterOptions{})
 		Expect(err).ToNot(HaveOccurred())
 		writerType := reflect.TypeOf(w).String()
 		Expect(writerType).To(Equal("*syslogwriter.syslogWriter"))
@@ -22,7 +22,7 @@ var _ = Describe("Writer", func() {
 
 	It("returns an tlsWriter for syslog-tls scheme", func() {
 		outputUrl, _ := url.Parse("syslog-tls://localhost:9999")
-		w, err := syslogwriter.NewWriter(outputUrl, "appId", false)
+		w, err := syslogwriter.NewWriter(outputUrl, "appId", syslogwriter.WriterOptions{})
 		Expect(err).ToNot(HaveOccurred())
 		writerType := reflect.TypeOf(w).String()
 		Expect(writerType).To(Equal("*syslogwriter.tlsWriter"))
@@ -30,16 +30,81 @@ var _ = Describe("Writer", func() {
 
 	It("returns an httpsWriter for https scheme", func() {
 		outputUrl, _ := url.Parse("https://localhost:9999")
-		w, err := syslogwriter.NewWriter(outputUrl, "appId", false)
+		w, err := syslogwriter.NewWriter(outputUrl, "appId", syslogwriter.WriterOptions{})
 		Expect(err).ToNot(HaveOccurred())
 		writerType := reflect.TypeOf(w).String()
 		Expect(writerType).To(Equal("*syslogwriter.httpsWriter"))
 	})
 
+	It("returns an syslogWriter for syslog+octet and syslog+nontransparent schemes", func() {
+		for _, scheme := range []string{"syslog+octet", "syslog+nontransparent"} {
+			outputUrl, _ := url.Parse(scheme + "://localhost:9999")
+			w, err := syslogwriter.NewWriter(outputUrl, "appId", syslogwriter.WriterOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			writerType := reflect.TypeOf(w).String()
+			Expect(writerType).To(Equal("*syslogwriter.syslogWriter"))
+		}
+	})
+
+	It("returns an udpWriter for syslog-udp scheme", func() {
+		outputUrl, _ := url.Parse("syslog-udp://localhost:9999")
+		w, err := syslogwriter.NewWriter(outputUrl, "appId", syslogwriter.WriterOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		writerType := reflect.TypeOf(w).String()
+		Expect(writerType).To(Equal("*syslogwriter.udpWriter"))
+	})
+
+	Context("with writers registered for additional schemes", func() {
+		var registered *fakeWriter
+
+		BeforeEach(func() {
+			registered = &fakeWriter{}
+			syslogwriter.RegisterWriter("fake", func(outputUrl *url.URL, appId string, options syslogwriter.WriterOptions) (syslogwriter.Writer, error) {
+				return registered, nil
+			})
+		})
+
+		AfterEach(func() {
+			syslogwriter.UnregisterWriter("fake")
+		})
+
+		It("returns the registered writer", func() {
+			outputUrl, _ := url.Parse("fake://localhost:9999")
+			w, err := syslogwriter.NewWriter(outputUrl, "appId", syslogwriter.WriterOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(w).To(BeIdenticalTo(registered))
+			Expect(syslogwriter.RegisteredSchemes()).To(ContainElement("fake"))
+		})
+
+		It("no longer returns it once unregistered", func() {
+			syslogwriter.UnregisterWriter("fake")
+
+			outputUrl, _ := url.Parse("fake://localhost:9999")
+			_, err := syslogwriter.NewWriter(outputUrl, "appId", syslogwriter.WriterOptions{})
+			Expect(err).To(HaveOccurred())
+			Expect(syslogwriter.RegisteredSchemes()).NotTo(ContainElement("fake"))
+		})
+	})
+
+	It("lists the registered schemes when the scheme is invalid", func() {
+		outputUrl, _ := url.Parse("notValid://localhost:9999")
+		_, err := syslogwriter.NewWriter(outputUrl, "appId", syslogwriter.WriterOptions{})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("syslog-udp"))
+	})
+
 	It("returns an error for invalid scheme", func() {
 		outputUrl, _ := url.Parse("notValid://localhost:9999")
-		w, err := syslogwriter.NewWriter(outputUrl, "appId", false)
+		w, err := syslogwriter.NewWriter(outputUrl, "appId", syslogwriter.WriterOptions{})
 		Expect(err).To(HaveOccurred())
 		Expect(w).To(BeNil())
 	})
 })
+
+type fakeWriter struct{}
+
+func (*fakeWriter) Connect() error { return nil }
+func (*fakeWriter) Write(p int, b []byte, source, sourceId string, timestamp int64) (int, error) {
+	return len(b), nil
+}
+func (*fakeWriter) Close() error { return nil }
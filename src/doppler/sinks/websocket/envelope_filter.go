@@ -0,0 +1,134 @@
+package websocket
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"github.com/cloudfoundry/dropsonde/events"
+)
+
+// EnvelopeFilter selects the envelopes a websocket consumer is interested in.
+// Repeated values of a parameter are alternatives, different parameters must
+// all match. The log specific criteria (source_type, source_instance,
+// message_type and message_regex) only restrict LogMessage envelopes.
+type EnvelopeFilter struct {
+	eventTypes      map[events.Envelope_EventType]struct{}
+	origins         map[string]struct{}
+	sourceTypes     map[string]struct{}
+	sourceInstances map[string]struct{}
+	messageTypes    map[events.LogMessage_MessageType]struct{}
+	messageRegexes  []*regexp.Regexp
+}
+
+func NewEnvelopeFilter(values url.Values) (*EnvelopeFilter, error) {
+	filter := &EnvelopeFilter{
+		origins:         stringSet(values["origin"]),
+		sourceTypes:     stringSet(values["source_type"]),
+		sourceInstances: stringSet(values["source_instance"]),
+	}
+
+	if len(values["event_type"]) > 0 {
+		filter.eventTypes = make(map[events.Envelope_EventType]struct{})
+		for _, name := range values["event_type"] {
+			eventType, ok := events.Envelope_EventType_value[name]
+			if !ok {
+				return nil, fmt.Errorf("invalid event_type %q", name)
+			}
+			filter.eventTypes[events.Envelope_EventType(eventType)] = struct{}{}
+		}
+	}
+
+	if len(values["message_type"]) > 0 {
+		filter.messageTypes = make(map[events.LogMessage_MessageType]struct{})
+		for _, name := range values["message_type"] {
+			messageType, ok := events.LogMessage_MessageType_value[name]
+			if !ok {
+				return nil, fmt.Errorf("invalid message_type %q", name)
+			}
+			filter.messageTypes[events.LogMessage_MessageType(messageType)] = struct{}{}
+		}
+	}
+
+	for _, expression := range values["message_regex"] {
+		regex, err := regexp.Compile(expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid message_regex %q: %s", expression, err.Error())
+		}
+		filter.messageRegexes = append(filter.messageRegexes, regex)
+	}
+
+	if filter.isEmpty() {
+		return nil, nil
+	}
+
+	return filter, nil
+}
+
+func (f *EnvelopeFilter) Matches(envelope *events.Envelope) bool {
+	if f == nil {
+		return true
+	}
+
+	if !contains(f.origins, envelope.GetOrigin()) {
+		return false
+	}
+
+	if f.eventTypes != nil {
+		if _, ok := f.eventTypes[envelope.GetEventType()]; !ok {
+			return false
+		}
+	}
+
+	logMessage := envelope.GetLogMessage()
+	if envelope.GetEventType() != events.Envelope_LogMessage || logMessage == nil {
+		return true
+	}
+
+	if !contains(f.sourceTypes, logMessage.GetSourceType()) || !contains(f.sourceInstances, logMessage.GetSourceInstance()) {
+		return false
+	}
+
+	if f.messageTypes != nil {
+		if _, ok := f.messageTypes[logMessage.GetMessageType()]; !ok {
+			return false
+		}
+	}
+
+	if len(f.messageRegexes) == 0 {
+		return true
+	}
+
+	for _, regex := range f.messageRegexes {
+		if regex.Match(logMessage.GetMessage()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *EnvelopeFilter) isEmpty() bool {
+	return f.eventTypes == nil && f.origins == nil && f.sourceTypes == nil &&
+		f.sourceInstances == nil && f.messageTypes == nil && len(f.messageRegexes) == 0
+}
+
+func stringSet(values []string) map[string]struct{} {
+	if len(values) == 0 {
+		return nil
+	}
+
+	set := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		set[value] = struct{}{}
+	}
+	return set
+}
+
+func contains(set map[string]struct{}, value string) bool {
+	if set == nil {
+		return true
+	}
+
+	_, ok := set[value]
+	return ok
+}
@@ -0,0 +1,93 @@
+package websocket_test
+
+import (
+	"doppler/sinks/websocket"
+	"net/url"
+
+	"github.com/cloudfoundry/dropsonde/emitter"
+	"github.com/cloudfoundry/dropsonde/events"
+	"github.com/cloudfoundry/dropsonde/factories"
+	"github.com/gogo/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("EnvelopeFilter", func() {
+	var (
+		outMessage  *events.Envelope
+		errMessage  *events.Envelope
+		valueMetric *events.Envelope
+	)
+
+	BeforeEach(func() {
+		outLog := factories.NewLogMessage(events.LogMessage_OUT, "GET /health 200", "appId", "RTR")
+		outLog.SourceInstance = proto.String("0")
+		outMessage, _ = emitter.Wrap(outLog, "router")
+
+		errLog := factories.NewLogMessage(events.LogMessage_ERR, "backend exhausted", "appId", "App")
+		errLog.SourceInstance = proto.String("1")
+		errMessage, _ = emitter.Wrap(errLog, "dea")
+
+		valueMetric, _ = emitter.Wrap(factories.NewValueMetric("metric", 1, "unit"), "router")
+	})
+
+	filterFor := func(values url.Values) *websocket.EnvelopeFilter {
+		filter, err := websocket.NewEnvelopeFilter(values)
+		Expect(err).NotTo(HaveOccurred())
+		return filter
+	}
+
+	It("returns a nil filter which matches everything when no criteria are given", func() {
+		filter := filterFor(url.Values{"unrelated": {"value"}})
+		Expect(filter).To(BeNil())
+		Expect(filter.Matches(outMessage)).To(BeTrue())
+	})
+
+	It("filters by event type", func() {
+		filter := filterFor(url.Values{"event_type": {"ValueMetric", "ContainerMetric"}})
+		Expect(filter.Matches(valueMetric)).To(BeTrue())
+		Expect(filter.Matches(outMessage)).To(BeFalse())
+	})
+
+	It("filters by origin", func() {
+		filter := filterFor(url.Values{"origin": {"router"}})
+		Expect(filter.Matches(outMessage)).To(BeTrue())
+		Expect(filter.Matches(valueMetric)).To(BeTrue())
+		Expect(filter.Matches(errMessage)).To(BeFalse())
+	})
+
+	It("filters log messages by source type and instance", func() {
+		filter := filterFor(url.Values{"source_type": {"App"}, "source_instance": {"1"}})
+		Expect(filter.Matches(errMessage)).To(BeTrue())
+		Expect(filter.Matches(outMessage)).To(BeFalse())
+	})
+
+	It("filters log messages by message type", func() {
+		filter := filterFor(url.Values{"message_type": {"ERR"}})
+		Expect(filter.Matches(errMessage)).To(BeTrue())
+		Expect(filter.Matches(outMessage)).To(BeFalse())
+	})
+
+	It("filters log messages by a regular expression on the message", func() {
+		filter := filterFor(url.Values{"message_regex": {"exhausted$"}})
+		Expect(filter.Matches(errMessage)).To(BeTrue())
+		Expect(filter.Matches(outMessage)).To(BeFalse())
+	})
+
+	It("does not apply log criteria to other event types", func() {
+		filter := filterFor(url.Values{"message_type": {"ERR"}})
+		Expect(filter.Matches(valueMetric)).To(BeTrue())
+	})
+
+	It("rejects invalid criteria", func() {
+		for _, values := range []url.Values{
+			{"event_type": {"Bogus"}},
+			{"message_type": {"WARN"}},
+			{"message_regex": {"("}},
+		} {
+			_, err := websocket.NewEnvelopeFilter(values)
+			Expect(err).To(HaveOccurred())
+		}
+	})
+})
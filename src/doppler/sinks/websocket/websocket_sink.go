@@ -25,9 +25,10 @@ type WebsocketSink struct {
 	wsMessageBufferSize uint
 	dropsondeOrigin     string
 	metricUpdateChannel chan<- int64
+	filter              *EnvelopeFilter
 }
 
-func NewWebsocketSink(streamId string, givenLogger *gosteno.Logger, ws remoteMessageWriter, wsMessageBufferSize uint, dropsondeOrigin string, metricUpdateChannel chan<- int64) *WebsocketSink {
+func NewWebsocketSink(streamId string, givenLogger *gosteno.Logger, ws remoteMessageWriter, wsMessageBufferSize uint, dropsondeOrigin string, metricUpdateChannel chan<- int64, filter *EnvelopeFilter) *WebsocketSink {
 	return &WebsocketSink{
 		logger:              givenLogger,
 		streamId:            streamId,
@@ -36,6 +37,7 @@ func NewWebsocketSink(streamId string, givenLogger *gosteno.Logger, ws remoteMes
 		wsMessageBufferSize: wsMessageBufferSize,
 		dropsondeOrigin:     dropsondeOrigin,
 		metricUpdateChannel: metricUpdateChannel,
+		filter:              filter,
 	}
 }
 
@@ -74,6 +76,10 @@ func (sink *WebsocketSink) Run(inputChan <-chan *events.Envelope) {
 			return
 		}
 
+		if !sink.filter.Matches(messageEnvelope) {
+			continue
+		}
+
 		messageBytes, err := proto.Marshal(messageEnvelope)
 
 		if err != nil {
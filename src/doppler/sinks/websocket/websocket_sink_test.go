@@ -3,6 +3,7 @@ package websocket_test
 import (
 	"doppler/sinks/websocket"
 	"net"
+	"net/url"
 	"sync"
 
 	"github.com/cloudfoundry/dropsonde/emitter"
@@ -61,7 +62,7 @@ var _ = Describe("WebsocketSink", func() {
 		logger = loggertesthelper.Logger()
 		fakeWebsocket = &fakeMessageWriter{}
 		updateMetricChan = make(chan int64, 1)
-		websocketSink = websocket.NewWebsocketSink("appId", logger, fakeWebsocket, 10, "dropsonde-origin", updateMetricChan)
+		websocketSink = websocket.NewWebsocketSink("appId", logger, fakeWebsocket, 10, "dropsonde-origin", updateMetricChan, nil)
 	})
 
 	Describe("Identifier", func() {
@@ -106,6 +107,25 @@ var _ = Describe("WebsocketSink", func() {
 			Eventually(fakeWebsocket.ReadMessages).Should(HaveLen(2))
 			Expect(fakeWebsocket.ReadMessages()[1]).To(Equal(messageTwoBytes))
 		})
+
+		It("only forwards messages that match the filter", func(done Done) {
+			defer close(done)
+			filter, err := websocket.NewEnvelopeFilter(url.Values{"event_type": {"ValueMetric"}})
+			Expect(err).NotTo(HaveOccurred())
+
+			websocketSink = websocket.NewWebsocketSink("appId", logger, fakeWebsocket, 10, "dropsonde-origin", updateMetricChan, filter)
+			go websocketSink.Run(inputChan)
+
+			logMessage, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "hello world", "appId", "App"), "origin")
+			valueMetric, _ := emitter.Wrap(factories.NewValueMetric("metric", 1, "unit"), "origin")
+			valueMetricBytes, _ := proto.Marshal(valueMetric)
+
+			inputChan <- logMessage
+			inputChan <- valueMetric
+
+			Eventually(fakeWebsocket.ReadMessages).Should(HaveLen(1))
+			Expect(fakeWebsocket.ReadMessages()[0]).To(Equal(valueMetricBytes))
+		})
 	})
 
 	Describe("UpdateDroppedMessageCount", func() {
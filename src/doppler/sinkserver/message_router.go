@@ -2,40 +2,63 @@ package sinkserver
 
 import (
 	"doppler/sinkserver/metrics"
+	"doppler/sinkserver/ratelimiter"
+	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/cloudfoundry/dropsonde/emitter"
 	"github.com/cloudfoundry/dropsonde/envelope_extensions"
 	"github.com/cloudfoundry/dropsonde/events"
+	"github.com/cloudfoundry/dropsonde/factories"
 	"github.com/cloudfoundry/gosteno"
 	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
 )
 
+const rateLimitNotice = "Rate limited, %d messages dropped. The app is logging more than doppler accepts per app."
+
 type MessageRouter struct {
-	sinkManager sinkManager
-	metrics     *metrics.MessageRouterMetrics
-	logger      *gosteno.Logger
-	done        chan struct{}
-	stopOnce    sync.Once
+	sinkManager     sinkManager
+	rateLimiter     *ratelimiter.RateLimiter
+	dropsondeOrigin string
+	metrics         *metrics.MessageRouterMetrics
+	logger          *gosteno.Logger
+	done            chan struct{}
+	stopOnce        sync.Once
 }
 
 type sinkManager interface {
 	SendTo(string, *events.Envelope)
 }
 
-func NewMessageRouter(sinkManager sinkManager, logger *gosteno.Logger) *MessageRouter {
+// NewMessageRouter creates a router that limits the log messages of every app
+// with rateLimiter, unless it is nil.
+func NewMessageRouter(sinkManager sinkManager, rateLimiter *ratelimiter.RateLimiter, dropsondeOrigin string, logger *gosteno.Logger) *MessageRouter {
 	return &MessageRouter{
-		sinkManager: sinkManager,
-		metrics:     &metrics.MessageRouterMetrics{},
-		logger:      logger,
-		done:        make(chan struct{}),
+		sinkManager:     sinkManager,
+		rateLimiter:     rateLimiter,
+		dropsondeOrigin: dropsondeOrigin,
+		metrics:         &metrics.MessageRouterMetrics{},
+		logger:          logger,
+		done:            make(chan struct{}),
 	}
 }
 
 func (r *MessageRouter) Start(incomingLogChan <-chan *events.Envelope) {
 	r.logger.Debug("MessageRouter:Starting")
+
+	var noticeTicks <-chan time.Time
+	if r.rateLimiter != nil {
+		ticker := time.NewTicker(r.rateLimiter.NoticeInterval())
+		defer ticker.Stop()
+		noticeTicks = ticker.C
+	}
+
 	for {
 		select {
+		case <-noticeTicks:
+			r.sendRateLimitNotices()
 		case <-r.done:
 			r.logger.Debug("MessageRouter:MessageReceived:Done")
 			return
@@ -63,7 +86,26 @@ func (r *MessageRouter) Emit() instrumentation.Context {
 func (r *MessageRouter) send(envelope *events.Envelope) {
 	appId := envelope_extensions.GetAppId(envelope)
 
+	if r.rateLimiter != nil && appId != "" && envelope.GetEventType() == events.Envelope_LogMessage && !r.rateLimiter.Allow(appId) {
+		r.logger.Debugf("MessageRouter:outgoingLogChan: Dropped rate limited message for appId [%s].", appId)
+		return
+	}
+
 	r.logger.Debugf("MessageRouter:outgoingLogChan: Searching for sinks with appId [%s].", appId)
 	r.sinkManager.SendTo(appId, envelope)
 	r.logger.Debugf("MessageRouter:outgoingLogChan: Done sending message.")
 }
+
+func (r *MessageRouter) sendRateLimitNotices() {
+	for appId, dropped := range r.rateLimiter.Dropped() {
+		logMessage := factories.NewLogMessage(events.LogMessage_ERR, fmt.Sprintf(rateLimitNotice, dropped), appId, "LGR")
+		envelope, err := emitter.Wrap(logMessage, r.dropsondeOrigin)
+		if err != nil {
+			r.logger.Warnf("MessageRouter: Error marshalling rate limit notice: %v", err)
+			continue
+		}
+
+		r.logger.Infof("MessageRouter: Rate limited appId [%s], %d messages dropped", appId, dropped)
+		r.sinkManager.SendTo(appId, envelope)
+	}
+}
@@ -2,7 +2,11 @@ package sinkserver_test
 
 import (
 	"doppler/sinkserver"
+	"doppler/sinkserver/ratelimiter"
+	"fmt"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/cloudfoundry/dropsonde/emitter"
 	"github.com/cloudfoundry/dropsonde/events"
@@ -50,7 +54,7 @@ var _ = Describe("Message Router", func() {
 
 	BeforeEach(func() {
 		fakeManager = &fakeSinkManager{receivedMessages: make([]*events.Envelope, 0), receivedDrains: make([][]string, 0)}
-		messageRouter = sinkserver.NewMessageRouter(fakeManager, loggertesthelper.Logger())
+		messageRouter = sinkserver.NewMessageRouter(fakeManager, nil, "origin", loggertesthelper.Logger())
 	})
 
 	Describe("Start", func() {
@@ -74,6 +78,79 @@ var _ = Describe("Message Router", func() {
 		})
 	})
 
+	Describe("with a rate limiter", func() {
+		var incomingLogChan chan *events.Envelope
+
+		BeforeEach(func() {
+			rateLimiter := ratelimiter.New(1, 3, 50*time.Millisecond)
+			messageRouter = sinkserver.NewMessageRouter(fakeManager, rateLimiter, "origin", loggertesthelper.Logger())
+			incomingLogChan = make(chan *events.Envelope)
+			go messageRouter.Start(incomingLogChan)
+		})
+
+		AfterEach(func() {
+			messageRouter.Stop()
+		})
+
+		sendLogs := func(appId string, count int) {
+			for i := 0; i < count; i++ {
+				message, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "testMessage", appId, "App"), "origin")
+				incomingLogChan <- message
+			}
+		}
+
+		countFor := func(appId string, text string) func() int {
+			return func() int {
+				count := 0
+				for _, envelope := range fakeManager.received() {
+					logMessage := envelope.GetLogMessage()
+					if logMessage.GetAppId() == appId && strings.Contains(string(logMessage.GetMessage()), text) {
+						count++
+					}
+				}
+				return count
+			}
+		}
+
+		It("drops the log messages of an app above its burst", func() {
+			sendLogs("noisyApp", 10)
+			Eventually(countFor("noisyApp", "testMessage")).Should(Equal(3))
+			Consistently(countFor("noisyApp", "testMessage"), 0.2).Should(Equal(3))
+		})
+
+		It("does not drop the messages of other apps", func() {
+			sendLogs("noisyApp", 10)
+			sendLogs("quietApp", 3)
+			Eventually(countFor("quietApp", "testMessage")).Should(Equal(3))
+		})
+
+		It("tells the app how many messages were dropped", func() {
+			sendLogs("noisyApp", 10)
+
+			droppedInNotices := func() int {
+				total := 0
+				for _, envelope := range fakeManager.received() {
+					var dropped int
+					_, err := fmt.Sscanf(string(envelope.GetLogMessage().GetMessage()), "Rate limited, %d messages dropped", &dropped)
+					if err == nil {
+						Expect(envelope.GetLogMessage().GetMessageType()).To(Equal(events.LogMessage_ERR))
+						total += dropped
+					}
+				}
+				return total
+			}
+			Eventually(droppedInNotices).Should(Equal(7))
+		})
+
+		It("does not limit other events", func() {
+			for i := 0; i < 10; i++ {
+				message, _ := emitter.Wrap(factories.NewContainerMetric("noisyApp", 0, 1, 2, 3), "origin")
+				incomingLogChan <- message
+			}
+			Eventually(fakeManager.received).Should(HaveLen(10))
+		})
+	})
+
 	Describe("Stop", func() {
 		It("returns", func() {
 			incomingLogChan := make(chan *events.Envelope)
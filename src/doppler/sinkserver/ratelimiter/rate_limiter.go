@@ -0,0 +1,140 @@
+package ratelimiter
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+)
+
+// idleBucketTimeout is how long the bucket of an app that sends nothing is
+// kept. Buckets of idle apps are full, so forgetting them changes nothing
+// but the per app metrics.
+const idleBucketTimeout = 5 * time.Minute
+
+// RateLimiter keeps a token bucket per app id. Every app gets its own rate
+// and burst, so an app that logs too much only loses its own messages.
+type RateLimiter struct {
+	rate           float64
+	burst          float64
+	noticeInterval time.Duration
+	now            func() time.Time
+
+	buckets      map[string]*bucket
+	totalDropped uint64
+	lock         sync.Mutex
+}
+
+type bucket struct {
+	tokens     float64
+	lastRefill time.Time
+	lastSeen   time.Time
+
+	// dropped counts the messages dropped since the last notice, total all
+	// messages dropped while the bucket is kept
+	dropped uint64
+	total   uint64
+}
+
+func New(messagesPerSecond int, burst int, noticeInterval time.Duration) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &RateLimiter{
+		rate:           float64(messagesPerSecond),
+		burst:          float64(burst),
+		noticeInterval: noticeInterval,
+		now:            time.Now,
+		buckets:        make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token from the bucket of the app and reports whether there
+// was one. Messages that are not allowed are counted as dropped.
+func (l *RateLimiter) Allow(appId string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[appId]
+	if !ok {
+		b = &bucket{tokens: l.burst, lastRefill: now}
+		l.buckets[appId] = b
+	}
+	b.lastSeen = now
+
+	b.tokens += now.Sub(b.lastRefill).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.lastRefill = now
+
+	if b.tokens < 1 {
+		b.dropped++
+		b.total++
+		l.totalDropped++
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+func (l *RateLimiter) NoticeInterval() time.Duration {
+	return l.noticeInterval
+}
+
+// Dropped returns the number of messages dropped per app since the last call
+// and forgets the buckets of apps that have been idle for a while.
+func (l *RateLimiter) Dropped() map[string]uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	dropped := make(map[string]uint64)
+	now := l.now()
+	for appId, b := range l.buckets {
+		if b.dropped > 0 {
+			dropped[appId] = b.dropped
+			b.dropped = 0
+			continue
+		}
+
+		if now.Sub(b.lastSeen) > idleBucketTimeout {
+			delete(l.buckets, appId)
+		}
+	}
+
+	return dropped
+}
+
+func (l *RateLimiter) Emit() instrumentation.Context {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	data := []instrumentation.Metric{
+		instrumentation.Metric{Name: "totalRateLimitedMessages", Value: l.totalDropped},
+	}
+
+	appIds := make([]string, 0, len(l.buckets))
+	for appId, b := range l.buckets {
+		if b.total > 0 {
+			appIds = append(appIds, appId)
+		}
+	}
+	sort.Strings(appIds)
+
+	for _, appId := range appIds {
+		data = append(data, instrumentation.Metric{
+			Name:  "rateLimitedMessages",
+			Value: l.buckets[appId].total,
+			Tags:  map[string]interface{}{"appId": appId},
+		})
+	}
+
+	return instrumentation.Context{
+		Name:    "rateLimiter",
+		Metrics: data,
+	}
+}
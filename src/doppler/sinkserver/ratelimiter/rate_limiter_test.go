@@ -0,0 +1,66 @@
+package ratelimiter_test
+
+import (
+	"doppler/sinkserver/ratelimiter"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RateLimiter", func() {
+	var rateLimiter *ratelimiter.RateLimiter
+
+	BeforeEach(func() {
+		rateLimiter = ratelimiter.New(20, 2, time.Second)
+	})
+
+	It("allows a burst of messages", func() {
+		Expect(rateLimiter.Allow("appId")).To(BeTrue())
+		Expect(rateLimiter.Allow("appId")).To(BeTrue())
+		Expect(rateLimiter.Allow("appId")).To(BeFalse())
+	})
+
+	It("refills the bucket at the configured rate", func() {
+		rateLimiter.Allow("appId")
+		rateLimiter.Allow("appId")
+		Expect(rateLimiter.Allow("appId")).To(BeFalse())
+
+		time.Sleep(100 * time.Millisecond)
+		Expect(rateLimiter.Allow("appId")).To(BeTrue())
+	})
+
+	It("keeps a bucket per app", func() {
+		rateLimiter.Allow("appId")
+		rateLimiter.Allow("appId")
+		Expect(rateLimiter.Allow("appId")).To(BeFalse())
+
+		Expect(rateLimiter.Allow("otherAppId")).To(BeTrue())
+	})
+
+	It("reports the messages dropped per app since the last call", func() {
+		for i := 0; i < 5; i++ {
+			rateLimiter.Allow("appId")
+		}
+		rateLimiter.Allow("otherAppId")
+
+		Expect(rateLimiter.Dropped()).To(Equal(map[string]uint64{"appId": 3}))
+		Expect(rateLimiter.Dropped()).To(BeEmpty())
+	})
+
+	It("emits the dropped messages in total and per app", func() {
+		for i := 0; i < 5; i++ {
+			rateLimiter.Allow("appId")
+		}
+		rateLimiter.Dropped()
+
+		context := rateLimiter.Emit()
+		Expect(context.Name).To(Equal("rateLimiter"))
+		Expect(context.Metrics).To(HaveLen(2))
+		Expect(context.Metrics[0].Name).To(Equal("totalRateLimitedMessages"))
+		Expect(context.Metrics[0].Value).To(Equal(uint64(3)))
+		Expect(context.Metrics[1].Name).To(Equal("rateLimitedMessages"))
+		Expect(context.Metrics[1].Value).To(Equal(uint64(3)))
+		Expect(context.Metrics[1].Tags).To(Equal(map[string]interface{}{"appId": "appId"}))
+	})
+})
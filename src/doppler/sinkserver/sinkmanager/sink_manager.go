@@ -1,6 +1,7 @@
 package sinkmanager
 
 import (
+	"doppler/diskqueue"
 	"doppler/groupedsinks"
 	"doppler/sinks"
 	"doppler/sinks/containermetric"
@@ -28,19 +29,21 @@ type SinkManager struct {
 	sinkDropUpdateChannel chan int64
 	metrics               *metrics.SinkManagerMetrics
 	recentLogCount        uint32
+	recentLogStore        dump.Store
+	retryQueues           *diskqueue.Queues
 
 	doneChannel            chan struct{}
 	errorChannel           chan *events.Envelope
 	urlBlacklistManager    *blacklist.URLBlacklistManager
 	sinks                  *groupedsinks.GroupedSinks
-	skipCertVerify         bool
+	writerOptions          syslogwriter.WriterOptions
 	sinkTimeout, metricTTL time.Duration
 	logger                 *gosteno.Logger
 
 	stopOnce sync.Once
 }
 
-func New(maxRetainedLogMessages uint32, skipCertVerify bool, blackListManager *blacklist.URLBlacklistManager, logger *gosteno.Logger, dropsondeOrigin string, sinkTimeout, metricTTL time.Duration) *SinkManager {
+func New(maxRetainedLogMessages uint32, writerOptions syslogwriter.WriterOptions, blackListManager *blacklist.URLBlacklistManager, logger *gosteno.Logger, dropsondeOrigin string, sinkTimeout, metricTTL time.Duration, recentLogStore dump.Store, retryQueues *diskqueue.Queues) *SinkManager {
 	sinkDropUpdateChannel := make(chan int64)
 
 	return &SinkManager{
@@ -48,8 +51,10 @@ func New(maxRetainedLogMessages uint32, skipCertVerify bool, blackListManager *b
 		errorChannel:          make(chan *events.Envelope, 100),
 		urlBlacklistManager:   blackListManager,
 		sinks:                 groupedsinks.NewGroupedSinks(logger),
-		skipCertVerify:        skipCertVerify,
+		writerOptions:         writerOptions,
 		recentLogCount:        maxRetainedLogMessages,
+		recentLogStore:        recentLogStore,
+		retryQueues:           retryQueues,
 		metrics:               metrics.NewSinkManagerMetrics(sinkDropUpdateChannel),
 		sinkDropUpdateChannel: sinkDropUpdateChannel,
 		logger:                logger,
@@ -144,10 +149,18 @@ func (sinkManager *SinkManager) UnregisterFirehoseSink(sink sinks.Sink) {
 func (sinkManager *SinkManager) RecentLogsFor(appId string) []*events.Envelope {
 	if sink := sinkManager.sinks.DumpFor(appId); sink != nil {
 		return sink.Dump()
-	} else {
-		sinkManager.logger.Debugf("SinkManager:DumpReceiverChan: No dump exists for appId [%s].", appId)
-		return []*events.Envelope{}
 	}
+
+	if sinkManager.recentLogStore != nil {
+		envelopes, err := sinkManager.recentLogStore.Read(appId)
+		if err == nil {
+			return envelopes
+		}
+		sinkManager.logger.Warnf("SinkManager:DumpReceiverChan: Error reading recent logs store for appId [%s]: %v", appId, err)
+	}
+
+	sinkManager.logger.Debugf("SinkManager:DumpReceiverChan: No dump exists for appId [%s].", appId)
+	return []*events.Envelope{}
 }
 
 func (sinkManager *SinkManager) LatestContainerMetrics(appId string) []*events.Envelope {
@@ -194,6 +207,13 @@ func (sinkManager *SinkManager) listenForDeletedAppServices(deletedAppServiceCha
 		if syslogSink != nil {
 			sinkManager.UnregisterSink(syslogSink)
 		}
+
+		if sinkManager.retryQueues != nil {
+			err := sinkManager.retryQueues.Remove(appService.AppId, appService.Url)
+			if err != nil {
+				sinkManager.logger.Warnf("SinkManager: Error removing retry queue of drain %s for app %s: %v", appService.Url, appService.AppId, err)
+			}
+		}
 	}
 }
 
@@ -221,12 +241,22 @@ func (sinkManager *SinkManager) registerNewSyslogSink(appId string, syslogSinkUr
 		return
 	}
 
-	syslogWriter, err := syslogwriter.NewWriter(parsedSyslogDrainUrl, appId, sinkManager.skipCertVerify)
+	syslogWriter, err := syslogwriter.NewWriter(parsedSyslogDrainUrl, appId, sinkManager.writerOptions)
 	if err != nil {
 		sinkManager.SendSyslogErrorToLoggregator(invalidSyslogUrlErrorMsg(appId, syslogSinkUrl, err), appId, syslogSinkUrl)
 		return
 	}
 
+	var retryQueue syslog.RetryQueue
+	if sinkManager.retryQueues != nil && sinkManager.sinks.DrainFor(appId, syslogSinkUrl) == nil {
+		queue, err := sinkManager.retryQueues.Open(appId, syslogSinkUrl)
+		if err != nil {
+			sinkManager.logger.Warnf("SinkManager: Error opening retry queue of drain %s for app %s, messages will not be retried: %v", syslogSinkUrl, appId, err)
+		} else {
+			retryQueue = queue
+		}
+	}
+
 	syslogSink := syslog.NewSyslogSink(
 		appId,
 		syslogSinkUrl,
@@ -235,10 +265,12 @@ func (sinkManager *SinkManager) registerNewSyslogSink(appId string, syslogSinkUr
 		sinkManager.SendSyslogErrorToLoggregator,
 		sinkManager.dropsondeOrigin,
 		sinkManager.sinkDropUpdateChannel,
+		retryQueue,
 	)
 
-	sinkManager.RegisterSink(syslogSink)
-
+	if !sinkManager.RegisterSink(syslogSink) && retryQueue != nil {
+		retryQueue.Close()
+	}
 }
 
 func invalidSyslogUrlErrorMsg(appId string, syslogSinkUrl string, err error) string {
@@ -256,6 +288,7 @@ func (sinkManager *SinkManager) ensureRecentLogsSinkFor(appId string) {
 		sinkManager.logger,
 		sinkManager.sinkTimeout,
 		sinkManager.sinkDropUpdateChannel,
+		sinkManager.recentLogStore,
 	)
 
 	sinkManager.RegisterSink(sink)
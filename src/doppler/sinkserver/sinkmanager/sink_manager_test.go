@@ -2,13 +2,16 @@ package sinkmanager_test
 
 import (
 	"doppler/iprange"
+	"doppler/logstore"
 	"doppler/sinks"
 	"doppler/sinks/dump"
 	"doppler/sinks/syslog"
 	"doppler/sinks/syslogwriter"
 	"doppler/sinkserver/blacklist"
 	"doppler/sinkserver/sinkmanager"
+	"io/ioutil"
 	"net/url"
+	"os"
 	"sync"
 	"time"
 
@@ -31,7 +34,7 @@ var _ = Describe("SinkManager", func() {
 	var newAppServiceChan, deletedAppServiceChan chan appservice.AppService
 
 	BeforeEach(func() {
-		sinkManager = sinkmanager.New(1, true, blackListManager, loggertesthelper.Logger(), "dropsonde-origin", 1*time.Second, 1*time.Second)
+		sinkManager = sinkmanager.New(1, syslogwriter.WriterOptions{SkipCertVerify: true}, blackListManager, loggertesthelper.Logger(), "dropsonde-origin", 1*time.Second, 1*time.Second, nil, nil)
 
 		newAppServiceChan = make(chan appservice.AppService)
 		deletedAppServiceChan = make(chan appservice.AppService)
@@ -273,7 +276,7 @@ var _ = Describe("SinkManager", func() {
 			var dumpSink *dump.DumpSink
 
 			BeforeEach(func() {
-				dumpSink = dump.NewDumpSink("appId", 1, loggertesthelper.Logger(), time.Hour, make(chan int64))
+				dumpSink = dump.NewDumpSink("appId", 1, loggertesthelper.Logger(), time.Hour, make(chan int64), nil)
 				sinkManager.RegisterSink(dumpSink)
 			})
 
@@ -301,7 +304,7 @@ var _ = Describe("SinkManager", func() {
 				url, err := url.Parse("syslog://localhost:9998")
 				Expect(err).To(BeNil())
 				writer, _ := syslogwriter.NewSyslogWriter(url, "appId")
-				syslogSink = syslog.NewSyslogSink("appId", "localhost:9999", loggertesthelper.Logger(), writer, func(string, string, string) {}, "dropsonde-origin", make(chan int64))
+				syslogSink = syslog.NewSyslogSink("appId", "localhost:9999", loggertesthelper.Logger(), writer, func(string, string, string) {}, "dropsonde-origin", make(chan int64), nil)
 
 				sinkManager.RegisterSink(syslogSink)
 			})
@@ -319,7 +322,7 @@ var _ = Describe("SinkManager", func() {
 			var dumpSink *dump.DumpSink
 
 			BeforeEach(func() {
-				dumpSink = dump.NewDumpSink("appId", 1, loggertesthelper.Logger(), time.Hour, make(chan int64))
+				dumpSink = dump.NewDumpSink("appId", 1, loggertesthelper.Logger(), time.Hour, make(chan int64), nil)
 				sinkManager.RegisterSink(dumpSink)
 			})
 
@@ -370,6 +373,62 @@ var _ = Describe("SinkManager", func() {
 		})
 	})
 
+	Describe("RecentLogsFor", func() {
+		Context("with a recent logs store", func() {
+			var storeDir string
+
+			BeforeEach(func() {
+				sinkManager.Stop()
+				<-sinkManagerDone
+
+				var err error
+				storeDir, err = ioutil.TempDir("", "recentlogs")
+				Expect(err).NotTo(HaveOccurred())
+
+				store, err := logstore.New(storeDir, 1024*1024, time.Hour, loggertesthelper.Logger())
+				Expect(err).NotTo(HaveOccurred())
+
+				sinkManager = sinkmanager.New(1, syslogwriter.WriterOptions{SkipCertVerify: true}, blackListManager, loggertesthelper.Logger(), "dropsonde-origin", 1*time.Second, 1*time.Second, store, nil)
+				sinkManagerDone = make(chan struct{})
+				go func() {
+					defer close(sinkManagerDone)
+					sinkManager.Start(newAppServiceChan, deletedAppServiceChan)
+				}()
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(storeDir)
+			})
+
+			It("returns more messages than the in-memory buffer holds", func() {
+				for _, text := range []string{"one", "two", "three"} {
+					message, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, text, "myApp", "App"), "origin")
+					sinkManager.SendTo("myApp", message)
+				}
+
+				Eventually(func() []*events.Envelope {
+					return sinkManager.RecentLogsFor("myApp")
+				}).Should(HaveLen(3))
+			})
+
+			It("still returns messages after the dump sink is gone", func() {
+				message, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "kept", "myApp", "App"), "origin")
+				sinkManager.SendTo("myApp", message)
+
+				Eventually(func() []*events.Envelope {
+					return sinkManager.RecentLogsFor("myApp")
+				}).Should(HaveLen(1))
+
+				dumpSink := dump.NewDumpSink("myApp", 1, loggertesthelper.Logger(), time.Hour, make(chan int64), nil)
+				sinkManager.UnregisterSink(dumpSink)
+
+				recentLogs := sinkManager.RecentLogsFor("myApp")
+				Expect(recentLogs).To(HaveLen(1))
+				Expect(string(recentLogs[0].GetLogMessage().GetMessage())).To(Equal("kept"))
+			})
+		})
+	})
+
 	Describe("Latest Container Metrics", func() {
 		var sink *channelSink
 		BeforeEach(func() {
@@ -1,6 +1,7 @@
 package sinkserver_test
 
 import (
+	"doppler/sinks/syslogwriter"
 	"doppler/sinkserver"
 	"doppler/sinkserver/blacklist"
 	"doppler/sinkserver/sinkmanager"
@@ -46,8 +47,8 @@ var _ = Describe("Dumping", func() {
 		deletedAppServiceChan := make(chan appservice.AppService)
 
 		emptyBlacklist := blacklist.New(nil)
-		sinkManager = sinkmanager.New(1024, false, emptyBlacklist, logger, "dropsonde-origin",
-			2*time.Second, 1*time.Second)
+		sinkManager = sinkmanager.New(1024, syslogwriter.WriterOptions{}, emptyBlacklist, logger, "dropsonde-origin",
+			2*time.Second, 1*time.Second, nil, nil)
 
 		services.Add(1)
 		goRoutineSpawned.Add(1)
@@ -57,7 +58,7 @@ var _ = Describe("Dumping", func() {
 			sinkManager.Start(newAppServiceChan, deletedAppServiceChan)
 		}()
 
-		TestMessageRouter = sinkserver.NewMessageRouter(sinkManager, logger)
+		TestMessageRouter = sinkserver.NewMessageRouter(sinkManager, nil, "dropsonde-origin", logger)
 
 		services.Add(1)
 		goRoutineSpawned.Add(1)
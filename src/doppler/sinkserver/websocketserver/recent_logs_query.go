@@ -0,0 +1,144 @@
+package websocketserver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/cloudfoundry/dropsonde/events"
+	"github.com/gogo/protobuf/proto"
+)
+
+// recentLogsQuery selects a window of recent logs. The cursor is issued by the
+// trafficcontroller and encodes the timestamp of the last message it returned
+// along with how many messages at that timestamp were already returned. Ties
+// at the cursor timestamp are resolved by the trafficcontroller, so dopplers
+// return them all and widen the limit accordingly. Messages are ordered by
+// timestamp and then by their marshalled bytes like the trafficcontroller
+// orders them, so every doppler cuts off the same messages at the limit.
+type recentLogsQuery struct {
+	startTime       int64
+	endTime         int64
+	limit           int
+	cursorTimestamp int64
+	cursorSkip      int
+}
+
+func parseRecentLogsQuery(values url.Values) (recentLogsQuery, error) {
+	var query recentLogsQuery
+	var err error
+
+	if value := values.Get("start_time"); value != "" {
+		query.startTime, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return query, fmt.Errorf("invalid start_time %q", value)
+		}
+	}
+
+	if value := values.Get("end_time"); value != "" {
+		query.endTime, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return query, fmt.Errorf("invalid end_time %q", value)
+		}
+	}
+
+	if value := values.Get("limit"); value != "" {
+		query.limit, err = strconv.Atoi(value)
+		if err != nil || query.limit < 0 {
+			return query, fmt.Errorf("invalid limit %q", value)
+		}
+	}
+
+	if value := values.Get("cursor"); value != "" {
+		query.cursorTimestamp, query.cursorSkip, err = decodeCursor(value)
+		if err != nil {
+			return query, fmt.Errorf("invalid cursor %q", value)
+		}
+	}
+
+	return query, nil
+}
+
+func (q recentLogsQuery) isEmpty() bool {
+	return q == recentLogsQuery{}
+}
+
+func (q recentLogsQuery) filter(envelopes []*events.Envelope) []*events.Envelope {
+	if q.isEmpty() {
+		return envelopes
+	}
+
+	sorted := make([]marshalledEnvelope, len(envelopes))
+	for i, envelope := range envelopes {
+		data, _ := proto.Marshal(envelope)
+		sorted[i] = marshalledEnvelope{envelope: envelope, data: data}
+	}
+	sort.Sort(byTimestamp(sorted))
+
+	start := q.startTime
+	if q.cursorTimestamp > start {
+		start = q.cursorTimestamp
+	}
+
+	limit := q.limit
+	if limit > 0 {
+		limit += q.cursorSkip
+	}
+
+	results := []*events.Envelope{}
+	for _, marshalled := range sorted {
+		envelope := marshalled.envelope
+		timestamp := envelope.GetTimestamp()
+		if timestamp < start {
+			continue
+		}
+
+		if q.endTime > 0 && timestamp >= q.endTime {
+			break
+		}
+
+		if limit > 0 && len(results) == limit {
+			break
+		}
+
+		results = append(results, envelope)
+	}
+
+	return results
+}
+
+func decodeCursor(cursor string) (int64, int, error) {
+	decoded, err := base64.URLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var timestamp int64
+	var skip int
+	_, err = fmt.Sscanf(string(decoded), "%d:%d", &timestamp, &skip)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return timestamp, skip, nil
+}
+
+type marshalledEnvelope struct {
+	envelope *events.Envelope
+	data     []byte
+}
+
+type byTimestamp []marshalledEnvelope
+
+func (e byTimestamp) Len() int      { return len(e) }
+func (e byTimestamp) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+
+func (e byTimestamp) Less(i, j int) bool {
+	if e[i].envelope.GetTimestamp() != e[j].envelope.GetTimestamp() {
+		return e[i].envelope.GetTimestamp() < e[j].envelope.GetTimestamp()
+	}
+	return bytes.Compare(e[i].data, e[j].data) < 0
+}
@@ -102,8 +102,14 @@ func (w *WebsocketServer) ServeHTTP(writer http.ResponseWriter, request *http.Re
 func (w *WebsocketServer) firehoseHandler(writer http.ResponseWriter, request *http.Request) (wsHandler, error) {
 	firehoseSubscriptionId := strings.Split(request.URL.Path, "/")[2]
 
+	filter, err := websocket.NewEnvelopeFilter(request.URL.Query())
+	if err != nil {
+		http.Error(writer, err.Error(), 400)
+		return nil, fmt.Errorf("Invalid filter (returning 400): %s", err.Error())
+	}
+
 	f := func(ws *gorilla.Conn) {
-		w.streamFirehose(firehoseSubscriptionId, ws)
+		w.streamFirehose(firehoseSubscriptionId, filter, ws)
 	}
 	return f, nil
 
@@ -134,9 +140,25 @@ func (w *WebsocketServer) appHandler(writer http.ResponseWriter, request *http.R
 
 	switch endpoint {
 	case "stream":
-		handler = w.streamLogs
+		filter, err := websocket.NewEnvelopeFilter(request.URL.Query())
+		if err != nil {
+			http.Error(writer, err.Error(), 400)
+			return nil, fmt.Errorf("Invalid filter (returning 400): %s", err.Error())
+		}
+
+		handler = func(appId string, ws *gorilla.Conn) {
+			w.streamLogs(appId, filter, ws)
+		}
 	case "recentlogs":
-		handler = w.recentLogs
+		query, err := parseRecentLogsQuery(request.URL.Query())
+		if err != nil {
+			http.Error(writer, err.Error(), 400)
+			return nil, fmt.Errorf("Invalid recent logs query (returning 400): %s", err.Error())
+		}
+
+		handler = func(appId string, ws *gorilla.Conn) {
+			w.recentLogs(appId, query, ws)
+		}
 	case "containermetrics":
 		handler = w.latestContainerMetrics
 	default:
@@ -150,17 +172,17 @@ func (w *WebsocketServer) appHandler(writer http.ResponseWriter, request *http.R
 	return f, nil
 }
 
-func (w *WebsocketServer) streamLogs(appId string, websocketConnection *gorilla.Conn) {
+func (w *WebsocketServer) streamLogs(appId string, filter *websocket.EnvelopeFilter, websocketConnection *gorilla.Conn) {
 	w.logger.Debugf("WebsocketServer: Requesting a wss sink for app %s", appId)
-	w.streamWebsocket(appId, websocketConnection, w.sinkManager.RegisterSink, w.sinkManager.UnregisterSink)
+	w.streamWebsocket(appId, filter, websocketConnection, w.sinkManager.RegisterSink, w.sinkManager.UnregisterSink)
 }
 
-func (w *WebsocketServer) streamFirehose(subscriptionId string, websocketConnection *gorilla.Conn) {
+func (w *WebsocketServer) streamFirehose(subscriptionId string, filter *websocket.EnvelopeFilter, websocketConnection *gorilla.Conn) {
 	w.logger.Debugf("WebsocketServer: Requesting firehose wss sink")
-	w.streamWebsocket(subscriptionId, websocketConnection, w.sinkManager.RegisterFirehoseSink, w.sinkManager.UnregisterFirehoseSink)
+	w.streamWebsocket(subscriptionId, filter, websocketConnection, w.sinkManager.RegisterFirehoseSink, w.sinkManager.UnregisterFirehoseSink)
 }
 
-func (w *WebsocketServer) streamWebsocket(appId string, websocketConnection *gorilla.Conn, register func(sinks.Sink) bool, unregister func(sinks.Sink)) {
+func (w *WebsocketServer) streamWebsocket(appId string, filter *websocket.EnvelopeFilter, websocketConnection *gorilla.Conn, register func(sinks.Sink) bool, unregister func(sinks.Sink)) {
 	websocketSink := websocket.NewWebsocketSink(
 		appId,
 		w.logger,
@@ -168,6 +190,7 @@ func (w *WebsocketServer) streamWebsocket(appId string, websocketConnection *gor
 		w.bufferSize,
 		w.dropsondeOrigin,
 		w.sinkManager.SinkDropUpdateChannel(),
+		filter,
 	)
 
 	register(websocketSink)
@@ -177,8 +200,8 @@ func (w *WebsocketServer) streamWebsocket(appId string, websocketConnection *gor
 	server.NewKeepAlive(websocketConnection, w.keepAliveInterval).Run()
 }
 
-func (w *WebsocketServer) recentLogs(appId string, websocketConnection *gorilla.Conn) {
-	logMessages := w.sinkManager.RecentLogsFor(appId)
+func (w *WebsocketServer) recentLogs(appId string, query recentLogsQuery, websocketConnection *gorilla.Conn) {
+	logMessages := query.filter(w.sinkManager.RecentLogsFor(appId))
 	sendMessagesToWebsocket(logMessages, websocketConnection, w.logger)
 }
 
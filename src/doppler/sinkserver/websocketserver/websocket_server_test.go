@@ -1,9 +1,11 @@
 package websocketserver_test
 
 import (
+	"doppler/sinks/syslogwriter"
 	"doppler/sinkserver/blacklist"
 	"doppler/sinkserver/sinkmanager"
 	"doppler/sinkserver/websocketserver"
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"time"
@@ -12,6 +14,7 @@ import (
 	"github.com/cloudfoundry/dropsonde/factories"
 	"github.com/cloudfoundry/loggregatorlib/cfcomponent"
 	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+	"github.com/gogo/protobuf/proto"
 	"github.com/gorilla/websocket"
 
 	"github.com/cloudfoundry/dropsonde/emitter"
@@ -22,7 +25,7 @@ import (
 var _ = Describe("WebsocketServer", func() {
 
 	var server *websocketserver.WebsocketServer
-	var sinkManager = sinkmanager.New(1024, false, blacklist.New(nil), loggertesthelper.Logger(), "dropsonde-origin", 1*time.Second, 1*time.Second)
+	var sinkManager = sinkmanager.New(1024, syslogwriter.WriterOptions{}, blacklist.New(nil), loggertesthelper.Logger(), "dropsonde-origin", 1*time.Second, 1*time.Second, nil, nil)
 	var appId = "my-app"
 	var wsReceivedChan chan []byte
 	var connectionDropped <-chan struct{}
@@ -69,6 +72,81 @@ var _ = Describe("WebsocketServer", func() {
 		close(done)
 	})
 
+	Context("with a recent logs query", func() {
+		var queriedAppId string
+
+		BeforeEach(func() {
+			queriedAppId = fmt.Sprintf("queried-app-%d", time.Now().UnixNano())
+			for i := 1; i <= 5; i++ {
+				lm, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, fmt.Sprintf("message %d", i), queriedAppId, "App"), "origin")
+				lm.Timestamp = proto.Int64(int64(i * 1000))
+				sinkManager.SendTo(queriedAppId, lm)
+			}
+
+			Eventually(func() []*events.Envelope { return sinkManager.RecentLogsFor(queriedAppId) }).Should(HaveLen(5))
+		})
+
+		It("only dumps messages within the time range", func(done Done) {
+			AddWSSink(wsReceivedChan, fmt.Sprintf("ws://%s/apps/%s/recentlogs?start_time=2000&end_time=4000", apiEndpoint, queriedAppId))
+
+			rlm, err := receiveEnvelope(wsReceivedChan)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(rlm.GetLogMessage().GetMessage())).To(Equal("message 2"))
+
+			rlm, err = receiveEnvelope(wsReceivedChan)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(rlm.GetLogMessage().GetMessage())).To(Equal("message 3"))
+
+			Consistently(wsReceivedChan).ShouldNot(Receive())
+			close(done)
+		}, 3)
+
+		It("honors the limit and the cursor", func(done Done) {
+			cursor := base64.URLEncoding.EncodeToString([]byte("3000:1"))
+			AddWSSink(wsReceivedChan, fmt.Sprintf("ws://%s/apps/%s/recentlogs?limit=1&cursor=%s", apiEndpoint, queriedAppId, cursor))
+
+			rlm, err := receiveEnvelope(wsReceivedChan)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(rlm.GetLogMessage().GetMessage())).To(Equal("message 3"))
+
+			rlm, err = receiveEnvelope(wsReceivedChan)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(rlm.GetLogMessage().GetMessage())).To(Equal("message 4"))
+
+			Consistently(wsReceivedChan).ShouldNot(Receive())
+			close(done)
+		}, 3)
+
+		It("cuts off messages with the same timestamp in the order of their bytes", func(done Done) {
+			for _, message := range []string{"tie c", "tie b", "tie a"} {
+				lm, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, message, queriedAppId, "App"), "origin")
+				lm.Timestamp = proto.Int64(7000)
+				sinkManager.SendTo(queriedAppId, lm)
+			}
+			Eventually(func() []*events.Envelope { return sinkManager.RecentLogsFor(queriedAppId) }).Should(HaveLen(8))
+
+			// the marshalled envelopes only differ in the log message, so
+			// they are ordered by it
+			AddWSSink(wsReceivedChan, fmt.Sprintf("ws://%s/apps/%s/recentlogs?start_time=7000&limit=2", apiEndpoint, queriedAppId))
+
+			rlm, err := receiveEnvelope(wsReceivedChan)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(rlm.GetLogMessage().GetMessage())).To(Equal("tie a"))
+
+			rlm, err = receiveEnvelope(wsReceivedChan)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(rlm.GetLogMessage().GetMessage())).To(Equal("tie b"))
+
+			Consistently(wsReceivedChan).ShouldNot(Receive())
+			close(done)
+		}, 3)
+
+		It("rejects invalid queries", func() {
+			_, connectionDropped = AddWSSink(wsReceivedChan, fmt.Sprintf("ws://%s/apps/%s/recentlogs?limit=lots", apiEndpoint, queriedAppId))
+			Expect(connectionDropped).To(BeClosed())
+		})
+	})
+
 	It("dumps container metric data to the websocket client with /containermetrics", func(done Done) {
 		cm := factories.NewContainerMetric(appId, 0, 42.42, 1234, 123412341234)
 		envelope, _ := emitter.Wrap(cm, "origin")
@@ -94,6 +172,39 @@ var _ = Describe("WebsocketServer", func() {
 		close(done)
 	})
 
+	It("only sends matching data to a filtered /stream", func(done Done) {
+		stopKeepAlive, _ := AddWSSink(wsReceivedChan, fmt.Sprintf("ws://%s/apps/%s/stream?message_type=ERR", apiEndpoint, appId))
+		outMessage, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "out message", appId, "App"), "origin")
+		errMessage, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_ERR, "err message", appId, "App"), "origin")
+		sinkManager.SendTo(appId, outMessage)
+		sinkManager.SendTo(appId, errMessage)
+
+		rlm, err := receiveEnvelope(wsReceivedChan)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(rlm.GetLogMessage().GetMessage())).To(Equal("err message"))
+		close(stopKeepAlive)
+		close(done)
+	})
+
+	It("only sends matching data to a filtered firehose", func(done Done) {
+		stopKeepAlive, _ := AddWSSink(wsReceivedChan, fmt.Sprintf("ws://%s/firehose/fire-subscription-filtered?event_type=ValueMetric", apiEndpoint))
+		lm, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "my message", appId, "App"), "origin")
+		vm, _ := emitter.Wrap(factories.NewValueMetric("my-metric", 1, "unit"), "origin")
+		sinkManager.SendTo(appId, lm)
+		sinkManager.SendTo(appId, vm)
+
+		received, err := receiveEnvelope(wsReceivedChan)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(received.GetEventType()).To(Equal(events.Envelope_ValueMetric))
+		close(stopKeepAlive)
+		close(done)
+	})
+
+	It("rejects an invalid filter", func() {
+		_, connectionDropped = AddWSSink(wsReceivedChan, fmt.Sprintf("ws://%s/apps/%s/stream?event_type=Bogus", apiEndpoint, appId))
+		Expect(connectionDropped).To(BeClosed())
+	})
+
 	It("sends data to the websocket firehose client", func(done Done) {
 		stopKeepAlive, _ := AddWSSink(wsReceivedChan, fmt.Sprintf("ws://%s/firehose/fire-subscription-a", apiEndpoint))
 		lm, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "my message", appId, "App"), "origin")
@@ -0,0 +1,236 @@
+package tcplistener
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+)
+
+// MaxMessageSize is the largest frame accepted. Larger frames mean the
+// connection is out of sync or not talking to metron, so it is closed.
+const MaxMessageSize = 1024 * 1024
+
+// TcpListener accepts connections from metron carrying signed envelopes. Every
+// message is framed by its length as a 4 byte big endian unsigned integer.
+type TcpListener struct {
+	address   string
+	tlsConfig *tls.Config
+	dataChan  chan []byte
+	logger    *gosteno.Logger
+
+	listener    net.Listener
+	connections map[net.Conn]struct{}
+	stopped     bool
+	lock        sync.Mutex
+	handlers    sync.WaitGroup
+
+	receivedMessageCount uint64
+	receivedByteCount    uint64
+	readErrors           uint64
+	openConnections      int64
+}
+
+// New creates a listener on address, using TLS when tlsConfig is not nil.
+func New(address string, tlsConfig *tls.Config, logger *gosteno.Logger) (*TcpListener, <-chan []byte) {
+	dataChan := make(chan []byte, 1024)
+	return &TcpListener{
+		address:     address,
+		tlsConfig:   tlsConfig,
+		dataChan:    dataChan,
+		logger:      logger,
+		connections: make(map[net.Conn]struct{}),
+	}, dataChan
+}
+
+// NewTLSConfig requires clients to present a certificate signed by the CA in
+// caCertFile, unless it is empty.
+func NewTLSConfig(certFile, keyFile, caCertFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if caCertFile != "" {
+		pemCerts, err := ioutil.ReadFile(caCertFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemCerts) {
+			return nil, fmt.Errorf("no certificates found in %s", caCertFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// Start accepts connections until Stop is called. It returns the error of
+// listening on the address right away, e.g. when the port is taken.
+func (l *TcpListener) Start() error {
+	listener, err := net.Listen("tcp", l.address)
+	if err != nil {
+		close(l.dataChan)
+		return err
+	}
+	if l.tlsConfig != nil {
+		listener = tls.NewListener(listener, l.tlsConfig)
+	}
+
+	l.lock.Lock()
+	if l.stopped {
+		l.lock.Unlock()
+		listener.Close()
+		close(l.dataChan)
+		return nil
+	}
+	l.listener = listener
+	l.lock.Unlock()
+
+	l.logger.Infof("TCP listener listening on %s", l.address)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			break
+		}
+
+		if !l.track(conn) {
+			conn.Close()
+			break
+		}
+
+		l.handlers.Add(1)
+		go l.handleConnection(conn)
+	}
+
+	l.handlers.Wait()
+	close(l.dataChan)
+	return nil
+}
+
+func (l *TcpListener) Stop() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.stopped {
+		return
+	}
+	l.stopped = true
+
+	if l.listener != nil {
+		l.listener.Close()
+	}
+	for conn := range l.connections {
+		conn.Close()
+	}
+}
+
+func (l *TcpListener) Address() string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.listener == nil {
+		return ""
+	}
+	return l.listener.Addr().String()
+}
+
+func (l *TcpListener) Emit() instrumentation.Context {
+	data := []instrumentation.Metric{
+		instrumentation.Metric{Name: "receivedMessageCount", Value: atomic.LoadUint64(&l.receivedMessageCount)},
+		instrumentation.Metric{Name: "receivedByteCount", Value: atomic.LoadUint64(&l.receivedByteCount)},
+		instrumentation.Metric{Name: "readErrors", Value: atomic.LoadUint64(&l.readErrors)},
+		instrumentation.Metric{Name: "openConnections", Value: atomic.LoadInt64(&l.openConnections)},
+	}
+
+	return instrumentation.Context{
+		Name:    "dropsondeTcpListener",
+		Metrics: data,
+	}
+}
+
+func (l *TcpListener) track(conn net.Conn) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.stopped {
+		return false
+	}
+	l.connections[conn] = struct{}{}
+	atomic.AddInt64(&l.openConnections, 1)
+	return true
+}
+
+func (l *TcpListener) untrack(conn net.Conn) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	delete(l.connections, conn)
+	atomic.AddInt64(&l.openConnections, -1)
+}
+
+func (l *TcpListener) handleConnection(conn net.Conn) {
+	defer l.handlers.Done()
+	defer l.untrack(conn)
+	defer conn.Close()
+
+	for {
+		message, err := readMessage(conn)
+		if err != nil {
+			if err != io.EOF && !l.isStopped() {
+				atomic.AddUint64(&l.readErrors, 1)
+				l.logger.Warnf("TCP listener: error reading from %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		atomic.AddUint64(&l.receivedMessageCount, 1)
+		atomic.AddUint64(&l.receivedByteCount, uint64(len(message)))
+		l.dataChan <- message
+	}
+}
+
+func (l *TcpListener) isStopped() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.stopped
+}
+
+func readMessage(reader io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	_, err := io.ReadFull(reader, header)
+	if err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header)
+	if size > MaxMessageSize {
+		return nil, errors.New(fmt.Sprintf("message of %d bytes exceeds the maximum of %d bytes", size, MaxMessageSize))
+	}
+
+	message := make([]byte, size)
+	_, err = io.ReadFull(reader, message)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return message, err
+}
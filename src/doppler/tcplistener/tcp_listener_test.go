@@ -0,0 +1,162 @@
+package tcplistener_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"doppler/tcplistener"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TcpListener", func() {
+	var (
+		listener *tcplistener.TcpListener
+		dataChan <-chan []byte
+		done     chan struct{}
+	)
+
+	start := func(tlsConfig *tls.Config) {
+		listener, dataChan = tcplistener.New("127.0.0.1:0", tlsConfig, loggertesthelper.Logger())
+		done = make(chan struct{})
+		go func() {
+			listener.Start()
+			close(done)
+		}()
+		Eventually(listener.Address).ShouldNot(BeEmpty())
+	}
+
+	frame := func(message string) []byte {
+		framed := make([]byte, 4+len(message))
+		binary.BigEndian.PutUint32(framed, uint32(len(message)))
+		copy(framed[4:], message)
+		return framed
+	}
+
+	AfterEach(func() {
+		listener.Stop()
+		Eventually(done).Should(BeClosed())
+	})
+
+	Context("without TLS", func() {
+		BeforeEach(func() {
+			start(nil)
+		})
+
+		It("reads length prefixed messages", func() {
+			conn, err := net.Dial("tcp", listener.Address())
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+
+			conn.Write(append(frame("first"), frame("second")...))
+
+			Eventually(dataChan).Should(Receive(Equal([]byte("first"))))
+			Eventually(dataChan).Should(Receive(Equal([]byte("second"))))
+		})
+
+		It("reads messages split across writes", func() {
+			conn, err := net.Dial("tcp", listener.Address())
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+
+			framed := frame("split message")
+			conn.Write(framed[:2])
+			conn.Write(framed[2:7])
+			conn.Write(framed[7:])
+
+			Eventually(dataChan).Should(Receive(Equal([]byte("split message"))))
+		})
+
+		It("closes connections sending oversized messages", func() {
+			conn, err := net.Dial("tcp", listener.Address())
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+
+			header := make([]byte, 4)
+			binary.BigEndian.PutUint32(header, tcplistener.MaxMessageSize+1)
+			conn.Write(header)
+
+			// returns once the listener closed the connection
+			ioutil.ReadAll(conn)
+			Eventually(func() interface{} { return listener.Emit().Metrics[2].Value }).Should(Equal(uint64(1)))
+		})
+
+		It("emits metrics", func() {
+			conn, err := net.Dial("tcp", listener.Address())
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+
+			conn.Write(frame("message"))
+			Eventually(dataChan).Should(Receive())
+
+			context := listener.Emit()
+			Expect(context.Name).To(Equal("dropsondeTcpListener"))
+			Expect(context.Metrics[0].Name).To(Equal("receivedMessageCount"))
+			Expect(context.Metrics[0].Value).To(Equal(uint64(1)))
+			Expect(context.Metrics[1].Name).To(Equal("receivedByteCount"))
+			Expect(context.Metrics[1].Value).To(Equal(uint64(7)))
+			Expect(context.Metrics[3].Name).To(Equal("openConnections"))
+			Expect(context.Metrics[3].Value).To(Equal(int64(1)))
+		})
+
+		It("closes the data channel when stopped", func() {
+			conn, err := net.Dial("tcp", listener.Address())
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+
+			listener.Stop()
+			Eventually(dataChan).Should(BeClosed())
+		})
+
+		It("returns an error when the address is taken", func() {
+			taken, takenDataChan := tcplistener.New(listener.Address(), nil, loggertesthelper.Logger())
+			Expect(taken.Start()).To(HaveOccurred())
+			Expect(takenDataChan).To(BeClosed())
+		})
+	})
+
+	Context("with TLS", func() {
+		var clientConfig *tls.Config
+
+		BeforeEach(func() {
+			tlsConfig, err := tcplistener.NewTLSConfig("fixtures/server.crt", "fixtures/server.key", "fixtures/ca.crt")
+			Expect(err).ToNot(HaveOccurred())
+			start(tlsConfig)
+
+			caPEM, err := ioutil.ReadFile("fixtures/ca.crt")
+			Expect(err).ToNot(HaveOccurred())
+			rootCAs := x509.NewCertPool()
+			rootCAs.AppendCertsFromPEM(caPEM)
+
+			clientCert, err := tls.LoadX509KeyPair("fixtures/client.crt", "fixtures/client.key")
+			Expect(err).ToNot(HaveOccurred())
+			clientConfig = &tls.Config{RootCAs: rootCAs, Certificates: []tls.Certificate{clientCert}}
+		})
+
+		It("reads messages from clients with a trusted certificate", func() {
+			conn, err := tls.Dial("tcp", listener.Address(), clientConfig)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+
+			conn.Write(frame("secure message"))
+			Eventually(dataChan).Should(Receive(Equal([]byte("secure message"))))
+		})
+
+		It("rejects clients without a certificate", func() {
+			clientConfig.Certificates = nil
+			conn, err := tls.Dial("tcp", listener.Address(), clientConfig)
+			if err == nil {
+				defer conn.Close()
+				conn.Write(frame("insecure message"))
+				_, err = conn.Read(make([]byte, 1))
+			}
+			Expect(err).To(HaveOccurred())
+			Consistently(dataChan).ShouldNot(Receive())
+		})
+	})
+})
@@ -0,0 +1,80 @@
+package dropsonde_batch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// FrameFormat is the name dopplers advertise when they accept batch frames.
+const FrameFormat = "batch-v1"
+
+// AdvertisementPath is where dopplers accepting batch frames keep their
+// address in etcd, next to their /healthstatus/doppler heartbeat.
+const AdvertisementPath = "/doppler/frameformats/" + FrameFormat
+
+// frameHeader starts every batch frame. Marshalled envelopes start with the
+// key of their origin field, so they can not be mistaken for a frame.
+var frameHeader = []byte{0xff, 'B', 1}
+
+// FrameOverhead is the number of bytes a frame adds to every message.
+const FrameOverhead = 4
+
+var ErrInvalidFrame = errors.New("invalid batch frame")
+
+// IsBatch reports whether message is a batch frame rather than a single
+// marshalled envelope.
+func IsBatch(message []byte) bool {
+	return bytes.HasPrefix(message, frameHeader)
+}
+
+// Size returns the size of a frame holding messages of the given total size.
+func Size(messageCount int, messageBytes int) int {
+	return len(frameHeader) + messageCount*FrameOverhead + messageBytes
+}
+
+// Marshal packs the messages into one frame, each prefixed with its length as
+// a 4 byte big endian unsigned integer.
+func Marshal(messages [][]byte) []byte {
+	size := 0
+	for _, message := range messages {
+		size += len(message)
+	}
+
+	frame := make([]byte, len(frameHeader), Size(len(messages), size))
+	copy(frame, frameHeader)
+
+	length := make([]byte, FrameOverhead)
+	for _, message := range messages {
+		binary.BigEndian.PutUint32(length, uint32(len(message)))
+		frame = append(frame, length...)
+		frame = append(frame, message...)
+	}
+	return frame
+}
+
+// Unmarshal returns the messages of a frame created by Marshal.
+func Unmarshal(frame []byte) ([][]byte, error) {
+	if !IsBatch(frame) {
+		return nil, ErrInvalidFrame
+	}
+
+	var messages [][]byte
+	rest := frame[len(frameHeader):]
+	for len(rest) > 0 {
+		if len(rest) < FrameOverhead {
+			return nil, ErrInvalidFrame
+		}
+
+		length := binary.BigEndian.Uint32(rest)
+		rest = rest[FrameOverhead:]
+		if uint64(length) > uint64(len(rest)) {
+			return nil, fmt.Errorf("%v: message of %d bytes exceeds the %d bytes left", ErrInvalidFrame, length, len(rest))
+		}
+
+		messages = append(messages, rest[:length])
+		rest = rest[length:]
+	}
+	return messages, nil
+}
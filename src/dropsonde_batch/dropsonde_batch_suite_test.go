@@ -0,0 +1,13 @@
+package dropsonde_batch_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestDropsondeBatch(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Dropsonde Batch Suite")
+}
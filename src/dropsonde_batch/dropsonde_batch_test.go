@@ -0,0 +1,100 @@
+package dropsonde_batch_test
+
+import (
+	"dropsonde_batch"
+
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DropsondeBatch", func() {
+	Describe("Marshal and Unmarshal", func() {
+		It("round trips the messages", func() {
+			messages := [][]byte{[]byte("first"), []byte(""), []byte("third message")}
+
+			frame := dropsonde_batch.Marshal(messages)
+			Expect(frame).To(HaveLen(dropsonde_batch.Size(3, 18)))
+			Expect(dropsonde_batch.IsBatch(frame)).To(BeTrue())
+
+			unpacked, err := dropsonde_batch.Unmarshal(frame)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(unpacked).To(Equal(messages))
+		})
+
+		It("does not treat a marshalled envelope as a frame", func() {
+			Expect(dropsonde_batch.IsBatch([]byte{0x0a, 0x06, 'o', 'r', 'i', 'g', 'i', 'n'})).To(BeFalse())
+			Expect(dropsonde_batch.IsBatch(nil)).To(BeFalse())
+		})
+
+		It("returns an error for a truncated frame", func() {
+			frame := dropsonde_batch.Marshal([][]byte{[]byte("message")})
+
+			_, err := dropsonde_batch.Unmarshal(frame[:len(frame)-1])
+			Expect(err).To(HaveOccurred())
+
+			_, err = dropsonde_batch.Unmarshal(append(frame, 0, 0))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("returns an error for a message that is not a frame", func() {
+			_, err := dropsonde_batch.Unmarshal([]byte("message"))
+			Expect(err).To(Equal(dropsonde_batch.ErrInvalidFrame))
+		})
+	})
+
+	Describe("Unpacker", func() {
+		var unpacker *dropsonde_batch.Unpacker
+		var inputChan chan []byte
+		var outputChan chan []byte
+
+		BeforeEach(func() {
+			unpacker = dropsonde_batch.NewUnpacker(loggertesthelper.Logger())
+			inputChan = make(chan []byte, 10)
+			outputChan = make(chan []byte, 10)
+			go unpacker.Run(inputChan, outputChan)
+		})
+
+		AfterEach(func() {
+			close(inputChan)
+		})
+
+		metric := func(name string) interface{} {
+			for _, m := range unpacker.Emit().Metrics {
+				if m.Name == name {
+					return m.Value
+				}
+			}
+			return nil
+		}
+
+		It("passes single messages through", func() {
+			inputChan <- []byte("message")
+			Eventually(outputChan).Should(Receive(Equal([]byte("message"))))
+		})
+
+		It("forwards every message of a frame in order", func() {
+			inputChan <- dropsonde_batch.Marshal([][]byte{[]byte("first"), []byte("second")})
+
+			Eventually(outputChan).Should(Receive(Equal([]byte("first"))))
+			Eventually(outputChan).Should(Receive(Equal([]byte("second"))))
+			Eventually(func() interface{} { return metric("receivedFrameCount") }).Should(Equal(uint64(1)))
+			Expect(metric("unpackedMessageCount")).To(Equal(uint64(2)))
+		})
+
+		It("drops invalid frames", func() {
+			frame := dropsonde_batch.Marshal([][]byte{[]byte("message")})
+			inputChan <- frame[:len(frame)-1]
+
+			Eventually(func() interface{} { return metric("invalidFrameCount") }).Should(Equal(uint64(1)))
+			Consistently(outputChan).ShouldNot(Receive())
+		})
+
+		It("emits its metrics in the batchUnpacker context", func() {
+			var context instrumentation.Context = unpacker.Emit()
+			Expect(context.Name).To(Equal("batchUnpacker"))
+		})
+	})
+})
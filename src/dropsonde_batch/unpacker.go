@@ -0,0 +1,57 @@
+package dropsonde_batch
+
+import (
+	"sync/atomic"
+
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+)
+
+// Unpacker splits batch frames into their messages. Messages that are not
+// batch frames are passed on unchanged, so it works with any metron version.
+type Unpacker struct {
+	logger *gosteno.Logger
+
+	receivedFrameCount   uint64
+	unpackedMessageCount uint64
+	invalidFrameCount    uint64
+}
+
+func NewUnpacker(logger *gosteno.Logger) *Unpacker {
+	return &Unpacker{
+		logger: logger,
+	}
+}
+
+func (u *Unpacker) Run(inputChan <-chan []byte, outputChan chan<- []byte) {
+	for message := range inputChan {
+		if !IsBatch(message) {
+			outputChan <- message
+			continue
+		}
+
+		atomic.AddUint64(&u.receivedFrameCount, 1)
+		messages, err := Unmarshal(message)
+		if err != nil {
+			atomic.AddUint64(&u.invalidFrameCount, 1)
+			u.logger.Warnf("Batch unpacker: dropping frame: %v", err)
+			continue
+		}
+
+		atomic.AddUint64(&u.unpackedMessageCount, uint64(len(messages)))
+		for _, m := range messages {
+			outputChan <- m
+		}
+	}
+}
+
+func (u *Unpacker) Emit() instrumentation.Context {
+	return instrumentation.Context{
+		Name: "batchUnpacker",
+		Metrics: []instrumentation.Metric{
+			instrumentation.Metric{Name: "receivedFrameCount", Value: atomic.LoadUint64(&u.receivedFrameCount)},
+			instrumentation.Metric{Name: "unpackedMessageCount", Value: atomic.LoadUint64(&u.unpackedMessageCount)},
+			instrumentation.Metric{Name: "invalidFrameCount", Value: atomic.LoadUint64(&u.invalidFrameCount)},
+		},
+	}
+}
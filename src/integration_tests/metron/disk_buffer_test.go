@@ -0,0 +1,251 @@
+package integration_test
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/events"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+	"github.com/cloudfoundry/storeadapter"
+	"github.com/gogo/protobuf/proto"
+	"github.com/onsi/gomega/gexec"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Disk buffer", func() {
+	const (
+		dopplerAddress = "localhost:3460"
+		metronPort     = 51171
+		varzPort       = 1235
+	)
+
+	var (
+		dir         string
+		session     *gexec.Session
+		doppler     *tcpDoppler
+		metricNames chan string
+		metronInput net.Conn
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "metron-disk-buffer")
+		Expect(err).NotTo(HaveOccurred())
+
+		config, _ := json.Marshal(map[string]interface{}{
+			"Index":                         43,
+			"Job":                           "test-component",
+			"VarzPort":                      varzPort,
+			"VarzUser":                      "admin",
+			"VarzPass":                      "admin",
+			"LegacyIncomingMessagesPort":    51170,
+			"DropsondeIncomingMessagesPort": metronPort,
+			"SharedSecret":                  "shared_secret",
+			"EtcdUrls":                      []string{"http://127.0.0.1:" + strconv.Itoa(etcdPort)},
+			"EtcdMaxConcurrentRequests":     1,
+			"EtcdQueryIntervalMilliseconds": 100,
+			"Zone":                          "z1",
+			"Deployment":                    "deployment-name",
+			"LoggregatorDropsondePort":      3461,
+			"LoggregatorDropsondeTcpPort":   3460,
+			"DopplerTransport":              "tcp",
+			"DiskBufferDirectory":           filepath.Join(dir, "buffer"),
+		})
+		configFile := filepath.Join(dir, "metron.json")
+		Expect(ioutil.WriteFile(configFile, config, 0644)).To(Succeed())
+
+		adapter := etcdRunner.Adapter()
+		adapter.SetMulti([]storeadapter.StoreNode{{
+			Key:   "/healthstatus/doppler/z1/0",
+			Value: []byte("localhost"),
+		}})
+
+		metricNames = make(chan string, 100)
+		doppler = startTCPDoppler(dopplerAddress, metricNames)
+
+		command := exec.Command(pathToMetronExecutable, "--config="+configFile, "--debug")
+		session, err = gexec.Start(command, gexec.NewPrefixedWriter("[o][metron-disk-buffer]", GinkgoWriter), gexec.NewPrefixedWriter("[e][metron-disk-buffer]", GinkgoWriter))
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func() error {
+			_, err := http.Get("http://" + localIPAddress + ":" + strconv.Itoa(varzPort))
+			return err
+		}, 3).ShouldNot(HaveOccurred())
+
+		metronInput, err = net.Dial("udp", "localhost:"+strconv.Itoa(metronPort))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		metronInput.Close()
+		doppler.kill()
+		session.Kill().Wait()
+		os.RemoveAll(dir)
+	})
+
+	send := func(name string) {
+		message, _ := proto.Marshal(&events.Envelope{
+			Origin:      proto.String("fake-origin"),
+			EventType:   events.Envelope_ValueMetric.Enum(),
+			ValueMetric: basicValueMetric(name, 1, "count"),
+		})
+		metronInput.Write(message)
+	}
+
+	diskBufferMetric := func(name string) func() float64 {
+		return func() float64 {
+			req, _ := http.NewRequest("GET", "http://"+localIPAddress+":"+strconv.Itoa(varzPort)+"/varz", nil)
+			req.SetBasicAuth("admin", "admin")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return 0
+			}
+			defer resp.Body.Close()
+
+			var message instrumentation.VarzMessage
+			json.NewDecoder(resp.Body).Decode(&message)
+			for _, context := range message.Contexts {
+				if context.Name != "diskBuffer" {
+					continue
+				}
+				for _, metric := range context.Metrics {
+					if metric.Name == name {
+						value, _ := metric.Value.(float64)
+						return value
+					}
+				}
+			}
+			return 0
+		}
+	}
+
+	It("spools the messages while the doppler is down and replays them once it is back", func() {
+		Eventually(func() bool {
+			send("before")
+			select {
+			case name := <-metricNames:
+				return name == "before"
+			case <-time.After(100 * time.Millisecond):
+				return false
+			}
+		}, 5).Should(BeTrue())
+
+		// etcd still lists the doppler, but nothing accepts its connections
+		doppler.kill()
+
+		for i := 0; i < 20; i++ {
+			send(fmt.Sprintf("during-%d", i))
+		}
+		Eventually(diskBufferMetric("spooledMessageCount"), 5).Should(BeNumerically(">", 0))
+
+		doppler = startTCPDoppler(dopplerAddress, metricNames)
+
+		// the first message written after the kill may still vanish in the
+		// socket of the closed connection, all later ones are replayed
+		received := make(map[string]bool)
+		missing := func() []string {
+			for {
+				select {
+				case name := <-metricNames:
+					received[name] = true
+					continue
+				default:
+				}
+				break
+			}
+
+			var names []string
+			for i := 1; i < 20; i++ {
+				name := fmt.Sprintf("during-%d", i)
+				if !received[name] {
+					names = append(names, name)
+				}
+			}
+			return names
+		}
+		Eventually(missing, 10).Should(BeEmpty())
+		Expect(diskBufferMetric("replayedMessageCount")()).To(BeNumerically(">", 0))
+	})
+})
+
+// signatureSize is the length of the signature metron puts before every
+// envelope
+const signatureSize = 32
+
+// tcpDoppler accepts length prefixed, signed envelopes like doppler does and
+// passes on the names of the value metrics in them.
+type tcpDoppler struct {
+	listener net.Listener
+
+	lock  sync.Mutex
+	conns []net.Conn
+}
+
+func startTCPDoppler(address string, metricNames chan<- string) *tcpDoppler {
+	listener, err := net.Listen("tcp", address)
+	Expect(err).NotTo(HaveOccurred())
+
+	d := &tcpDoppler{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			d.lock.Lock()
+			d.conns = append(d.conns, conn)
+			d.lock.Unlock()
+
+			go d.read(conn, metricNames)
+		}
+	}()
+	return d
+}
+
+func (d *tcpDoppler) read(conn net.Conn, metricNames chan<- string) {
+	for {
+		var length uint32
+		err := binary.Read(conn, binary.BigEndian, &length)
+		if err != nil {
+			return
+		}
+
+		frame := make([]byte, length)
+		_, err = io.ReadFull(conn, frame)
+		if err != nil || len(frame) < signatureSize {
+			return
+		}
+
+		var envelope events.Envelope
+		err = proto.Unmarshal(frame[signatureSize:], &envelope)
+		if err == nil && envelope.GetValueMetric() != nil {
+			metricNames <- envelope.GetValueMetric().GetName()
+		}
+	}
+}
+
+// kill closes the listener and every connection, as if the doppler died.
+func (d *tcpDoppler) kill() {
+	d.listener.Close()
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, conn := range d.conns {
+		conn.Close()
+	}
+	d.conns = nil
+}
@@ -21,13 +21,15 @@ func TestIntegrationTest(t *testing.T) {
 	RunSpecs(t, "IntegrationTest Suite")
 }
 
+var pathToMetronExecutable string
 var metronSession *gexec.Session
 var etcdRunner *etcdstorerunner.ETCDClusterRunner
 var etcdPort int
 var localIPAddress string
 
 var _ = BeforeSuite(func() {
-	pathToMetronExecutable, err := gexec.Build("metron")
+	var err error
+	pathToMetronExecutable, err = gexec.Build("metron")
 	Expect(err).ShouldNot(HaveOccurred())
 
 	command := exec.Command(pathToMetronExecutable, "--config=fixtures/metron.json", "--debug")
@@ -0,0 +1,137 @@
+package batcher
+
+import (
+	"dropsonde_batch"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+)
+
+type AddressList interface {
+	GetAddresses() []string
+}
+
+// Batcher packs marshalled envelopes into batch frames of at most maxBytes,
+// flushing partial frames every flushInterval. Old dopplers can not unpack
+// frames, so envelopes are passed on one by one unless every doppler
+// advertises the batch frame format.
+type Batcher struct {
+	maxBytes         int
+	flushInterval    time.Duration
+	dopplers         AddressList
+	batchingDopplers AddressList
+	logger           *gosteno.Logger
+
+	batchingEnabled       int32
+	sentFrameCount        uint64
+	batchedMessageCount   uint64
+	unbatchedMessageCount uint64
+}
+
+func New(maxBytes int, flushInterval time.Duration, dopplers, batchingDopplers AddressList, logger *gosteno.Logger) *Batcher {
+	return &Batcher{
+		maxBytes:         maxBytes,
+		flushInterval:    flushInterval,
+		dopplers:         dopplers,
+		batchingDopplers: batchingDopplers,
+		logger:           logger,
+	}
+}
+
+func (b *Batcher) Run(inputChan <-chan []byte, outputChan chan<- []byte) {
+	ticker := time.NewTicker(b.flushInterval)
+	defer ticker.Stop()
+
+	var batch [][]byte
+	batchBytes := 0
+
+	flush := func() {
+		switch len(batch) {
+		case 0:
+			return
+		case 1:
+			atomic.AddUint64(&b.unbatchedMessageCount, 1)
+			outputChan <- batch[0]
+		default:
+			atomic.AddUint64(&b.sentFrameCount, 1)
+			atomic.AddUint64(&b.batchedMessageCount, uint64(len(batch)))
+			outputChan <- dropsonde_batch.Marshal(batch)
+		}
+		batch = nil
+		batchBytes = 0
+	}
+
+	batching := b.updateBatchingEnabled()
+	for {
+		select {
+		case message, ok := <-inputChan:
+			if !ok {
+				flush()
+				return
+			}
+
+			if !batching || dropsonde_batch.Size(1, len(message)) > b.maxBytes {
+				flush()
+				atomic.AddUint64(&b.unbatchedMessageCount, 1)
+				outputChan <- message
+				continue
+			}
+
+			if dropsonde_batch.Size(len(batch)+1, batchBytes+len(message)) > b.maxBytes {
+				flush()
+			}
+			batch = append(batch, message)
+			batchBytes += len(message)
+		case <-ticker.C:
+			flush()
+			batching = b.updateBatchingEnabled()
+		}
+	}
+}
+
+func (b *Batcher) Emit() instrumentation.Context {
+	return instrumentation.Context{
+		Name: "batcher",
+		Metrics: []instrumentation.Metric{
+			instrumentation.Metric{Name: "batchingEnabled", Value: atomic.LoadInt32(&b.batchingEnabled)},
+			instrumentation.Metric{Name: "sentFrameCount", Value: atomic.LoadUint64(&b.sentFrameCount)},
+			instrumentation.Metric{Name: "batchedMessageCount", Value: atomic.LoadUint64(&b.batchedMessageCount)},
+			instrumentation.Metric{Name: "unbatchedMessageCount", Value: atomic.LoadUint64(&b.unbatchedMessageCount)},
+		},
+	}
+}
+
+// updateBatchingEnabled checks whether every known doppler advertises the
+// batch frame format.
+func (b *Batcher) updateBatchingEnabled() bool {
+	enabled := dopplersAcceptBatches(b.dopplers.GetAddresses(), b.batchingDopplers.GetAddresses())
+
+	var value int32
+	if enabled {
+		value = 1
+	}
+	if atomic.SwapInt32(&b.batchingEnabled, value) != value {
+		b.logger.Infof("Batcher: batching enabled: %t", enabled)
+	}
+	return enabled
+}
+
+func dopplersAcceptBatches(dopplers, batchingDopplers []string) bool {
+	if len(dopplers) == 0 {
+		return false
+	}
+
+	batching := make(map[string]bool, len(batchingDopplers))
+	for _, address := range batchingDopplers {
+		batching[address] = true
+	}
+
+	for _, address := range dopplers {
+		if !batching[address] {
+			return false
+		}
+	}
+	return true
+}
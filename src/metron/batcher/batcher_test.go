@@ -0,0 +1,113 @@
+package batcher_test
+
+import (
+	"dropsonde_batch"
+	"metron/batcher"
+	"time"
+
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeAddressList []string
+
+func (f fakeAddressList) GetAddresses() []string {
+	return f
+}
+
+var _ = Describe("Batcher", func() {
+	var inputChan chan []byte
+	var outputChan chan []byte
+	var dopplers, batchingDopplers fakeAddressList
+	var maxBytes int
+	var flushInterval time.Duration
+
+	BeforeEach(func() {
+		inputChan = make(chan []byte, 10)
+		outputChan = make(chan []byte, 10)
+		dopplers = fakeAddressList{"10.0.0.1", "10.0.0.2"}
+		batchingDopplers = fakeAddressList{"10.0.0.2", "10.0.0.1"}
+		maxBytes = 1024
+		flushInterval = 50 * time.Millisecond
+	})
+
+	start := func() *batcher.Batcher {
+		b := batcher.New(maxBytes, flushInterval, dopplers, batchingDopplers, loggertesthelper.Logger())
+		go b.Run(inputChan, outputChan)
+		return b
+	}
+
+	AfterEach(func() {
+		close(inputChan)
+	})
+
+	It("packs messages into one frame when the flush interval passes", func() {
+		start()
+		inputChan <- []byte("first")
+		inputChan <- []byte("second")
+
+		var frame []byte
+		Eventually(outputChan).Should(Receive(&frame))
+		Expect(dropsonde_batch.IsBatch(frame)).To(BeTrue())
+
+		messages, err := dropsonde_batch.Unmarshal(frame)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(messages).To(Equal([][]byte{[]byte("first"), []byte("second")}))
+	})
+
+	It("sends a lone message without a frame", func() {
+		start()
+		inputChan <- []byte("message")
+
+		Eventually(outputChan).Should(Receive(Equal([]byte("message"))))
+	})
+
+	It("flushes the frame before it exceeds the maximum size", func() {
+		maxBytes = dropsonde_batch.Size(2, 20)
+		flushInterval = time.Hour
+		start()
+
+		inputChan <- make([]byte, 10)
+		inputChan <- make([]byte, 10)
+		inputChan <- make([]byte, 10)
+
+		var frame []byte
+		Eventually(outputChan).Should(Receive(&frame))
+		Expect(frame).To(HaveLen(maxBytes))
+		Consistently(outputChan).ShouldNot(Receive())
+	})
+
+	It("sends messages larger than the maximum size on their own", func() {
+		maxBytes = 16
+		flushInterval = time.Hour
+		start()
+
+		inputChan <- make([]byte, 20)
+		Eventually(outputChan).Should(Receive(HaveLen(20)))
+	})
+
+	It("does not batch while a doppler does not advertise the batch frame format", func() {
+		batchingDopplers = fakeAddressList{"10.0.0.1"}
+		b := start()
+
+		inputChan <- []byte("first")
+		inputChan <- []byte("second")
+
+		Eventually(outputChan).Should(Receive(Equal([]byte("first"))))
+		Eventually(outputChan).Should(Receive(Equal([]byte("second"))))
+		Expect(b.Emit().Metrics[0].Value).To(Equal(int32(0)))
+	})
+
+	It("does not batch without any dopplers", func() {
+		dopplers = fakeAddressList{}
+		start()
+
+		inputChan <- []byte("first")
+		inputChan <- []byte("second")
+
+		Eventually(outputChan).Should(Receive(Equal([]byte("first"))))
+		Eventually(outputChan).Should(Receive(Equal([]byte("second"))))
+	})
+})
@@ -0,0 +1,382 @@
+package diskbuffer
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+)
+
+// segmentsPerBuffer is the number of segments the size cap is split into.
+// The oldest segment is dropped as a whole when the buffer is full.
+const segmentsPerBuffer = 8
+
+// undeliveredQueueLength is the number of messages handed to Spool that wait
+// for Run to spool them
+const undeliveredQueueLength = 1024
+
+// Options configures the disk buffer.
+type Options struct {
+	Directory string
+	MaxBytes  int64
+
+	// MaxAge is how long a message is kept before it expires, messages
+	// never expire when 0
+	MaxAge time.Duration
+
+	// DrainRate is the number of buffered messages replayed per second once
+	// messages can be sent again, on top of the new messages
+	DrainRate int
+}
+
+type Sender interface {
+	Send(message []byte) error
+}
+
+// Buffer passes messages on to a Sender, spooling them to disk while the
+// Sender fails, e.g. because no doppler is known. Spooled messages are
+// replayed oldest first at the drain rate. Segments left behind by a previous
+// run are replayed as well, and so are messages the Sender accepted but could
+// not deliver after all and handed to Spool.
+type Buffer struct {
+	options         Options
+	segmentMaxBytes int64
+	logger          *gosteno.Logger
+	now             func() time.Time
+	undelivered     chan []byte
+
+	lock         sync.Mutex
+	segments     []*segment
+	nextSequence uint64
+	bytes        int64
+
+	spooledMessageCount  uint64
+	replayedMessageCount uint64
+	expiredMessageCount  uint64
+	droppedMessageCount  uint64
+	spoolErrorCount      uint64
+}
+
+func New(options Options, logger *gosteno.Logger) (*Buffer, error) {
+	err := os.MkdirAll(options.Directory, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Buffer{
+		options:         options,
+		segmentMaxBytes: options.MaxBytes / segmentsPerBuffer,
+		logger:          logger,
+		now:             time.Now,
+		undelivered:     make(chan []byte, undeliveredQueueLength),
+	}
+
+	err = b.loadSegments()
+	if err != nil {
+		return nil, err
+	}
+
+	if buffered := b.bufferedMessageCount(); buffered > 0 {
+		logger.Infof("Disk buffer: found %d messages spooled by a previous run", buffered)
+	}
+	return b, nil
+}
+
+func (b *Buffer) Run(inputChan <-chan []byte, sender Sender) {
+	interval, batchSize := drainSchedule(b.options.DrainRate)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	spooling := false
+	for {
+		select {
+		case message, ok := <-inputChan:
+			if !ok {
+				return
+			}
+
+			err := sender.Send(message)
+			if err == nil {
+				if spooling {
+					b.logger.Info("Disk buffer: forwarding messages again")
+					spooling = false
+				}
+				continue
+			}
+
+			if !spooling {
+				b.logger.Warnf("Disk buffer: can't forward messages, spooling them to disk: %v", err)
+				spooling = true
+			}
+			b.spool(message)
+		case message := <-b.undelivered:
+			b.spool(message)
+		case <-ticker.C:
+			b.expire()
+			b.drain(sender, batchSize)
+		}
+	}
+}
+
+// Spool keeps a message the Sender accepted but could not deliver, e.g.
+// because its doppler went away while the message was queued. Run spools it,
+// so it may be called from any goroutine. The message is dropped when too
+// many are waiting for Run.
+func (b *Buffer) Spool(message []byte) {
+	select {
+	case b.undelivered <- message:
+	default:
+		b.lock.Lock()
+		b.droppedMessageCount++
+		b.lock.Unlock()
+	}
+}
+
+func (b *Buffer) Emit() instrumentation.Context {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return instrumentation.Context{
+		Name: "diskBuffer",
+		Metrics: []instrumentation.Metric{
+			instrumentation.Metric{Name: "spooledMessageCount", Value: b.spooledMessageCount},
+			instrumentation.Metric{Name: "replayedMessageCount", Value: b.replayedMessageCount},
+			instrumentation.Metric{Name: "expiredMessageCount", Value: b.expiredMessageCount},
+			instrumentation.Metric{Name: "droppedMessageCount", Value: b.droppedMessageCount},
+			instrumentation.Metric{Name: "spoolErrorCount", Value: b.spoolErrorCount},
+			instrumentation.Metric{Name: "bufferedMessageCount", Value: b.bufferedMessageCount()},
+			instrumentation.Metric{Name: "bufferedBytes", Value: b.bytes},
+		},
+	}
+}
+
+// drainSchedule spreads the drain rate over ticks of at least 100ms.
+func drainSchedule(rate int) (time.Duration, int) {
+	if rate <= 0 {
+		rate = 1
+	}
+	if rate < 10 {
+		return time.Second / time.Duration(rate), 1
+	}
+	return 100 * time.Millisecond, rate / 10
+}
+
+func (b *Buffer) spool(message []byte) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	recordSize := int64(recordHeaderSize + len(message))
+	if recordSize > b.options.MaxBytes {
+		b.droppedMessageCount++
+		return
+	}
+
+	tail := b.tail()
+	if tail == nil || (tail.size > 0 && tail.size+recordSize > b.segmentMaxBytes) {
+		var err error
+		tail, err = b.addSegment()
+		if err != nil {
+			b.spoolErrorCount++
+			b.logger.Errorf("Disk buffer: can't create segment: %v", err)
+			return
+		}
+	}
+
+	for b.bytes+recordSize > b.options.MaxBytes && len(b.segments) > 1 {
+		b.droppedMessageCount += uint64(b.segments[0].unread())
+		b.removeHead()
+	}
+
+	if b.bytes+recordSize > b.options.MaxBytes {
+		b.droppedMessageCount++
+		return
+	}
+
+	err := tail.write(message, b.now())
+	if err != nil {
+		b.spoolErrorCount++
+		b.logger.Errorf("Disk buffer: can't spool message: %v", err)
+		return
+	}
+
+	b.bytes += recordSize
+	b.spooledMessageCount++
+}
+
+// drain replays up to batchSize messages, stopping at the first one that
+// can't be sent.
+func (b *Buffer) drain(sender Sender, batchSize int) {
+	for sent := 0; sent < batchSize; sent++ {
+		message, ok := b.next()
+		if !ok {
+			return
+		}
+
+		if sender.Send(message) != nil {
+			return
+		}
+		b.markReplayed()
+	}
+}
+
+// next returns the oldest message that has not expired, without removing it
+// from the buffer.
+func (b *Buffer) next() ([]byte, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for {
+		head := b.head()
+		if head == nil {
+			return nil, false
+		}
+
+		if head.unread() == 0 {
+			b.removeHead()
+			continue
+		}
+
+		message, spooledAt, err := head.peek()
+		if err != nil {
+			b.logger.Errorf("Disk buffer: dropping unreadable segment %s: %v", head.path, err)
+			b.droppedMessageCount += uint64(head.unread())
+			b.removeHead()
+			continue
+		}
+
+		if b.expired(spooledAt) {
+			b.expiredMessageCount++
+			head.skip()
+			continue
+		}
+
+		return message, true
+	}
+}
+
+// markReplayed removes the message returned by next. Only Run changes the
+// segments, so the head is still the segment next read from.
+func (b *Buffer) markReplayed() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.head().skip()
+	b.replayedMessageCount++
+}
+
+// expire drops the segments that only hold expired messages, so they stop
+// taking up disk space while nothing can be sent.
+func (b *Buffer) expire() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for {
+		head := b.head()
+		if head == nil || !b.expired(head.newest) {
+			return
+		}
+
+		b.expiredMessageCount += uint64(head.unread())
+		b.removeHead()
+	}
+}
+
+func (b *Buffer) expired(spooledAt time.Time) bool {
+	return b.options.MaxAge > 0 && b.now().Sub(spooledAt) > b.options.MaxAge
+}
+
+func (b *Buffer) head() *segment {
+	if len(b.segments) == 0 {
+		return nil
+	}
+	return b.segments[0]
+}
+
+func (b *Buffer) tail() *segment {
+	if len(b.segments) == 0 {
+		return nil
+	}
+	return b.segments[len(b.segments)-1]
+}
+
+func (b *Buffer) addSegment() (*segment, error) {
+	s, err := openSegment(segmentPath(b.options.Directory, b.nextSequence), b.maxMessageLength())
+	if err != nil {
+		return nil, err
+	}
+
+	b.nextSequence++
+	b.segments = append(b.segments, s)
+	return s, nil
+}
+
+func (b *Buffer) removeHead() {
+	head := b.segments[0]
+	b.segments = b.segments[1:]
+	b.bytes -= head.size
+
+	err := head.remove()
+	if err != nil {
+		b.logger.Warnf("Disk buffer: can't remove segment %s: %v", head.path, err)
+	}
+}
+
+// maxMessageLength is the length of the longest message that fits into the
+// buffer.
+func (b *Buffer) maxMessageLength() int64 {
+	return b.options.MaxBytes - recordHeaderSize
+}
+
+func (b *Buffer) bufferedMessageCount() int {
+	count := 0
+	for _, s := range b.segments {
+		count += s.unread()
+	}
+	return count
+}
+
+func (b *Buffer) loadSegments() error {
+	files, err := ioutil.ReadDir(b.options.Directory)
+	if err != nil {
+		return err
+	}
+
+	var sequences []uint64
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+
+		sequence, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		sequences = append(sequences, sequence)
+	}
+	sort.Sort(uint64Slice(sequences))
+
+	for _, sequence := range sequences {
+		s, err := openSegment(segmentPath(b.options.Directory, sequence), b.maxMessageLength())
+		if err != nil {
+			return err
+		}
+
+		b.segments = append(b.segments, s)
+		b.bytes += s.size
+		b.nextSequence = sequence + 1
+	}
+	return nil
+}
+
+type uint64Slice []uint64
+
+func (s uint64Slice) Len() int           { return len(s) }
+func (s uint64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
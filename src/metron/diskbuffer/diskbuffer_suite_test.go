@@ -0,0 +1,13 @@
+package diskbuffer_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestDiskbuffer(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Diskbuffer Suite")
+}
@@ -0,0 +1,213 @@
+package diskbuffer_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"metron/diskbuffer"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeSender struct {
+	failing bool
+	sent    []string
+	lock    sync.Mutex
+}
+
+func (f *fakeSender) Send(message []byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.failing {
+		return errors.New("no doppler servers available")
+	}
+	f.sent = append(f.sent, string(message))
+	return nil
+}
+
+func (f *fakeSender) setFailing(failing bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.failing = failing
+}
+
+func (f *fakeSender) sentMessages() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]string(nil), f.sent...)
+}
+
+var _ = Describe("Buffer", func() {
+	var (
+		dir       string
+		options   diskbuffer.Options
+		sender    *fakeSender
+		inputChan chan []byte
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "diskbuffer")
+		Expect(err).NotTo(HaveOccurred())
+
+		options = diskbuffer.Options{
+			Directory: dir,
+			MaxBytes:  1024 * 1024,
+			MaxAge:    time.Hour,
+			DrainRate: 1000,
+		}
+		sender = &fakeSender{}
+		inputChan = make(chan []byte)
+	})
+
+	AfterEach(func() {
+		close(inputChan)
+		os.RemoveAll(dir)
+	})
+
+	start := func() *diskbuffer.Buffer {
+		buffer, err := diskbuffer.New(options, loggertesthelper.Logger())
+		Expect(err).NotTo(HaveOccurred())
+		go buffer.Run(inputChan, sender)
+		return buffer
+	}
+
+	metric := func(buffer *diskbuffer.Buffer, name string) func() interface{} {
+		return func() interface{} {
+			return findMetric(buffer.Emit().Metrics, name)
+		}
+	}
+
+	It("passes messages on while they can be sent", func() {
+		buffer := start()
+		inputChan <- []byte("message")
+
+		Eventually(sender.sentMessages).Should(Equal([]string{"message"}))
+		Expect(metric(buffer, "spooledMessageCount")()).To(BeNumerically("==", 0))
+	})
+
+	It("replays spooled messages in order once they can be sent again", func() {
+		sender.setFailing(true)
+		buffer := start()
+		for i := 0; i < 3; i++ {
+			inputChan <- []byte(strconv.Itoa(i))
+		}
+		Eventually(metric(buffer, "bufferedMessageCount")).Should(BeNumerically("==", 3))
+		Expect(metric(buffer, "spooledMessageCount")()).To(BeNumerically("==", 3))
+
+		sender.setFailing(false)
+		Eventually(sender.sentMessages).Should(Equal([]string{"0", "1", "2"}))
+		Expect(metric(buffer, "replayedMessageCount")()).To(BeNumerically("==", 3))
+		Expect(metric(buffer, "bufferedMessageCount")()).To(BeNumerically("==", 0))
+		Expect(metric(buffer, "bufferedBytes")()).To(BeNumerically("==", 0))
+	})
+
+	It("spools and replays the messages the sender could not deliver after all", func() {
+		buffer := start()
+		buffer.Spool([]byte("undelivered"))
+
+		Eventually(sender.sentMessages).Should(Equal([]string{"undelivered"}))
+		Expect(metric(buffer, "spooledMessageCount")()).To(BeNumerically("==", 1))
+		Expect(metric(buffer, "replayedMessageCount")()).To(BeNumerically("==", 1))
+	})
+
+	It("replays no more than the drain rate", func() {
+		options.DrainRate = 5
+		sender.setFailing(true)
+		buffer := start()
+		for i := 0; i < 5; i++ {
+			inputChan <- []byte(strconv.Itoa(i))
+		}
+		Eventually(metric(buffer, "bufferedMessageCount")).Should(BeNumerically("==", 5))
+
+		sender.setFailing(false)
+		Eventually(sender.sentMessages).Should(HaveLen(1))
+		Consistently(sender.sentMessages, 150*time.Millisecond).Should(HaveLen(1))
+		Eventually(sender.sentMessages, 2).Should(HaveLen(5))
+	})
+
+	It("expires messages older than the maximum age", func() {
+		options.MaxAge = 50 * time.Millisecond
+		sender.setFailing(true)
+		buffer := start()
+		inputChan <- []byte("old")
+
+		Eventually(metric(buffer, "expiredMessageCount")).Should(BeNumerically("==", 1))
+		Expect(metric(buffer, "bufferedMessageCount")()).To(BeNumerically("==", 0))
+
+		sender.setFailing(false)
+		inputChan <- []byte("new")
+		Consistently(sender.sentMessages, 200*time.Millisecond).Should(Equal([]string{"new"}))
+	})
+
+	It("drops the oldest messages when it is full", func() {
+		options.MaxBytes = 8 * (12 + 10)
+		sender.setFailing(true)
+		buffer := start()
+		for i := 0; i < 10; i++ {
+			inputChan <- []byte("message-0" + strconv.Itoa(i))
+		}
+		Eventually(metric(buffer, "spooledMessageCount")).Should(BeNumerically("==", 10))
+		Expect(metric(buffer, "droppedMessageCount")()).To(BeNumerically("==", 2))
+		Expect(metric(buffer, "bufferedBytes")()).To(BeNumerically("<=", options.MaxBytes))
+
+		sender.setFailing(false)
+		Eventually(sender.sentMessages).Should(HaveLen(8))
+		Expect(sender.sentMessages()[0]).To(Equal("message-02"))
+	})
+
+	It("replays messages spooled by a previous run", func() {
+		sender.setFailing(true)
+		buffer := start()
+		inputChan <- []byte("before restart")
+		Eventually(metric(buffer, "bufferedMessageCount")).Should(BeNumerically("==", 1))
+
+		restarted, err := diskbuffer.New(options, loggertesthelper.Logger())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(findMetric(restarted.Emit().Metrics, "bufferedMessageCount")).To(BeNumerically("==", 1))
+
+		otherSender := &fakeSender{}
+		otherInputChan := make(chan []byte)
+		defer close(otherInputChan)
+		go restarted.Run(otherInputChan, otherSender)
+		Eventually(otherSender.sentMessages).Should(Equal([]string{"before restart"}))
+	})
+
+	It("drops segments holding a message larger than the buffer", func() {
+		options.MaxBytes = 1000
+		record := func(message []byte) []byte {
+			header := make([]byte, 12)
+			binary.BigEndian.PutUint64(header, uint64(time.Now().UnixNano()))
+			binary.BigEndian.PutUint32(header[8:], uint32(len(message)))
+			return append(header, message...)
+		}
+		segment := append(record([]byte("first")), record(make([]byte, 2000))...)
+		err := ioutil.WriteFile(filepath.Join(dir, "00000000000000000000.seg"), segment, 0644)
+		Expect(err).NotTo(HaveOccurred())
+
+		buffer := start()
+		Eventually(metric(buffer, "droppedMessageCount")).Should(BeNumerically("==", 1))
+		Expect(sender.sentMessages()).To(Equal([]string{"first"}))
+	})
+})
+
+func findMetric(metrics []instrumentation.Metric, name string) interface{} {
+	for _, metric := range metrics {
+		if metric.Name == name {
+			return metric.Value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package diskbuffer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// recordHeaderSize is the size of the header in front of every message: the
+// time it was spooled in nanoseconds and its length, both big endian.
+const recordHeaderSize = 12
+
+const segmentSuffix = ".seg"
+
+var errRecordTooLarge = errors.New("record larger than the buffer")
+
+// segment is a file of records. Only the last segment of a buffer is written
+// to, only the first one is read from.
+type segment struct {
+	path       string
+	file       *os.File
+	maxLength  int64
+	size       int64
+	readOffset int64
+	messages   int
+	read       int
+	newest     time.Time
+}
+
+func segmentPath(directory string, sequence uint64) string {
+	return filepath.Join(directory, fmt.Sprintf("%020d%s", sequence, segmentSuffix))
+}
+
+// openSegment opens a segment, counting the records it already holds. A
+// record that was only partly written when metron stopped is cut off.
+// Messages longer than maxLength can't have been spooled, peek refuses them.
+func openSegment(path string, maxLength int64) (*segment, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	s := &segment{path: path, file: file, maxLength: maxLength}
+	for {
+		spooledAt, length, err := s.readHeader(s.size)
+		if err != nil {
+			break
+		}
+
+		end := s.size + recordHeaderSize + int64(length)
+		if end > info.Size() {
+			break
+		}
+
+		s.size = end
+		s.messages++
+		s.newest = spooledAt
+	}
+
+	err = file.Truncate(s.size)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *segment) write(message []byte, spooledAt time.Time) error {
+	record := make([]byte, recordHeaderSize+len(message))
+	binary.BigEndian.PutUint64(record, uint64(spooledAt.UnixNano()))
+	binary.BigEndian.PutUint32(record[8:], uint32(len(message)))
+	copy(record[recordHeaderSize:], message)
+
+	_, err := s.file.Write(record)
+	if err != nil {
+		// drop whatever part of the record made it to disk, so the
+		// next record starts where the reader expects it
+		s.file.Truncate(s.size)
+		return err
+	}
+
+	s.size += int64(len(record))
+	s.messages++
+	s.newest = spooledAt
+	return nil
+}
+
+// peek returns the next unread message and when it was spooled.
+func (s *segment) peek() ([]byte, time.Time, error) {
+	spooledAt, length, err := s.readHeader(s.readOffset)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if int64(length) > s.maxLength {
+		return nil, time.Time{}, errRecordTooLarge
+	}
+
+	message := make([]byte, length)
+	_, err = s.file.ReadAt(message, s.readOffset+recordHeaderSize)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return message, spooledAt, nil
+}
+
+// skip marks the message returned by peek as read.
+func (s *segment) skip() error {
+	_, length, err := s.readHeader(s.readOffset)
+	if err != nil {
+		return err
+	}
+
+	s.readOffset += recordHeaderSize + int64(length)
+	s.read++
+	return nil
+}
+
+func (s *segment) unread() int {
+	return s.messages - s.read
+}
+
+func (s *segment) remove() error {
+	s.file.Close()
+	return os.Remove(s.path)
+}
+
+func (s *segment) readHeader(offset int64) (time.Time, uint32, error) {
+	header := make([]byte, recordHeaderSize)
+	_, err := s.file.ReadAt(header, offset)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	spooledAt := time.Unix(0, int64(binary.BigEndian.Uint64(header)))
+	return spooledAt, binary.BigEndian.Uint32(header[8:]), nil
+}
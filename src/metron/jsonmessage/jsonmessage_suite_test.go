@@ -0,0 +1,13 @@
+package jsonmessage_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestJsonmessage(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Jsonmessage Suite")
+}
@@ -0,0 +1,208 @@
+package jsonmessage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/events"
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+	"github.com/gogo/protobuf/proto"
+)
+
+// maxRequestBytes limits the size of a request and thereby of a single line.
+const maxRequestBytes = 4 * 1024 * 1024
+
+// message is a line of a request. It is a log message when it has a message,
+// and a value metric when it has a name.
+type message struct {
+	AppId          string   `json:"app_id"`
+	SourceType     string   `json:"source_type"`
+	SourceInstance string   `json:"source_instance"`
+	MessageType    string   `json:"message_type"`
+	Message        *string  `json:"message"`
+	Name           string   `json:"name"`
+	Value          *float64 `json:"value"`
+	Unit           string   `json:"unit"`
+	Timestamp      int64    `json:"timestamp"`
+}
+
+// Listener accepts newline delimited JSON log messages and metrics POSTed to
+// /messages and turns them into envelopes. A request with an invalid line is
+// rejected as a whole, so clients can fix and resend it.
+type Listener struct {
+	origin     string
+	outputChan chan<- *events.Envelope
+	logger     *gosteno.Logger
+	now        func() time.Time
+
+	receivedRequestCount     uint64
+	receivedLogMessageCount  uint64
+	receivedValueMetricCount uint64
+	invalidRequestCount      uint64
+}
+
+func NewListener(origin string, outputChan chan<- *events.Envelope, logger *gosteno.Logger) *Listener {
+	return &Listener{
+		origin:     origin,
+		outputChan: outputChan,
+		logger:     logger,
+		now:        time.Now,
+	}
+}
+
+// ListenAndServe accepts messages until the listener fails.
+func (l *Listener) ListenAndServe(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/messages", l)
+
+	l.logger.Infof("Startup: accepting JSON messages on %s/messages", address)
+	err := http.ListenAndServe(address, mux)
+	if err != nil {
+		l.logger.Errorf("JSON message endpoint stopped: %v", err)
+	}
+}
+
+func (l *Listener) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "POST" {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	atomic.AddUint64(&l.receivedRequestCount, 1)
+	envelopes, err := l.parse(http.MaxBytesReader(writer, request.Body, maxRequestBytes))
+	if err != nil {
+		atomic.AddUint64(&l.invalidRequestCount, 1)
+		l.logger.Debugf("jsonListener: rejecting request: %v", err)
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, envelope := range envelopes {
+		if envelope.GetEventType() == events.Envelope_LogMessage {
+			atomic.AddUint64(&l.receivedLogMessageCount, 1)
+		} else {
+			atomic.AddUint64(&l.receivedValueMetricCount, 1)
+		}
+		l.outputChan <- envelope
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (l *Listener) Emit() instrumentation.Context {
+	return instrumentation.Context{
+		Name: "jsonListener",
+		Metrics: []instrumentation.Metric{
+			instrumentation.Metric{Name: "receivedRequestCount", Value: atomic.LoadUint64(&l.receivedRequestCount)},
+			instrumentation.Metric{Name: "receivedLogMessageCount", Value: atomic.LoadUint64(&l.receivedLogMessageCount)},
+			instrumentation.Metric{Name: "receivedValueMetricCount", Value: atomic.LoadUint64(&l.receivedValueMetricCount)},
+			instrumentation.Metric{Name: "invalidRequestCount", Value: atomic.LoadUint64(&l.invalidRequestCount)},
+		},
+	}
+}
+
+func (l *Listener) parse(body io.Reader) ([]*events.Envelope, error) {
+	reader := bufio.NewReader(body)
+
+	var envelopes []*events.Envelope
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			envelope, convertErr := l.convert(line)
+			if convertErr != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNumber, convertErr)
+			}
+			envelopes = append(envelopes, envelope)
+		}
+
+		if err == io.EOF {
+			return envelopes, nil
+		}
+	}
+}
+
+func (l *Listener) convert(line []byte) (*events.Envelope, error) {
+	var m message
+	err := json.Unmarshal(line, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := m.Timestamp
+	if timestamp == 0 {
+		timestamp = l.now().UnixNano()
+	}
+
+	switch {
+	case m.Message != nil && m.Name != "":
+		return nil, errors.New("a line can't have both a message and a name")
+	case m.Message != nil:
+		return l.logMessage(m, timestamp)
+	case m.Name != "":
+		return l.valueMetric(m, timestamp)
+	}
+	return nil, errors.New("a line needs either a message or a name")
+}
+
+func (l *Listener) logMessage(m message, timestamp int64) (*events.Envelope, error) {
+	if m.AppId == "" {
+		return nil, errors.New("missing app_id")
+	}
+
+	var messageType events.LogMessage_MessageType
+	switch strings.ToUpper(m.MessageType) {
+	case "", "OUT":
+		messageType = events.LogMessage_OUT
+	case "ERR":
+		messageType = events.LogMessage_ERR
+	default:
+		return nil, fmt.Errorf("invalid message_type %q, must be OUT or ERR", m.MessageType)
+	}
+
+	return &events.Envelope{
+		Origin:    proto.String(l.origin),
+		EventType: events.Envelope_LogMessage.Enum(),
+		Timestamp: proto.Int64(timestamp),
+		LogMessage: &events.LogMessage{
+			Message:        []byte(*m.Message),
+			MessageType:    messageType.Enum(),
+			Timestamp:      proto.Int64(timestamp),
+			AppId:          proto.String(m.AppId),
+			SourceType:     proto.String(m.SourceType),
+			SourceInstance: proto.String(m.SourceInstance),
+		},
+	}, nil
+}
+
+func (l *Listener) valueMetric(m message, timestamp int64) (*events.Envelope, error) {
+	if m.Value == nil {
+		return nil, errors.New("missing value")
+	}
+	if m.Unit == "" {
+		return nil, errors.New("missing unit")
+	}
+
+	return &events.Envelope{
+		Origin:    proto.String(l.origin),
+		EventType: events.Envelope_ValueMetric.Enum(),
+		Timestamp: proto.Int64(timestamp),
+		ValueMetric: &events.ValueMetric{
+			Name:  proto.String(m.Name),
+			Value: proto.Float64(*m.Value),
+			Unit:  proto.String(m.Unit),
+		},
+	}, nil
+}
@@ -0,0 +1,109 @@
+package jsonmessage_test
+
+import (
+	"metron/jsonmessage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/cloudfoundry/dropsonde/events"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Listener", func() {
+	var (
+		outputChan chan *events.Envelope
+		listener   *jsonmessage.Listener
+	)
+
+	BeforeEach(func() {
+		outputChan = make(chan *events.Envelope, 10)
+		listener = jsonmessage.NewListener("json", outputChan, loggertesthelper.Logger())
+	})
+
+	post := func(body string) *httptest.ResponseRecorder {
+		request, err := http.NewRequest("POST", "/messages", strings.NewReader(body))
+		Expect(err).NotTo(HaveOccurred())
+
+		recorder := httptest.NewRecorder()
+		listener.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	It("converts log messages", func() {
+		recorder := post(`{"app_id":"app-id","source_type":"SIDECAR","source_instance":"2","message_type":"err","message":"hello","timestamp":1234}`)
+		Expect(recorder.Code).To(Equal(http.StatusNoContent))
+
+		var envelope *events.Envelope
+		Expect(outputChan).To(Receive(&envelope))
+		Expect(envelope.GetOrigin()).To(Equal("json"))
+		Expect(envelope.GetEventType()).To(Equal(events.Envelope_LogMessage))
+		Expect(envelope.GetTimestamp()).To(BeEquivalentTo(1234))
+
+		logMessage := envelope.GetLogMessage()
+		Expect(string(logMessage.GetMessage())).To(Equal("hello"))
+		Expect(logMessage.GetMessageType()).To(Equal(events.LogMessage_ERR))
+		Expect(logMessage.GetAppId()).To(Equal("app-id"))
+		Expect(logMessage.GetSourceType()).To(Equal("SIDECAR"))
+		Expect(logMessage.GetSourceInstance()).To(Equal("2"))
+		Expect(logMessage.GetTimestamp()).To(BeEquivalentTo(1234))
+	})
+
+	It("converts value metrics", func() {
+		recorder := post(`{"name":"queueDepth","value":12.5,"unit":"count"}`)
+		Expect(recorder.Code).To(Equal(http.StatusNoContent))
+
+		var envelope *events.Envelope
+		Expect(outputChan).To(Receive(&envelope))
+		Expect(envelope.GetEventType()).To(Equal(events.Envelope_ValueMetric))
+		Expect(envelope.GetTimestamp()).NotTo(BeZero())
+		Expect(envelope.GetValueMetric().GetName()).To(Equal("queueDepth"))
+		Expect(envelope.GetValueMetric().GetValue()).To(Equal(12.5))
+		Expect(envelope.GetValueMetric().GetUnit()).To(Equal("count"))
+	})
+
+	It("accepts several lines per request", func() {
+		recorder := post("{\"app_id\":\"app-id\",\"message\":\"one\"}\n\n{\"name\":\"zero\",\"value\":0,\"unit\":\"count\"}\n")
+		Expect(recorder.Code).To(Equal(http.StatusNoContent))
+		Expect(outputChan).To(HaveLen(2))
+
+		metrics := listener.Emit().Metrics
+		Expect(metrics[0].Value).To(BeEquivalentTo(1))
+		Expect(metrics[1].Value).To(BeEquivalentTo(1))
+		Expect(metrics[2].Value).To(BeEquivalentTo(1))
+	})
+
+	It("rejects the whole request when a line is invalid", func() {
+		recorder := post("{\"app_id\":\"app-id\",\"message\":\"one\"}\n{\"name\":\"noUnit\",\"value\":1}\n")
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		Expect(recorder.Body.String()).To(ContainSubstring("line 2: missing unit"))
+		Expect(outputChan).To(BeEmpty())
+		Expect(listener.Emit().Metrics[3].Value).To(BeEquivalentTo(1))
+	})
+
+	for _, invalid := range []struct{ description, line, reason string }{
+		{"not JSON", `app-id hello`, "line 1"},
+		{"a log message without app id", `{"message":"hello"}`, "missing app_id"},
+		{"an unknown message type", `{"app_id":"app-id","message":"hello","message_type":"WARN"}`, "invalid message_type"},
+		{"a metric without value", `{"name":"metric","unit":"count"}`, "missing value"},
+		{"both a message and a name", `{"app_id":"app-id","message":"hello","name":"metric"}`, "both"},
+		{"neither a message nor a name", `{"app_id":"app-id"}`, "either"},
+	} {
+		invalid := invalid
+		It("rejects "+invalid.description, func() {
+			recorder := post(invalid.line)
+			Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+			Expect(recorder.Body.String()).To(ContainSubstring(invalid.reason))
+		})
+	}
+
+	It("only accepts POST requests", func() {
+		request, _ := http.NewRequest("GET", "/messages", nil)
+		recorder := httptest.NewRecorder()
+		listener.ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
@@ -0,0 +1,170 @@
+package logmetric
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/cloudfoundry/dropsonde/events"
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+	"github.com/gogo/protobuf/proto"
+)
+
+// Rule matches log messages by origin, source type and a pattern on the
+// message, an empty origin or source type matches any. A rule whose pattern
+// has a capturing group emits a ValueMetric with the number captured by the
+// first group, any other rule emits a CounterEvent with a delta of 1.
+type Rule struct {
+	Name       string
+	Origin     string
+	SourceType string
+	Pattern    string
+
+	// Unit is the unit of the ValueMetric, it is required for rules with a
+	// capturing group
+	Unit string
+}
+
+type rule struct {
+	Rule
+	pattern *regexp.Regexp
+	isValue bool
+
+	matchCount uint64
+}
+
+// Extractor passes every envelope on and emits the metrics of the rules
+// matching a log message right after it. The origin and timestamp of the
+// metrics are the ones of the log message.
+type Extractor struct {
+	rules  []*rule
+	logger *gosteno.Logger
+
+	emittedCounterEventCount uint64
+	emittedValueMetricCount  uint64
+	invalidValueCount        uint64
+}
+
+func New(rules []Rule, logger *gosteno.Logger) (*Extractor, error) {
+	e := &Extractor{logger: logger}
+	for i, r := range rules {
+		compiled, err := compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("log metric rule %d: %v", i, err)
+		}
+		e.rules = append(e.rules, compiled)
+	}
+	return e, nil
+}
+
+func (e *Extractor) Run(inputChan <-chan *events.Envelope, outputChan chan<- *events.Envelope) {
+	for envelope := range inputChan {
+		outputChan <- envelope
+
+		if envelope.GetEventType() != events.Envelope_LogMessage {
+			continue
+		}
+
+		for _, r := range e.rules {
+			metric := e.extract(r, envelope)
+			if metric != nil {
+				outputChan <- metric
+			}
+		}
+	}
+}
+
+func (e *Extractor) Emit() instrumentation.Context {
+	metrics := []instrumentation.Metric{
+		instrumentation.Metric{Name: "emittedCounterEventCount", Value: atomic.LoadUint64(&e.emittedCounterEventCount)},
+		instrumentation.Metric{Name: "emittedValueMetricCount", Value: atomic.LoadUint64(&e.emittedValueMetricCount)},
+		instrumentation.Metric{Name: "invalidValueCount", Value: atomic.LoadUint64(&e.invalidValueCount)},
+	}
+
+	for _, r := range e.rules {
+		metrics = append(metrics, instrumentation.Metric{
+			Name:  "matchCount",
+			Value: atomic.LoadUint64(&r.matchCount),
+			Tags:  map[string]interface{}{"rule": r.Name},
+		})
+	}
+
+	return instrumentation.Context{
+		Name:    "logMetricExtractor",
+		Metrics: metrics,
+	}
+}
+
+func compile(r Rule) (*rule, error) {
+	if r.Name == "" {
+		return nil, errors.New("missing name")
+	}
+
+	pattern, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	isValue := pattern.NumSubexp() > 0
+	if isValue && r.Unit == "" {
+		return nil, fmt.Errorf("%s: missing unit of the captured value", r.Name)
+	}
+	if !isValue && r.Unit != "" {
+		return nil, fmt.Errorf("%s: unit needs a capturing group in the pattern", r.Name)
+	}
+
+	return &rule{Rule: r, pattern: pattern, isValue: isValue}, nil
+}
+
+func (e *Extractor) extract(r *rule, envelope *events.Envelope) *events.Envelope {
+	logMessage := envelope.GetLogMessage()
+	if (r.Origin != "" && r.Origin != envelope.GetOrigin()) || (r.SourceType != "" && r.SourceType != logMessage.GetSourceType()) {
+		return nil
+	}
+
+	if !r.isValue {
+		if !r.pattern.Match(logMessage.GetMessage()) {
+			return nil
+		}
+
+		atomic.AddUint64(&r.matchCount, 1)
+		atomic.AddUint64(&e.emittedCounterEventCount, 1)
+		return &events.Envelope{
+			Origin:    proto.String(envelope.GetOrigin()),
+			EventType: events.Envelope_CounterEvent.Enum(),
+			Timestamp: envelope.Timestamp,
+			CounterEvent: &events.CounterEvent{
+				Name:  proto.String(r.Name),
+				Delta: proto.Uint64(1),
+			},
+		}
+	}
+
+	match := r.pattern.FindSubmatch(logMessage.GetMessage())
+	if match == nil {
+		return nil
+	}
+	atomic.AddUint64(&r.matchCount, 1)
+
+	value, err := strconv.ParseFloat(string(match[1]), 64)
+	if err != nil {
+		atomic.AddUint64(&e.invalidValueCount, 1)
+		e.logger.Debugf("logMetricExtractor: %s captured %q, which is not a number", r.Name, match[1])
+		return nil
+	}
+
+	atomic.AddUint64(&e.emittedValueMetricCount, 1)
+	return &events.Envelope{
+		Origin:    proto.String(envelope.GetOrigin()),
+		EventType: events.Envelope_ValueMetric.Enum(),
+		Timestamp: envelope.Timestamp,
+		ValueMetric: &events.ValueMetric{
+			Name:  proto.String(r.Name),
+			Value: proto.Float64(value),
+			Unit:  proto.String(r.Unit),
+		},
+	}
+}
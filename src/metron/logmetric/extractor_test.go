@@ -0,0 +1,150 @@
+package logmetric_test
+
+import (
+	"metron/logmetric"
+
+	"github.com/cloudfoundry/dropsonde/events"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+	"github.com/gogo/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Extractor", func() {
+	var (
+		inputChan  chan *events.Envelope
+		outputChan chan *events.Envelope
+		rules      []logmetric.Rule
+	)
+
+	BeforeEach(func() {
+		inputChan = make(chan *events.Envelope, 10)
+		outputChan = make(chan *events.Envelope, 10)
+		rules = []logmetric.Rule{
+			{Name: "backendExhausted", Origin: "gorouter", SourceType: "RTR", Pattern: "backend exhausted"},
+			{Name: "responseTime", Origin: "gorouter", Pattern: `response_time:([0-9.]+)`, Unit: "s"},
+		}
+	})
+
+	start := func() *logmetric.Extractor {
+		extractor, err := logmetric.New(rules, loggertesthelper.Logger())
+		Expect(err).NotTo(HaveOccurred())
+		go extractor.Run(inputChan, outputChan)
+		return extractor
+	}
+
+	AfterEach(func() {
+		close(inputChan)
+	})
+
+	It("emits a counter event after a matching log message", func() {
+		start()
+		log := logEnvelope("gorouter", "RTR", "backend exhausted for app-id")
+		inputChan <- log
+
+		Eventually(outputChan).Should(Receive(Equal(log)))
+
+		var metric *events.Envelope
+		Eventually(outputChan).Should(Receive(&metric))
+		Expect(metric.GetEventType()).To(Equal(events.Envelope_CounterEvent))
+		Expect(metric.GetOrigin()).To(Equal("gorouter"))
+		Expect(metric.GetTimestamp()).To(Equal(log.GetTimestamp()))
+		Expect(metric.GetCounterEvent().GetName()).To(Equal("backendExhausted"))
+		Expect(metric.GetCounterEvent().GetDelta()).To(BeEquivalentTo(1))
+	})
+
+	It("emits a value metric from the captured number", func() {
+		start()
+		inputChan <- logEnvelope("gorouter", "RTR", "GET /v2/info response_time:0.025 app_id:app-id")
+
+		Eventually(outputChan).Should(Receive())
+
+		var metric *events.Envelope
+		Eventually(outputChan).Should(Receive(&metric))
+		Expect(metric.GetEventType()).To(Equal(events.Envelope_ValueMetric))
+		Expect(metric.GetValueMetric().GetName()).To(Equal("responseTime"))
+		Expect(metric.GetValueMetric().GetValue()).To(Equal(0.025))
+		Expect(metric.GetValueMetric().GetUnit()).To(Equal("s"))
+	})
+
+	It("only matches log messages of the origin and source type of a rule", func() {
+		start()
+		inputChan <- logEnvelope("uaa", "RTR", "backend exhausted")
+		inputChan <- logEnvelope("gorouter", "APP", "backend exhausted")
+
+		Eventually(outputChan).Should(HaveLen(2))
+		Consistently(outputChan).Should(HaveLen(2))
+	})
+
+	It("passes other envelopes on", func() {
+		start()
+		valueMetric := &events.Envelope{
+			Origin:    proto.String("gorouter"),
+			EventType: events.Envelope_ValueMetric.Enum(),
+			ValueMetric: &events.ValueMetric{
+				Name:  proto.String("backend exhausted"),
+				Value: proto.Float64(1),
+				Unit:  proto.String("count"),
+			},
+		}
+		inputChan <- valueMetric
+
+		Eventually(outputChan).Should(Receive(Equal(valueMetric)))
+		Consistently(outputChan).ShouldNot(Receive())
+	})
+
+	It("counts the matches of every rule", func() {
+		extractor := start()
+		inputChan <- logEnvelope("gorouter", "RTR", "backend exhausted")
+		inputChan <- logEnvelope("gorouter", "RTR", "response_time:. app_id:app-id")
+		Eventually(outputChan).Should(HaveLen(3))
+
+		metrics := extractor.Emit().Metrics
+		Expect(metrics).To(HaveLen(5))
+		Expect(metrics[0].Value).To(BeEquivalentTo(1))
+		Expect(metrics[1].Value).To(BeEquivalentTo(0))
+		Expect(metrics[2].Value).To(BeEquivalentTo(1))
+		Expect(metrics[3].Tags["rule"]).To(Equal("backendExhausted"))
+		Expect(metrics[3].Value).To(BeEquivalentTo(1))
+		Expect(metrics[4].Tags["rule"]).To(Equal("responseTime"))
+		Expect(metrics[4].Value).To(BeEquivalentTo(1))
+	})
+
+	Describe("New", func() {
+		It("rejects rules without a name", func() {
+			_, err := logmetric.New([]logmetric.Rule{{Pattern: "exhausted"}}, loggertesthelper.Logger())
+			Expect(err).To(MatchError("log metric rule 0: missing name"))
+		})
+
+		It("rejects invalid patterns", func() {
+			_, err := logmetric.New([]logmetric.Rule{{Name: "broken", Pattern: "("}}, loggertesthelper.Logger())
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects value rules without a unit", func() {
+			_, err := logmetric.New([]logmetric.Rule{{Name: "responseTime", Pattern: "time:([0-9]+)"}}, loggertesthelper.Logger())
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects counter rules with a unit", func() {
+			_, err := logmetric.New([]logmetric.Rule{{Name: "exhausted", Pattern: "exhausted", Unit: "count"}}, loggertesthelper.Logger())
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+func logEnvelope(origin, sourceType, message string) *events.Envelope {
+	return &events.Envelope{
+		Origin:    proto.String(origin),
+		EventType: events.Envelope_LogMessage.Enum(),
+		Timestamp: proto.Int64(1234),
+		LogMessage: &events.LogMessage{
+			Message:     []byte(message),
+			MessageType: events.LogMessage_OUT.Enum(),
+			Timestamp:   proto.Int64(1234),
+			AppId:       proto.String("app-id"),
+			SourceType:  proto.String(sourceType),
+		},
+	}
+}
@@ -0,0 +1,13 @@
+package logmetric_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestLogmetric(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Logmetric Suite")
+}
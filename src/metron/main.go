@@ -1,14 +1,25 @@
 package main
 
 import (
+	"crypto/tls"
+	"dropsonde_batch"
 	"flag"
 	"fmt"
+	"metron/batcher"
+	"metron/diskbuffer"
 	"metron/eventlistener"
 	"metron/heartbeatrequester"
+	"metron/jsonmessage"
 	"metron/legacymessage"
+	"metron/logmetric"
 	"metron/messageaggregator"
+	"metron/statsdmessage"
 	"metron/tagger"
+	"metron/tcpclient"
 	"metron/varzforwarder"
+	"net"
+	"prometheus_exporter"
+	"strconv"
 	"time"
 
 	"github.com/cloudfoundry/dropsonde/dropsonde_marshaller"
@@ -21,7 +32,6 @@ import (
 	"github.com/cloudfoundry/loggregatorlib/cfcomponent"
 	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
 	"github.com/cloudfoundry/loggregatorlib/cfcomponent/registrars/collectorregistrar"
-	"github.com/cloudfoundry/loggregatorlib/clientpool"
 	"github.com/cloudfoundry/loggregatorlib/logmessage"
 	"github.com/cloudfoundry/loggregatorlib/servicediscovery"
 	"github.com/cloudfoundry/storeadapter"
@@ -49,7 +59,23 @@ func main() {
 	flag.Parse()
 	config, logger := parseConfig(*debug, *configFilePath, *logFilePath)
 
-	dopplerClientPool := initializeClientPool(config, logger, config.LoggregatorDropsondePort)
+	adapter := storeAdapterProvider(config.EtcdUrls, config.EtcdMaxConcurrentRequests)
+	err := adapter.Connect()
+	if err != nil {
+		logger.Errorf("Error connecting to ETCD: %v", err)
+	}
+
+	inZoneServerAddressList, allZoneServerAddressList := initializeServerAddressLists(config, adapter, logger)
+
+	var diskBuffer *diskbuffer.Buffer
+	var undelivered func([]byte)
+	if config.DiskBufferDirectory != "" {
+		diskBuffer = initializeDiskBuffer(config, logger)
+		undelivered = diskBuffer.Spool
+	}
+
+	dopplerClientPool := initializeDopplerClientPool(config, logger, inZoneServerAddressList, allZoneServerAddressList, undelivered)
+	dopplerSender := poolDopplerSender{dopplerClientPool}
 
 	// TODO: delete next three lines when "legacy" format goes away
 	legacyMessageListener, legacyMessageChan := agentlistener.NewAgentListener(fmt.Sprintf("localhost:%d", config.LegacyIncomingMessagesPort), logger, "legacyAgentListener")
@@ -61,9 +87,9 @@ func main() {
 
 	unmarshaller := dropsonde_unmarshaller.NewDropsondeUnmarshaller(logger)
 	messageAggregator := messageaggregator.New(logger)
-	varzForwarder := varzforwarder.New(config.Job, metricTTL, logger)
+	varzForwarder := varzforwarder.New(config.Job, metricTTL, latencyHistogramOptions(config), logger)
 	marshaller := dropsonde_marshaller.NewDropsondeMarshaller(logger)
-	messageTagger := tagger.New(config.Deployment, config.Job, config.Index)
+	messageTagger := tagger.New(config.Deployment, config.Job, config.Index, taggerOptions(config))
 
 	instrumentables := []instrumentation.Instrumentable{
 		legacyMessageListener,
@@ -72,6 +98,43 @@ func main() {
 		varzForwarder,
 		messageAggregator,
 		marshaller,
+		dopplerClientPool,
+	}
+
+	var statsdListener agentlistener.AgentListener
+	var statsdMessageChan <-chan []byte
+	var statsdConverter *statsdmessage.Converter
+	if config.StatsdIncomingMessagesPort != 0 {
+		statsdListener, statsdMessageChan = agentlistener.NewAgentListener(fmt.Sprintf("localhost:%d", config.StatsdIncomingMessagesPort), logger, "statsdAgentListener")
+		statsdConverter = statsdmessage.NewConverter(config.StatsdOrigin, logger)
+		instrumentables = append(instrumentables, statsdListener, statsdConverter)
+	}
+
+	dropsondeEventChan := make(chan *events.Envelope)
+
+	var jsonListener *jsonmessage.Listener
+	if config.JsonIncomingMessagesPort != 0 {
+		jsonListener = jsonmessage.NewListener(config.JsonOrigin, dropsondeEventChan, logger)
+		instrumentables = append(instrumentables, jsonListener)
+	}
+
+	var logMetricExtractor *logmetric.Extractor
+	if len(config.LogMetricRules) > 0 {
+		logMetricExtractor, err = logmetric.New(config.LogMetricRules, logger)
+		if err != nil {
+			panic(err)
+		}
+		instrumentables = append(instrumentables, logMetricExtractor)
+	}
+
+	var messageBatcher *batcher.Batcher
+	if config.BatchMaxBytes > 0 {
+		messageBatcher = initializeBatcher(config, adapter, allZoneServerAddressList, logger)
+		instrumentables = append(instrumentables, messageBatcher)
+	}
+
+	if diskBuffer != nil {
+		instrumentables = append(instrumentables, diskBuffer)
 	}
 
 	component := initializeComponent(config, logger, instrumentables)
@@ -79,8 +142,12 @@ func main() {
 	go collectorregistrar.NewCollectorRegistrar(cfcomponent.DefaultYagnatsClientProvider, component, time.Duration(config.CollectorRegistrarIntervalMilliseconds)*time.Millisecond, &config.Config).Run()
 	go startMonitoringEndpoints(component, logger)
 
+	if config.PrometheusMetricsPort != 0 {
+		exporter := prometheus_exporter.New("metron", instrumentables)
+		go exporter.ListenAndServe(net.JoinHostPort(config.PrometheusMetricsHost, strconv.Itoa(int(config.PrometheusMetricsPort))), logger)
+	}
+
 	// Produce channels for connecting processing pipeline stages
-	dropsondeEventChan := make(chan *events.Envelope)
 	logEnvelopesChan := make(chan *logmessage.LogEnvelope)
 	aggregatedEventChan := make(chan *events.Envelope)
 	taggedEventChan := make(chan *events.Envelope)
@@ -97,13 +164,42 @@ func main() {
 	go dropsondeMessageListener.Start()
 	go unmarshaller.Run(dropsondeMessageChan, dropsondeEventChan)
 
+	// Listen for StatsD metrics, convert, and drop onto dropsondeEventChan
+	if statsdListener != nil {
+		go statsdListener.Start()
+		go statsdConverter.Run(statsdMessageChan, dropsondeEventChan)
+	}
+
+	// Accept newline delimited JSON over HTTP, convert, and drop onto dropsondeEventChan
+	if jsonListener != nil {
+		go jsonListener.ListenAndServe(fmt.Sprintf("localhost:%d", config.JsonIncomingMessagesPort))
+	}
+
 	// Start the message processing pipeline
-	go messageAggregator.Run(dropsondeEventChan, aggregatedEventChan)
+	if logMetricExtractor != nil {
+		extractedEventChan := make(chan *events.Envelope)
+		go logMetricExtractor.Run(dropsondeEventChan, extractedEventChan)
+		go messageAggregator.Run(extractedEventChan, aggregatedEventChan)
+	} else {
+		go messageAggregator.Run(dropsondeEventChan, aggregatedEventChan)
+	}
 	go messageTagger.Run(aggregatedEventChan, taggedEventChan)
 	go varzForwarder.Run(taggedEventChan, forwardedEventChan)
 	go marshaller.Run(forwardedEventChan, reMarshalledMessageChan)
-	go signMessages(config.SharedSecret, reMarshalledMessageChan, signedMessageChan)
-	forwardMessagesToDoppler(dopplerClientPool, signedMessageChan, logger)
+
+	if messageBatcher != nil {
+		batchedMessageChan := make(chan []byte)
+		go messageBatcher.Run(reMarshalledMessageChan, batchedMessageChan)
+		go signMessages(config.SharedSecret, batchedMessageChan, signedMessageChan)
+	} else {
+		go signMessages(config.SharedSecret, reMarshalledMessageChan, signedMessageChan)
+	}
+
+	if diskBuffer != nil {
+		diskBuffer.Run(signedMessageChan, dopplerSender)
+	} else {
+		forwardMessagesToDoppler(dopplerSender, signedMessageChan, logger)
+	}
 }
 
 func signMessages(sharedSecret string, dropsondeMessageChan <-chan ([]byte), signedMessageChan chan<- ([]byte)) {
@@ -119,21 +215,79 @@ func startMonitoringEndpoints(component cfcomponent.Component, logger *gosteno.L
 	}
 }
 
-func initializeClientPool(config metronConfig, logger *gosteno.Logger, port int) *clientpool.LoggregatorClientPool {
-	adapter := storeAdapterProvider(config.EtcdUrls, config.EtcdMaxConcurrentRequests)
-	err := adapter.Connect()
-	if err != nil {
-		logger.Errorf("Error connecting to ETCD: %v", err)
-	}
-
+func initializeServerAddressLists(config metronConfig, adapter storeadapter.StoreAdapter, logger *gosteno.Logger) (*servicediscovery.ServerAddressList, *servicediscovery.ServerAddressList) {
 	inZoneServerAddressList := servicediscovery.NewServerAddressList(adapter, "/healthstatus/doppler/"+config.Zone, logger)
 	allZoneServerAddressList := servicediscovery.NewServerAddressList(adapter, "/healthstatus/doppler/", logger)
 
 	go inZoneServerAddressList.Run(time.Duration(config.EtcdQueryIntervalMilliseconds) * time.Millisecond)
 	go allZoneServerAddressList.Run(time.Duration(config.EtcdQueryIntervalMilliseconds) * time.Millisecond)
 
-	clientPool := clientpool.NewLoggregatorClientPool(logger, port, inZoneServerAddressList, allZoneServerAddressList)
-	return clientPool
+	return inZoneServerAddressList, allZoneServerAddressList
+}
+
+func taggerOptions(config metronConfig) tagger.Options {
+	return tagger.Options{
+		Zone:                 config.Zone,
+		Tags:                 config.Tags,
+		MetricNamePrefixTags: config.MetricNamePrefixTags,
+	}
+}
+
+func latencyHistogramOptions(config metronConfig) varzforwarder.LatencyHistogramOptions {
+	var buckets []float64
+	switch config.LatencyHistogramBuckets {
+	case "fixed":
+		buckets = varzforwarder.FixedBuckets(config.LatencyHistogramBucketStartMilliseconds, config.LatencyHistogramBucketCount)
+	case "exponential":
+		buckets = varzforwarder.ExponentialBuckets(config.LatencyHistogramBucketStartMilliseconds, config.LatencyHistogramBucketFactor, config.LatencyHistogramBucketCount)
+	}
+
+	return varzforwarder.LatencyHistogramOptions{
+		Buckets:      buckets,
+		Window:       time.Duration(config.LatencyHistogramWindowSeconds) * time.Second,
+		PerRoute:     config.LatencyHistogramPerRoute,
+		MaxRoutes:    config.LatencyHistogramMaxRoutes,
+		EmitInterval: time.Duration(config.LatencyHistogramEmitIntervalSeconds) * time.Second,
+	}
+}
+
+func initializeBatcher(config metronConfig, adapter storeadapter.StoreAdapter, allZoneServerAddressList batcher.AddressList, logger *gosteno.Logger) *batcher.Batcher {
+	batchingServerAddressList := servicediscovery.NewServerAddressList(adapter, dropsonde_batch.AdvertisementPath+"/", logger)
+	go batchingServerAddressList.Run(time.Duration(config.EtcdQueryIntervalMilliseconds) * time.Millisecond)
+
+	flushInterval := time.Duration(config.BatchFlushIntervalMilliseconds) * time.Millisecond
+	return batcher.New(config.BatchMaxBytes, flushInterval, allZoneServerAddressList, batchingServerAddressList, logger)
+}
+
+func initializeDopplerClientPool(config metronConfig, logger *gosteno.Logger, inZoneServerAddressList, allZoneServerAddressList tcpclient.AddressList, undelivered func([]byte)) *tcpclient.Pool {
+	if config.DopplerTransport == "" || config.DopplerTransport == "udp" {
+		return tcpclient.NewUDPPool(config.LoggregatorDropsondePort, inZoneServerAddressList, allZoneServerAddressList, undelivered, logger)
+	}
+
+	var tlsConfig *tls.Config
+	if config.DopplerTransport == "tls" {
+		var err error
+		tlsConfig, err = tcpclient.NewTLSConfig(config.DopplerTLSCertFile, config.DopplerTLSKeyFile, config.DopplerTLSCACertFile, config.DopplerTLSServerName)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	return tcpclient.NewPool(config.LoggregatorDropsondeTcpPort, inZoneServerAddressList, allZoneServerAddressList, tlsConfig, undelivered, logger)
+}
+
+func initializeDiskBuffer(config metronConfig, logger *gosteno.Logger) *diskbuffer.Buffer {
+	buffer, err := diskbuffer.New(diskbuffer.Options{
+		Directory: config.DiskBufferDirectory,
+		MaxBytes:  config.DiskBufferMaxBytes,
+		MaxAge:    time.Duration(config.DiskBufferMaxAgeSeconds) * time.Second,
+		DrainRate: config.DiskBufferDrainRate,
+	}, logger)
+	if err != nil {
+		panic(err)
+	}
+
+	return buffer
 }
 
 type metronConfig struct {
@@ -148,8 +302,45 @@ type metronConfig struct {
 	EtcdQueryIntervalMilliseconds int
 	LoggregatorLegacyPort         int
 	LoggregatorDropsondePort      int
+	LoggregatorDropsondeTcpPort   int
 	SharedSecret                  string
 	Deployment                    string
+	PrometheusMetricsPort         uint32
+	PrometheusMetricsHost         string
+
+	Tags                 map[string]string
+	MetricNamePrefixTags []string
+
+	StatsdIncomingMessagesPort int
+	StatsdOrigin               string
+
+	JsonIncomingMessagesPort int
+	JsonOrigin               string
+
+	LogMetricRules []logmetric.Rule
+
+	LatencyHistogramBuckets                 string
+	LatencyHistogramBucketStartMilliseconds float64
+	LatencyHistogramBucketFactor            float64
+	LatencyHistogramBucketCount             int
+	LatencyHistogramWindowSeconds           int
+	LatencyHistogramPerRoute                bool
+	LatencyHistogramMaxRoutes               int
+	LatencyHistogramEmitIntervalSeconds     int
+
+	BatchMaxBytes                  int
+	BatchFlushIntervalMilliseconds int
+
+	DiskBufferDirectory     string
+	DiskBufferMaxBytes      int64
+	DiskBufferMaxAgeSeconds int
+	DiskBufferDrainRate     int
+
+	DopplerTransport     string
+	DopplerTLSCertFile   string
+	DopplerTLSKeyFile    string
+	DopplerTLSCACertFile string
+	DopplerTLSServerName string
 }
 
 type metronHealthMonitor struct{}
@@ -184,16 +375,95 @@ func parseConfig(debug bool, configFile, logFilePath string) (metronConfig, *gos
 	logger := cfcomponent.NewLogger(debug, logFilePath, "metron", config.Config)
 	logger.Info("Startup: Setting up the Metron agent")
 
+	switch config.DopplerTransport {
+	case "", "udp", "tcp", "tls":
+	default:
+		panic(fmt.Errorf("invalid DopplerTransport %q, must be udp, tcp or tls", config.DopplerTransport))
+	}
+
+	err = taggerOptions(config).Validate()
+	if err != nil {
+		panic(err)
+	}
+
+	if config.PrometheusMetricsHost == "" {
+		config.PrometheusMetricsHost = prometheus_exporter.DefaultHost
+	}
+
+	if config.StatsdOrigin == "" {
+		config.StatsdOrigin = "statsd"
+	}
+
+	if config.JsonOrigin == "" {
+		config.JsonOrigin = "json"
+	}
+
+	switch config.LatencyHistogramBuckets {
+	case "", "fixed", "exponential":
+	default:
+		panic(fmt.Errorf("invalid LatencyHistogramBuckets %q, must be fixed or exponential", config.LatencyHistogramBuckets))
+	}
+
+	if config.LatencyHistogramBucketStartMilliseconds == 0 {
+		config.LatencyHistogramBucketStartMilliseconds = 5
+	}
+
+	if config.LatencyHistogramBucketFactor == 0 {
+		config.LatencyHistogramBucketFactor = 2
+	}
+
+	if config.LatencyHistogramBucketCount < 0 {
+		panic(fmt.Errorf("invalid LatencyHistogramBucketCount %d, must not be negative", config.LatencyHistogramBucketCount))
+	}
+
+	if config.LatencyHistogramBucketCount == 0 {
+		config.LatencyHistogramBucketCount = 12
+	}
+
+	if config.LatencyHistogramWindowSeconds == 0 {
+		config.LatencyHistogramWindowSeconds = 60
+	}
+
+	if config.LatencyHistogramMaxRoutes == 0 {
+		config.LatencyHistogramMaxRoutes = 100
+	}
+
+	if config.BatchFlushIntervalMilliseconds == 0 {
+		config.BatchFlushIntervalMilliseconds = 100
+	}
+
+	if config.DiskBufferMaxBytes == 0 {
+		config.DiskBufferMaxBytes = 100 * 1024 * 1024
+	}
+
+	if config.DiskBufferMaxAgeSeconds == 0 {
+		config.DiskBufferMaxAgeSeconds = 300
+	}
+
+	if config.DiskBufferDrainRate == 0 {
+		config.DiskBufferDrainRate = 1000
+	}
+
 	return config, logger
 }
 
-func forwardMessagesToDoppler(clientPool *clientpool.LoggregatorClientPool, messageChan <-chan []byte, logger *gosteno.Logger) {
+func forwardMessagesToDoppler(sender diskbuffer.Sender, messageChan <-chan []byte, logger *gosteno.Logger) {
 	for message := range messageChan {
-		client, err := clientPool.RandomClient()
+		err := sender.Send(message)
 		if err != nil {
 			logger.Errorf("can't forward message: %v", err)
-			continue
 		}
-		client.Send(message)
 	}
 }
+
+type poolDopplerSender struct {
+	clientPool *tcpclient.Pool
+}
+
+func (s poolDopplerSender) Send(message []byte) error {
+	client, err := s.clientPool.RandomClient()
+	if err != nil {
+		return err
+	}
+	return client.Send(message)
+}
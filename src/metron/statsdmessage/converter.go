@@ -0,0 +1,226 @@
+package statsdmessage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/events"
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+	"github.com/gogo/protobuf/proto"
+)
+
+const (
+	// maxGauges bounds the number of gauges whose last value is kept. The
+	// least recently updated gauge is forgotten to make room for a new one.
+	maxGauges = 10000
+
+	// gaugeTtl is how long the last value of a gauge is kept without updates
+	gaugeTtl = 10 * time.Minute
+)
+
+var errNegativeCounter = errors.New("negative counter delta")
+
+// Converter turns StatsD packets into dropsonde envelopes. Counters become
+// CounterEvents, gauges and timers become ValueMetrics with the unit "gauge"
+// and "ms". A packet may hold several metrics separated by newlines.
+//
+// Negative counter deltas like "-1|c" are dropped and counted as
+// negativeCounterCount, as the deltas of CounterEvents can't be negative.
+// Relative gauge updates of a gauge that was forgotten, see maxGauges and
+// gaugeTtl, apply to 0.
+type Converter struct {
+	origin string
+	logger *gosteno.Logger
+
+	// gauges holds the last value of every gauge, so that relative
+	// updates like "+3" can be turned into absolute values
+	gauges map[string]gauge
+
+	counterCount         uint64
+	gaugeCount           uint64
+	timerCount           uint64
+	invalidMetricCount   uint64
+	negativeCounterCount uint64
+}
+
+type gauge struct {
+	value   float64
+	updated time.Time
+}
+
+func NewConverter(origin string, logger *gosteno.Logger) *Converter {
+	return &Converter{
+		origin: origin,
+		logger: logger,
+		gauges: make(map[string]gauge),
+	}
+}
+
+func (c *Converter) Run(inputChan <-chan []byte, outputChan chan<- *events.Envelope) {
+	for packet := range inputChan {
+		for _, line := range bytes.Split(packet, []byte("\n")) {
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 {
+				continue
+			}
+
+			envelope, err := c.convert(string(line))
+			if err == errNegativeCounter {
+				atomic.AddUint64(&c.negativeCounterCount, 1)
+				c.logger.Debugf("statsdConverter: dropping %q: %v", line, err)
+				continue
+			}
+			if err != nil {
+				atomic.AddUint64(&c.invalidMetricCount, 1)
+				c.logger.Debugf("statsdConverter: dropping %q: %v", line, err)
+				continue
+			}
+			outputChan <- envelope
+		}
+	}
+}
+
+func (c *Converter) Emit() instrumentation.Context {
+	return instrumentation.Context{
+		Name: "statsdConverter",
+		Metrics: []instrumentation.Metric{
+			instrumentation.Metric{Name: "counterCount", Value: atomic.LoadUint64(&c.counterCount)},
+			instrumentation.Metric{Name: "gaugeCount", Value: atomic.LoadUint64(&c.gaugeCount)},
+			instrumentation.Metric{Name: "timerCount", Value: atomic.LoadUint64(&c.timerCount)},
+			instrumentation.Metric{Name: "invalidMetricCount", Value: atomic.LoadUint64(&c.invalidMetricCount)},
+			instrumentation.Metric{Name: "negativeCounterCount", Value: atomic.LoadUint64(&c.negativeCounterCount)},
+		},
+	}
+}
+
+// convert parses a line of the form <name>:<value>|<type>[|@<sample rate>].
+func (c *Converter) convert(line string) (*events.Envelope, error) {
+	colon := strings.Index(line, ":")
+	if colon <= 0 {
+		return nil, errors.New("missing metric name")
+	}
+	name := line[:colon]
+
+	fields := strings.Split(line[colon+1:], "|")
+	if len(fields) < 2 || fields[0] == "" {
+		return nil, errors.New("missing metric value or type")
+	}
+	value := fields[0]
+	metricType := fields[1]
+
+	sampleRate := 1.0
+	for _, field := range fields[2:] {
+		if strings.HasPrefix(field, "@") {
+			rate, err := strconv.ParseFloat(field[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return nil, fmt.Errorf("invalid sample rate %q", field[1:])
+			}
+			sampleRate = rate
+		}
+	}
+
+	switch metricType {
+	case "c":
+		return c.convertCounter(name, value, sampleRate)
+	case "g":
+		return c.convertGauge(name, value)
+	case "ms":
+		return c.convertTimer(name, value)
+	}
+	return nil, fmt.Errorf("unsupported metric type %q", metricType)
+}
+
+func (c *Converter) convertCounter(name, value string, sampleRate float64) (*events.Envelope, error) {
+	delta, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid counter value %q", value)
+	}
+	if delta < 0 {
+		return nil, errNegativeCounter
+	}
+
+	atomic.AddUint64(&c.counterCount, 1)
+	return &events.Envelope{
+		Origin:    proto.String(c.origin),
+		EventType: events.Envelope_CounterEvent.Enum(),
+		Timestamp: proto.Int64(time.Now().UnixNano()),
+		CounterEvent: &events.CounterEvent{
+			Name:  proto.String(name),
+			Delta: proto.Uint64(uint64(math.Floor(delta/sampleRate + 0.5))),
+		},
+	}, nil
+}
+
+func (c *Converter) convertGauge(name, value string) (*events.Envelope, error) {
+	gaugeValue, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gauge value %q", value)
+	}
+
+	now := time.Now()
+	last, ok := c.gauges[name]
+	if ok && now.Sub(last.updated) > gaugeTtl {
+		ok = false
+	}
+	if ok && (value[0] == '+' || value[0] == '-') {
+		gaugeValue += last.value
+	}
+
+	if _, known := c.gauges[name]; !known && len(c.gauges) >= maxGauges {
+		c.forgetGauges(now)
+	}
+	c.gauges[name] = gauge{value: gaugeValue, updated: now}
+
+	atomic.AddUint64(&c.gaugeCount, 1)
+	return c.valueMetric(name, gaugeValue, "gauge"), nil
+}
+
+// forgetGauges removes the gauges that expired, or the least recently updated
+// one when none did.
+func (c *Converter) forgetGauges(now time.Time) {
+	var oldestName string
+	var oldest time.Time
+	for name, g := range c.gauges {
+		if now.Sub(g.updated) > gaugeTtl {
+			delete(c.gauges, name)
+			continue
+		}
+		if oldestName == "" || g.updated.Before(oldest) {
+			oldestName, oldest = name, g.updated
+		}
+	}
+
+	if len(c.gauges) >= maxGauges {
+		delete(c.gauges, oldestName)
+	}
+}
+
+func (c *Converter) convertTimer(name, value string) (*events.Envelope, error) {
+	duration, err := strconv.ParseFloat(value, 64)
+	if err != nil || duration < 0 {
+		return nil, fmt.Errorf("invalid timer value %q", value)
+	}
+
+	atomic.AddUint64(&c.timerCount, 1)
+	return c.valueMetric(name, duration, "ms"), nil
+}
+
+func (c *Converter) valueMetric(name string, value float64, unit string) *events.Envelope {
+	return &events.Envelope{
+		Origin:    proto.String(c.origin),
+		EventType: events.Envelope_ValueMetric.Enum(),
+		Timestamp: proto.Int64(time.Now().UnixNano()),
+		ValueMetric: &events.ValueMetric{
+			Name:  proto.String(name),
+			Value: proto.Float64(value),
+			Unit:  proto.String(unit),
+		},
+	}
+}
@@ -0,0 +1,133 @@
+package statsdmessage_test
+
+import (
+	"metron/statsdmessage"
+	"time"
+
+	"github.com/cloudfoundry/dropsonde/events"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+	"github.com/gogo/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StatsdMessageConverter", func() {
+	var (
+		inputChan   chan []byte
+		outputChan  chan *events.Envelope
+		runComplete chan struct{}
+		converter   *statsdmessage.Converter
+	)
+
+	BeforeEach(func() {
+		inputChan = make(chan []byte, 10)
+		outputChan = make(chan *events.Envelope, 10)
+		runComplete = make(chan struct{})
+		converter = statsdmessage.NewConverter("my-origin", loggertesthelper.Logger())
+
+		go func() {
+			converter.Run(inputChan, outputChan)
+			close(runComplete)
+		}()
+	})
+
+	AfterEach(func() {
+		close(inputChan)
+		Eventually(runComplete).Should(BeClosed())
+	})
+
+	counter := func(name string, delta uint64) *events.Envelope {
+		return &events.Envelope{
+			Origin:    proto.String("my-origin"),
+			EventType: events.Envelope_CounterEvent.Enum(),
+			CounterEvent: &events.CounterEvent{
+				Name:  proto.String(name),
+				Delta: proto.Uint64(delta),
+			},
+		}
+	}
+
+	valueMetric := func(name string, value float64, unit string) *events.Envelope {
+		return &events.Envelope{
+			Origin:    proto.String("my-origin"),
+			EventType: events.Envelope_ValueMetric.Enum(),
+			ValueMetric: &events.ValueMetric{
+				Name:  proto.String(name),
+				Value: proto.Float64(value),
+				Unit:  proto.String(unit),
+			},
+		}
+	}
+
+	// receive returns the next envelope after checking that it was stamped
+	// with the time of its conversion, and clears the timestamp so that it
+	// can be compared with the expected envelope
+	receive := func() *events.Envelope {
+		var envelope *events.Envelope
+		Eventually(outputChan).Should(Receive(&envelope))
+		Expect(time.Unix(0, envelope.GetTimestamp())).To(BeTemporally("~", time.Now(), time.Second))
+		envelope.Timestamp = nil
+		return envelope
+	}
+
+	metric := func(name string) interface{} {
+		for _, m := range converter.Emit().Metrics {
+			if m.Name == name {
+				return m.Value
+			}
+		}
+		return nil
+	}
+
+	It("converts counters into counter events", func() {
+		inputChan <- []byte("router.requests:3|c")
+
+		Expect(receive()).To(Equal(counter("router.requests", 3)))
+		Expect(metric("counterCount")).To(Equal(uint64(1)))
+	})
+
+	It("scales counters by their sample rate", func() {
+		inputChan <- []byte("router.requests:1|c|@0.1")
+
+		Expect(receive()).To(Equal(counter("router.requests", 10)))
+	})
+
+	It("converts gauges into value metrics", func() {
+		inputChan <- []byte("router.connections:42|g")
+
+		Expect(receive()).To(Equal(valueMetric("router.connections", 42, "gauge")))
+		Expect(metric("gaugeCount")).To(Equal(uint64(1)))
+	})
+
+	It("applies relative gauge updates to the last value", func() {
+		inputChan <- []byte("router.connections:42|g\nrouter.connections:+3|g\nrouter.connections:-5|g")
+
+		Expect(receive()).To(Equal(valueMetric("router.connections", 42, "gauge")))
+		Expect(receive()).To(Equal(valueMetric("router.connections", 45, "gauge")))
+		Expect(receive()).To(Equal(valueMetric("router.connections", 40, "gauge")))
+	})
+
+	It("converts timers into value metrics in milliseconds", func() {
+		inputChan <- []byte("router.latency:12.5|ms|@0.5")
+
+		Expect(receive()).To(Equal(valueMetric("router.latency", 12.5, "ms")))
+		Expect(metric("timerCount")).To(Equal(uint64(1)))
+	})
+
+	It("converts every metric of a packet", func() {
+		inputChan <- []byte("first:1|c\n\nsecond:2|c\n")
+
+		Expect(receive()).To(Equal(counter("first", 1)))
+		Expect(receive()).To(Equal(counter("second", 2)))
+	})
+
+	It("drops invalid and unsupported metrics", func() {
+		inputChan <- []byte("no-type:1\n:1|c\ncounter:-1|c\ncounter:abc|c\nrate:1|c|@2\nunique.users:42|s\nvalid:1|c")
+
+		Expect(receive()).To(Equal(counter("valid", 1)))
+		Expect(outputChan).ToNot(Receive())
+		Expect(metric("invalidMetricCount")).To(Equal(uint64(5)))
+		Expect(metric("negativeCounterCount")).To(Equal(uint64(1)))
+	})
+})
@@ -0,0 +1,13 @@
+package statsdmessage_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestStatsdMessage(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "StatsdMessage Suite")
+}
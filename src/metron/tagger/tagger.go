@@ -1,29 +1,69 @@
 package tagger
 
 import (
+	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/cloudfoundry/dropsonde/events"
 	"github.com/gogo/protobuf/proto"
 	"github.com/pivotal-golang/localip"
 )
 
+// Options configures the tags added besides deployment, job, index and ip.
+// Envelopes only have fields for those four, so the zone and the static tags
+// reach consumers by prefixing them onto the names of value metrics and
+// counter events.
+type Options struct {
+	Zone string
+	Tags map[string]string
+
+	// MetricNamePrefixTags are the names of the tags whose values are
+	// prefixed, in order, onto metric names
+	MetricNamePrefixTags []string
+}
+
 type Tagger struct {
 	deploymentName string
 	job            string
 	index          uint
+	options        Options
 }
 
-func New(deploymentName string, job string, index uint) *Tagger {
+func New(deploymentName string, job string, index uint, options Options) *Tagger {
 	return &Tagger{
 		deploymentName: deploymentName,
 		job:            job,
 		index:          index,
+		options:        options,
+	}
+}
+
+// Validate returns an error for prefix tags that have no value.
+func (o Options) Validate() error {
+	known := map[string]bool{"deployment": true, "job": true, "index": true, "ip": true, "zone": true}
+	for name := range o.Tags {
+		known[name] = true
+	}
+
+	var unknown []string
+	for _, name := range o.MetricNamePrefixTags {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
 	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown metric name prefix tags: %s", strings.Join(unknown, ", "))
+	}
+	return nil
 }
 
 func (t *Tagger) Run(inputChan <-chan *events.Envelope, outputChan chan<- *events.Envelope) {
 	ip, _ := localip.LocalIP()
+	prefix := t.metricNamePrefix(ip)
+
 	for envelope := range inputChan {
 		newEnvelope := *envelope
 
@@ -32,6 +72,50 @@ func (t *Tagger) Run(inputChan <-chan *events.Envelope, outputChan chan<- *event
 		newEnvelope.Index = proto.String(strconv.Itoa(int(t.index)))
 		newEnvelope.Ip = proto.String(ip)
 
+		if prefix != "" {
+			prefixMetricName(&newEnvelope, prefix)
+		}
+
 		outputChan <- &newEnvelope
 	}
 }
+
+func (t *Tagger) metricNamePrefix(ip string) string {
+	values := map[string]string{
+		"deployment": t.deploymentName,
+		"job":        t.job,
+		"index":      strconv.Itoa(int(t.index)),
+		"ip":         ip,
+		"zone":       t.options.Zone,
+	}
+	for name, value := range t.options.Tags {
+		values[name] = value
+	}
+
+	var prefix []string
+	for _, name := range t.options.MetricNamePrefixTags {
+		// dots separate the parts of metric names, so they can not be
+		// part of a value
+		prefix = append(prefix, strings.Replace(values[name], ".", "_", -1)+".")
+	}
+	return strings.Join(prefix, "")
+}
+
+func prefixMetricName(envelope *events.Envelope, prefix string) {
+	switch envelope.GetEventType() {
+	case events.Envelope_ValueMetric:
+		if envelope.ValueMetric == nil {
+			return
+		}
+		valueMetric := *envelope.ValueMetric
+		valueMetric.Name = proto.String(prefix + valueMetric.GetName())
+		envelope.ValueMetric = &valueMetric
+	case events.Envelope_CounterEvent:
+		if envelope.CounterEvent == nil {
+			return
+		}
+		counterEvent := *envelope.CounterEvent
+		counterEvent.Name = proto.String(prefix + counterEvent.GetName())
+		envelope.CounterEvent = &counterEvent
+	}
+}
@@ -13,7 +13,7 @@ import (
 
 var _ = Describe("Tagger", func() {
 	It("tags events with the given deployment name, job, index and IP address", func() {
-		t := tagger.New("test-deployment", "test-job", 2)
+		t := tagger.New("test-deployment", "test-job", 2, tagger.Options{})
 
 		inputChan := make(chan *events.Envelope)
 		outputChan := make(chan *events.Envelope)
@@ -23,6 +23,76 @@ var _ = Describe("Tagger", func() {
 		expectedEnvelope := basicTaggedHttpStartStopMessage(*envelope)
 		Eventually(outputChan).Should(Receive(Equal(expectedEnvelope)))
 	})
+
+	Context("with metric name prefix tags", func() {
+		var inputChan chan *events.Envelope
+		var outputChan chan *events.Envelope
+
+		BeforeEach(func() {
+			t := tagger.New("test-deployment", "test-job", 2, tagger.Options{
+				Zone:                 "z1",
+				Tags:                 map[string]string{"region": "eu.west"},
+				MetricNamePrefixTags: []string{"region", "zone", "job", "index"},
+			})
+
+			inputChan = make(chan *events.Envelope)
+			outputChan = make(chan *events.Envelope)
+			go t.Run(inputChan, outputChan)
+		})
+
+		AfterEach(func() {
+			close(inputChan)
+		})
+
+		It("prefixes the tag values onto value metric names", func() {
+			envelope := &events.Envelope{
+				Origin:      proto.String("origin"),
+				EventType:   events.Envelope_ValueMetric.Enum(),
+				ValueMetric: &events.ValueMetric{Name: proto.String("metric"), Value: proto.Float64(1), Unit: proto.String("ms")},
+			}
+			inputChan <- envelope
+
+			var tagged *events.Envelope
+			Eventually(outputChan).Should(Receive(&tagged))
+			Expect(tagged.GetValueMetric().GetName()).To(Equal("eu_west.z1.test-job.2.metric"))
+			Expect(tagged.GetDeployment()).To(Equal("test-deployment"))
+			Expect(envelope.GetValueMetric().GetName()).To(Equal("metric"))
+		})
+
+		It("prefixes the tag values onto counter event names", func() {
+			inputChan <- &events.Envelope{
+				Origin:       proto.String("origin"),
+				EventType:    events.Envelope_CounterEvent.Enum(),
+				CounterEvent: &events.CounterEvent{Name: proto.String("counter"), Delta: proto.Uint64(1)},
+			}
+
+			var tagged *events.Envelope
+			Eventually(outputChan).Should(Receive(&tagged))
+			Expect(tagged.GetCounterEvent().GetName()).To(Equal("eu_west.z1.test-job.2.counter"))
+		})
+
+		It("leaves other events alone", func() {
+			envelope := basicHttpStartStopMessage()
+			inputChan <- envelope
+
+			Eventually(outputChan).Should(Receive(Equal(basicTaggedHttpStartStopMessage(*envelope))))
+		})
+	})
+
+	Describe("Options", func() {
+		It("accepts the built in and static tags as prefix tags", func() {
+			options := tagger.Options{
+				Tags:                 map[string]string{"region": "eu"},
+				MetricNamePrefixTags: []string{"deployment", "job", "index", "ip", "zone", "region"},
+			}
+			Expect(options.Validate()).To(Succeed())
+		})
+
+		It("rejects unknown prefix tags", func() {
+			options := tagger.Options{MetricNamePrefixTags: []string{"zone", "rack"}}
+			Expect(options.Validate()).To(MatchError("unknown metric name prefix tags: rack"))
+		})
+	})
 })
 
 func basicHttpStartStopMessage() *events.Envelope {
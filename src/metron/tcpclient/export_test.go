@@ -0,0 +1,12 @@
+package tcpclient
+
+import "time"
+
+// SetHealth lets the tests make a doppler look slow or flaky without one.
+func (c *Client) SetHealth(writeLatency time.Duration, errorRate float64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.clientStats.writeLatency = writeLatency
+	c.clientStats.errorRate = errorRate
+}
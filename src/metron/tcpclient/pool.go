@@ -0,0 +1,365 @@
+package tcpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+)
+
+var (
+	ErrNoDopplers        = errors.New("no doppler servers available")
+	ErrNoHealthyDopplers = errors.New("no healthy doppler servers available")
+)
+
+const (
+	// maxConsecutiveFailures is the number of messages a doppler may fail in
+	// a row before it is ejected
+	maxConsecutiveFailures = 3
+
+	minEjection = time.Second
+	maxEjection = time.Minute
+
+	// minWeight keeps the slowest dopplers getting some messages, so their
+	// latency and error rate are measured again
+	minWeight = 0.001
+)
+
+type AddressList interface {
+	GetAddresses() []string
+}
+
+// Pool keeps a client per doppler and hands out a random one, preferring the
+// dopplers in the zone of metron like the UDP client pool does. Connections
+// are reused across messages and closed once their doppler is gone.
+//
+// Dopplers that fail several messages in a row are ejected for a while,
+// doubling the time every time they fail again right after coming back. A
+// doppler that reads too slowly fails the messages that don't fit into the
+// queue of its client. When every doppler is ejected, RandomClient returns
+// ErrNoHealthyDopplers so the caller can keep the message.
+//
+// Healthy dopplers are picked with a weight that falls with their write
+// latency and error rate, so a doppler that reads slowly or fails some
+// messages gets less traffic before it has to be ejected.
+type Pool struct {
+	name               string
+	port               int
+	inZoneAddressList  AddressList
+	allZoneAddressList AddressList
+	newClient          func(address string) *Client
+	logger             *gosteno.Logger
+	now                func() time.Time
+	clients            map[string]*Client
+	health             map[string]*dopplerHealth
+	stoppedClientStats stats
+	lock               sync.Mutex
+
+	inZoneSelections    uint64
+	otherZoneSelections uint64
+	noHealthyDopplers   uint64
+	ejections           uint64
+}
+
+type dopplerHealth struct {
+	ejected           bool
+	ejectedUntil      time.Time
+	ejection          time.Duration
+	sentAtReadmission uint64
+	selections        uint64
+}
+
+// NewPool creates a pool of TCP clients, using TLS when tlsConfig is not nil.
+// The clients hand the messages they accepted but could not write to
+// undelivered, unless it is nil.
+func NewPool(port int, inZoneAddressList, allZoneAddressList AddressList, tlsConfig *tls.Config, undelivered func([]byte), logger *gosteno.Logger) *Pool {
+	create := func(address string) *Client {
+		return newClient(address, "tcp", tlsConfig, 0, undelivered, logger)
+	}
+	return newPool("dopplerTcpClientPool", port, inZoneAddressList, allZoneAddressList, create, logger)
+}
+
+// NewUDPPool creates a pool of UDP clients.
+func NewUDPPool(port int, inZoneAddressList, allZoneAddressList AddressList, undelivered func([]byte), logger *gosteno.Logger) *Pool {
+	create := func(address string) *Client {
+		return newClient(address, "udp", nil, udpRecovery, undelivered, logger)
+	}
+	return newPool("dopplerUdpClientPool", port, inZoneAddressList, allZoneAddressList, create, logger)
+}
+
+func newPool(name string, port int, inZoneAddressList, allZoneAddressList AddressList, newClient func(string) *Client, logger *gosteno.Logger) *Pool {
+	return &Pool{
+		name:               name,
+		port:               port,
+		inZoneAddressList:  inZoneAddressList,
+		allZoneAddressList: allZoneAddressList,
+		newClient:          newClient,
+		logger:             logger,
+		now:                time.Now,
+		clients:            make(map[string]*Client),
+		health:             make(map[string]*dopplerHealth),
+	}
+}
+
+// NewTLSConfig presents the client certificate to doppler when certFile is
+// set and trusts the CA in caCertFile instead of the system roots when set.
+func NewTLSConfig(certFile, keyFile, caCertFile, serverName string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if caCertFile != "" {
+		pemCerts, err := ioutil.ReadFile(caCertFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemCerts) {
+			return nil, fmt.Errorf("no certificates found in %s", caCertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+func (p *Pool) RandomClient() (*Client, error) {
+	inZoneHosts := p.inZoneAddressList.GetAddresses()
+	allZoneHosts := p.allZoneAddressList.GetAddresses()
+	if len(inZoneHosts) == 0 && len(allZoneHosts) == 0 {
+		return nil, ErrNoDopplers
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.removeStaleClients(inZoneHosts, allZoneHosts)
+	now := p.now()
+
+	if client := p.pickHealthy(inZoneHosts, now); client != nil {
+		p.inZoneSelections++
+		return client, nil
+	}
+
+	if client := p.pickHealthy(allZoneHosts, now); client != nil {
+		p.otherZoneSelections++
+		return client, nil
+	}
+
+	p.noHealthyDopplers++
+	return nil, ErrNoHealthyDopplers
+}
+
+func (p *Pool) Emit() instrumentation.Context {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	total := p.stoppedClientStats
+	for _, client := range p.clients {
+		total.add(client.snapshot())
+	}
+
+	ejected := 0
+	for _, health := range p.health {
+		if health.ejected {
+			ejected++
+		}
+	}
+
+	data := []instrumentation.Metric{
+		instrumentation.Metric{Name: "numberOfClients", Value: len(p.clients)},
+		instrumentation.Metric{Name: "numberOfEjectedClients", Value: ejected},
+		instrumentation.Metric{Name: "ejections", Value: p.ejections},
+		instrumentation.Metric{Name: "inZoneSelections", Value: p.inZoneSelections},
+		instrumentation.Metric{Name: "otherZoneSelections", Value: p.otherZoneSelections},
+		instrumentation.Metric{Name: "noHealthyDopplers", Value: p.noHealthyDopplers},
+		instrumentation.Metric{Name: "sentMessageCount", Value: total.sentMessageCount},
+		instrumentation.Metric{Name: "sentByteCount", Value: total.sentByteCount},
+		instrumentation.Metric{Name: "writeErrors", Value: total.writeErrors},
+		instrumentation.Metric{Name: "dialErrors", Value: total.dialErrors},
+		instrumentation.Metric{Name: "droppedMessageCount", Value: total.droppedMessageCount},
+		instrumentation.Metric{Name: "rejectedMessageCount", Value: total.rejectedMessageCount},
+	}
+
+	addresses := make([]string, 0, len(p.clients))
+	for address := range p.clients {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	for _, address := range addresses {
+		clientStats := p.clients[address].snapshot()
+		health := p.healthOf(address)
+		tags := map[string]interface{}{"address": address}
+		data = append(data,
+			instrumentation.Metric{Name: "writeErrors", Value: clientStats.writeErrors, Tags: tags},
+			instrumentation.Metric{Name: "dialErrors", Value: clientStats.dialErrors, Tags: tags},
+			instrumentation.Metric{Name: "writeLatencyMicroseconds", Value: clientStats.writeLatency.Nanoseconds() / 1000, Tags: tags},
+			instrumentation.Metric{Name: "errorRate", Value: clientStats.errorRate, Tags: tags},
+			instrumentation.Metric{Name: "selections", Value: health.selections, Tags: tags},
+			instrumentation.Metric{Name: "ejected", Value: health.ejected, Tags: tags},
+		)
+	}
+
+	return instrumentation.Context{
+		Name:    p.name,
+		Metrics: data,
+	}
+}
+
+// pickHealthy returns a random client of the hosts that are not ejected,
+// weighted by their write latency and error rate, or nil when all of them
+// are ejected.
+func (p *Pool) pickHealthy(hosts []string, now time.Time) *Client {
+	var candidates []*Client
+	var weights []float64
+	totalWeight := 0.0
+
+	for _, host := range hosts {
+		client := p.client(p.address(host))
+		if !p.isHealthy(client, client.snapshot(), now) {
+			continue
+		}
+
+		// a readmitted doppler starts over, so look at its stats again
+		clientStats := client.snapshot()
+		weight := (1 - clientStats.errorRate) / (1 + clientStats.writeLatency.Seconds()*1000)
+		if weight < minWeight {
+			weight = minWeight
+		}
+		candidates = append(candidates, client)
+		weights = append(weights, weight)
+		totalWeight += weight
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	picked := candidates[len(candidates)-1]
+	pick := rand.Float64() * totalWeight
+	for i, weight := range weights {
+		pick -= weight
+		if pick < 0 {
+			picked = candidates[i]
+			break
+		}
+	}
+
+	p.healthOf(picked.Address()).selections++
+	return picked
+}
+
+// isHealthy ejects dopplers that failed too many messages in a row and lets
+// them back in once their ejection is over.
+func (p *Pool) isHealthy(client *Client, clientStats stats, now time.Time) bool {
+	health := p.healthOf(client.Address())
+
+	if health.ejected {
+		if now.Before(health.ejectedUntil) {
+			return false
+		}
+
+		p.logger.Infof("Doppler client pool: readmitting doppler %s", client.Address())
+		health.ejected = false
+		health.sentAtReadmission = clientStats.sentMessageCount
+		client.resetFailures()
+		return true
+	}
+
+	if clientStats.consecutiveFailures >= maxConsecutiveFailures {
+		if health.ejection == 0 {
+			health.ejection = minEjection
+		} else {
+			health.ejection *= 2
+		}
+		if health.ejection > maxEjection {
+			health.ejection = maxEjection
+		}
+
+		health.ejected = true
+		health.ejectedUntil = now.Add(health.ejection)
+		p.ejections++
+		p.logger.Warnf("Doppler client pool: ejecting doppler %s for %s after %d failed messages", client.Address(), health.ejection, clientStats.consecutiveFailures)
+		return false
+	}
+
+	// a doppler that sends again after coming back starts over with the
+	// shortest ejection
+	if health.ejection > 0 && clientStats.consecutiveFailures == 0 && clientStats.sentMessageCount > health.sentAtReadmission {
+		health.ejection = 0
+	}
+	return true
+}
+
+func (p *Pool) address(host string) string {
+	return net.JoinHostPort(host, strconv.Itoa(p.port))
+}
+
+func (p *Pool) client(address string) *Client {
+	client, ok := p.clients[address]
+	if !ok {
+		client = p.newClient(address)
+		p.clients[address] = client
+	}
+	return client
+}
+
+func (p *Pool) healthOf(address string) *dopplerHealth {
+	health, ok := p.health[address]
+	if !ok {
+		health = &dopplerHealth{}
+		p.health[address] = health
+	}
+	return health
+}
+
+// removeStaleClients stops the clients of dopplers that are not announced
+// anymore, looking at all zones so a zone failover keeps the connections.
+func (p *Pool) removeStaleClients(inZoneHosts, allZoneHosts []string) {
+	current := make(map[string]bool)
+	for _, host := range allZoneHosts {
+		current[p.address(host)] = true
+	}
+	for _, host := range inZoneHosts {
+		current[p.address(host)] = true
+	}
+
+	for address, client := range p.clients {
+		if !current[address] {
+			client.Stop()
+			p.stoppedClientStats.add(client.snapshot())
+			delete(p.clients, address)
+			delete(p.health, address)
+		}
+	}
+}
+
+func (s *stats) add(other stats) {
+	s.sentMessageCount += other.sentMessageCount
+	s.sentByteCount += other.sentByteCount
+	s.writeErrors += other.writeErrors
+	s.dialErrors += other.dialErrors
+	s.droppedMessageCount += other.droppedMessageCount
+	s.rejectedMessageCount += other.rejectedMessageCount
+}
@@ -0,0 +1,293 @@
+package tcpclient_test
+
+import (
+	"io"
+	"io/ioutil"
+	"metron/tcpclient"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeAddressList struct {
+	sync.Mutex
+	addresses []string
+}
+
+func (f *fakeAddressList) GetAddresses() []string {
+	f.Lock()
+	defer f.Unlock()
+	return f.addresses
+}
+
+func (f *fakeAddressList) set(addresses ...string) {
+	f.Lock()
+	defer f.Unlock()
+	f.addresses = addresses
+}
+
+var _ = Describe("Pool", func() {
+	var (
+		inZone  *fakeAddressList
+		allZone *fakeAddressList
+		pool    *tcpclient.Pool
+	)
+
+	BeforeEach(func() {
+		inZone = &fakeAddressList{}
+		allZone = &fakeAddressList{}
+		pool = tcpclient.NewPool(3458, inZone, allZone, nil, nil, loggertesthelper.Logger())
+	})
+
+	It("returns an error without dopplers", func() {
+		_, err := pool.RandomClient()
+		Expect(err).To(Equal(tcpclient.ErrNoDopplers))
+	})
+
+	It("prefers the dopplers in the same zone", func() {
+		inZone.set("10.0.0.1")
+		allZone.set("10.0.0.1", "10.0.1.1")
+
+		for i := 0; i < 10; i++ {
+			client, err := pool.RandomClient()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(client.Address()).To(Equal("10.0.0.1:3458"))
+		}
+	})
+
+	It("uses the dopplers of other zones when none are in the same zone", func() {
+		allZone.set("10.0.1.1")
+
+		client, err := pool.RandomClient()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(client.Address()).To(Equal("10.0.1.1:3458"))
+	})
+
+	It("reuses the client of a doppler", func() {
+		inZone.set("10.0.0.1")
+
+		first, _ := pool.RandomClient()
+		second, _ := pool.RandomClient()
+		Expect(second).To(BeIdenticalTo(first))
+	})
+
+	It("replaces clients of dopplers that are gone", func() {
+		inZone.set("10.0.0.1")
+		first, _ := pool.RandomClient()
+
+		inZone.set("10.0.0.2")
+		pool.RandomClient()
+		Expect(pool.Emit().Metrics[0].Value).To(Equal(1))
+
+		inZone.set("10.0.0.1")
+		second, _ := pool.RandomClient()
+		Expect(second).ToNot(BeIdenticalTo(first))
+	})
+
+	Context("weighting dopplers", func() {
+		selections := func() map[string]int {
+			counts := make(map[string]int)
+			for i := 0; i < 1000; i++ {
+				client, err := pool.RandomClient()
+				Expect(err).ToNot(HaveOccurred())
+				counts[client.Address()]++
+			}
+			return counts
+		}
+
+		BeforeEach(func() {
+			inZone.set("10.0.0.1", "10.0.0.2")
+		})
+
+		It("picks a slow doppler less often", func() {
+			fast, _ := pool.RandomClient()
+			slow, _ := pool.RandomClient()
+			for slow == fast {
+				slow, _ = pool.RandomClient()
+			}
+			fast.SetHealth(time.Millisecond, 0)
+			slow.SetHealth(50*time.Millisecond, 0)
+
+			counts := selections()
+			Expect(counts[slow.Address()]).To(BeNumerically("<", counts[fast.Address()]/5))
+			Expect(counts[slow.Address()]).To(BeNumerically(">", 0))
+		})
+
+		It("picks a doppler that fails messages less often", func() {
+			healthy, _ := pool.RandomClient()
+			flaky, _ := pool.RandomClient()
+			for flaky == healthy {
+				flaky, _ = pool.RandomClient()
+			}
+			flaky.SetHealth(0, 0.8)
+
+			counts := selections()
+			Expect(counts[flaky.Address()]).To(BeNumerically("<", counts[healthy.Address()]/2))
+		})
+	})
+
+	Context("with a failing doppler", func() {
+		var listener net.Listener
+
+		BeforeEach(func() {
+			var err error
+			listener, err = net.Listen("tcp", "127.0.0.1:3459")
+			Expect(err).ToNot(HaveOccurred())
+			go func() {
+				for {
+					conn, err := listener.Accept()
+					if err != nil {
+						return
+					}
+					go io.Copy(ioutil.Discard, conn)
+				}
+			}()
+
+			pool = tcpclient.NewPool(3459, inZone, allZone, nil, nil, loggertesthelper.Logger())
+		})
+
+		AfterEach(func() {
+			listener.Close()
+		})
+
+		metric := func(name string) interface{} {
+			for _, m := range pool.Emit().Metrics {
+				if m.Name == name && m.Tags == nil {
+					return m.Value
+				}
+			}
+			return nil
+		}
+
+		// nothing listens on 127.0.0.2, so every message to it fails
+		failThreeTimes := func() {
+			for i := 0; i < 3; i++ {
+				client, err := pool.RandomClient()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(client.Address()).To(Equal("127.0.0.2:3459"))
+				client.Send([]byte("message"))
+				Eventually(func() uint64 {
+					return metric("droppedMessageCount").(uint64) + metric("rejectedMessageCount").(uint64)
+				}).Should(Equal(uint64(i + 1)))
+			}
+		}
+
+		It("ejects the doppler and falls back to the other zones", func() {
+			inZone.set("127.0.0.2")
+			allZone.set("127.0.0.2", "127.0.0.1")
+			failThreeTimes()
+
+			client, err := pool.RandomClient()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(client.Address()).To(Equal("127.0.0.1:3459"))
+
+			Expect(metric("ejections")).To(Equal(uint64(1)))
+			Expect(metric("numberOfEjectedClients")).To(Equal(1))
+			Expect(metric("inZoneSelections")).To(Equal(uint64(3)))
+			Expect(metric("otherZoneSelections")).To(Equal(uint64(1)))
+		})
+
+		It("readmits the doppler once its ejection is over", func() {
+			inZone.set("127.0.0.2")
+			allZone.set("127.0.0.2", "127.0.0.1")
+			failThreeTimes()
+
+			Eventually(func() string {
+				client, _ := pool.RandomClient()
+				return client.Address()
+			}, 3).Should(Equal("127.0.0.2:3459"))
+			Expect(metric("numberOfEjectedClients")).To(Equal(0))
+		})
+
+		It("returns an error when every doppler is ejected", func() {
+			inZone.set("127.0.0.2")
+			failThreeTimes()
+
+			_, err := pool.RandomClient()
+			Expect(err).To(Equal(tcpclient.ErrNoHealthyDopplers))
+			Expect(metric("noHealthyDopplers")).To(Equal(uint64(1)))
+		})
+
+		It("hands the messages it could not write to undelivered", func() {
+			undelivered := make(chan []byte, 10)
+			pool = tcpclient.NewPool(3459, inZone, allZone, nil, func(message []byte) {
+				undelivered <- message
+			}, loggertesthelper.Logger())
+			inZone.set("127.0.0.2")
+
+			client, err := pool.RandomClient()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(client.Send([]byte("message"))).To(Succeed())
+
+			Eventually(undelivered).Should(Receive(Equal([]byte("message"))))
+		})
+
+		It("ejects a doppler that refuses UDP messages", func() {
+			conn, err := net.ListenPacket("udp", "127.0.0.2:3459")
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			go io.Copy(ioutil.Discard, packetReader{conn})
+
+			// nothing listens for UDP on 127.0.0.1:3459, the ICMP port
+			// unreachable fails the writes that follow
+			pool = tcpclient.NewUDPPool(3459, inZone, allZone, nil, loggertesthelper.Logger())
+			inZone.set("127.0.0.1")
+			allZone.set("127.0.0.1", "127.0.0.2")
+
+			Eventually(func() string {
+				client, _ := pool.RandomClient()
+				client.Send([]byte("message"))
+				return client.Address()
+			}).Should(Equal("127.0.0.2:3459"))
+			Expect(metric("ejections")).To(Equal(uint64(1)))
+		})
+
+		It("emits the write latency and error rate of every doppler", func() {
+			inZone.set("127.0.0.2")
+			allZone.set("127.0.0.2", "127.0.0.1")
+			failThreeTimes()
+
+			client, _ := pool.RandomClient()
+			client.Send([]byte("message"))
+			Eventually(func() interface{} { return metric("sentMessageCount") }).Should(Equal(uint64(1)))
+
+			tagged := func(name, address string) interface{} {
+				for _, m := range pool.Emit().Metrics {
+					if m.Name == name && m.Tags["address"] == address {
+						return m.Value
+					}
+				}
+				return nil
+			}
+			Expect(tagged("errorRate", "127.0.0.2:3459")).To(BeNumerically(">", 0.4))
+			Expect(tagged("errorRate", "127.0.0.1:3459")).To(Equal(0.0))
+			Expect(tagged("writeLatencyMicroseconds", "127.0.0.1:3459")).To(BeNumerically(">=", 0))
+		})
+
+		It("does not eject a healthy doppler", func() {
+			inZone.set("127.0.0.1")
+
+			for i := 0; i < 10; i++ {
+				client, _ := pool.RandomClient()
+				client.Send([]byte("message"))
+			}
+
+			Eventually(func() interface{} { return metric("sentMessageCount") }).Should(Equal(uint64(10)))
+			Expect(metric("ejections")).To(Equal(uint64(0)))
+		})
+	})
+})
+
+type packetReader struct {
+	conn net.PacketConn
+}
+
+func (r packetReader) Read(p []byte) (int, error) {
+	n, _, err := r.conn.ReadFrom(p)
+	return n, err
+}
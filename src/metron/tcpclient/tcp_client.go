@@ -0,0 +1,338 @@
+package tcpclient
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gosteno"
+)
+
+const (
+	dialTimeout  = 5 * time.Second
+	writeTimeout = 5 * time.Second
+
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 30 * time.Second
+
+	// queueLength is the number of messages a client holds while its writer
+	// waits for doppler
+	queueLength = 1024
+
+	// udpRecovery is how long a UDP client has to send without errors before
+	// its failures are forgotten. A write to a connected UDP socket only
+	// fails after the ICMP port unreachable of an earlier datagram came
+	// back, so the writes in between succeed even though doppler is down.
+	udpRecovery = 5 * time.Second
+)
+
+var (
+	ErrStopped     = errors.New("client is stopped")
+	ErrQueueFull   = errors.New("client queue is full")
+	ErrUnreachable = errors.New("doppler is unreachable")
+)
+
+// Client sends messages to a doppler over one long lived TCP connection,
+// prefixing every message with its length as a 4 byte big endian unsigned
+// integer. Send only queues the message, a writer goroutine per client dials
+// and writes, so a slow or unreachable doppler never blocks the caller.
+// The connection is dialed lazily and, after an error, redialed once the
+// backoff has passed. Send refuses messages while the queue is full or the
+// client is backing off. Messages that were already queued but can't be
+// written are dropped, or handed to the undelivered func the client was
+// created with so they can be kept elsewhere.
+//
+// Clients created by NewUDP send every message as a datagram of a connected
+// UDP socket instead, so that the pool learns about dopplers that refuse
+// them.
+type Client struct {
+	address   string
+	network   string
+	tlsConfig *tls.Config
+	logger    *gosteno.Logger
+
+	undelivered func(message []byte)
+
+	// recovery is how long the client has to send without errors before
+	// its failures are forgotten
+	recovery time.Duration
+
+	queue    chan []byte
+	done     chan struct{}
+	stopOnce sync.Once
+
+	// only used by the writer goroutine
+	conn       net.Conn
+	backoff    time.Duration
+	nextDialAt time.Time
+
+	lock             sync.Mutex // guards the fields below
+	clientStats      stats
+	lastFailure      time.Time
+	unreachableUntil time.Time
+}
+
+type stats struct {
+	sentMessageCount     uint64
+	sentByteCount        uint64
+	writeErrors          uint64
+	dialErrors           uint64
+	droppedMessageCount  uint64
+	rejectedMessageCount uint64
+
+	// consecutiveFailures counts the messages dropped or rejected since the
+	// client last recovered
+	consecutiveFailures uint64
+
+	// writeLatency and errorRate are moving averages of the write durations
+	// and of the share of failed messages
+	writeLatency time.Duration
+	errorRate    float64
+}
+
+// New creates a client for address, using TLS when tlsConfig is not nil, and
+// starts its writer. Stop the client to stop the writer.
+func New(address string, tlsConfig *tls.Config, logger *gosteno.Logger) *Client {
+	return newClient(address, "tcp", tlsConfig, 0, nil, logger)
+}
+
+// NewUDP creates a client that sends to address over UDP and starts its
+// writer.
+func NewUDP(address string, logger *gosteno.Logger) *Client {
+	return newClient(address, "udp", nil, udpRecovery, nil, logger)
+}
+
+func newClient(address, network string, tlsConfig *tls.Config, recovery time.Duration, undelivered func([]byte), logger *gosteno.Logger) *Client {
+	c := &Client{
+		address:     address,
+		network:     network,
+		tlsConfig:   tlsConfig,
+		recovery:    recovery,
+		undelivered: undelivered,
+		logger:      logger,
+		queue:       make(chan []byte, queueLength),
+		done:        make(chan struct{}),
+	}
+
+	go c.run()
+	return c
+}
+
+func (c *Client) Address() string {
+	return c.address
+}
+
+// Send queues message, returning an error when the client refuses it.
+func (c *Client) Send(message []byte) error {
+	frame := message
+	if c.network == "tcp" {
+		frame = make([]byte, 4+len(message))
+		binary.BigEndian.PutUint32(frame, uint32(len(message)))
+		copy(frame[4:], message)
+	}
+
+	select {
+	case <-c.done:
+		c.rejected()
+		return ErrStopped
+	default:
+	}
+
+	c.lock.Lock()
+	unreachable := time.Now().Before(c.unreachableUntil)
+	c.lock.Unlock()
+	if unreachable {
+		c.rejected()
+		return ErrUnreachable
+	}
+
+	select {
+	case c.queue <- frame:
+		return nil
+	default:
+		c.rejected()
+		return ErrQueueFull
+	}
+}
+
+// Stop stops the writer and drops the queued messages, handing them to the
+// undelivered func.
+func (c *Client) Stop() {
+	c.stopOnce.Do(func() { close(c.done) })
+	c.dropQueued()
+}
+
+func (c *Client) run() {
+	for {
+		select {
+		case <-c.done:
+			if c.conn != nil {
+				c.conn.Close()
+			}
+			c.dropQueued()
+			return
+		case frame := <-c.queue:
+			c.write(frame)
+		}
+	}
+}
+
+func (c *Client) write(frame []byte) {
+	// a connection closed by doppler is only noticed on the next write, so
+	// a failed write is retried once on a new connection. A UDP write that
+	// failed is not retried, the new socket would hide that doppler refused
+	// the earlier datagrams.
+	attempts := 2
+	if c.network == "udp" {
+		attempts = 1
+	}
+
+	for attempt := 0; attempt < attempts; attempt++ {
+		if c.conn == nil && !c.dial() {
+			break
+		}
+
+		writeStart := time.Now()
+		c.conn.SetWriteDeadline(writeStart.Add(writeTimeout))
+		_, err := c.conn.Write(frame)
+		if err == nil {
+			c.backoff = 0
+			c.sent(len(frame), time.Since(writeStart))
+			return
+		}
+
+		c.lock.Lock()
+		c.clientStats.writeErrors++
+		c.lock.Unlock()
+
+		c.logger.Warnf("Doppler client: error writing to %s: %v", c.address, err)
+		c.conn.Close()
+		c.conn = nil
+	}
+
+	c.dropped(frame)
+}
+
+// dropQueued empties the queue of a stopped client, counting its messages as
+// dropped.
+func (c *Client) dropQueued() {
+	for {
+		select {
+		case frame := <-c.queue:
+			c.dropped(frame)
+		default:
+			return
+		}
+	}
+}
+
+func (c *Client) sent(byteCount int, latency time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.clientStats.sentMessageCount++
+	c.clientStats.sentByteCount += uint64(byteCount)
+	if time.Since(c.lastFailure) >= c.recovery {
+		c.clientStats.consecutiveFailures = 0
+	}
+
+	if c.clientStats.writeLatency == 0 {
+		c.clientStats.writeLatency = latency
+	} else {
+		c.clientStats.writeLatency = (4*c.clientStats.writeLatency + latency) / 5
+	}
+	c.clientStats.errorRate = 4 * c.clientStats.errorRate / 5
+}
+
+func (c *Client) dropped(frame []byte) {
+	c.lock.Lock()
+	c.clientStats.droppedMessageCount++
+	c.failed()
+	c.lock.Unlock()
+
+	if c.undelivered != nil {
+		message := frame
+		if c.network == "tcp" {
+			message = frame[4:]
+		}
+		c.undelivered(message)
+	}
+}
+
+func (c *Client) rejected() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.clientStats.rejectedMessageCount++
+	c.failed()
+}
+
+func (c *Client) failed() {
+	c.clientStats.consecutiveFailures++
+	c.clientStats.errorRate = (4*c.clientStats.errorRate + 1) / 5
+	c.lastFailure = time.Now()
+}
+
+func (c *Client) snapshot() stats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.clientStats
+}
+
+func (c *Client) resetFailures() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.clientStats.consecutiveFailures = 0
+	c.clientStats.errorRate = 0
+}
+
+func (c *Client) dial() bool {
+	now := time.Now()
+	if now.Before(c.nextDialAt) {
+		return false
+	}
+
+	dialer := &net.Dialer{Timeout: dialTimeout}
+
+	var conn net.Conn
+	var err error
+	if c.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, c.network, c.address, c.tlsConfig)
+	} else {
+		conn, err = dialer.Dial(c.network, c.address)
+	}
+
+	if err != nil {
+		c.lock.Lock()
+		c.clientStats.dialErrors++
+		c.lock.Unlock()
+
+		c.increaseBackoff(now)
+		c.logger.Warnf("Doppler client: error connecting to %s, retrying in %s: %v", c.address, c.backoff, err)
+		return false
+	}
+
+	c.conn = conn
+	return true
+}
+
+func (c *Client) increaseBackoff(now time.Time) {
+	if c.backoff == 0 {
+		c.backoff = minBackoff
+	} else {
+		c.backoff *= 2
+	}
+	if c.backoff > maxBackoff {
+		c.backoff = maxBackoff
+	}
+	c.nextDialAt = now.Add(c.backoff)
+
+	c.lock.Lock()
+	c.unreachableUntil = c.nextDialAt
+	c.lock.Unlock()
+}
@@ -0,0 +1,194 @@
+package tcpclient_test
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"metron/tcpclient"
+	"net"
+	"time"
+
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		listener net.Listener
+		received chan []byte
+		accepted chan net.Conn
+		client   *tcpclient.Client
+	)
+
+	serve := func(l net.Listener) {
+		listener = l
+		received = make(chan []byte, 10)
+		accepted = make(chan net.Conn, 10)
+
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				accepted <- conn
+
+				go func() {
+					for {
+						header := make([]byte, 4)
+						if _, err := io.ReadFull(conn, header); err != nil {
+							return
+						}
+						message := make([]byte, binary.BigEndian.Uint32(header))
+						if _, err := io.ReadFull(conn, message); err != nil {
+							return
+						}
+						received <- message
+					}
+				}()
+			}
+		}()
+	}
+
+	AfterEach(func() {
+		client.Stop()
+		listener.Close()
+	})
+
+	Context("without TLS", func() {
+		BeforeEach(func() {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			serve(l)
+			client = tcpclient.New(listener.Addr().String(), nil, loggertesthelper.Logger())
+		})
+
+		It("sends length prefixed messages over one connection", func() {
+			client.Send([]byte("first"))
+			client.Send([]byte("second"))
+
+			Eventually(received).Should(Receive(Equal([]byte("first"))))
+			Eventually(received).Should(Receive(Equal([]byte("second"))))
+			Expect(accepted).To(HaveLen(1))
+		})
+
+		It("reconnects when doppler closed the connection", func() {
+			client.Send([]byte("first"))
+			Eventually(received).Should(Receive())
+
+			var conn net.Conn
+			Eventually(accepted).Should(Receive(&conn))
+			conn.Close()
+
+			Eventually(func() []byte {
+				client.Send([]byte("second"))
+				select {
+				case message := <-received:
+					return message
+				default:
+					return nil
+				}
+			}).Should(Equal([]byte("second")))
+		})
+
+		It("does not block while doppler is not reading", func() {
+			stalled, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			defer stalled.Close()
+			go func() {
+				conn, err := stalled.Accept()
+				if err == nil {
+					defer conn.Close()
+					time.Sleep(10 * time.Second)
+				}
+			}()
+
+			stalledClient := tcpclient.New(stalled.Addr().String(), nil, loggertesthelper.Logger())
+			defer stalledClient.Stop()
+
+			message := make([]byte, 64*1024)
+			start := time.Now()
+			for i := 0; i < 2000; i++ {
+				stalledClient.Send(message)
+			}
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+			Expect(stalledClient.Send(message)).To(Equal(tcpclient.ErrQueueFull))
+		})
+
+		It("refuses messages while doppler is not reachable", func() {
+			listener.Close()
+
+			Expect(client.Send([]byte("first"))).To(Succeed())
+			Eventually(func() error {
+				return client.Send([]byte("second"))
+			}).Should(Equal(tcpclient.ErrUnreachable))
+
+			Consistently(received).ShouldNot(Receive())
+		})
+
+		It("refuses messages once stopped", func() {
+			client.Stop()
+
+			Expect(client.Send([]byte("message"))).To(Equal(tcpclient.ErrStopped))
+		})
+	})
+
+	Context("with TLS", func() {
+		BeforeEach(func() {
+			cert, err := tls.LoadX509KeyPair("fixtures/server.crt", "fixtures/server.key")
+			Expect(err).ToNot(HaveOccurred())
+			l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+			Expect(err).ToNot(HaveOccurred())
+			serve(l)
+		})
+
+		It("sends messages to a doppler with a trusted certificate", func() {
+			tlsConfig, err := tcpclient.NewTLSConfig("fixtures/client.crt", "fixtures/client.key", "fixtures/ca.crt", "")
+			Expect(err).ToNot(HaveOccurred())
+			client = tcpclient.New(listener.Addr().String(), tlsConfig, loggertesthelper.Logger())
+
+			client.Send([]byte("secure message"))
+			Eventually(received).Should(Receive(Equal([]byte("secure message"))))
+		})
+
+		It("does not send messages to a doppler with an untrusted certificate", func() {
+			tlsConfig, err := tcpclient.NewTLSConfig("", "", "", "")
+			Expect(err).ToNot(HaveOccurred())
+			client = tcpclient.New(listener.Addr().String(), tlsConfig, loggertesthelper.Logger())
+
+			client.Send([]byte("secure message"))
+			Consistently(received).ShouldNot(Receive())
+		})
+	})
+})
+
+var _ = Describe("UDP Client", func() {
+	var (
+		conn   net.PacketConn
+		client *tcpclient.Client
+	)
+
+	BeforeEach(func() {
+		var err error
+		conn, err = net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		client = tcpclient.NewUDP(conn.LocalAddr().String(), loggertesthelper.Logger())
+	})
+
+	AfterEach(func() {
+		client.Stop()
+		conn.Close()
+	})
+
+	It("sends every message as a datagram without a length prefix", func() {
+		client.Send([]byte("message"))
+
+		buffer := make([]byte, 64)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buffer)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(buffer[:n]).To(Equal([]byte("message")))
+	})
+})
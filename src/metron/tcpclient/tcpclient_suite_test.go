@@ -0,0 +1,13 @@
+package tcpclient_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestTcpclient(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Tcpclient Suite")
+}
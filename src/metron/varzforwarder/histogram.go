@@ -0,0 +1,171 @@
+package varzforwarder
+
+import (
+	"math"
+	"time"
+)
+
+// LatencyHistogramOptions configures the latency histograms built from
+// HttpStartStop events. Histograms are disabled when Buckets is empty.
+type LatencyHistogramOptions struct {
+	// Buckets are the upper bounds of the buckets in milliseconds, see
+	// FixedBuckets and ExponentialBuckets
+	Buckets []float64
+
+	// Window is how long observations count towards the percentiles. The
+	// bucket counts are never reset.
+	Window time.Duration
+
+	// PerRoute adds a histogram per host of the request uri, for at most
+	// MaxRoutes hosts per origin
+	PerRoute  bool
+	MaxRoutes int
+
+	// EmitInterval is the interval at which the percentiles are sent on as
+	// ValueMetric envelopes. They are only available through Emit when 0.
+	EmitInterval time.Duration
+}
+
+var percentiles = []struct {
+	name  string
+	value float64
+}{
+	{"p50", 0.5},
+	{"p90", 0.9},
+	{"p99", 0.99},
+}
+
+// FixedBuckets returns count buckets that are width milliseconds wide.
+func FixedBuckets(width float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = width * float64(i+1)
+	}
+	return buckets
+}
+
+// ExponentialBuckets returns count buckets, the first one ending at start
+// milliseconds and every following one factor times as wide.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	bound := start
+	for i := range buckets {
+		buckets[i] = bound
+		bound *= factor
+	}
+	return buckets
+}
+
+// histogram counts latencies per bucket. The last count is for the latencies
+// above the highest bound. Percentiles are estimated from the observations
+// of the current and the previous window.
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+
+	window         time.Duration
+	windowStart    time.Time
+	windowCounts   []uint64
+	previousCounts []uint64
+	windowMaxima   [2]float64
+	now            func() time.Time
+}
+
+func newHistogram(bounds []float64, window time.Duration, now func() time.Time) *histogram {
+	return &histogram{
+		bounds:         bounds,
+		counts:         make([]uint64, len(bounds)+1),
+		window:         window,
+		windowStart:    now(),
+		windowCounts:   make([]uint64, len(bounds)+1),
+		previousCounts: make([]uint64, len(bounds)+1),
+		now:            now,
+	}
+}
+
+func (h *histogram) observe(latency float64) {
+	h.rotate()
+
+	bucket := len(h.bounds)
+	for i, bound := range h.bounds {
+		if latency <= bound {
+			bucket = i
+			break
+		}
+	}
+
+	h.counts[bucket]++
+	h.count++
+	h.sum += latency
+	h.windowCounts[bucket]++
+	h.windowMaxima[0] = math.Max(h.windowMaxima[0], latency)
+}
+
+// percentile interpolates linearly within the bucket holding the percentile.
+// Latencies above the highest bound are assumed to be at most the largest
+// latency observed.
+func (h *histogram) percentile(p float64) float64 {
+	h.rotate()
+
+	total := uint64(0)
+	for i := range h.windowCounts {
+		total += h.windowCounts[i] + h.previousCounts[i]
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := p * float64(total)
+	seen := uint64(0)
+	for i := range h.windowCounts {
+		inBucket := h.windowCounts[i] + h.previousCounts[i]
+		if inBucket == 0 || float64(seen+inBucket) < rank {
+			seen += inBucket
+			continue
+		}
+
+		lower := 0.0
+		if i > 0 {
+			lower = h.bounds[i-1]
+		}
+		upper := math.Max(h.windowMaxima[0], h.windowMaxima[1])
+		if i < len(h.bounds) {
+			upper = h.bounds[i]
+		}
+		if upper < lower {
+			upper = lower
+		}
+
+		return lower + (upper-lower)*(rank-float64(seen))/float64(inBucket)
+	}
+	return h.bounds[len(h.bounds)-1]
+}
+
+func (h *histogram) rotate() {
+	if h.window <= 0 {
+		return
+	}
+
+	elapsed := h.now().Sub(h.windowStart)
+	if elapsed < h.window {
+		return
+	}
+
+	if elapsed < 2*h.window {
+		copy(h.previousCounts, h.windowCounts)
+		h.windowMaxima[1] = h.windowMaxima[0]
+	} else {
+		for i := range h.previousCounts {
+			h.previousCounts[i] = 0
+		}
+		h.windowMaxima[1] = 0
+	}
+
+	for i := range h.windowCounts {
+		h.windowCounts[i] = 0
+	}
+	h.windowMaxima[0] = 0
+	h.windowStart = h.windowStart.Add(elapsed - elapsed%h.window)
+}
@@ -1,6 +1,7 @@
 package varzforwarder
 
 import (
+	"net/url"
 	"time"
 
 	"github.com/cloudfoundry/dropsonde/events"
@@ -9,6 +10,15 @@ import (
 type metrics struct {
 	metricsByName map[string]float64
 	timer         *time.Timer
+
+	latencyOptions LatencyHistogramOptions
+	latency        *histogram
+	routeLatencies map[string]*histogram
+	now            func() time.Time
+
+	// lastHTTPStartStop is the latest tagged event of the origin, its tags
+	// are copied onto the latency envelopes
+	lastHTTPStartStop *events.Envelope
 }
 
 func (metrics *metrics) processMetric(metric *events.Envelope) {
@@ -52,4 +62,46 @@ func (metrics *metrics) processHTTPStartStop(metric *events.Envelope) {
 		metrics.metricsByName["responseCount5XX"] = metrics.metricsByName["responseCount5XX"] + 1
 	default:
 	}
+
+	if len(metrics.latencyOptions.Buckets) > 0 {
+		metrics.processLatency(metric)
+	}
+}
+
+func (metrics *metrics) processLatency(metric *events.Envelope) {
+	startStop := metric.GetHttpStartStop()
+	start, stop := startStop.GetStartTimestamp(), startStop.GetStopTimestamp()
+	if start <= 0 || stop < start {
+		return
+	}
+	latency := float64(stop-start) / float64(time.Millisecond)
+
+	metrics.lastHTTPStartStop = metric
+	if metrics.latency == nil {
+		metrics.latency = metrics.newHistogram()
+	}
+	metrics.latency.observe(latency)
+
+	if !metrics.latencyOptions.PerRoute {
+		return
+	}
+
+	uri, err := url.Parse(startStop.GetUri())
+	if err != nil || uri.Host == "" {
+		return
+	}
+
+	routeLatency, ok := metrics.routeLatencies[uri.Host]
+	if !ok {
+		if len(metrics.routeLatencies) >= metrics.latencyOptions.MaxRoutes {
+			return
+		}
+		routeLatency = metrics.newHistogram()
+		metrics.routeLatencies[uri.Host] = routeLatency
+	}
+	routeLatency.observe(latency)
+}
+
+func (metrics *metrics) newHistogram() *histogram {
+	return newHistogram(metrics.latencyOptions.Buckets, metrics.latencyOptions.Window, metrics.now)
 }
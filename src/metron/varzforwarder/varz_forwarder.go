@@ -2,44 +2,67 @@ package varzforwarder
 
 import (
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/cloudfoundry/dropsonde/events"
 	"github.com/cloudfoundry/gosteno"
 	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+	"github.com/gogo/protobuf/proto"
 )
 
 type VarzForwarder struct {
 	metricsByOrigin map[string]*metrics
 	componentName   string
 	ttl             time.Duration
+	latencyOptions  LatencyHistogramOptions
 
 	logger *gosteno.Logger
 	lock   sync.RWMutex
 }
 
-func New(componentName string, ttl time.Duration, logger *gosteno.Logger) *VarzForwarder {
+func New(componentName string, ttl time.Duration, latencyOptions LatencyHistogramOptions, logger *gosteno.Logger) *VarzForwarder {
 	return &VarzForwarder{
 		metricsByOrigin: make(map[string]*metrics),
 		componentName:   componentName,
 		ttl:             ttl,
+		latencyOptions:  latencyOptions,
 		logger:          logger,
 	}
 }
 
 func (vf *VarzForwarder) Run(metricChan <-chan *events.Envelope, outputChan chan<- *events.Envelope) {
-	for metric := range metricChan {
-		vf.addMetric(metric)
-		vf.resetTimer(metric.GetOrigin())
+	var emitLatencies <-chan time.Time
+	if len(vf.latencyOptions.Buckets) > 0 && vf.latencyOptions.EmitInterval > 0 {
+		ticker := time.NewTicker(vf.latencyOptions.EmitInterval)
+		defer ticker.Stop()
+		emitLatencies = ticker.C
+	}
 
-		outputChan <- metric
+	for {
+		select {
+		case metric, ok := <-metricChan:
+			if !ok {
+				return
+			}
+
+			vf.addMetric(metric)
+			vf.resetTimer(metric.GetOrigin())
+
+			outputChan <- metric
+		case <-emitLatencies:
+			for _, envelope := range vf.latencyEnvelopes() {
+				outputChan <- envelope
+			}
+		}
 	}
 }
 
 func (vf *VarzForwarder) Emit() instrumentation.Context {
-	vf.lock.RLock()
-	defer vf.lock.RUnlock()
+	// percentiles move the histogram windows along, so this is not a read
+	vf.lock.Lock()
+	defer vf.lock.Unlock()
 
 	c := instrumentation.Context{Name: "forwarder"}
 	metrics := []instrumentation.Metric{}
@@ -52,6 +75,14 @@ func (vf *VarzForwarder) Emit() instrumentation.Context {
 			metricName := fmt.Sprintf("%s.%s", origin, name)
 			metrics = append(metrics, instrumentation.Metric{Name: metricName, Value: value, Tags: tags})
 		}
+
+		if originMetrics.latency != nil {
+			metrics = append(metrics, latencyMetrics(origin+".latency", originMetrics.latency, tags)...)
+		}
+		for route, routeLatency := range originMetrics.routeLatencies {
+			routeTags := withTag(tags, "route", route)
+			metrics = append(metrics, latencyMetrics(origin+".latency", routeLatency, routeTags)...)
+		}
 	}
 
 	c.Metrics = metrics
@@ -74,8 +105,11 @@ func (vf *VarzForwarder) addMetric(metric *events.Envelope) {
 func (vf *VarzForwarder) createMetrics(origin string) *metrics {
 	vf.logger.Debugf("creating metrics for origin %v", origin)
 	return &metrics{
-		metricsByName: make(map[string]float64),
-		timer:         time.AfterFunc(vf.ttl, func() { vf.deleteMetrics(origin) }),
+		metricsByName:  make(map[string]float64),
+		timer:          time.AfterFunc(vf.ttl, func() { vf.deleteMetrics(origin) }),
+		latencyOptions: vf.latencyOptions,
+		routeLatencies: make(map[string]*histogram),
+		now:            time.Now,
 	}
 }
 
@@ -96,3 +130,72 @@ func (vf *VarzForwarder) resetTimer(origin string) {
 		metrics.timer.Reset(vf.ttl)
 	}
 }
+
+// latencyEnvelopes returns the latency percentiles of every origin as
+// ValueMetrics, tagged like the last HttpStartStop event of the origin.
+func (vf *VarzForwarder) latencyEnvelopes() []*events.Envelope {
+	vf.lock.Lock()
+	defer vf.lock.Unlock()
+
+	var envelopes []*events.Envelope
+	for _, originMetrics := range vf.metricsByOrigin {
+		if originMetrics.latency == nil {
+			continue
+		}
+
+		template := originMetrics.lastHTTPStartStop
+		for _, p := range percentiles {
+			envelopes = append(envelopes, latencyEnvelope(template, "latency."+p.name, originMetrics.latency.percentile(p.value)))
+		}
+		for route, routeLatency := range originMetrics.routeLatencies {
+			for _, p := range percentiles {
+				envelopes = append(envelopes, latencyEnvelope(template, "latency."+route+"."+p.name, routeLatency.percentile(p.value)))
+			}
+		}
+	}
+	return envelopes
+}
+
+func latencyEnvelope(template *events.Envelope, name string, value float64) *events.Envelope {
+	envelope := *template
+	envelope.EventType = events.Envelope_ValueMetric.Enum()
+	envelope.Timestamp = proto.Int64(time.Now().UnixNano())
+	envelope.HttpStartStop = nil
+	envelope.ValueMetric = &events.ValueMetric{
+		Name:  proto.String(name),
+		Value: proto.Float64(value),
+		Unit:  proto.String("ms"),
+	}
+	return &envelope
+}
+
+func latencyMetrics(name string, h *histogram, tags map[string]interface{}) []instrumentation.Metric {
+	metrics := []instrumentation.Metric{
+		instrumentation.Metric{Name: name + ".count", Value: h.count, Tags: tags},
+		instrumentation.Metric{Name: name + ".sum", Value: h.sum, Tags: tags},
+	}
+
+	for _, p := range percentiles {
+		metrics = append(metrics, instrumentation.Metric{Name: name + "." + p.name, Value: h.percentile(p.value), Tags: tags})
+	}
+
+	cumulative := uint64(0)
+	for i, count := range h.counts {
+		cumulative += count
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = strconv.FormatFloat(h.bounds[i], 'g', -1, 64)
+		}
+		metrics = append(metrics, instrumentation.Metric{Name: name + ".bucket", Value: cumulative, Tags: withTag(tags, "le", le)})
+	}
+	return metrics
+}
+
+func withTag(tags map[string]interface{}, key string, value interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(tags)+1)
+	for k, v := range tags {
+		merged[k] = v
+	}
+	merged[key] = value
+	return merged
+}
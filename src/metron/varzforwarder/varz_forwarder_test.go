@@ -21,7 +21,7 @@ var _ = Describe("VarzForwarder", func() {
 	)
 
 	BeforeEach(func() {
-		forwarder = varzforwarder.New("test-component", time.Millisecond*100, loggertesthelper.Logger())
+		forwarder = varzforwarder.New("test-component", time.Millisecond*100, varzforwarder.LatencyHistogramOptions{}, loggertesthelper.Logger())
 		metricChan = make(chan *events.Envelope)
 		outputChan = make(chan *events.Envelope, 1024)
 	})
@@ -186,6 +186,119 @@ var _ = Describe("VarzForwarder", func() {
 		})
 	})
 
+	Describe("latency histograms", func() {
+		var options varzforwarder.LatencyHistogramOptions
+
+		BeforeEach(func() {
+			options = varzforwarder.LatencyHistogramOptions{
+				Buckets:   varzforwarder.FixedBuckets(10, 10),
+				Window:    time.Minute,
+				MaxRoutes: 1,
+			}
+		})
+
+		perform := func() {
+			forwarder = varzforwarder.New("test-component", time.Second, options, loggertesthelper.Logger())
+			go forwarder.Run(metricChan, outputChan)
+		}
+
+		send := func(count int, uri string, latency time.Duration) {
+			for i := 0; i < count; i++ {
+				metricChan <- httpStartStop("origin", uri, latency)
+			}
+		}
+
+		findMetric := func(name string, tags map[string]interface{}) *instrumentation.Metric {
+			for _, metric := range forwarder.Emit().Metrics {
+				if metric.Name != name {
+					continue
+				}
+
+				matches := true
+				for key, value := range tags {
+					if metric.Tags[key] != value {
+						matches = false
+					}
+				}
+				if matches && (tags["route"] != nil || metric.Tags["route"] == nil) {
+					return &metric
+				}
+			}
+			return nil
+		}
+
+		It("counts the latencies per bucket and origin", func() {
+			perform()
+			send(50, "http://app.example.com/", 5*time.Millisecond)
+			send(40, "http://app.example.com/", 15*time.Millisecond)
+			send(10, "http://app.example.com/", 250*time.Millisecond)
+
+			Eventually(func() interface{} { return findMetric("origin.latency.count", nil).Value }).Should(Equal(uint64(100)))
+			Expect(findMetric("origin.latency.bucket", map[string]interface{}{"le": "10"}).Value).To(Equal(uint64(50)))
+			Expect(findMetric("origin.latency.bucket", map[string]interface{}{"le": "20"}).Value).To(Equal(uint64(90)))
+			Expect(findMetric("origin.latency.bucket", map[string]interface{}{"le": "100"}).Value).To(Equal(uint64(90)))
+			Expect(findMetric("origin.latency.bucket", map[string]interface{}{"le": "+Inf"}).Value).To(Equal(uint64(100)))
+			Expect(findMetric("origin.latency.sum", nil).Value).To(BeNumerically("~", 50*5+40*15+10*250, 0.001))
+		})
+
+		It("estimates the percentiles from the buckets", func() {
+			perform()
+			send(50, "http://app.example.com/", 5*time.Millisecond)
+			send(40, "http://app.example.com/", 15*time.Millisecond)
+			send(10, "http://app.example.com/", 250*time.Millisecond)
+
+			Eventually(func() interface{} { return findMetric("origin.latency.count", nil).Value }).Should(Equal(uint64(100)))
+			Expect(findMetric("origin.latency.p50", nil).Value).To(BeNumerically("~", 10, 0.001))
+			Expect(findMetric("origin.latency.p90", nil).Value).To(BeNumerically("~", 20, 0.001))
+			Expect(findMetric("origin.latency.p99", nil).Value).To(BeNumerically(">", 100))
+			Expect(findMetric("origin.latency.p99", nil).Value).To(BeNumerically("<=", 250))
+		})
+
+		It("ignores events without valid timestamps", func() {
+			perform()
+			metricChan <- httpmetric("origin", 200)
+
+			Consistently(func() *instrumentation.Metric { return findMetric("origin.latency.count", nil) }).Should(BeNil())
+		})
+
+		It("keeps a histogram per route when enabled", func() {
+			options.PerRoute = true
+			perform()
+			send(2, "http://app.example.com/path", 5*time.Millisecond)
+			send(1, "http://other.example.com/", 5*time.Millisecond)
+
+			Eventually(func() interface{} { return findMetric("origin.latency.count", nil).Value }).Should(Equal(uint64(3)))
+			route := findMetric("origin.latency.count", map[string]interface{}{"route": "app.example.com"})
+			Expect(route.Value).To(Equal(uint64(2)))
+			Expect(findMetric("origin.latency.count", map[string]interface{}{"route": "other.example.com"})).To(BeNil())
+		})
+
+		It("sends the percentiles on as value metrics", func() {
+			options.EmitInterval = 10 * time.Millisecond
+			perform()
+			send(1, "http://app.example.com/", 5*time.Millisecond)
+			Eventually(outputChan).Should(Receive())
+
+			var envelope *events.Envelope
+			Eventually(outputChan).Should(Receive(&envelope))
+			Expect(envelope.GetOrigin()).To(Equal("origin"))
+			Expect(envelope.GetEventType()).To(Equal(events.Envelope_ValueMetric))
+			Expect(envelope.GetValueMetric().GetName()).To(HavePrefix("latency.p"))
+			Expect(envelope.GetValueMetric().GetUnit()).To(Equal("ms"))
+			Expect(time.Unix(0, envelope.GetTimestamp())).To(BeTemporally("~", time.Now(), time.Second))
+		})
+	})
+
+	Describe("buckets", func() {
+		It("creates fixed buckets", func() {
+			Expect(varzforwarder.FixedBuckets(5, 3)).To(Equal([]float64{5, 10, 15}))
+		})
+
+		It("creates exponential buckets", func() {
+			Expect(varzforwarder.ExponentialBuckets(1, 2, 4)).To(Equal([]float64{1, 2, 4, 8}))
+		})
+	})
+
 	Describe("Run", func() {
 		It("passes ValueMetrics through", func() {
 			perform()
@@ -237,6 +350,20 @@ func httpmetric(origin string, status int32) *events.Envelope {
 	}
 }
 
+func httpStartStop(origin, uri string, latency time.Duration) *events.Envelope {
+	start := time.Now().UnixNano()
+	return &events.Envelope{
+		Origin:    &origin,
+		EventType: events.Envelope_HttpStartStop.Enum(),
+		HttpStartStop: &events.HttpStartStop{
+			StartTimestamp: proto.Int64(start),
+			StopTimestamp:  proto.Int64(start + int64(latency)),
+			StatusCode:     proto.Int32(200),
+			Uri:            proto.String(uri),
+		},
+	}
+}
+
 func findMetricByName(metrics []instrumentation.Metric, metricName string) *instrumentation.Metric {
 	for _, metric := range metrics {
 		if metric.Name == metricName {
@@ -0,0 +1,198 @@
+package prometheus_exporter
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+)
+
+const contentType = "text/plain; version=0.0.4"
+
+// DefaultHost keeps the metrics endpoint off public interfaces unless a
+// component is configured to listen elsewhere, e.g. on its internal IP.
+const DefaultHost = "127.0.0.1"
+
+// Exporter serves the contexts emitted by the instrumentables in the
+// Prometheus text format. Every metric is named
+// <namespace>_<context>_<metric> in snake case and its tags become labels.
+// Metrics with a value that is not a number are left out.
+type Exporter struct {
+	namespace       string
+	instrumentables []instrumentation.Instrumentable
+}
+
+func New(namespace string, instrumentables []instrumentation.Instrumentable) *Exporter {
+	return &Exporter{
+		namespace:       namespace,
+		instrumentables: instrumentables,
+	}
+}
+
+// ListenAndServe serves the metrics on /metrics until the listener fails.
+func (e *Exporter) ListenAndServe(address string, logger *gosteno.Logger) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+
+	logger.Infof("Startup: serving Prometheus metrics on %s/metrics", address)
+	err := http.ListenAndServe(address, mux)
+	if err != nil {
+		logger.Errorf("Prometheus metrics endpoint stopped: %v", err)
+	}
+}
+
+func (e *Exporter) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" && request.Method != "HEAD" {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	writer.Header().Set("Content-Type", contentType)
+	writer.Write(e.render())
+}
+
+type sample struct {
+	labels string
+	value  string
+}
+
+func (e *Exporter) render() []byte {
+	samples := make(map[string][]sample)
+	seen := make(map[string]bool)
+
+	for _, instrumentable := range e.instrumentables {
+		context := instrumentable.Emit()
+		for _, metric := range context.Metrics {
+			value, ok := formatValue(metric.Value)
+			if !ok {
+				continue
+			}
+
+			name := metricName(e.namespace, context.Name, metric.Name)
+			labels := formatLabels(metric.Tags)
+
+			// the same series can only be reported once
+			if seen[name+labels] {
+				continue
+			}
+			seen[name+labels] = true
+
+			samples[name] = append(samples[name], sample{labels: labels, value: value})
+		}
+	}
+
+	names := make([]string, 0, len(samples))
+	for name := range samples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buffer bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buffer, "# TYPE %s untyped\n", name)
+		for _, s := range samples[name] {
+			fmt.Fprintf(&buffer, "%s%s %s\n", name, s.labels, s.value)
+		}
+	}
+	return buffer.Bytes()
+}
+
+func metricName(parts ...string) string {
+	var names []string
+	for _, part := range parts {
+		if part != "" {
+			names = append(names, snakeCase(part))
+		}
+	}
+	return strings.Join(names, "_")
+}
+
+// snakeCase turns names like numberOfHTTPRequests into
+// number_of_http_requests and replaces characters that are not allowed in
+// Prometheus names with underscores.
+func snakeCase(name string) string {
+	runes := []rune(name)
+
+	var buffer bytes.Buffer
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			previous := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(previous) || unicode.IsDigit(previous) || (unicode.IsUpper(previous) && nextIsLower) {
+				buffer.WriteRune('_')
+			}
+		}
+
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			buffer.WriteRune(r)
+		case r >= 'A' && r <= 'Z':
+			buffer.WriteRune(unicode.ToLower(r))
+		default:
+			buffer.WriteRune('_')
+		}
+	}
+
+	result := buffer.String()
+	if len(result) > 0 && result[0] >= '0' && result[0] <= '9' {
+		result = "_" + result
+	}
+	return result
+}
+
+func formatLabels(tags map[string]interface{}) string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	labels := make([]string, 0, len(keys))
+	for _, key := range keys {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, snakeCase(key), escapeLabelValue(fmt.Sprint(tags[key]))))
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func formatValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case int:
+		return strconv.FormatInt(int64(v), 10), true
+	case int32:
+		return strconv.FormatInt(int64(v), 10), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), true
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), true
+	case uint64:
+		return strconv.FormatUint(v, 10), true
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 64), true
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), true
+	case bool:
+		if v {
+			return "1", true
+		}
+		return "0", true
+	}
+	return "", false
+}
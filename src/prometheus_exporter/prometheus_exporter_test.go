@@ -0,0 +1,125 @@
+package prometheus_exporter_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"prometheus_exporter"
+
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeInstrumentable struct {
+	context instrumentation.Context
+}
+
+func (f *fakeInstrumentable) Emit() instrumentation.Context {
+	return f.context
+}
+
+var _ = Describe("Exporter", func() {
+	var server *httptest.Server
+
+	serve := func(instrumentables ...instrumentation.Instrumentable) {
+		exporter := prometheus_exporter.New("doppler", instrumentables)
+		server = httptest.NewServer(exporter)
+	}
+
+	scrape := func() (*http.Response, string) {
+		response, err := http.Get(server.URL + "/metrics")
+		Expect(err).ToNot(HaveOccurred())
+		defer response.Body.Close()
+
+		body, err := ioutil.ReadAll(response.Body)
+		Expect(err).ToNot(HaveOccurred())
+		return response, string(body)
+	}
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("renders the metrics in the Prometheus text format", func() {
+		serve(&fakeInstrumentable{instrumentation.Context{
+			Name: "messageRouter",
+			Metrics: []instrumentation.Metric{
+				{Name: "numberOfDumpSinks", Value: 3},
+				{Name: "totalDroppedMessages", Value: int64(7)},
+			},
+		}})
+
+		response, body := scrape()
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+		Expect(response.Header.Get("Content-Type")).To(Equal("text/plain; version=0.0.4"))
+		Expect(body).To(Equal(
+			"# TYPE doppler_message_router_number_of_dump_sinks untyped\n" +
+				"doppler_message_router_number_of_dump_sinks 3\n" +
+				"# TYPE doppler_message_router_total_dropped_messages untyped\n" +
+				"doppler_message_router_total_dropped_messages 7\n"))
+	})
+
+	It("renders tags as labels", func() {
+		serve(&fakeInstrumentable{instrumentation.Context{
+			Name: "rateLimiter",
+			Metrics: []instrumentation.Metric{
+				{Name: "rateLimitedMessages", Value: uint64(2), Tags: map[string]interface{}{"appId": "app-1"}},
+				{Name: "rateLimitedMessages", Value: uint64(5), Tags: map[string]interface{}{"appId": `app "2"`}},
+			},
+		}})
+
+		_, body := scrape()
+		Expect(body).To(Equal(
+			"# TYPE doppler_rate_limiter_rate_limited_messages untyped\n" +
+				"doppler_rate_limiter_rate_limited_messages{app_id=\"app-1\"} 2\n" +
+				"doppler_rate_limiter_rate_limited_messages{app_id=\"app \\\"2\\\"\"} 5\n"))
+	})
+
+	It("renders the contexts of every instrumentable", func() {
+		serve(
+			&fakeInstrumentable{instrumentation.Context{Name: "agentListener", Metrics: []instrumentation.Metric{{Name: "receivedMessageCount", Value: uint64(1)}}}},
+			&fakeInstrumentable{instrumentation.Context{Name: "signatureVerifier", Metrics: []instrumentation.Metric{{Name: "invalidSignatureErrors", Value: 0.5}}}},
+		)
+
+		_, body := scrape()
+		Expect(body).To(ContainSubstring("doppler_agent_listener_received_message_count 1\n"))
+		Expect(body).To(ContainSubstring("doppler_signature_verifier_invalid_signature_errors 0.5\n"))
+	})
+
+	It("converts acronyms and invalid characters in names", func() {
+		serve(&fakeInstrumentable{instrumentation.Context{
+			Name:    "HTTPServer",
+			Metrics: []instrumentation.Metric{{Name: "job.requestCount", Value: 1}},
+		}})
+
+		_, body := scrape()
+		Expect(body).To(ContainSubstring("doppler_http_server_job_request_count 1\n"))
+	})
+
+	It("leaves out metrics that are not numbers and duplicate series", func() {
+		serve(
+			&fakeInstrumentable{instrumentation.Context{Name: "messageRouter", Metrics: []instrumentation.Metric{
+				{Name: "version", Value: "1.2.3"},
+				{Name: "numberOfDumpSinks", Value: 1},
+			}}},
+			&fakeInstrumentable{instrumentation.Context{Name: "messageRouter", Metrics: []instrumentation.Metric{
+				{Name: "numberOfDumpSinks", Value: 2},
+			}}},
+		)
+
+		_, body := scrape()
+		Expect(body).To(Equal(
+			"# TYPE doppler_message_router_number_of_dump_sinks untyped\n" +
+				"doppler_message_router_number_of_dump_sinks 1\n"))
+	})
+
+	It("only allows GET and HEAD requests", func() {
+		serve()
+
+		response, err := http.Post(server.URL+"/metrics", "text/plain", nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusMethodNotAllowed))
+	})
+})
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syslog_drain_binder/shared_types"
+	"time"
+
+	"github.com/cloudfoundry/gosteno"
+)
+
+const tokenExpiryMargin = 30 * time.Second
+
+var nameParameters = []string{"drain-org", "drain-space", "drain-app"}
+
+type AppNames struct {
+	Org   string
+	Space string
+	App   string
+}
+
+type cachedNames struct {
+	names   AppNames
+	expires time.Time
+}
+
+// AppNameResolver looks up the org, space and app names of apps in the Cloud
+// Controller, as a UAA client allowed to read all apps. The names are cached
+// for the given ttl, renamed apps show their new name once it expired.
+type AppNameResolver struct {
+	ccAddress    string
+	uaaAddress   string
+	clientId     string
+	clientSecret string
+	ttl          time.Duration
+	client       *http.Client
+	logger       *gosteno.Logger
+	now          func() time.Time
+
+	sync.Mutex
+	token        string
+	tokenExpires time.Time
+	names        map[shared_types.AppId]cachedNames
+}
+
+func NewAppNameResolver(ccAddress, uaaAddress, clientId, clientSecret string, ttl time.Duration, skipCertVerify bool, logger *gosteno.Logger) *AppNameResolver {
+	tr := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: skipCertVerify},
+	}
+
+	return &AppNameResolver{
+		ccAddress:    ccAddress,
+		uaaAddress:   uaaAddress,
+		clientId:     clientId,
+		clientSecret: clientSecret,
+		ttl:          ttl,
+		client:       &http.Client{Transport: tr, Timeout: 10 * time.Second},
+		logger:       logger,
+		now:          time.Now,
+		names:        make(map[shared_types.AppId]cachedNames),
+	}
+}
+
+// NameDrains replaces the drain-org, drain-space and drain-app parameters of
+// the drain urls with the names the Cloud Controller knows the app by. The
+// names are only added to drains that use them for their hostname or
+// structured data; names given by whoever bound the drain are always removed,
+// they could name any org, space or app.
+func (r *AppNameResolver) NameDrains(drainUrls map[shared_types.AppId][]shared_types.DrainURL) map[shared_types.AppId][]shared_types.DrainURL {
+	r.forgetExpired()
+
+	named := make(map[shared_types.AppId][]shared_types.DrainURL, len(drainUrls))
+	for appId, urls := range drainUrls {
+		var names *AppNames
+		if r.clientSecret != "" && anyUsesNames(urls) {
+			appNames, err := r.Lookup(appId)
+			if err != nil {
+				r.logger.Warnf("Leaving out the names of app %s: %s", appId, err.Error())
+			} else {
+				names = &appNames
+			}
+		}
+
+		namedUrls := make([]shared_types.DrainURL, 0, len(urls))
+		for _, drainUrl := range urls {
+			namedUrls = append(namedUrls, withAppNames(drainUrl, names))
+		}
+		named[appId] = namedUrls
+	}
+
+	return named
+}
+
+func (r *AppNameResolver) Lookup(appId shared_types.AppId) (AppNames, error) {
+	r.Lock()
+	cached, ok := r.names[appId]
+	r.Unlock()
+	if ok {
+		return cached.names, nil
+	}
+
+	names, err := r.fetchNames(appId)
+	if err != nil {
+		return AppNames{}, err
+	}
+
+	r.Lock()
+	r.names[appId] = cachedNames{names: names, expires: r.now().Add(r.ttl)}
+	r.Unlock()
+
+	return names, nil
+}
+
+func (r *AppNameResolver) forgetExpired() {
+	r.Lock()
+	defer r.Unlock()
+
+	now := r.now()
+	for appId, cached := range r.names {
+		if !now.Before(cached.expires) {
+			delete(r.names, appId)
+		}
+	}
+}
+
+func (r *AppNameResolver) fetchNames(appId shared_types.AppId) (AppNames, error) {
+	token, err := r.accessToken()
+	if err != nil {
+		return AppNames{}, err
+	}
+
+	request, _ := http.NewRequest("GET", fmt.Sprintf("%s/v2/apps/%s?inline-relations-depth=2", r.ccAddress, url.QueryEscape(string(appId))), nil)
+	request.Header.Set("Authorization", "bearer "+token)
+
+	response, err := r.client.Do(request)
+	if err != nil {
+		return AppNames{}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusUnauthorized {
+		r.forgetToken()
+	}
+	if response.StatusCode != http.StatusOK {
+		return AppNames{}, errors.New(fmt.Sprintf("Remote server error: %s", http.StatusText(response.StatusCode)))
+	}
+
+	var app appResource
+	err = json.NewDecoder(response.Body).Decode(&app)
+	if err != nil {
+		return AppNames{}, err
+	}
+
+	names := AppNames{
+		Org:   app.Entity.Space.Entity.Organization.Entity.Name,
+		Space: app.Entity.Space.Entity.Name,
+		App:   app.Entity.Name,
+	}
+	if names.Org == "" || names.Space == "" || names.App == "" {
+		return AppNames{}, errors.New("Cloud Controller did not return the org, space and app names")
+	}
+
+	return names, nil
+}
+
+func (r *AppNameResolver) accessToken() (string, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.token != "" && r.now().Before(r.tokenExpires) {
+		return r.token, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	request, _ := http.NewRequest("POST", r.uaaAddress+"/oauth/token", strings.NewReader(form.Encode()))
+	request.SetBasicAuth(r.clientId, r.clientSecret)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+
+	response, err := r.client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", errors.New(fmt.Sprintf("Could not get a token from UAA: %s", http.StatusText(response.StatusCode)))
+	}
+
+	var token tokenResponse
+	err = json.NewDecoder(response.Body).Decode(&token)
+	if err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("UAA did not return an access token")
+	}
+
+	r.token = token.AccessToken
+	r.tokenExpires = r.now().Add(time.Duration(token.ExpiresIn)*time.Second - tokenExpiryMargin)
+	return r.token, nil
+}
+
+func (r *AppNameResolver) forgetToken() {
+	r.Lock()
+	defer r.Unlock()
+	r.token = ""
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+type namedEntity struct {
+	Name string `json:"name"`
+}
+
+type appResource struct {
+	Entity struct {
+		Name  string `json:"name"`
+		Space struct {
+			Entity struct {
+				Name         string `json:"name"`
+				Organization struct {
+					Entity namedEntity `json:"entity"`
+				} `json:"organization"`
+			} `json:"entity"`
+		} `json:"space"`
+	} `json:"entity"`
+}
+
+func anyUsesNames(urls []shared_types.DrainURL) bool {
+	for _, drainUrl := range urls {
+		for _, parameter := range queryParameters(drainUrl) {
+			key := parameterKey(parameter)
+			if key == "drain-hostname" || key == "drain-structured-data" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withAppNames edits the raw query of the drain url, so that the parameters
+// meant for the drain itself keep their order and encoding. Like url.ParseQuery
+// it takes a semicolon to separate parameters too.
+func withAppNames(drainUrl shared_types.DrainURL, names *AppNames) shared_types.DrainURL {
+	base, fragment := splitOnce(string(drainUrl), "#")
+	base, _ = splitOnce(base, "?")
+
+	var kept []string
+	if names != nil {
+		kept = append(kept,
+			"drain-org="+url.QueryEscape(names.Org),
+			"drain-space="+url.QueryEscape(names.Space),
+			"drain-app="+url.QueryEscape(names.App),
+		)
+	}
+
+	for _, parameter := range queryParameters(drainUrl) {
+		if !isNameParameter(parameterKey(parameter)) {
+			kept = append(kept, parameter)
+		}
+	}
+
+	named := base
+	if len(kept) > 0 {
+		named += "?" + strings.Join(kept, "&")
+	}
+	if strings.Contains(string(drainUrl), "#") {
+		named += "#" + fragment
+	}
+	return shared_types.DrainURL(named)
+}
+
+func queryParameters(drainUrl shared_types.DrainURL) []string {
+	withoutFragment, _ := splitOnce(string(drainUrl), "#")
+	_, query := splitOnce(withoutFragment, "?")
+
+	return strings.FieldsFunc(query, func(r rune) bool {
+		return r == '&' || r == ';'
+	})
+}
+
+func parameterKey(parameter string) string {
+	key, _ := splitOnce(parameter, "=")
+	unescaped, err := url.QueryUnescape(key)
+	if err != nil {
+		return key
+	}
+	return unescaped
+}
+
+func isNameParameter(key string) bool {
+	for _, name := range nameParameters {
+		if key == name {
+			return true
+		}
+	}
+	return false
+}
+
+func splitOnce(s, separator string) (string, string) {
+	parts := strings.SplitN(s, separator, 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
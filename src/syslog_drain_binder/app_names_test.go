@@ -0,0 +1,158 @@
+package main_test
+
+import (
+	syslog_drain_binder "syslog_drain_binder"
+
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"syslog_drain_binder/shared_types"
+	"time"
+
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AppNameResolver", func() {
+	var (
+		fake       *fakeCCWithUAA
+		testServer *httptest.Server
+		resolver   *syslog_drain_binder.AppNameResolver
+	)
+
+	BeforeEach(func() {
+		fake = &fakeCCWithUAA{}
+		testServer = httptest.NewServer(fake)
+		resolver = syslog_drain_binder.NewAppNameResolver(testServer.URL, testServer.URL, "binder", "secret", time.Minute, false, loggertesthelper.Logger())
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	It("replaces the names given with the drain by the ones of the Cloud Controller", func() {
+		drains := map[shared_types.AppId][]shared_types.DrainURL{
+			"app-guid": {"syslog://example.com:514?drain-hostname=org.space.app&drain-org=other&drain-app=victim"},
+		}
+
+		named := resolver.NameDrains(drains)
+		Expect(named).To(HaveKeyWithValue(shared_types.AppId("app-guid"), []shared_types.DrainURL{
+			"syslog://example.com:514?drain-org=my+org&drain-space=my-space&drain-app=my-app&drain-hostname=org.space.app",
+		}))
+		Expect(fake.tokenAuthorization()).To(Equal("binder:secret"))
+		Expect(fake.appRequests()).To(Equal([]string{"/v2/apps/app-guid"}))
+	})
+
+	It("removes names separated by a semicolon too", func() {
+		drains := map[shared_types.AppId][]shared_types.DrainURL{
+			"app-guid": {"syslog://example.com:514?drain-structured-data=true;drain-org=other"},
+		}
+
+		named := resolver.NameDrains(drains)
+		Expect(named["app-guid"]).To(Equal([]shared_types.DrainURL{
+			"syslog://example.com:514?drain-org=my+org&drain-space=my-space&drain-app=my-app&drain-structured-data=true",
+		}))
+	})
+
+	It("does not look up apps whose drains do not use the names", func() {
+		drains := map[shared_types.AppId][]shared_types.DrainURL{
+			"app-guid": {"syslog://example.com:514?drain-org=other", "https://example.com/drain?token=abc"},
+		}
+
+		named := resolver.NameDrains(drains)
+		Expect(named["app-guid"]).To(Equal([]shared_types.DrainURL{
+			"syslog://example.com:514",
+			"https://example.com/drain?token=abc",
+		}))
+		Expect(fake.appRequests()).To(BeEmpty())
+	})
+
+	It("caches the names of an app", func() {
+		drains := map[shared_types.AppId][]shared_types.DrainURL{
+			"app-guid": {"syslog://example.com:514?drain-hostname=app"},
+		}
+
+		resolver.NameDrains(drains)
+		resolver.NameDrains(drains)
+		Expect(fake.appRequests()).To(HaveLen(1))
+		Expect(fake.tokenRequestCount()).To(Equal(1))
+	})
+
+	It("leaves the names out when the Cloud Controller cannot tell them", func() {
+		fake.failApps = true
+		drains := map[shared_types.AppId][]shared_types.DrainURL{
+			"app-guid": {"syslog://example.com:514?drain-hostname=app&drain-app=victim"},
+		}
+
+		named := resolver.NameDrains(drains)
+		Expect(named["app-guid"]).To(Equal([]shared_types.DrainURL{"syslog://example.com:514?drain-hostname=app"}))
+	})
+
+	It("only removes the names when it has no client secret", func() {
+		resolver = syslog_drain_binder.NewAppNameResolver(testServer.URL, testServer.URL, "binder", "", time.Minute, false, loggertesthelper.Logger())
+		drains := map[shared_types.AppId][]shared_types.DrainURL{
+			"app-guid": {"syslog://example.com:514?drain-hostname=app&drain-app=victim"},
+		}
+
+		named := resolver.NameDrains(drains)
+		Expect(named["app-guid"]).To(Equal([]shared_types.DrainURL{"syslog://example.com:514?drain-hostname=app"}))
+		Expect(fake.tokenRequestCount()).To(Equal(0))
+	})
+})
+
+type fakeCCWithUAA struct {
+	failApps bool
+
+	sync.Mutex
+	tokenRequests int
+	tokenAuth     string
+	apps          []string
+}
+
+func (fake *fakeCCWithUAA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fake.Lock()
+	defer fake.Unlock()
+
+	if r.URL.Path == "/oauth/token" {
+		fake.tokenRequests++
+		username, password, _ := r.BasicAuth()
+		fake.tokenAuth = username + ":" + password
+		w.Write([]byte(`{"access_token":"the-token","expires_in":3600}`))
+		return
+	}
+
+	if r.Header.Get("Authorization") != "bearer the-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	fake.apps = append(fake.apps, r.URL.Path)
+	if fake.failApps || !strings.HasPrefix(r.URL.Path, "/v2/apps/") {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprint(w, `{"entity":{"name":"my-app","space":{"entity":{"name":"my-space","organization":{"entity":{"name":"my org"}}}}}}`)
+}
+
+func (fake *fakeCCWithUAA) tokenRequestCount() int {
+	fake.Lock()
+	defer fake.Unlock()
+	return fake.tokenRequests
+}
+
+func (fake *fakeCCWithUAA) tokenAuthorization() string {
+	fake.Lock()
+	defer fake.Unlock()
+	return fake.tokenAuth
+}
+
+func (fake *fakeCCWithUAA) appRequests() []string {
+	fake.Lock()
+	defer fake.Unlock()
+	return append([]string(nil), fake.apps...)
+}
@@ -3,6 +3,9 @@ package main
 import (
 	"errors"
 	"flag"
+	"net"
+	"prometheus_exporter"
+	"strconv"
 	"syslog_drain_binder/elector"
 	"syslog_drain_binder/etcd_syslog_drain_store"
 	"time"
@@ -11,6 +14,7 @@ import (
 	"github.com/cloudfoundry/dropsonde/metrics"
 	"github.com/cloudfoundry/gunk/workpool"
 	"github.com/cloudfoundry/loggregatorlib/cfcomponent"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
 	"github.com/cloudfoundry/storeadapter"
 	"github.com/cloudfoundry/storeadapter/etcdstoreadapter"
 )
@@ -37,6 +41,15 @@ func main() {
 	drainTTL := time.Duration(config.DrainUrlTtlSeconds) * time.Second
 	store := etcd_syslog_drain_store.NewEtcdSyslogDrainStore(adapter, drainTTL, logger)
 
+	appNamesTTL := time.Duration(config.AppNamesTtlSeconds) * time.Second
+	resolver := NewAppNameResolver(config.CloudControllerAddress, config.UaaAddress, config.UaaClientId, config.UaaClientSecret, appNamesTTL, config.SkipCertVerify, logger)
+
+	binderMetrics := &pollMetrics{}
+	if config.PrometheusMetricsPort != 0 {
+		exporter := prometheus_exporter.New("syslog_drain_binder", []instrumentation.Instrumentable{binderMetrics})
+		go exporter.ListenAndServe(net.JoinHostPort(config.PrometheusMetricsHost, strconv.Itoa(int(config.PrometheusMetricsPort))), logger)
+	}
+
 	var err error
 	ticker := time.NewTicker(updateInterval)
 	for {
@@ -65,6 +78,7 @@ func main() {
 			drainUrls, err := Poll(config.CloudControllerAddress, config.BulkApiUsername, config.BulkApiPassword, config.PollingBatchSize, config.SkipCertVerify)
 			if err != nil {
 				logger.Errorf("Error when polling cloud controller: %s", err.Error())
+				binderMetrics.failed()
 				politician.Vacate()
 				continue
 			}
@@ -77,9 +91,10 @@ func main() {
 			}
 
 			metrics.SendValue("totalDrains", float64(totalDrains), "drains")
+			binderMetrics.polled(totalDrains)
 
 			logger.Debugf("Updating drain URLs for %d application(s)", len(drainUrls))
-			err = store.UpdateDrains(drainUrls)
+			err = store.UpdateDrains(resolver.NameDrains(drainUrls))
 			if err != nil {
 				logger.Errorf("Error when updating ETCD: %s", err.Error())
 				politician.Vacate()
@@ -104,8 +119,16 @@ type Config struct {
 	BulkApiPassword        string
 	PollingBatchSize       int
 
+	UaaAddress         string
+	UaaClientId        string
+	UaaClientSecret    string
+	AppNamesTtlSeconds int64
+
 	SkipCertVerify bool
 
+	PrometheusMetricsPort uint32
+	PrometheusMetricsHost string
+
 	cfcomponent.Config
 }
 
@@ -122,6 +145,14 @@ func parseConfig(configFile string) Config {
 		panic(err)
 	}
 
+	if config.AppNamesTtlSeconds == 0 {
+		config.AppNamesTtlSeconds = 300
+	}
+
+	if config.PrometheusMetricsHost == "" {
+		config.PrometheusMetricsHost = prometheus_exporter.DefaultHost
+	}
+
 	return config
 }
 
@@ -136,5 +167,9 @@ func (config Config) validate() error {
 		return errors.New("Need Metron address (host:port).")
 	}
 
+	if config.AppNamesTtlSeconds < 0 {
+		return errors.New("AppNamesTtlSeconds must not be negative.")
+	}
+
 	return nil
 }
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+)
+
+// pollMetrics mirrors the metrics sent to metron for the Prometheus endpoint.
+type pollMetrics struct {
+	pollCount   uint64
+	pollErrors  uint64
+	totalDrains int64
+}
+
+func (m *pollMetrics) polled(totalDrains int) {
+	atomic.AddUint64(&m.pollCount, 1)
+	atomic.StoreInt64(&m.totalDrains, int64(totalDrains))
+}
+
+func (m *pollMetrics) failed() {
+	atomic.AddUint64(&m.pollErrors, 1)
+}
+
+func (m *pollMetrics) Emit() instrumentation.Context {
+	data := []instrumentation.Metric{
+		instrumentation.Metric{Name: "pollCount", Value: atomic.LoadUint64(&m.pollCount)},
+		instrumentation.Metric{Name: "pollErrors", Value: atomic.LoadUint64(&m.pollErrors)},
+		instrumentation.Metric{Name: "totalDrains", Value: atomic.LoadInt64(&m.totalDrains)},
+	}
+
+	return instrumentation.Context{
+		Name:    "syslogDrainBinder",
+		Metrics: data,
+	}
+}
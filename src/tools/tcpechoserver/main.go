@@ -2,6 +2,7 @@ package main
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"io"
@@ -12,14 +13,24 @@ import (
 )
 
 var useSSL = flag.Bool("ssl", false, "Use SSL")
+var useUDP = flag.Bool("udp", false, "Listen for UDP datagrams instead of TCP connections")
 var certFile = flag.String("cert", "", "TLS certificate")
 var keyFile = flag.String("key", "", "TLS private key")
+var clientCAFile = flag.String("client-ca", "", "Require TLS client certificates signed by this CA")
 
 var address = flag.String("address", "", "Listener address")
 
 func main() {
 	flag.Parse()
 
+	if *useUDP {
+		go listenUDP(udpListener(*address))
+
+		log.Printf("Startup: udp echo server listening")
+		blocker := make(chan bool)
+		<-blocker
+	}
+
 	var listener net.Listener
 	if *useSSL {
 		cert, err := ioutil.ReadFile(*certFile)
@@ -32,7 +43,15 @@ func main() {
 			panic(err)
 		}
 
-		listener = secureListener(*address, cert, key)
+		var clientCA []byte
+		if *clientCAFile != "" {
+			clientCA, err = ioutil.ReadFile(*clientCAFile)
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		listener = secureListener(*address, cert, key, clientCA)
 	} else {
 		listener = insecureListener(*address)
 	}
@@ -53,7 +72,16 @@ func insecureListener(address string) net.Listener {
 	return listener
 }
 
-func secureListener(address string, certContent []byte, keyContent []byte) net.Listener {
+func udpListener(address string) net.PacketConn {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		panic(err)
+	}
+
+	return conn
+}
+
+func secureListener(address string, certContent []byte, keyContent []byte, clientCAContent []byte) net.Listener {
 	cert, err := tls.X509KeyPair(certContent, keyContent)
 	if err != nil {
 		panic(err)
@@ -64,6 +92,12 @@ func secureListener(address string, certContent []byte, keyContent []byte) net.L
 		SessionTicketsDisabled: true,
 	}
 
+	if clientCAContent != nil {
+		config.ClientCAs = x509.NewCertPool()
+		config.ClientCAs.AppendCertsFromPEM(clientCAContent)
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
 	listener, err := tls.Listen("tcp", address, config)
 	if err != nil {
 		panic(err)
@@ -98,3 +132,15 @@ func listen(listener net.Listener) {
 		}()
 	}
 }
+
+func listenUDP(conn net.PacketConn) {
+	buffer := make([]byte, 65536)
+	for {
+		readCount, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+
+		fmt.Printf("%s\n", buffer[:readCount])
+	}
+}
@@ -31,6 +31,9 @@ func NewAdminAccessAuthorizer(disableAccessControl bool, client uaa_client.UaaCl
 
 		if err != nil {
 			logger.Errorf("Error getting auth data: %s", err.Error())
+			if _, ok := err.(uaa_client.UnavailableError); ok {
+				return false, undecidedError{errors.New(INVALID_AUTH_TOKEN_ERROR_MESSAGE)}
+			}
 			return false, errors.New(INVALID_AUTH_TOKEN_ERROR_MESSAGE)
 		}
 
@@ -0,0 +1,178 @@
+package authorization
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+)
+
+// AuthorizationCache remembers authorization decisions per token and target,
+// so clients that reconnect don't cost a request to the Cloud Controller or
+// UAA every time. Denials are remembered for a shorter time than grants,
+// lookups that failed without a decision, e.g. because the Cloud Controller
+// could not be reached, are not remembered at all. Concurrent lookups for the
+// same token and target share one request. Tokens are only kept as hashes.
+// The least recently used decisions are evicted once maxEntries are cached.
+type AuthorizationCache struct {
+	name        string
+	maxEntries  int
+	ttl         time.Duration
+	negativeTtl time.Duration
+
+	lock    sync.Mutex
+	entries map[cacheKey]*list.Element
+	lru     *list.List
+	pending map[cacheKey]*pendingLookup
+
+	hitCount             uint64
+	missCount            uint64
+	coalescedLookupCount uint64
+	evictionCount        uint64
+}
+
+type cacheKey struct {
+	tokenHash [sha256.Size]byte
+	target    string
+}
+
+type cacheEntry struct {
+	key        cacheKey
+	authorized bool
+	err        error
+	expires    time.Time
+}
+
+type pendingLookup struct {
+	done       chan struct{}
+	authorized bool
+	err        error
+}
+
+func NewAuthorizationCache(name string, maxEntries int, ttl time.Duration, negativeTtl time.Duration) *AuthorizationCache {
+	return &AuthorizationCache{
+		name:        name,
+		maxEntries:  maxEntries,
+		ttl:         ttl,
+		negativeTtl: negativeTtl,
+		entries:     make(map[cacheKey]*list.Element),
+		lru:         list.New(),
+		pending:     make(map[cacheKey]*pendingLookup),
+	}
+}
+
+func NewCachingLogAccessAuthorizer(authorizer LogAccessAuthorizer, cache *AuthorizationCache) LogAccessAuthorizer {
+	return func(authToken string, target string, logger *gosteno.Logger) (bool, error) {
+		if authToken == "" {
+			return authorizer(authToken, target, logger)
+		}
+
+		return cache.Lookup(authToken, target, func() (bool, error) {
+			return authorizer(authToken, target, logger)
+		})
+	}
+}
+
+func NewCachingAdminAccessAuthorizer(authorizer AdminAccessAuthorizer, cache *AuthorizationCache) AdminAccessAuthorizer {
+	return func(authToken string, logger *gosteno.Logger) (bool, error) {
+		if authToken == "" {
+			return authorizer(authToken, logger)
+		}
+
+		return cache.Lookup(authToken, "", func() (bool, error) {
+			return authorizer(authToken, logger)
+		})
+	}
+}
+
+// Lookup returns the cached decision for the token and target, calling
+// authorize when there is none.
+func (c *AuthorizationCache) Lookup(authToken string, target string, authorize func() (bool, error)) (bool, error) {
+	key := cacheKey{tokenHash: sha256.Sum256([]byte(authToken)), target: target}
+
+	c.lock.Lock()
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		if time.Now().Before(entry.expires) {
+			c.lru.MoveToFront(element)
+			c.hitCount++
+			c.lock.Unlock()
+			return entry.authorized, entry.err
+		}
+		c.remove(element)
+	}
+
+	if lookup, ok := c.pending[key]; ok {
+		c.coalescedLookupCount++
+		c.lock.Unlock()
+
+		<-lookup.done
+		return lookup.authorized, lookup.err
+	}
+
+	c.missCount++
+	lookup := &pendingLookup{done: make(chan struct{})}
+	c.pending[key] = lookup
+	c.lock.Unlock()
+
+	lookup.authorized, lookup.err = authorize()
+
+	c.lock.Lock()
+	delete(c.pending, key)
+	c.add(key, lookup.authorized, lookup.err)
+	c.lock.Unlock()
+
+	close(lookup.done)
+	return lookup.authorized, lookup.err
+}
+
+func (c *AuthorizationCache) Emit() instrumentation.Context {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return instrumentation.Context{
+		Name: c.name,
+		Metrics: []instrumentation.Metric{
+			instrumentation.Metric{Name: "hitCount", Value: c.hitCount},
+			instrumentation.Metric{Name: "missCount", Value: c.missCount},
+			instrumentation.Metric{Name: "coalescedLookupCount", Value: c.coalescedLookupCount},
+			instrumentation.Metric{Name: "evictionCount", Value: c.evictionCount},
+			instrumentation.Metric{Name: "entryCount", Value: c.lru.Len()},
+		},
+	}
+}
+
+func (c *AuthorizationCache) add(key cacheKey, authorized bool, err error) {
+	if _, ok := err.(undecidedError); ok {
+		return
+	}
+
+	ttl := c.ttl
+	if !authorized {
+		ttl = c.negativeTtl
+	}
+	if ttl <= 0 || c.maxEntries <= 0 {
+		return
+	}
+
+	for c.lru.Len() >= c.maxEntries {
+		c.remove(c.lru.Back())
+		c.evictionCount++
+	}
+
+	entry := &cacheEntry{
+		key:        key,
+		authorized: authorized,
+		err:        err,
+		expires:    time.Now().Add(ttl),
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+}
+
+func (c *AuthorizationCache) remove(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
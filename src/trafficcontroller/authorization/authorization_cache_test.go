@@ -0,0 +1,191 @@
+package authorization_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+	"trafficcontroller/authorization"
+	"trafficcontroller/uaa_client"
+
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("AuthorizationCache", func() {
+	var (
+		cache   *authorization.AuthorizationCache
+		server  *httptest.Server
+		ccCalls int64
+		delay   time.Duration
+	)
+
+	BeforeEach(func() {
+		cache = authorization.NewAuthorizationCache("logAccessAuthorizationCache", 2, time.Minute, 100*time.Millisecond)
+
+		ccCalls = 0
+		delay = 0
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&ccCalls, 1)
+			time.Sleep(delay)
+			new(handler).ServeHTTP(w, r)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Context("caching log access decisions", func() {
+		var authorizer authorization.LogAccessAuthorizer
+
+		BeforeEach(func() {
+			authorizer = authorization.NewCachingLogAccessAuthorizer(authorization.NewLogAccessAuthorizer(false, server.URL, true), cache)
+		})
+
+		It("asks the Cloud Controller once per token and app", func() {
+			for i := 0; i < 5; i++ {
+				authorized, err := authorizer("bearer something", "myAppId", loggertesthelper.Logger())
+				Expect(authorized).To(BeTrue())
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(atomic.LoadInt64(&ccCalls)).To(BeEquivalentTo(1))
+
+			authorizer("bearer other", "myAppId", loggertesthelper.Logger())
+			Expect(atomic.LoadInt64(&ccCalls)).To(BeEquivalentTo(2))
+		})
+
+		It("remembers denials for a short time only", func() {
+			for i := 0; i < 3; i++ {
+				authorized, err := authorizer("bearer something", "notMyAppId", loggertesthelper.Logger())
+				Expect(authorized).To(BeFalse())
+				Expect(err).To(Equal(errors.New(authorization.INVALID_AUTH_TOKEN_ERROR_MESSAGE)))
+			}
+			Expect(atomic.LoadInt64(&ccCalls)).To(BeEquivalentTo(1))
+
+			time.Sleep(150 * time.Millisecond)
+			authorizer("bearer something", "notMyAppId", loggertesthelper.Logger())
+			Expect(atomic.LoadInt64(&ccCalls)).To(BeEquivalentTo(2))
+		})
+
+		It("does not remember lookups the Cloud Controller failed to answer", func() {
+			for i := 0; i < 3; i++ {
+				authorized, err := authorizer("bearer something", "broken/app", loggertesthelper.Logger())
+				Expect(authorized).To(BeFalse())
+				Expect(err).To(MatchError(authorization.INVALID_AUTH_TOKEN_ERROR_MESSAGE))
+			}
+
+			Expect(atomic.LoadInt64(&ccCalls)).To(BeEquivalentTo(3))
+			Expect(metricValue(cache, "entryCount")).To(BeEquivalentTo(0))
+		})
+
+		It("shares one request between concurrent lookups", func() {
+			delay = 100 * time.Millisecond
+
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					authorized, _ := authorizer("bearer something", "myAppId", loggertesthelper.Logger())
+					Expect(authorized).To(BeTrue())
+				}()
+			}
+			wg.Wait()
+
+			Expect(atomic.LoadInt64(&ccCalls)).To(BeEquivalentTo(1))
+		})
+
+		It("does not cache requests without a token", func() {
+			_, err := authorizer("", "myAppId", loggertesthelper.Logger())
+			Expect(err).To(Equal(errors.New(authorization.NO_AUTH_TOKEN_PROVIDED_ERROR_MESSAGE)))
+			Expect(metricValue(cache, "missCount")).To(BeEquivalentTo(0))
+		})
+
+		It("evicts the least recently used decisions", func() {
+			authorizer("bearer first", "myAppId", loggertesthelper.Logger())
+			authorizer("bearer second", "myAppId", loggertesthelper.Logger())
+			authorizer("bearer first", "myAppId", loggertesthelper.Logger())
+			authorizer("bearer third", "myAppId", loggertesthelper.Logger())
+			Expect(atomic.LoadInt64(&ccCalls)).To(BeEquivalentTo(3))
+
+			authorizer("bearer first", "myAppId", loggertesthelper.Logger())
+			Expect(atomic.LoadInt64(&ccCalls)).To(BeEquivalentTo(3))
+
+			authorizer("bearer second", "myAppId", loggertesthelper.Logger())
+			Expect(atomic.LoadInt64(&ccCalls)).To(BeEquivalentTo(4))
+		})
+
+		It("emits hits and misses", func() {
+			authorizer("bearer something", "myAppId", loggertesthelper.Logger())
+			authorizer("bearer something", "myAppId", loggertesthelper.Logger())
+			authorizer("bearer something", "myAppId", loggertesthelper.Logger())
+
+			Expect(cache.Emit().Name).To(Equal("logAccessAuthorizationCache"))
+			Expect(metricValue(cache, "hitCount")).To(BeEquivalentTo(2))
+			Expect(metricValue(cache, "missCount")).To(BeEquivalentTo(1))
+			Expect(metricValue(cache, "entryCount")).To(BeEquivalentTo(1))
+		})
+	})
+
+	Context("caching admin access decisions", func() {
+		It("asks UAA once per token", func() {
+			client := &CountingUaaClient{}
+			authorizer := authorization.NewCachingAdminAccessAuthorizer(authorization.NewAdminAccessAuthorizer(false, client), cache)
+
+			for i := 0; i < 5; i++ {
+				authorized, err := authorizer("bearer my-token", loggertesthelper.Logger())
+				Expect(authorized).To(BeTrue())
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			Expect(client.calls).To(Equal(1))
+		})
+
+		It("does not remember lookups UAA failed to answer", func() {
+			client := &UnavailableUaaClient{}
+			authorizer := authorization.NewCachingAdminAccessAuthorizer(authorization.NewAdminAccessAuthorizer(false, client), cache)
+
+			for i := 0; i < 3; i++ {
+				authorized, err := authorizer("bearer my-token", loggertesthelper.Logger())
+				Expect(authorized).To(BeFalse())
+				Expect(err).To(MatchError(authorization.INVALID_AUTH_TOKEN_ERROR_MESSAGE))
+			}
+
+			Expect(client.calls).To(Equal(3))
+		})
+	})
+})
+
+type CountingUaaClient struct {
+	calls int
+}
+
+func (client *CountingUaaClient) GetAuthData(token string) (*uaa_client.AuthData, error) {
+	client.calls++
+	return &uaa_client.AuthData{Scope: []string{"doppler.firehose"}}, nil
+}
+
+type UnavailableUaaClient struct {
+	calls int
+}
+
+func (client *UnavailableUaaClient) GetAuthData(token string) (*uaa_client.AuthData, error) {
+	client.calls++
+	return nil, uaa_client.UnavailableError{Err: errors.New("connection refused")}
+}
+
+func metricValue(instrumentable instrumentation.Instrumentable, name string) interface{} {
+	for _, metric := range instrumentable.Emit().Metrics {
+		if metric.Name == name {
+			return metric.Value
+		}
+	}
+	return nil
+}
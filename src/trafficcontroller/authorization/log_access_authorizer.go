@@ -12,6 +12,13 @@ const (
 	INVALID_AUTH_TOKEN_ERROR_MESSAGE     = "Error: Invalid authorization"
 )
 
+// undecidedError is returned when the Cloud Controller or UAA did not decide
+// about an access, e.g. because they could not be reached. Unlike denials it
+// is not cached.
+type undecidedError struct {
+	error
+}
+
 type LogAccessAuthorizer func(authToken string, appId string, logger *gosteno.Logger) (bool, error)
 
 func disableLogAccessControlAuthorizer(_, _ string, _ *gosteno.Logger) (bool, error) {
@@ -39,16 +46,21 @@ func NewLogAccessAuthorizer(disableAccessControl bool, apiHost string, skipCertV
 		res, err := client.Do(req)
 		if err != nil {
 			logger.Errorf("Could not get app information: [%s]", err)
-			return false, errors.New(INVALID_AUTH_TOKEN_ERROR_MESSAGE)
+			return false, undecidedError{errors.New(INVALID_AUTH_TOKEN_ERROR_MESSAGE)}
 		}
 
 		defer res.Body.Close()
 
-		if res.StatusCode != 200 {
+		switch res.StatusCode {
+		case http.StatusOK:
+			return true, nil
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
 			logger.Warnf("Non 200 response from CC API: %d", res.StatusCode)
 			return false, errors.New(INVALID_AUTH_TOKEN_ERROR_MESSAGE)
+		default:
+			logger.Warnf("Non 200 response from CC API: %d", res.StatusCode)
+			return false, undecidedError{errors.New(INVALID_AUTH_TOKEN_ERROR_MESSAGE)}
 		}
-		return true, nil
 	}
 
 	return LogAccessAuthorizer(isAccessAllowed)
@@ -110,7 +110,7 @@ var _ = Describe("LogAccessAuthorizer", func() {
 			authorizer := authorization.NewLogAccessAuthorizer(false, server.URL, false)
 			authorized, err := authorizer("bearer something", "myAppId", logger)
 			Expect(authorized).To(Equal(false))
-			Expect(err).To(Equal(errors.New(authorization.INVALID_AUTH_TOKEN_ERROR_MESSAGE)))
+			Expect(err).To(MatchError(authorization.INVALID_AUTH_TOKEN_ERROR_MESSAGE))
 		})
 	})
 
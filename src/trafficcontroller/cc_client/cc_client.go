@@ -0,0 +1,110 @@
+package cc_client
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+const (
+	SpaceGroup = "spaces"
+	OrgGroup   = "orgs"
+)
+
+var NotAuthorizedError = errors.New("Error: Invalid authorization")
+
+// AppLister resolves a space or an org to the apps in it that the owner of
+// the token can see.
+type AppLister interface {
+	ListApps(authToken string, groupType string, guid string) ([]string, error)
+}
+
+type ccClient struct {
+	apiHost    string
+	httpClient *http.Client
+}
+
+func NewCcClient(apiHost string, skipCertVerify bool) AppLister {
+	return &ccClient{
+		apiHost: apiHost,
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: skipCertVerify},
+			},
+		},
+	}
+}
+
+// ListApps asks the Cloud Controller for the space or org first, so users
+// that can't see it are told so, then pages through its apps.
+func (client *ccClient) ListApps(authToken string, groupType string, guid string) ([]string, error) {
+	var groupPath, filter string
+	switch groupType {
+	case SpaceGroup:
+		groupPath, filter = "/v2/spaces/", "space_guid:"
+	case OrgGroup:
+		groupPath, filter = "/v2/organizations/", "organization_guid:"
+	default:
+		return nil, fmt.Errorf("unknown app group %q", groupType)
+	}
+
+	_, err := client.get(authToken, groupPath+url.QueryEscape(guid))
+	if err != nil {
+		return nil, err
+	}
+
+	var appIds []string
+	next := "/v2/apps?" + url.Values{"q": {filter + guid}, "results-per-page": {"100"}}.Encode()
+	for next != "" {
+		body, err := client.get(authToken, next)
+		if err != nil {
+			return nil, err
+		}
+
+		var page appsPage
+		err = json.Unmarshal(body, &page)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, resource := range page.Resources {
+			appIds = append(appIds, resource.Metadata.Guid)
+		}
+		next = page.NextUrl
+	}
+
+	return appIds, nil
+}
+
+func (client *ccClient) get(authToken string, path string) ([]byte, error) {
+	req, _ := http.NewRequest("GET", client.apiHost+path, nil)
+	req.Header.Set("Authorization", authToken)
+
+	res, err := client.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return ioutil.ReadAll(res.Body)
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		return nil, NotAuthorizedError
+	default:
+		return nil, fmt.Errorf("Non 200 response from CC API: %d", res.StatusCode)
+	}
+}
+
+type appsPage struct {
+	NextUrl   string `json:"next_url"`
+	Resources []struct {
+		Metadata struct {
+			Guid string `json:"guid"`
+		} `json:"metadata"`
+	} `json:"resources"`
+}
@@ -0,0 +1,88 @@
+package cc_client_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"trafficcontroller/cc_client"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CcClient", func() {
+	var (
+		server   *httptest.Server
+		requests []string
+	)
+
+	BeforeEach(func() {
+		requests = nil
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.URL.RequestURI())
+			if r.Header.Get("Authorization") != "bearer something" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			switch r.URL.Path {
+			case "/v2/spaces/space-guid", "/v2/organizations/org-guid", "/v2/organizations/broken-org-guid":
+				w.Write([]byte("{}"))
+			case "/v2/apps":
+				switch r.URL.Query().Get("q") {
+				case "space_guid:space-guid":
+					if r.URL.Query().Get("page") == "" {
+						fmt.Fprint(w, `{"next_url":"/v2/apps?page=2&q=space_guid%3Aspace-guid","resources":[{"metadata":{"guid":"app-1"}},{"metadata":{"guid":"app-2"}}]}`)
+					} else {
+						fmt.Fprint(w, `{"next_url":null,"resources":[{"metadata":{"guid":"app-3"}}]}`)
+					}
+				case "organization_guid:org-guid":
+					fmt.Fprint(w, `{"next_url":null,"resources":[{"metadata":{"guid":"app-4"}}]}`)
+				default:
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("lists the apps of a space, following the pages", func() {
+		client := cc_client.NewCcClient(server.URL, true)
+
+		appIds, err := client.ListApps("bearer something", cc_client.SpaceGroup, "space-guid")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(appIds).To(Equal([]string{"app-1", "app-2", "app-3"}))
+		Expect(requests[0]).To(Equal("/v2/spaces/space-guid"))
+	})
+
+	It("lists the apps of an org", func() {
+		client := cc_client.NewCcClient(server.URL, true)
+
+		appIds, err := client.ListApps("bearer something", cc_client.OrgGroup, "org-guid")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(appIds).To(Equal([]string{"app-4"}))
+	})
+
+	It("returns NotAuthorizedError when the space can't be seen", func() {
+		client := cc_client.NewCcClient(server.URL, true)
+
+		_, err := client.ListApps("bearer something", cc_client.SpaceGroup, "other-space-guid")
+		Expect(err).To(Equal(cc_client.NotAuthorizedError))
+
+		_, err = client.ListApps("bearer other", cc_client.SpaceGroup, "space-guid")
+		Expect(err).To(Equal(cc_client.NotAuthorizedError))
+	})
+
+	It("returns other errors of the Cloud Controller", func() {
+		client := cc_client.NewCcClient(server.URL, true)
+
+		_, err := client.ListApps("bearer something", cc_client.OrgGroup, "broken-org-guid")
+		Expect(err).To(HaveOccurred())
+		Expect(err).NotTo(Equal(cc_client.NotAuthorizedError))
+	})
+})
@@ -8,6 +8,7 @@ import (
 	"github.com/cloudfoundry/gosteno"
 	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
 	"github.com/gogo/protobuf/proto"
+	"net/url"
 	"sync"
 	"time"
 	"trafficcontroller/doppler_endpoint"
@@ -89,6 +90,18 @@ var _ = Describe("ChannelGroupConnector", func() {
 					Eventually(fakeListeners[0].ConnectedHost).Should(Equal("ws://10.0.0.1:1234/firehose/subscription-123"))
 				})
 
+				It("forwards the filter to the listener", func() {
+					channelConnector := channel_group_connector.NewChannelGroupConnector(provider, listenerConstructor, marshaller.DropsondeLogMessage, logger)
+					outputChan := make(chan []byte, 10)
+					stopChan := make(chan struct{})
+					defer close(stopChan)
+					dopplerEndpoint := doppler_endpoint.NewDopplerEndpoint("firehose", "subscription-123", true)
+					dopplerEndpoint.Filter = url.Values{"event_type": {"ValueMetric"}}
+					go channelConnector.Connect(dopplerEndpoint, outputChan, stopChan)
+
+					Eventually(fakeListeners[0].ConnectedHost).Should(Equal("ws://10.0.0.1:1234/firehose/subscription-123?event_type=ValueMetric"))
+				})
+
 				It("puts messages on the channel received by the listener", func() {
 					channelConnector := channel_group_connector.NewChannelGroupConnector(provider, listenerConstructor, marshaller.DropsondeLogMessage, logger)
 					outputChan := make(chan []byte)
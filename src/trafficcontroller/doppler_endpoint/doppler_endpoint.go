@@ -7,6 +7,7 @@ import (
 	"github.com/cloudfoundry/loggregatorlib/server/handlers"
 	"github.com/gogo/protobuf/proto"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -20,6 +21,8 @@ type DopplerEndpoint struct {
 	Reconnect bool
 	Timeout   time.Duration
 	HProvider HandlerProvider
+	Query     RecentLogsQuery
+	Filter    url.Values
 }
 
 func NewDopplerEndpoint(endpoint string,
@@ -63,11 +66,22 @@ func ContainerMetricHandlerProvider(messages <-chan []byte, logger *gosteno.Logg
 }
 
 func (endpoint *DopplerEndpoint) GetPath() string {
+	var path string
 	if endpoint.Endpoint == "firehose" {
-		return "/firehose/" + endpoint.StreamId
+		path = "/firehose/" + endpoint.StreamId
 	} else {
-		return fmt.Sprintf("/apps/%s/%s", endpoint.StreamId, endpoint.Endpoint)
+		path = fmt.Sprintf("/apps/%s/%s", endpoint.StreamId, endpoint.Endpoint)
 	}
+
+	values := endpoint.Query.Values()
+	for key, value := range endpoint.Filter {
+		values[key] = value
+	}
+
+	if len(values) > 0 {
+		path += "?" + values.Encode()
+	}
+	return path
 }
 
 func DeDupe(input <-chan []byte) <-chan []byte {
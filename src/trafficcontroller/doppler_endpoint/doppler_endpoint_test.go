@@ -5,6 +5,7 @@ import (
 	"github.com/cloudfoundry/dropsonde/factories"
 	"github.com/cloudfoundry/loggregatorlib/server/handlers"
 	"github.com/gogo/protobuf/proto"
+	"net/url"
 	"time"
 	"trafficcontroller/doppler_endpoint"
 
@@ -59,6 +60,24 @@ var _ = Describe("GetPath", func() {
 		dopplerEndpoint := doppler_endpoint.NewDopplerEndpoint("recentlogs", "abc123", true)
 		Expect(dopplerEndpoint.GetPath()).To(Equal("/apps/abc123/recentlogs"))
 	})
+
+	It("includes the recent logs query", func() {
+		dopplerEndpoint := doppler_endpoint.NewDopplerEndpoint("recentlogs", "abc123", false)
+		dopplerEndpoint.Query = doppler_endpoint.RecentLogsQuery{StartTime: 10, Limit: 5}
+		Expect(dopplerEndpoint.GetPath()).To(Equal("/apps/abc123/recentlogs?limit=5&start_time=10"))
+	})
+
+	It("includes the filter for streams", func() {
+		dopplerEndpoint := doppler_endpoint.NewDopplerEndpoint("stream", "abc123", true)
+		dopplerEndpoint.Filter = url.Values{"message_type": {"ERR"}}
+		Expect(dopplerEndpoint.GetPath()).To(Equal("/apps/abc123/stream?message_type=ERR"))
+	})
+
+	It("includes the filter for the firehose", func() {
+		dopplerEndpoint := doppler_endpoint.NewDopplerEndpoint("firehose", "subscription-123", true)
+		dopplerEndpoint.Filter = url.Values{"event_type": {"ContainerMetric", "ValueMetric"}}
+		Expect(dopplerEndpoint.GetPath()).To(Equal("/firehose/subscription-123?event_type=ContainerMetric&event_type=ValueMetric"))
+	})
 })
 
 var _ = Describe("ContainerMetricsHandler", func() {
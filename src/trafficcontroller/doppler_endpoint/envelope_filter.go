@@ -0,0 +1,46 @@
+package doppler_endpoint
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"github.com/cloudfoundry/dropsonde/events"
+)
+
+var filterParameters = []string{"event_type", "origin", "source_type", "source_instance", "message_type", "message_regex"}
+
+// ParseEnvelopeFilter extracts the filter parameters of a stream or firehose
+// request so they can be forwarded to every doppler, which does the filtering.
+func ParseEnvelopeFilter(values url.Values) (url.Values, error) {
+	filter := url.Values{}
+	for _, parameter := range filterParameters {
+		if len(values[parameter]) > 0 {
+			filter[parameter] = values[parameter]
+		}
+	}
+
+	for _, name := range filter["event_type"] {
+		if _, ok := events.Envelope_EventType_value[name]; !ok {
+			return nil, fmt.Errorf("invalid event_type %q", name)
+		}
+	}
+
+	for _, name := range filter["message_type"] {
+		if _, ok := events.LogMessage_MessageType_value[name]; !ok {
+			return nil, fmt.Errorf("invalid message_type %q", name)
+		}
+	}
+
+	for _, expression := range filter["message_regex"] {
+		if _, err := regexp.Compile(expression); err != nil {
+			return nil, fmt.Errorf("invalid message_regex %q: %s", expression, err.Error())
+		}
+	}
+
+	if len(filter) == 0 {
+		return nil, nil
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,41 @@
+package doppler_endpoint_test
+
+import (
+	"net/url"
+	"trafficcontroller/doppler_endpoint"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseEnvelopeFilter", func() {
+	It("keeps only the filter parameters", func() {
+		filter, err := doppler_endpoint.ParseEnvelopeFilter(url.Values{
+			"event_type":    {"LogMessage", "ValueMetric"},
+			"message_regex": {"^GET"},
+			"app":           {"abc123"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filter).To(Equal(url.Values{
+			"event_type":    {"LogMessage", "ValueMetric"},
+			"message_regex": {"^GET"},
+		}))
+	})
+
+	It("returns nil without filter parameters", func() {
+		filter, err := doppler_endpoint.ParseEnvelopeFilter(url.Values{"app": {"abc123"}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filter).To(BeNil())
+	})
+
+	It("rejects invalid parameters", func() {
+		for _, values := range []url.Values{
+			{"event_type": {"Bogus"}},
+			{"message_type": {"WARN"}},
+			{"message_regex": {"("}},
+		} {
+			_, err := doppler_endpoint.ParseEnvelopeFilter(values)
+			Expect(err).To(HaveOccurred())
+		}
+	})
+})
@@ -0,0 +1,128 @@
+package doppler_endpoint
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/server/handlers"
+)
+
+// WantsJson tells whether a request asks for messages rendered as JSON, with
+// a format=json query parameter or an Accept header of application/json.
+func WantsJson(request *http.Request) bool {
+	if request.URL.Query().Get("format") == "json" {
+		return true
+	}
+
+	for _, mediaRange := range strings.Split(request.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err == nil && mediaType == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+// JsonHandlerProvider serves the messages of an endpoint as JSON: recent logs
+// and container metrics as a JSON array, streams and the firehose as
+// websocket messages holding one JSON document each.
+func JsonHandlerProvider(endpoint string, render JsonRenderer) HandlerProvider {
+	switch endpoint {
+	case "recentlogs":
+		return func(messages <-chan []byte, logger *gosteno.Logger) http.Handler {
+			return NewJsonArrayHandler(messages, render, logger)
+		}
+	case "containermetrics":
+		return func(messages <-chan []byte, logger *gosteno.Logger) http.Handler {
+			return NewJsonArrayHandler(DeDupe(messages), render, logger)
+		}
+	default:
+		return func(messages <-chan []byte, logger *gosteno.Logger) http.Handler {
+			return &jsonWebsocketHandler{messages: messages, render: render, logger: logger}
+		}
+	}
+}
+
+// JsonArrayHandler writes all messages as one JSON array, element by element
+// as they arrive, and ends it once the messages channel is closed.
+type JsonArrayHandler struct {
+	messages <-chan []byte
+	render   JsonRenderer
+	logger   *gosteno.Logger
+}
+
+func NewJsonArrayHandler(messages <-chan []byte, render JsonRenderer, logger *gosteno.Logger) *JsonArrayHandler {
+	return &JsonArrayHandler{
+		messages: messages,
+		render:   render,
+		logger:   logger,
+	}
+}
+
+func (h *JsonArrayHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	h.logger.Debugf("json array handler: request received from %s", request.RemoteAddr)
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+
+	separator := []byte("[")
+	for message := range h.messages {
+		data, err := h.render(message)
+		if err != nil {
+			h.logger.Debugf("json array handler: dropping message: %v", err)
+			continue
+		}
+
+		_, err = writer.Write(append(separator, data...))
+		if err != nil {
+			h.logger.Debugf("json array handler: client %s went away: %v", request.RemoteAddr, err)
+			return
+		}
+		separator = []byte(",")
+	}
+
+	if separator[0] == '[' {
+		writer.Write([]byte("[]"))
+		return
+	}
+	writer.Write([]byte("]"))
+}
+
+type jsonWebsocketHandler struct {
+	messages <-chan []byte
+	render   JsonRenderer
+	logger   *gosteno.Logger
+}
+
+func (h *jsonWebsocketHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	done := make(chan struct{})
+	defer close(done)
+
+	rendered := renderJson(h.messages, h.render, done, h.logger)
+	handlers.NewWebsocketHandler(rendered, WebsocketKeepAliveDuration, h.logger).ServeHTTP(writer, request)
+}
+
+// renderJson renders the messages until they run out or done is closed, which
+// happens when the websocket handler returns and stops reading.
+func renderJson(messages <-chan []byte, render JsonRenderer, done <-chan struct{}, logger *gosteno.Logger) <-chan []byte {
+	output := make(chan []byte)
+	go func() {
+		defer close(output)
+		for message := range messages {
+			data, err := render(message)
+			if err != nil {
+				logger.Debugf("json websocket handler: dropping message: %v", err)
+				continue
+			}
+
+			select {
+			case output <- data:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return output
+}
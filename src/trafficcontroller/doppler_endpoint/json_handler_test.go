@@ -0,0 +1,73 @@
+package doppler_endpoint_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"trafficcontroller/doppler_endpoint"
+
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WantsJson", func() {
+	It("is true for the format query parameter", func() {
+		request, _ := http.NewRequest("GET", "/apps/abc123/recentlogs?format=json", nil)
+		Expect(doppler_endpoint.WantsJson(request)).To(BeTrue())
+	})
+
+	It("is true for an Accept header of application/json", func() {
+		request, _ := http.NewRequest("GET", "/apps/abc123/recentlogs", nil)
+		request.Header.Set("Accept", "text/html, application/json; charset=utf-8")
+		Expect(doppler_endpoint.WantsJson(request)).To(BeTrue())
+	})
+
+	It("is false otherwise", func() {
+		request, _ := http.NewRequest("GET", "/apps/abc123/recentlogs?format=protobuf", nil)
+		request.Header.Set("Accept", "*/*")
+		Expect(doppler_endpoint.WantsJson(request)).To(BeFalse())
+	})
+})
+
+var _ = Describe("JsonArrayHandler", func() {
+	var (
+		messages chan []byte
+		recorder *httptest.ResponseRecorder
+		request  *http.Request
+	)
+
+	render := func(message []byte) ([]byte, error) {
+		if string(message) == "invalid" {
+			return nil, errors.New("invalid message")
+		}
+		return []byte(`{"message":"` + string(message) + `"}`), nil
+	}
+
+	BeforeEach(func() {
+		messages = make(chan []byte, 10)
+		recorder = httptest.NewRecorder()
+		request, _ = http.NewRequest("GET", "/apps/abc123/recentlogs", nil)
+	})
+
+	It("writes the messages as a JSON array, leaving out the ones that can't be rendered", func() {
+		messages <- []byte("hello")
+		messages <- []byte("invalid")
+		messages <- []byte("goodbye")
+		close(messages)
+
+		doppler_endpoint.NewJsonArrayHandler(messages, render, loggertesthelper.Logger()).ServeHTTP(recorder, request)
+
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(recorder.Body.String()).To(Equal(`[{"message":"hello"},{"message":"goodbye"}]`))
+	})
+
+	It("writes an empty array without messages", func() {
+		close(messages)
+
+		doppler_endpoint.NewJsonArrayHandler(messages, render, loggertesthelper.Logger()).ServeHTTP(recorder, request)
+
+		Expect(recorder.Body.String()).To(Equal(`[]`))
+	})
+})
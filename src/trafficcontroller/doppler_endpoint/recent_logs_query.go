@@ -0,0 +1,183 @@
+package doppler_endpoint
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"trafficcontroller/marshaller"
+)
+
+const RecentLogsCursorHeader = "X-Recent-Logs-Cursor"
+
+// RecentLogsQuery narrows recent logs to [StartTime, EndTime) in nanoseconds,
+// returning at most Limit messages in timestamp order. Cursor is the value of
+// the RecentLogsCursorHeader from a previous response and resumes right after
+// the last message returned by it.
+type RecentLogsQuery struct {
+	StartTime int64
+	EndTime   int64
+	Limit     int
+	Cursor    string
+}
+
+func ParseRecentLogsQuery(values url.Values) (RecentLogsQuery, error) {
+	var query RecentLogsQuery
+	var err error
+
+	if value := values.Get("start_time"); value != "" {
+		query.StartTime, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return query, fmt.Errorf("invalid start_time %q", value)
+		}
+	}
+
+	if value := values.Get("end_time"); value != "" {
+		query.EndTime, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return query, fmt.Errorf("invalid end_time %q", value)
+		}
+	}
+
+	if value := values.Get("limit"); value != "" {
+		query.Limit, err = strconv.Atoi(value)
+		if err != nil || query.Limit < 0 {
+			return query, fmt.Errorf("invalid limit %q", value)
+		}
+	}
+
+	if value := values.Get("cursor"); value != "" {
+		_, _, err = decodeCursor(value)
+		if err != nil {
+			return query, fmt.Errorf("invalid cursor %q", value)
+		}
+		query.Cursor = value
+	}
+
+	return query, nil
+}
+
+func (q RecentLogsQuery) IsEmpty() bool {
+	return q == RecentLogsQuery{}
+}
+
+func (q RecentLogsQuery) Values() url.Values {
+	values := url.Values{}
+	if q.StartTime != 0 {
+		values.Set("start_time", strconv.FormatInt(q.StartTime, 10))
+	}
+	if q.EndTime != 0 {
+		values.Set("end_time", strconv.FormatInt(q.EndTime, 10))
+	}
+	if q.Limit != 0 {
+		values.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Cursor != "" {
+		values.Set("cursor", q.Cursor)
+	}
+	return values
+}
+
+// MergeRecentLogs drains the messages of every doppler, orders them by
+// timestamp and applies the query. Messages with the same timestamp are
+// ordered by their bytes, so the cursor skips the same ones no matter in which
+// order the dopplers answered. It returns the selected messages together
+// with the cursor to resume from.
+func MergeRecentLogs(input <-chan []byte, query RecentLogsQuery, timestampOf marshaller.TimestampExtractor) (<-chan []byte, string) {
+	cursorTimestamp, cursorSkip, _ := decodeCursor(query.Cursor)
+
+	messages := []timestampedMessage{}
+	for message := range input {
+		timestamp, err := timestampOf(message)
+		if err != nil {
+			continue
+		}
+		messages = append(messages, timestampedMessage{timestamp: timestamp, message: message})
+	}
+
+	sort.Sort(byTimestamp(messages))
+
+	output := make(chan []byte, len(messages))
+	defer close(output)
+
+	lastTimestamp, lastCount := cursorTimestamp, cursorSkip
+	skip := cursorSkip
+	sent := 0
+	for _, m := range messages {
+		if m.timestamp < cursorTimestamp || m.timestamp < query.StartTime {
+			continue
+		}
+
+		if query.EndTime > 0 && m.timestamp >= query.EndTime {
+			break
+		}
+
+		if m.timestamp == cursorTimestamp && skip > 0 {
+			skip--
+			continue
+		}
+
+		if query.Limit > 0 && sent == query.Limit {
+			break
+		}
+
+		output <- m.message
+		sent++
+
+		if m.timestamp == lastTimestamp {
+			lastCount++
+		} else {
+			lastTimestamp = m.timestamp
+			lastCount = 1
+		}
+	}
+
+	if sent == 0 {
+		return output, query.Cursor
+	}
+
+	return output, encodeCursor(lastTimestamp, lastCount)
+}
+
+func encodeCursor(timestamp int64, skip int) string {
+	return base64.URLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", timestamp, skip)))
+}
+
+func decodeCursor(cursor string) (int64, int, error) {
+	if cursor == "" {
+		return 0, 0, nil
+	}
+
+	decoded, err := base64.URLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var timestamp int64
+	var skip int
+	_, err = fmt.Sscanf(string(decoded), "%d:%d", &timestamp, &skip)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return timestamp, skip, nil
+}
+
+type timestampedMessage struct {
+	timestamp int64
+	message   []byte
+}
+
+type byTimestamp []timestampedMessage
+
+func (m byTimestamp) Len() int      { return len(m) }
+func (m byTimestamp) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+
+func (m byTimestamp) Less(i, j int) bool {
+	if m[i].timestamp != m[j].timestamp {
+		return m[i].timestamp < m[j].timestamp
+	}
+	return bytes.Compare(m[i].message, m[j].message) < 0
+}
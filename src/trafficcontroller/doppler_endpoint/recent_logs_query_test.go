@@ -0,0 +1,136 @@
+package doppler_endpoint_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/url"
+	"trafficcontroller/doppler_endpoint"
+	"trafficcontroller/marshaller"
+
+	"github.com/cloudfoundry/dropsonde/emitter"
+	"github.com/cloudfoundry/dropsonde/events"
+	"github.com/cloudfoundry/dropsonde/factories"
+	"github.com/gogo/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseRecentLogsQuery", func() {
+	It("parses all parameters", func() {
+		cursor := base64.URLEncoding.EncodeToString([]byte("5:1"))
+		values := url.Values{"start_time": {"1"}, "end_time": {"10"}, "limit": {"3"}, "cursor": {cursor}}
+
+		query, err := doppler_endpoint.ParseRecentLogsQuery(values)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(query).To(Equal(doppler_endpoint.RecentLogsQuery{StartTime: 1, EndTime: 10, Limit: 3, Cursor: cursor}))
+	})
+
+	It("returns an empty query without parameters", func() {
+		query, err := doppler_endpoint.ParseRecentLogsQuery(url.Values{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(query.IsEmpty()).To(BeTrue())
+	})
+
+	It("rejects invalid values", func() {
+		for _, values := range []url.Values{
+			{"start_time": {"yesterday"}},
+			{"end_time": {"tomorrow"}},
+			{"limit": {"-1"}},
+			{"cursor": {"not a cursor"}},
+		} {
+			_, err := doppler_endpoint.ParseRecentLogsQuery(values)
+			Expect(err).To(HaveOccurred())
+		}
+	})
+})
+
+var _ = Describe("MergeRecentLogs", func() {
+	var input chan []byte
+
+	BeforeEach(func() {
+		input = make(chan []byte, 10)
+		for _, timestamp := range []int64{3, 1, 5, 2, 4} {
+			input <- envelopeAt(timestamp)
+		}
+		close(input)
+	})
+
+	It("orders messages from all dopplers by timestamp", func() {
+		output, _ := doppler_endpoint.MergeRecentLogs(input, doppler_endpoint.RecentLogsQuery{StartTime: 1}, marshaller.DropsondeTimestamp)
+		Expect(timestampsOf(output)).To(Equal([]int64{1, 2, 3, 4, 5}))
+	})
+
+	It("applies the limit and resumes from the returned cursor", func() {
+		output, cursor := doppler_endpoint.MergeRecentLogs(input, doppler_endpoint.RecentLogsQuery{Limit: 2}, marshaller.DropsondeTimestamp)
+		Expect(timestampsOf(output)).To(Equal([]int64{1, 2}))
+
+		resumed := make(chan []byte, 10)
+		for _, timestamp := range []int64{2, 3, 4} {
+			resumed <- envelopeAt(timestamp)
+		}
+		close(resumed)
+
+		output, _ = doppler_endpoint.MergeRecentLogs(resumed, doppler_endpoint.RecentLogsQuery{Limit: 2, Cursor: cursor}, marshaller.DropsondeTimestamp)
+		Expect(timestampsOf(output)).To(Equal([]int64{3, 4}))
+	})
+
+	It("resumes at the same message however dopplers ordered equal timestamps", func() {
+		first := envelopeWithMessageAt("a", 1)
+		second := envelopeWithMessageAt("b", 1)
+		if bytes.Compare(first, second) > 0 {
+			first, second = second, first
+		}
+
+		for _, order := range [][][]byte{{first, second}, {second, first}} {
+			input := make(chan []byte, 2)
+			input <- order[0]
+			input <- order[1]
+			close(input)
+
+			output, cursor := doppler_endpoint.MergeRecentLogs(input, doppler_endpoint.RecentLogsQuery{Limit: 1}, marshaller.DropsondeTimestamp)
+			Expect(<-output).To(Equal(first))
+
+			resumed := make(chan []byte, 2)
+			resumed <- order[1]
+			resumed <- order[0]
+			close(resumed)
+
+			output, _ = doppler_endpoint.MergeRecentLogs(resumed, doppler_endpoint.RecentLogsQuery{Cursor: cursor}, marshaller.DropsondeTimestamp)
+			Expect(<-output).To(Equal(second))
+			Expect(output).To(BeClosed())
+		}
+	})
+
+	It("excludes messages at or after the end time", func() {
+		output, _ := doppler_endpoint.MergeRecentLogs(input, doppler_endpoint.RecentLogsQuery{StartTime: 2, EndTime: 4}, marshaller.DropsondeTimestamp)
+		Expect(timestampsOf(output)).To(Equal([]int64{2, 3}))
+	})
+
+	It("returns the original cursor when nothing matched", func() {
+		query := doppler_endpoint.RecentLogsQuery{StartTime: 10, Cursor: base64.URLEncoding.EncodeToString([]byte("9:0"))}
+		output, cursor := doppler_endpoint.MergeRecentLogs(input, query, marshaller.DropsondeTimestamp)
+		Expect(output).To(BeClosed())
+		Expect(cursor).To(Equal(query.Cursor))
+	})
+})
+
+func envelopeAt(timestamp int64) []byte {
+	return envelopeWithMessageAt("message", timestamp)
+}
+
+func envelopeWithMessageAt(message string, timestamp int64) []byte {
+	envelope, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, message, "abc123", "App"), "origin")
+	envelope.Timestamp = proto.Int64(timestamp)
+	bytes, _ := proto.Marshal(envelope)
+	return bytes
+}
+
+func timestampsOf(messages <-chan []byte) []int64 {
+	timestamps := []int64{}
+	for message := range messages {
+		timestamp, _ := marshaller.DropsondeTimestamp(message)
+		timestamps = append(timestamps, timestamp)
+	}
+	return timestamps
+}
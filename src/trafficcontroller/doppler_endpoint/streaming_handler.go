@@ -0,0 +1,144 @@
+package doppler_endpoint
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/gosteno"
+)
+
+// StreamFormat is a plain HTTP alternative to websockets for streams and the
+// firehose, for clients behind proxies that break websockets.
+type StreamFormat string
+
+const (
+	ServerSentEvents StreamFormat = "text/event-stream"
+	NDJson           StreamFormat = "application/x-ndjson"
+)
+
+// JsonRenderer turns a message as received from doppler into JSON.
+type JsonRenderer func([]byte) ([]byte, error)
+
+// StreamFormatFromAccept returns the stream format asked for by an Accept
+// header, if any.
+func StreamFormatFromAccept(accept string) (StreamFormat, bool) {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+
+		switch StreamFormat(mediaType) {
+		case ServerSentEvents:
+			return ServerSentEvents, true
+		case NDJson:
+			return NDJson, true
+		}
+	}
+	return "", false
+}
+
+func StreamingHandlerProvider(format StreamFormat, render JsonRenderer) HandlerProvider {
+	return func(messages <-chan []byte, logger *gosteno.Logger) http.Handler {
+		return NewStreamingHandler(messages, format, render, WebsocketKeepAliveDuration, logger)
+	}
+}
+
+// StreamingHandler writes every message as JSON, either as the data of a
+// server-sent event or as a line of newline delimited JSON. Idle connections
+// get a keep alive every keepAlive, an SSE comment or an empty line, so
+// proxies do not close them.
+type StreamingHandler struct {
+	messages  <-chan []byte
+	format    StreamFormat
+	render    JsonRenderer
+	keepAlive time.Duration
+	logger    *gosteno.Logger
+}
+
+func NewStreamingHandler(messages <-chan []byte, format StreamFormat, render JsonRenderer, keepAlive time.Duration, logger *gosteno.Logger) *StreamingHandler {
+	return &StreamingHandler{
+		messages:  messages,
+		format:    format,
+		render:    render,
+		keepAlive: keepAlive,
+		logger:    logger,
+	}
+}
+
+func (h *StreamingHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	h.logger.Debugf("streaming handler: serving %s to %s", h.format, request.RemoteAddr)
+
+	writer.Header().Set("Content-Type", string(h.format))
+	writer.Header().Set("Cache-Control", "no-cache")
+	// keeps nginx based proxies from buffering the stream
+	writer.Header().Set("X-Accel-Buffering", "no")
+	writer.WriteHeader(http.StatusOK)
+
+	flusher, _ := writer.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	flush()
+
+	var closed <-chan bool
+	if notifier, ok := writer.(http.CloseNotifier); ok {
+		closed = notifier.CloseNotify()
+	}
+
+	keepAlive := time.NewTicker(h.keepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case message, ok := <-h.messages:
+			if !ok {
+				return
+			}
+
+			data, err := h.render(message)
+			if err != nil {
+				h.logger.Debugf("streaming handler: dropping message: %v", err)
+				continue
+			}
+
+			_, err = writer.Write(h.frame(data))
+			if err != nil {
+				h.logger.Debugf("streaming handler: client %s went away: %v", request.RemoteAddr, err)
+				return
+			}
+			flush()
+		case <-keepAlive.C:
+			_, err := writer.Write(h.keepAliveFrame())
+			if err != nil {
+				return
+			}
+			flush()
+		case <-closed:
+			h.logger.Debugf("streaming handler: client %s closed the connection", request.RemoteAddr)
+			return
+		}
+	}
+}
+
+func (h *StreamingHandler) frame(data []byte) []byte {
+	if h.format == ServerSentEvents {
+		// JSON never holds a raw newline, so it fits on one data line
+		frame := make([]byte, 0, len(data)+8)
+		frame = append(frame, "data: "...)
+		frame = append(frame, data...)
+		return append(frame, '\n', '\n')
+	}
+	return append(data, '\n')
+}
+
+func (h *StreamingHandler) keepAliveFrame() []byte {
+	if h.format == ServerSentEvents {
+		return []byte(":\n\n")
+	}
+	return []byte("\n")
+}
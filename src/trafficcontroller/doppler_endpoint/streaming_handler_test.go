@@ -0,0 +1,94 @@
+package doppler_endpoint_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+	"trafficcontroller/doppler_endpoint"
+
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StreamFormatFromAccept", func() {
+	It("finds server-sent events", func() {
+		format, ok := doppler_endpoint.StreamFormatFromAccept("text/event-stream")
+		Expect(ok).To(BeTrue())
+		Expect(format).To(Equal(doppler_endpoint.ServerSentEvents))
+	})
+
+	It("finds newline delimited JSON among other media types", func() {
+		format, ok := doppler_endpoint.StreamFormatFromAccept("text/html, application/x-ndjson;q=0.9")
+		Expect(ok).To(BeTrue())
+		Expect(format).To(Equal(doppler_endpoint.NDJson))
+	})
+
+	It("finds nothing for other media types", func() {
+		_, ok := doppler_endpoint.StreamFormatFromAccept("*/*")
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var _ = Describe("StreamingHandler", func() {
+	var (
+		messages chan []byte
+		recorder *httptest.ResponseRecorder
+		request  *http.Request
+	)
+
+	render := func(message []byte) ([]byte, error) {
+		if string(message) == "invalid" {
+			return nil, errors.New("invalid message")
+		}
+		return []byte(`{"message":"` + string(message) + `"}`), nil
+	}
+
+	BeforeEach(func() {
+		messages = make(chan []byte, 10)
+		recorder = httptest.NewRecorder()
+		request, _ = http.NewRequest("GET", "/apps/abc123/stream", nil)
+	})
+
+	It("writes every message as a server-sent event", func() {
+		messages <- []byte("hello")
+		messages <- []byte("invalid")
+		messages <- []byte("goodbye")
+		close(messages)
+
+		handler := doppler_endpoint.NewStreamingHandler(messages, doppler_endpoint.ServerSentEvents, render, time.Minute, loggertesthelper.Logger())
+		handler.ServeHTTP(recorder, request)
+
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("text/event-stream"))
+		Expect(recorder.Header().Get("Cache-Control")).To(Equal("no-cache"))
+		Expect(recorder.Body.String()).To(Equal("data: {\"message\":\"hello\"}\n\ndata: {\"message\":\"goodbye\"}\n\n"))
+		Expect(recorder.Flushed).To(BeTrue())
+	})
+
+	It("writes every message as a line of JSON", func() {
+		messages <- []byte("hello")
+		messages <- []byte("goodbye")
+		close(messages)
+
+		handler := doppler_endpoint.NewStreamingHandler(messages, doppler_endpoint.NDJson, render, time.Minute, loggertesthelper.Logger())
+		handler.ServeHTTP(recorder, request)
+
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("application/x-ndjson"))
+		Expect(recorder.Body.String()).To(Equal("{\"message\":\"hello\"}\n{\"message\":\"goodbye\"}\n"))
+	})
+
+	It("keeps idle streams alive", func() {
+		handler := doppler_endpoint.NewStreamingHandler(messages, doppler_endpoint.ServerSentEvents, render, 10*time.Millisecond, loggertesthelper.Logger())
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			close(messages)
+		}()
+		handler.ServeHTTP(recorder, request)
+
+		Expect(strings.Count(recorder.Body.String(), ":\n\n")).To(BeNumerically(">=", 2))
+	})
+})
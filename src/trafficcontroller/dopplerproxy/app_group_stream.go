@@ -0,0 +1,98 @@
+package dopplerproxy
+
+import (
+	"net/url"
+	"sync"
+	"trafficcontroller/channel_group_connector"
+	"trafficcontroller/doppler_endpoint"
+)
+
+// appGroupStream multiplexes the streams of a changing set of apps into one
+// channel.
+type appGroupStream struct {
+	connector channel_group_connector.ChannelGroupConnector
+	filter    url.Values
+	messages  chan []byte
+
+	lock    sync.Mutex
+	streams map[string]chan struct{}
+	stopped bool
+}
+
+func newAppGroupStream(connector channel_group_connector.ChannelGroupConnector, filter url.Values) *appGroupStream {
+	return &appGroupStream{
+		connector: connector,
+		filter:    filter,
+		messages:  make(chan []byte, 100),
+		streams:   make(map[string]chan struct{}),
+	}
+}
+
+// update connects to the streams of apps that joined and disconnects from
+// the ones of apps that left.
+func (s *appGroupStream) update(appIds []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.stopped {
+		return
+	}
+
+	current := make(map[string]bool, len(appIds))
+	for _, appId := range appIds {
+		current[appId] = true
+		if _, ok := s.streams[appId]; !ok {
+			s.streams[appId] = s.connect(appId)
+		}
+	}
+
+	for appId, stopChan := range s.streams {
+		if !current[appId] {
+			close(stopChan)
+			delete(s.streams, appId)
+		}
+	}
+}
+
+// stop disconnects from all streams. Updates of a refresh that was still
+// running are ignored.
+func (s *appGroupStream) stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for appId, stopChan := range s.streams {
+		close(stopChan)
+		delete(s.streams, appId)
+	}
+	s.stopped = true
+}
+
+func (s *appGroupStream) connect(appId string) chan struct{} {
+	dopplerEndpoint := doppler_endpoint.NewDopplerEndpoint("stream", appId, true)
+	dopplerEndpoint.Filter = s.filter
+
+	messagesChan := make(chan []byte, 100)
+	stopChan := make(chan struct{})
+	go s.connector.Connect(dopplerEndpoint, messagesChan, stopChan)
+
+	go func() {
+		for {
+			select {
+			case message, ok := <-messagesChan:
+				if !ok {
+					return
+				}
+
+				select {
+				case s.messages <- message:
+				case <-stopChan:
+					return
+				}
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+
+	return stopChan
+}
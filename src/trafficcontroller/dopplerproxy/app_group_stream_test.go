@@ -0,0 +1,262 @@
+package dopplerproxy_test
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+	"trafficcontroller/cc_client"
+	"trafficcontroller/doppler_endpoint"
+	"trafficcontroller/dopplerproxy"
+	"trafficcontroller/marshaller"
+
+	"github.com/cloudfoundry/dropsonde/emitter"
+	"github.com/cloudfoundry/dropsonde/events"
+	"github.com/cloudfoundry/dropsonde/factories"
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+	"github.com/gogo/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("App group streams", func() {
+	var (
+		connector *fakeAppConnector
+		appLister *fakeAppLister
+		proxy     *dopplerproxy.Proxy
+		recorder  *httptest.ResponseRecorder
+	)
+
+	BeforeEach(func() {
+		connector = &fakeAppConnector{
+			endpoints: make(map[string]doppler_endpoint.DopplerEndpoint),
+			stopped:   make(map[string]bool),
+		}
+		appLister = &fakeAppLister{appIds: []string{"app-1", "app-2"}}
+
+		auth := LogAuthorizer{Result: AuthorizerResult{Authorized: true}}
+		adminAuth := AdminAuthorizer{Result: AuthorizerResult{Authorized: true}}
+		proxy = dopplerproxy.NewDopplerProxy(
+			auth.Authorize,
+			adminAuth.Authorize,
+			connector,
+			appLister,
+			dopplerproxy.AppGroupOptions{MaxApps: 3, RefreshInterval: 50 * time.Millisecond},
+			dopplerproxy.TranslateFromDropsondePath,
+			marshaller.DropsondeTimestamp,
+			marshaller.DropsondeJson,
+			"cookieDomain",
+			loggertesthelper.Logger(),
+		)
+
+		recorder = httptest.NewRecorder()
+	})
+
+	Context("with a streaming client", func() {
+		var (
+			server   *httptest.Server
+			response *http.Response
+			lines    *bufio.Reader
+		)
+
+		BeforeEach(func() {
+			server = httptest.NewServer(proxy)
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		open := func(path string) {
+			req, _ := http.NewRequest("GET", server.URL+path, nil)
+			req.Header.Add("Authorization", "bearer token")
+			req.Header.Add("Accept", "application/x-ndjson")
+
+			var err error
+			response, err = http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+			lines = bufio.NewReader(response.Body)
+		}
+
+		readLines := func(count int) []string {
+			var read []string
+			for len(read) < count {
+				line, err := lines.ReadString('\n')
+				Expect(err).NotTo(HaveOccurred())
+				if line != "\n" {
+					read = append(read, line)
+				}
+			}
+			return read
+		}
+
+		It("streams the logs of every app in the space", func() {
+			open("/spaces/space-guid/stream?message_type=ERR")
+			defer response.Body.Close()
+
+			received := readLines(2)
+			Expect(received).To(ContainElement(ContainSubstring(`"appId":"app-1"`)))
+			Expect(received).To(ContainElement(ContainSubstring(`"appId":"app-2"`)))
+
+			Expect(appLister.lastRequest()).To(Equal([]string{"bearer token", cc_client.SpaceGroup, "space-guid"}))
+			endpoint := connector.endpoint("app-1")
+			Expect(endpoint.Endpoint).To(Equal("stream"))
+			Expect(endpoint.Reconnect).To(BeTrue())
+			Expect(endpoint.Filter).To(Equal(url.Values{"message_type": {"ERR"}}))
+		})
+
+		It("picks up apps that join or leave the org", func() {
+			open("/orgs/org-guid/stream")
+			defer response.Body.Close()
+			readLines(2)
+
+			appLister.setAppIds([]string{"app-2", "app-3"})
+
+			Expect(readLines(1)[0]).To(ContainSubstring(`"appId":"app-3"`))
+			Eventually(func() bool { return connector.isStopped("app-1") }).Should(BeTrue())
+			Expect(connector.isStopped("app-2")).To(BeFalse())
+			Expect(appLister.lastRequest()[1]).To(Equal(cc_client.OrgGroup))
+		})
+
+		It("keeps the apps when the org grew too large", func() {
+			open("/orgs/org-guid/stream")
+			defer response.Body.Close()
+			readLines(2)
+
+			appLister.setAppIds([]string{"app-1", "app-2", "app-3", "app-4"})
+
+			Consistently(connector.connectionCount, 200*time.Millisecond).Should(Equal(2))
+			Expect(connector.isStopped("app-1")).To(BeFalse())
+		})
+
+		It("disconnects from all apps when the client goes away", func() {
+			open("/spaces/space-guid/stream")
+			readLines(2)
+			response.Body.Close()
+
+			Eventually(func() bool { return connector.isStopped("app-1") && connector.isStopped("app-2") }).Should(BeTrue())
+		})
+	})
+
+	It("returns an unauthorized status if the user can't see the space", func() {
+		appLister.err = cc_client.NotAuthorizedError
+
+		req, _ := http.NewRequest("GET", "/spaces/space-guid/stream", nil)
+		req.Header.Add("Authorization", "bearer token")
+		proxy.ServeHTTP(recorder, req)
+
+		Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+		Expect(recorder.HeaderMap.Get("WWW-Authenticate")).To(Equal("Basic"))
+		Expect(connector.connectionCount()).To(Equal(0))
+	})
+
+	It("returns an unauthorized status without a token", func() {
+		req, _ := http.NewRequest("GET", "/spaces/space-guid/stream", nil)
+		proxy.ServeHTTP(recorder, req)
+
+		Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+		Expect(appLister.lastRequest()).To(BeNil())
+	})
+
+	It("returns a request entity too large status if the space has too many apps", func() {
+		appLister.appIds = []string{"app-1", "app-2", "app-3", "app-4"}
+
+		req, _ := http.NewRequest("GET", "/spaces/space-guid/stream", nil)
+		req.Header.Add("Authorization", "bearer token")
+		proxy.ServeHTTP(recorder, req)
+
+		Expect(recorder.Code).To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(connector.connectionCount()).To(Equal(0))
+	})
+
+	It("returns a bad gateway status if the apps can't be looked up", func() {
+		appLister.err = errors.New("connection refused")
+
+		req, _ := http.NewRequest("GET", "/orgs/org-guid/stream", nil)
+		req.Header.Add("Authorization", "bearer token")
+		proxy.ServeHTTP(recorder, req)
+
+		Expect(recorder.Code).To(Equal(http.StatusBadGateway))
+	})
+
+	It("returns a 404 for other paths", func() {
+		req, _ := http.NewRequest("GET", "/spaces/space-guid/recentlogs", nil)
+		req.Header.Add("Authorization", "bearer token")
+		proxy.ServeHTTP(recorder, req)
+
+		Expect(recorder.Code).To(Equal(http.StatusNotFound))
+	})
+})
+
+type fakeAppLister struct {
+	sync.Mutex
+	appIds  []string
+	err     error
+	request []string
+}
+
+func (f *fakeAppLister) ListApps(authToken string, groupType string, guid string) ([]string, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.request = []string{authToken, groupType, guid}
+	return f.appIds, f.err
+}
+
+func (f *fakeAppLister) setAppIds(appIds []string) {
+	f.Lock()
+	defer f.Unlock()
+	f.appIds = appIds
+}
+
+func (f *fakeAppLister) lastRequest() []string {
+	f.Lock()
+	defer f.Unlock()
+	return f.request
+}
+
+// fakeAppConnector sends one log message per app it is connected to.
+type fakeAppConnector struct {
+	sync.Mutex
+	endpoints map[string]doppler_endpoint.DopplerEndpoint
+	stopped   map[string]bool
+}
+
+func (f *fakeAppConnector) Connect(dopplerEndpoint doppler_endpoint.DopplerEndpoint, messagesChan chan<- []byte, stopChan <-chan struct{}) {
+	defer close(messagesChan)
+
+	f.Lock()
+	f.endpoints[dopplerEndpoint.StreamId] = dopplerEndpoint
+	f.Unlock()
+
+	envelope, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "hello", dopplerEndpoint.StreamId, "App"), "origin")
+	bytes, _ := proto.Marshal(envelope)
+	messagesChan <- bytes
+
+	<-stopChan
+	f.Lock()
+	defer f.Unlock()
+	f.stopped[dopplerEndpoint.StreamId] = true
+}
+
+func (f *fakeAppConnector) endpoint(appId string) doppler_endpoint.DopplerEndpoint {
+	f.Lock()
+	defer f.Unlock()
+	return f.endpoints[appId]
+}
+
+func (f *fakeAppConnector) isStopped(appId string) bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.stopped[appId]
+}
+
+func (f *fakeAppConnector) connectionCount() int {
+	f.Lock()
+	defer f.Unlock()
+	return len(f.endpoints)
+}
@@ -3,41 +3,71 @@ package dopplerproxy
 import (
 	"fmt"
 	"github.com/cloudfoundry/gosteno"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
 	"github.com/cloudfoundry/loggregatorlib/logmessage"
 	"github.com/gogo/protobuf/proto"
 	"net/http"
 	"net/url"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"trafficcontroller/authorization"
+	"trafficcontroller/cc_client"
 	"trafficcontroller/channel_group_connector"
 	"trafficcontroller/doppler_endpoint"
+	"trafficcontroller/marshaller"
 )
 
 const FIREHOSE_ID = "firehose"
 
+// AppGroupOptions configures the streams of spaces and orgs.
+type AppGroupOptions struct {
+	// MaxApps is the number of apps a stream may hold, as every app takes a
+	// connection to each doppler. Larger spaces and orgs are refused.
+	MaxApps int
+
+	// RefreshInterval is how often the apps of a stream are looked up
+	// again, so apps pushed or deleted meanwhile are picked up
+	RefreshInterval time.Duration
+}
+
 type Proxy struct {
-	logAuthorize   authorization.LogAccessAuthorizer
-	adminAuthorize authorization.AdminAccessAuthorizer
-	connector      channel_group_connector.ChannelGroupConnector
-	translate      RequestTranslator
-	cookieDomain   string
-	logger         *gosteno.Logger
+	logAuthorize    authorization.LogAccessAuthorizer
+	adminAuthorize  authorization.AdminAccessAuthorizer
+	connector       channel_group_connector.ChannelGroupConnector
+	appLister       cc_client.AppLister
+	appGroupOptions AppGroupOptions
+	translate       RequestTranslator
+	timestampOf     marshaller.TimestampExtractor
+	jsonOf          doppler_endpoint.JsonRenderer
+	cookieDomain    string
+	logger          *gosteno.Logger
+
+	requests        map[string]uint64
+	requestsLock    sync.Mutex
+	openConnections int64
 }
 
 type RequestTranslator func(request *http.Request) (*http.Request, error)
 
 type Authorizer func(authToken string, appId string, logger *gosteno.Logger) (bool, error)
 
-func NewDopplerProxy(logAuthorize authorization.LogAccessAuthorizer, adminAuthorizer authorization.AdminAccessAuthorizer, connector channel_group_connector.ChannelGroupConnector, translator RequestTranslator, cookieDomain string, logger *gosteno.Logger) *Proxy {
+func NewDopplerProxy(logAuthorize authorization.LogAccessAuthorizer, adminAuthorizer authorization.AdminAccessAuthorizer, connector channel_group_connector.ChannelGroupConnector, appLister cc_client.AppLister, appGroupOptions AppGroupOptions, translator RequestTranslator, timestampExtractor marshaller.TimestampExtractor, jsonRenderer doppler_endpoint.JsonRenderer, cookieDomain string, logger *gosteno.Logger) *Proxy {
 	return &Proxy{
-		logAuthorize:   logAuthorize,
-		adminAuthorize: adminAuthorizer,
-		connector:      connector,
-		translate:      translator,
-		cookieDomain:   cookieDomain,
-		logger:         logger,
+		logAuthorize:    logAuthorize,
+		adminAuthorize:  adminAuthorizer,
+		connector:       connector,
+		appLister:       appLister,
+		appGroupOptions: appGroupOptions,
+		translate:       translator,
+		timestampOf:     timestampExtractor,
+		jsonOf:          jsonRenderer,
+		cookieDomain:    cookieDomain,
+		logger:          logger,
+		requests:        make(map[string]uint64),
 	}
 }
 
@@ -58,12 +88,15 @@ func (proxy *Proxy) ServeHTTP(writer http.ResponseWriter, request *http.Request)
 	}
 
 	endpointName := strings.Split(translatedRequest.URL.Path, "/")[1]
+	proxy.countRequest(endpointName)
 
 	switch endpointName {
 	case "firehose":
 		proxy.serveFirehose(writer, translatedRequest)
 	case "apps":
 		proxy.serveAppLogs(writer, translatedRequest)
+	case cc_client.SpaceGroup, cc_client.OrgGroup:
+		proxy.serveAppGroupLogs(writer, translatedRequest)
 	case "set-cookie":
 		proxy.serveSetCookie(writer, translatedRequest, proxy.cookieDomain)
 	default:
@@ -91,6 +124,14 @@ func (proxy *Proxy) serveFirehose(writer http.ResponseWriter, request *http.Requ
 
 	dopplerEndpoint := doppler_endpoint.NewDopplerEndpoint(FIREHOSE_ID, firehoseSubscriptionId, true)
 
+	filter, err := doppler_endpoint.ParseEnvelopeFilter(request.URL.Query())
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(writer, "Invalid filter. %s", err.Error())
+		return
+	}
+	dopplerEndpoint.Filter = filter
+
 	authorizer := func(authToken string, appId string, logger *gosteno.Logger) (bool, error) {
 		return proxy.adminAuthorize(authToken, logger)
 	}
@@ -142,20 +183,164 @@ func (proxy *Proxy) serveAppLogs(writer http.ResponseWriter, request *http.Reque
 
 	dopplerEndpoint := doppler_endpoint.NewDopplerEndpoint(endpoint_type, appId, reconnect)
 
+	switch endpoint_type {
+	case "recentlogs":
+		query, err := doppler_endpoint.ParseRecentLogsQuery(request.URL.Query())
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(writer, "Invalid recent logs query. %s", err.Error())
+			return
+		}
+		dopplerEndpoint.Query = query
+	case "stream":
+		filter, err := doppler_endpoint.ParseEnvelopeFilter(request.URL.Query())
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(writer, "Invalid filter. %s", err.Error())
+			return
+		}
+		dopplerEndpoint.Filter = filter
+	}
+
 	proxy.serveWithDoppler(writer, request, dopplerEndpoint)
 }
 
 func (proxy *Proxy) serveWithDoppler(writer http.ResponseWriter, request *http.Request, dopplerEndpoint doppler_endpoint.DopplerEndpoint) {
+	atomic.AddInt64(&proxy.openConnections, 1)
+	defer atomic.AddInt64(&proxy.openConnections, -1)
+
 	messagesChan := make(chan []byte, 100)
 	stopChan := make(chan struct{})
 	defer close(stopChan)
 
 	go proxy.connector.Connect(dopplerEndpoint, messagesChan, stopChan)
 
-	handler := dopplerEndpoint.HProvider(messagesChan, proxy.logger)
+	var messages <-chan []byte = messagesChan
+	if !dopplerEndpoint.Query.IsEmpty() {
+		var cursor string
+		messages, cursor = doppler_endpoint.MergeRecentLogs(messagesChan, dopplerEndpoint.Query, proxy.timestampOf)
+		writer.Header().Set(doppler_endpoint.RecentLogsCursorHeader, cursor)
+	}
+
+	handler := proxy.handlerProvider(request, dopplerEndpoint)(messages, proxy.logger)
+	handler.ServeHTTP(writer, request)
+}
+
+// serveAppGroupLogs streams the logs of all apps in a space or org the user
+// can see over one connection.
+func (proxy *Proxy) serveAppGroupLogs(writer http.ResponseWriter, request *http.Request) {
+	authToken := getAuthToken(request)
+
+	validPaths := regexp.MustCompile("^/(spaces|orgs)/([^/]*)/stream$")
+	matches := validPaths.FindStringSubmatch(request.URL.Path)
+	if len(matches) != 3 {
+		writer.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(writer, "Resource Not Found. %s", request.URL.Path)
+		return
+	}
+	groupType, guid := matches[1], matches[2]
+
+	if guid == "" {
+		writer.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(writer, "GUID missing. Make request to /%s/GUID/stream", groupType)
+		return
+	}
+
+	filter, err := doppler_endpoint.ParseEnvelopeFilter(request.URL.Query())
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(writer, "Invalid filter. %s", err.Error())
+		return
+	}
+
+	if authToken == "" {
+		writer.Header().Set("WWW-Authenticate", "Basic")
+		writer.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintf(writer, "You are not authorized. %s", authorization.NO_AUTH_TOKEN_PROVIDED_ERROR_MESSAGE)
+		return
+	}
+
+	appIds, err := proxy.appLister.ListApps(authToken, groupType, guid)
+	if err == cc_client.NotAuthorizedError {
+		proxy.logger.Warnf("HttpServer: Auth token [%s] not authorized to access %s [%s].", authToken, groupType, guid)
+		writer.Header().Set("WWW-Authenticate", "Basic")
+		writer.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintf(writer, "You are not authorized. %s", err.Error())
+		return
+	}
+	if err != nil {
+		proxy.logger.Errorf("Could not get the apps of %s [%s]: %s", groupType, guid, err.Error())
+		writer.WriteHeader(http.StatusBadGateway)
+		fmt.Fprintf(writer, "Could not get the apps of %s %s.", groupType, guid)
+		return
+	}
+
+	if len(appIds) > proxy.appGroupOptions.MaxApps {
+		writer.WriteHeader(http.StatusRequestEntityTooLarge)
+		fmt.Fprintf(writer, "Too many apps. %s %s has %d apps, at most %d can be streamed together.", groupType, guid, len(appIds), proxy.appGroupOptions.MaxApps)
+		return
+	}
+
+	atomic.AddInt64(&proxy.openConnections, 1)
+	defer atomic.AddInt64(&proxy.openConnections, -1)
+
+	stream := newAppGroupStream(proxy.connector, filter)
+	defer stream.stop()
+	stream.update(appIds)
+
+	done := make(chan struct{})
+	defer close(done)
+	go proxy.refreshAppGroup(stream, authToken, groupType, guid, done)
+
+	dopplerEndpoint := doppler_endpoint.NewDopplerEndpoint("stream", guid, true)
+	handler := proxy.handlerProvider(request, dopplerEndpoint)(stream.messages, proxy.logger)
 	handler.ServeHTTP(writer, request)
 }
 
+// refreshAppGroup keeps the apps of a stream in sync with the Cloud
+// Controller. Like app streams, group streams are only authorized when they
+// are opened, so the apps stay as they are when the lookup fails, e.g.
+// because the token expired meanwhile, or when there are too many apps now.
+func (proxy *Proxy) refreshAppGroup(stream *appGroupStream, authToken string, groupType string, guid string, done <-chan struct{}) {
+	ticker := time.NewTicker(proxy.appGroupOptions.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			appIds, err := proxy.appLister.ListApps(authToken, groupType, guid)
+			if err != nil {
+				proxy.logger.Warnf("doppler proxy: could not refresh the apps of %s [%s]: %s", groupType, guid, err.Error())
+				continue
+			}
+			if len(appIds) > proxy.appGroupOptions.MaxApps {
+				proxy.logger.Warnf("doppler proxy: not refreshing the apps of %s [%s], %d apps are more than %d", groupType, guid, len(appIds), proxy.appGroupOptions.MaxApps)
+				continue
+			}
+			stream.update(appIds)
+		case <-done:
+			return
+		}
+	}
+}
+
+// handlerProvider serves streams as server-sent events or newline delimited
+// JSON instead of over a websocket when the client accepts them, and any
+// endpoint as JSON instead of protobuf when the client asks for it.
+func (proxy *Proxy) handlerProvider(request *http.Request, dopplerEndpoint doppler_endpoint.DopplerEndpoint) doppler_endpoint.HandlerProvider {
+	if dopplerEndpoint.Endpoint == "stream" || dopplerEndpoint.Endpoint == FIREHOSE_ID {
+		format, ok := doppler_endpoint.StreamFormatFromAccept(request.Header.Get("Accept"))
+		if ok {
+			return doppler_endpoint.StreamingHandlerProvider(format, proxy.jsonOf)
+		}
+	}
+
+	if doppler_endpoint.WantsJson(request) {
+		return doppler_endpoint.JsonHandlerProvider(dopplerEndpoint.Endpoint, proxy.jsonOf)
+	}
+	return dopplerEndpoint.HProvider
+}
+
 func (proxy *Proxy) isAuthorized(authorizer Authorizer, appId, authToken string, clientAddress string) (bool, *logmessage.LogMessage) {
 	newLogMessage := func(message []byte) *logmessage.LogMessage {
 		currentTime := time.Now()
@@ -227,3 +412,46 @@ type TrafficControllerMonitor struct {
 func (hm TrafficControllerMonitor) Ok() bool {
 	return true
 }
+
+func (proxy *Proxy) countRequest(endpointName string) {
+	switch endpointName {
+	case "firehose", "apps", "spaces", "orgs", "set-cookie":
+	default:
+		endpointName = "unknown"
+	}
+
+	proxy.requestsLock.Lock()
+	defer proxy.requestsLock.Unlock()
+	proxy.requests[endpointName]++
+}
+
+// Emit tags the metrics with the domain of the proxy, as the trafficcontroller
+// runs one proxy per domain.
+func (proxy *Proxy) Emit() instrumentation.Context {
+	tags := map[string]interface{}{"domain": proxy.cookieDomain}
+	data := []instrumentation.Metric{
+		instrumentation.Metric{Name: "openConnections", Value: atomic.LoadInt64(&proxy.openConnections), Tags: tags},
+	}
+
+	proxy.requestsLock.Lock()
+	defer proxy.requestsLock.Unlock()
+
+	endpointNames := make([]string, 0, len(proxy.requests))
+	for endpointName := range proxy.requests {
+		endpointNames = append(endpointNames, endpointName)
+	}
+	sort.Strings(endpointNames)
+
+	for _, endpointName := range endpointNames {
+		data = append(data, instrumentation.Metric{
+			Name:  "requests",
+			Value: proxy.requests[endpointName],
+			Tags:  map[string]interface{}{"domain": proxy.cookieDomain, "endpoint": endpointName},
+		})
+	}
+
+	return instrumentation.Context{
+		Name:    "dopplerProxy",
+		Metrics: data,
+	}
+}
@@ -1,17 +1,25 @@
 package dopplerproxy_test
 
 import (
+	"encoding/json"
+	"fmt"
 	"trafficcontroller/dopplerproxy"
 
+	"github.com/cloudfoundry/dropsonde/emitter"
+	"github.com/cloudfoundry/dropsonde/events"
+	"github.com/cloudfoundry/dropsonde/factories"
 	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
 	"github.com/cloudfoundry/loggregatorlib/server/handlers"
+	"github.com/gogo/protobuf/proto"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"sync"
 	"time"
 	"trafficcontroller/doppler_endpoint"
+	"trafficcontroller/marshaller"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -36,7 +44,11 @@ var _ = Describe("ServeHTTP", func() {
 			auth.Authorize,
 			adminAuth.Authorize,
 			channelGroupConnector,
+			&fakeAppLister{},
+			dopplerproxy.AppGroupOptions{MaxApps: 100, RefreshInterval: 30 * time.Second},
 			dopplerproxy.TranslateFromDropsondePath,
+			marshaller.DropsondeTimestamp,
+			marshaller.DropsondeJson,
 			"cookieDomain",
 			loggertesthelper.Logger(),
 		)
@@ -181,6 +193,135 @@ var _ = Describe("ServeHTTP", func() {
 			Expect(responseBody).To(ContainSubstring("goodbye"))
 		})
 
+		Context("with a recent logs query", func() {
+			It("passes the query through to doppler", func() {
+				close(channelGroupConnector.messages)
+				req, _ := http.NewRequest("GET", "/apps/abc123/recentlogs?start_time=10&limit=2", nil)
+				req.Header.Add("Authorization", "token")
+
+				proxy.ServeHTTP(recorder, req)
+
+				Eventually(channelGroupConnector.getQuery).Should(Equal(doppler_endpoint.RecentLogsQuery{StartTime: 10, Limit: 2}))
+			})
+
+			It("returns the merged messages and a cursor", func() {
+				for _, timestamp := range []int64{30, 10, 20} {
+					envelope, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, fmt.Sprintf("message at %d", timestamp), "abc123", "App"), "origin")
+					envelope.Timestamp = proto.Int64(timestamp)
+					bytes, _ := proto.Marshal(envelope)
+					channelGroupConnector.messages <- bytes
+				}
+				close(channelGroupConnector.messages)
+
+				req, _ := http.NewRequest("GET", "/apps/abc123/recentlogs?limit=2", nil)
+				req.Header.Add("Authorization", "token")
+
+				proxy.ServeHTTP(recorder, req)
+
+				responseBody := recorder.Body.String()
+				Expect(responseBody).To(ContainSubstring("message at 10"))
+				Expect(responseBody).To(ContainSubstring("message at 20"))
+				Expect(responseBody).NotTo(ContainSubstring("message at 30"))
+				Expect(strings.Index(responseBody, "message at 10")).To(BeNumerically("<", strings.Index(responseBody, "message at 20")))
+				Expect(recorder.Header().Get(doppler_endpoint.RecentLogsCursorHeader)).NotTo(BeEmpty())
+			})
+
+			It("returns a 400 for an invalid query", func() {
+				req, _ := http.NewRequest("GET", "/apps/abc123/recentlogs?limit=all", nil)
+				req.Header.Add("Authorization", "token")
+
+				proxy.ServeHTTP(recorder, req)
+
+				Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+				Consistently(channelGroupConnector.getPath).Should(Equal(""))
+			})
+		})
+
+		Context("with a filter", func() {
+			It("passes the filter through to doppler for streams", func() {
+				req, _ := http.NewRequest("GET", "/apps/abc123/stream?message_type=ERR&source_type=App", nil)
+				req.Header.Add("Authorization", "token")
+
+				proxy.ServeHTTP(recorder, req)
+
+				Eventually(channelGroupConnector.getFilter).Should(Equal(url.Values{"message_type": {"ERR"}, "source_type": {"App"}}))
+			})
+
+			It("returns a 400 for an invalid filter", func() {
+				req, _ := http.NewRequest("GET", "/apps/abc123/stream?event_type=Bogus", nil)
+				req.Header.Add("Authorization", "token")
+
+				proxy.ServeHTTP(recorder, req)
+
+				Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+				Consistently(channelGroupConnector.getPath).Should(Equal(""))
+			})
+		})
+
+		It("streams server-sent events when the client accepts them", func() {
+			envelope, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, "hello", "abc123", "App"), "origin")
+			bytes, _ := proto.Marshal(envelope)
+			channelGroupConnector.messages <- bytes
+			close(channelGroupConnector.messages)
+
+			req, _ := http.NewRequest("GET", "/apps/abc123/stream", nil)
+			req.Header.Add("Authorization", "token")
+			req.Header.Add("Accept", "text/event-stream")
+
+			proxy.ServeHTTP(recorder, req)
+
+			Expect(recorder.Header().Get("Content-Type")).To(Equal("text/event-stream"))
+			Expect(recorder.Body.String()).To(HavePrefix(`data: {"origin":"origin"`))
+			Expect(recorder.Body.String()).To(HaveSuffix("}\n\n"))
+		})
+
+		It("returns recent logs as a JSON array when asked for JSON", func() {
+			for _, message := range []string{"first", "second"} {
+				envelope, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, message, "abc123", "App"), "origin")
+				bytes, _ := proto.Marshal(envelope)
+				channelGroupConnector.messages <- bytes
+			}
+			close(channelGroupConnector.messages)
+
+			req, _ := http.NewRequest("GET", "/apps/abc123/recentlogs?format=json", nil)
+			req.Header.Add("Authorization", "token")
+
+			proxy.ServeHTTP(recorder, req)
+
+			Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+			var rendered []map[string]interface{}
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &rendered)).To(Succeed())
+			Expect(rendered).To(HaveLen(2))
+			Expect(rendered[0]["eventType"]).To(Equal("LogMessage"))
+		})
+
+		It("returns container metrics as a JSON array when the client accepts JSON", func() {
+			envelope := &events.Envelope{
+				Origin:    proto.String("origin"),
+				EventType: events.Envelope_ContainerMetric.Enum(),
+				Timestamp: proto.Int64(1),
+				ContainerMetric: &events.ContainerMetric{
+					ApplicationId: proto.String("abc123"),
+					InstanceIndex: proto.Int32(0),
+					CpuPercentage: proto.Float64(1.5),
+					MemoryBytes:   proto.Uint64(1024),
+					DiskBytes:     proto.Uint64(2048),
+				},
+			}
+			bytes, _ := proto.Marshal(envelope)
+			channelGroupConnector.messages <- bytes
+			close(channelGroupConnector.messages)
+
+			req, _ := http.NewRequest("GET", "/apps/abc123/containermetrics", nil)
+			req.Header.Add("Authorization", "token")
+			req.Header.Add("Accept", "application/json")
+
+			proxy.ServeHTTP(recorder, req)
+
+			Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+			Expect(recorder.Body.String()).To(HavePrefix(`[{"origin":"origin","eventType":"ContainerMetric"`))
+		})
+
 		It("stops the connector when the handler finishes", func() {
 			req, _ := http.NewRequest("GET", "/apps/abc123/stream", nil)
 			req.Header.Add("Authorization", "token")
@@ -204,6 +345,35 @@ var _ = Describe("ServeHTTP", func() {
 				Eventually(channelGroupConnector.getReconnect).Should(BeTrue())
 			})
 
+			It("passes the filter through to doppler", func() {
+				req, _ := http.NewRequest("GET", "/firehose/abc-123?event_type=ContainerMetric&event_type=ValueMetric", nil)
+				req.Header.Add("Authorization", "token")
+
+				proxy.ServeHTTP(recorder, req)
+
+				Eventually(channelGroupConnector.getFilter).Should(Equal(url.Values{"event_type": {"ContainerMetric", "ValueMetric"}}))
+			})
+
+			It("streams newline delimited JSON when the client accepts it", func() {
+				for _, message := range []string{"first", "second"} {
+					envelope, _ := emitter.Wrap(factories.NewLogMessage(events.LogMessage_OUT, message, "abc123", "App"), "origin")
+					bytes, _ := proto.Marshal(envelope)
+					channelGroupConnector.messages <- bytes
+				}
+				close(channelGroupConnector.messages)
+
+				req, _ := http.NewRequest("GET", "/firehose/abc-123", nil)
+				req.Header.Add("Authorization", "token")
+				req.Header.Add("Accept", "application/x-ndjson")
+
+				proxy.ServeHTTP(recorder, req)
+
+				Expect(recorder.Header().Get("Content-Type")).To(Equal("application/x-ndjson"))
+				lines := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")
+				Expect(lines).To(HaveLen(2))
+				Expect(lines[0]).To(HavePrefix(`{"origin":"origin"`))
+			})
+
 			It("returns an unauthorized status and sets the WWW-Authenticate header if authorization fails", func() {
 				adminAuth.Result = AuthorizerResult{Authorized: false, ErrorMessage: "Error: Invalid authorization"}
 
@@ -371,6 +541,29 @@ var _ = Describe("ServeHTTP", func() {
 			Expect(recorder.Header().Get("Access-Control-Allow-Credentials")).To(Equal("true"))
 		})
 	})
+
+	Context("Emit", func() {
+		It("counts the requests per endpoint", func() {
+			close(channelGroupConnector.messages)
+			for _, path := range []string{"/apps/abc123/recentlogs", "/firehose/subscription", "/firehose/subscription", "/notApps"} {
+				req, _ := http.NewRequest("GET", path, nil)
+				proxy.ServeHTTP(httptest.NewRecorder(), req)
+			}
+
+			context := proxy.Emit()
+			Expect(context.Name).To(Equal("dopplerProxy"))
+			Expect(context.Metrics).To(HaveLen(4))
+			Expect(context.Metrics[0].Name).To(Equal("openConnections"))
+			Expect(context.Metrics[0].Tags).To(Equal(map[string]interface{}{"domain": "cookieDomain"}))
+
+			Expect(context.Metrics[1].Name).To(Equal("requests"))
+			Expect(context.Metrics[1].Value).To(Equal(uint64(1)))
+			Expect(context.Metrics[1].Tags).To(Equal(map[string]interface{}{"domain": "cookieDomain", "endpoint": "apps"}))
+			Expect(context.Metrics[2].Value).To(Equal(uint64(2)))
+			Expect(context.Metrics[2].Tags).To(Equal(map[string]interface{}{"domain": "cookieDomain", "endpoint": "firehose"}))
+			Expect(context.Metrics[3].Tags).To(Equal(map[string]interface{}{"domain": "cookieDomain", "endpoint": "unknown"}))
+		})
+	})
 })
 
 var _ = Describe("DefaultHandlerProvider", func() {
@@ -439,6 +632,18 @@ func (f *fakeChannelGroupConnector) getStreamId() string {
 	return f.dopplerEndpoint.StreamId
 }
 
+func (f *fakeChannelGroupConnector) getQuery() doppler_endpoint.RecentLogsQuery {
+	f.Lock()
+	defer f.Unlock()
+	return f.dopplerEndpoint.Query
+}
+
+func (f *fakeChannelGroupConnector) getFilter() url.Values {
+	f.Lock()
+	defer f.Unlock()
+	return f.dopplerEndpoint.Filter
+}
+
 func (f *fakeChannelGroupConnector) getReconnect() bool {
 	f.Lock()
 	defer f.Unlock()
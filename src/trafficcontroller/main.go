@@ -11,11 +11,13 @@ import (
 	"strconv"
 	"time"
 	"trafficcontroller/authorization"
+	"trafficcontroller/cc_client"
 
 	"github.com/cloudfoundry/dropsonde"
 	"github.com/cloudfoundry/gosteno"
 	"github.com/cloudfoundry/gunk/workpool"
 	"github.com/cloudfoundry/loggregatorlib/cfcomponent"
+	"github.com/cloudfoundry/loggregatorlib/cfcomponent/instrumentation"
 	"github.com/cloudfoundry/loggregatorlib/cfcomponent/registrars/routerregistrar"
 	"github.com/cloudfoundry/loggregatorlib/servicediscovery"
 	"github.com/cloudfoundry/storeadapter"
@@ -23,7 +25,9 @@ import (
 	"github.com/cloudfoundry/yagnats"
 	"github.com/cloudfoundry/yagnats/fakeyagnats"
 	"github.com/pivotal-golang/localip"
+	"prometheus_exporter"
 	"trafficcontroller/channel_group_connector"
+	"trafficcontroller/doppler_endpoint"
 	"trafficcontroller/dopplerproxy"
 	"trafficcontroller/listener"
 	"trafficcontroller/marshaller"
@@ -59,6 +63,17 @@ type Config struct {
 	UaaHost               string
 	UaaClientId           string
 	UaaClientSecret       string
+	PrometheusMetricsPort uint32
+	PrometheusMetricsHost string
+
+	AuthorizationCacheMaxEntries         int
+	AuthorizationCacheTtlSeconds         int
+	AuthorizationCacheNegativeTtlSeconds int
+
+	UaaTokenKeysRefreshIntervalSeconds int
+
+	AppGroupMaxApps                int
+	AppGroupRefreshIntervalSeconds int
 }
 
 func (c *Config) setDefaults() {
@@ -69,6 +84,34 @@ func (c *Config) setDefaults() {
 	if c.EtcdMaxConcurrentRequests == 0 {
 		c.EtcdMaxConcurrentRequests = 10
 	}
+
+	if c.PrometheusMetricsHost == "" {
+		c.PrometheusMetricsHost = prometheus_exporter.DefaultHost
+	}
+
+	if c.AuthorizationCacheMaxEntries == 0 {
+		c.AuthorizationCacheMaxEntries = 10000
+	}
+
+	if c.AuthorizationCacheTtlSeconds == 0 {
+		c.AuthorizationCacheTtlSeconds = 30
+	}
+
+	if c.AuthorizationCacheNegativeTtlSeconds == 0 {
+		c.AuthorizationCacheNegativeTtlSeconds = 5
+	}
+
+	if c.UaaTokenKeysRefreshIntervalSeconds == 0 {
+		c.UaaTokenKeysRefreshIntervalSeconds = 600
+	}
+
+	if c.AppGroupMaxApps == 0 {
+		c.AppGroupMaxApps = 100
+	}
+
+	if c.AppGroupRefreshIntervalSeconds == 0 {
+		c.AppGroupRefreshIntervalSeconds = 30
+	}
 }
 
 func (c *Config) validate(logger *gosteno.Logger) (err error) {
@@ -136,12 +179,20 @@ func main() {
 		panic(err)
 	}
 
-	dopplerProxy := makeDopplerProxy(adapter, config, logger)
+	logAuthorizer, adminAuthorizer, authorizationCaches := makeAuthorizers(config, logger)
+
+	dopplerProxy := makeDopplerProxy(adapter, config, logAuthorizer, adminAuthorizer, logger)
 	startOutgoingDopplerProxy(net.JoinHostPort(ipAddress, strconv.FormatUint(uint64(config.OutgoingDropsondePort), 10)), dopplerProxy)
 
-	legacyProxy := makeLegacyProxy(adapter, config, logger)
+	legacyProxy := makeLegacyProxy(adapter, config, logAuthorizer, adminAuthorizer, logger)
 	startOutgoingProxy(net.JoinHostPort(ipAddress, strconv.FormatUint(uint64(config.OutgoingPort), 10)), legacyProxy)
 
+	if config.PrometheusMetricsPort != 0 {
+		instrumentables := append([]instrumentation.Instrumentable{dopplerProxy, legacyProxy}, authorizationCaches...)
+		exporter := prometheus_exporter.New("trafficcontroller", instrumentables)
+		go exporter.ListenAndServe(net.JoinHostPort(config.PrometheusMetricsHost, strconv.FormatUint(uint64(config.PrometheusMetricsPort), 10)), logger)
+	}
+
 	rr := routerregistrar.NewRouterRegistrar(config.MbusClient, logger)
 	uri := "loggregator." + config.SystemDomain
 	err = rr.RegisterWithRouter(ipAddress, config.OutgoingPort, []string{uri})
@@ -212,24 +263,52 @@ func startOutgoingProxy(host string, proxy http.Handler) {
 	}()
 }
 
-func makeDopplerProxy(adapter storeadapter.StoreAdapter, config *Config, logger *gosteno.Logger) *dopplerproxy.Proxy {
-	return makeProxy(adapter, config, logger, marshaller.DropsondeLogMessage, dopplerproxy.TranslateFromDropsondePath, newDropsondeWebsocketListener, "doppler."+config.SystemDomain)
-}
+// makeAuthorizers returns the authorizers shared by both proxies. Their
+// decisions are cached unless access control is disabled. JWTs for the
+// firehose are validated locally with the token keys of UAA.
+func makeAuthorizers(config *Config, logger *gosteno.Logger) (authorization.LogAccessAuthorizer, authorization.AdminAccessAuthorizer, []instrumentation.Instrumentable) {
+	logAuthorizer := authorization.NewLogAccessAuthorizer(*disableAccessControl, config.ApiHost, config.SkipCertVerify)
+
+	if *disableAccessControl {
+		adminAuthorizer := authorization.NewAdminAccessAuthorizer(true, nil)
+		return logAuthorizer, adminAuthorizer, nil
+	}
 
-func makeLegacyProxy(adapter storeadapter.StoreAdapter, config *Config, logger *gosteno.Logger) *dopplerproxy.Proxy {
-	return makeProxy(adapter, config, logger, marshaller.LoggregatorLogMessage, dopplerproxy.TranslateFromLegacyPath, newLegacyWebsocketListener, "loggregator."+config.SystemDomain)
+	checkTokenClient := uaa_client.NewUaaClient(config.UaaHost, config.UaaClientId, config.UaaClientSecret, config.SkipCertVerify)
+	uaaClient := uaa_client.NewJwtUaaClient(config.UaaHost, config.UaaClientId, config.UaaClientSecret, config.SkipCertVerify, "doppler", &checkTokenClient, logger)
+	go uaaClient.RefreshKeysEvery(time.Duration(config.UaaTokenKeysRefreshIntervalSeconds) * time.Second)
+
+	adminAuthorizer := authorization.NewAdminAccessAuthorizer(false, uaaClient)
+
+	ttl := time.Duration(config.AuthorizationCacheTtlSeconds) * time.Second
+	negativeTtl := time.Duration(config.AuthorizationCacheNegativeTtlSeconds) * time.Second
+	logCache := authorization.NewAuthorizationCache("logAccessAuthorizationCache", config.AuthorizationCacheMaxEntries, ttl, negativeTtl)
+	adminCache := authorization.NewAuthorizationCache("adminAccessAuthorizationCache", config.AuthorizationCacheMaxEntries, ttl, negativeTtl)
+
+	return authorization.NewCachingLogAccessAuthorizer(logAuthorizer, logCache),
+		authorization.NewCachingAdminAccessAuthorizer(adminAuthorizer, adminCache),
+		[]instrumentation.Instrumentable{logCache, adminCache}
 }
 
-func makeProxy(adapter storeadapter.StoreAdapter, config *Config, logger *gosteno.Logger, messageGenerator marshaller.MessageGenerator, translator dopplerproxy.RequestTranslator, listenerConstructor channel_group_connector.ListenerConstructor, cookieDomain string) *dopplerproxy.Proxy {
-	logAuthorizer := authorization.NewLogAccessAuthorizer(*disableAccessControl, config.ApiHost, config.SkipCertVerify)
+func makeDopplerProxy(adapter storeadapter.StoreAdapter, config *Config, logAuthorizer authorization.LogAccessAuthorizer, adminAuthorizer authorization.AdminAccessAuthorizer, logger *gosteno.Logger) *dopplerproxy.Proxy {
+	return makeProxy(adapter, config, logAuthorizer, adminAuthorizer, logger, marshaller.DropsondeLogMessage, marshaller.DropsondeTimestamp, marshaller.DropsondeJson, dopplerproxy.TranslateFromDropsondePath, newDropsondeWebsocketListener, "doppler."+config.SystemDomain)
+}
 
-	uaaClient := uaa_client.NewUaaClient(config.UaaHost, config.UaaClientId, config.UaaClientSecret, config.SkipCertVerify)
-	adminAuthorizer := authorization.NewAdminAccessAuthorizer(*disableAccessControl, &uaaClient)
+func makeLegacyProxy(adapter storeadapter.StoreAdapter, config *Config, logAuthorizer authorization.LogAccessAuthorizer, adminAuthorizer authorization.AdminAccessAuthorizer, logger *gosteno.Logger) *dopplerproxy.Proxy {
+	return makeProxy(adapter, config, logAuthorizer, adminAuthorizer, logger, marshaller.LoggregatorLogMessage, marshaller.LoggregatorTimestamp, marshaller.LoggregatorJson, dopplerproxy.TranslateFromLegacyPath, newLegacyWebsocketListener, "loggregator."+config.SystemDomain)
+}
 
+func makeProxy(adapter storeadapter.StoreAdapter, config *Config, logAuthorizer authorization.LogAccessAuthorizer, adminAuthorizer authorization.AdminAccessAuthorizer, logger *gosteno.Logger, messageGenerator marshaller.MessageGenerator, timestampExtractor marshaller.TimestampExtractor, jsonRenderer doppler_endpoint.JsonRenderer, translator dopplerproxy.RequestTranslator, listenerConstructor channel_group_connector.ListenerConstructor, cookieDomain string) *dopplerproxy.Proxy {
 	provider := MakeProvider(adapter, "/healthstatus/doppler", config.DopplerPort, logger)
 	cgc := channel_group_connector.NewChannelGroupConnector(provider, listenerConstructor, messageGenerator, logger)
 
-	return dopplerproxy.NewDopplerProxy(logAuthorizer, adminAuthorizer, cgc, translator, cookieDomain, logger)
+	appLister := cc_client.NewCcClient(config.ApiHost, config.SkipCertVerify)
+	appGroupOptions := dopplerproxy.AppGroupOptions{
+		MaxApps:         config.AppGroupMaxApps,
+		RefreshInterval: time.Duration(config.AppGroupRefreshIntervalSeconds) * time.Second,
+	}
+
+	return dopplerproxy.NewDopplerProxy(logAuthorizer, adminAuthorizer, cgc, appLister, appGroupOptions, translator, timestampExtractor, jsonRenderer, cookieDomain, logger)
 }
 
 func startOutgoingDopplerProxy(host string, proxy http.Handler) {
@@ -0,0 +1,295 @@
+package marshaller
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/cloudfoundry/dropsonde/events"
+	"github.com/cloudfoundry/loggregatorlib/logmessage"
+	"github.com/gogo/protobuf/proto"
+)
+
+// The JSON representation of envelopes follows the JSON mapping of protocol
+// buffers: field names are in lower camel case, enums are rendered by name,
+// bytes as base64 and 64 bit integers as decimal strings, since JavaScript
+// can not represent nanosecond timestamps as numbers. UUIDs are rendered in
+// their canonical form. Fields that are not set are left out.
+
+type jsonEnvelope struct {
+	Origin          string               `json:"origin"`
+	EventType       string               `json:"eventType"`
+	Timestamp       *int64               `json:"timestamp,omitempty,string"`
+	Deployment      *string              `json:"deployment,omitempty"`
+	Job             *string              `json:"job,omitempty"`
+	Index           *string              `json:"index,omitempty"`
+	Ip              *string              `json:"ip,omitempty"`
+	Heartbeat       *jsonHeartbeat       `json:"heartbeat,omitempty"`
+	HttpStart       *jsonHttpStart       `json:"httpStart,omitempty"`
+	HttpStop        *jsonHttpStop        `json:"httpStop,omitempty"`
+	HttpStartStop   *jsonHttpStartStop   `json:"httpStartStop,omitempty"`
+	LogMessage      *jsonLogMessage      `json:"logMessage,omitempty"`
+	ValueMetric     *jsonValueMetric     `json:"valueMetric,omitempty"`
+	CounterEvent    *jsonCounterEvent    `json:"counterEvent,omitempty"`
+	Error           *jsonError           `json:"error,omitempty"`
+	ContainerMetric *jsonContainerMetric `json:"containerMetric,omitempty"`
+}
+
+type jsonHeartbeat struct {
+	SentCount                *uint64 `json:"sentCount,omitempty,string"`
+	ReceivedCount            *uint64 `json:"receivedCount,omitempty,string"`
+	ErrorCount               *uint64 `json:"errorCount,omitempty,string"`
+	ControlMessageIdentifier *string `json:"controlMessageIdentifier,omitempty"`
+}
+
+type jsonHttpStart struct {
+	Timestamp       *int64  `json:"timestamp,omitempty,string"`
+	RequestId       *string `json:"requestId,omitempty"`
+	PeerType        *string `json:"peerType,omitempty"`
+	Method          *string `json:"method,omitempty"`
+	Uri             *string `json:"uri,omitempty"`
+	RemoteAddress   *string `json:"remoteAddress,omitempty"`
+	UserAgent       *string `json:"userAgent,omitempty"`
+	ParentRequestId *string `json:"parentRequestId,omitempty"`
+	ApplicationId   *string `json:"applicationId,omitempty"`
+	InstanceIndex   *int32  `json:"instanceIndex,omitempty"`
+	InstanceId      *string `json:"instanceId,omitempty"`
+}
+
+type jsonHttpStop struct {
+	Timestamp     *int64  `json:"timestamp,omitempty,string"`
+	Uri           *string `json:"uri,omitempty"`
+	RequestId     *string `json:"requestId,omitempty"`
+	PeerType      *string `json:"peerType,omitempty"`
+	StatusCode    *int32  `json:"statusCode,omitempty"`
+	ContentLength *int64  `json:"contentLength,omitempty,string"`
+	ApplicationId *string `json:"applicationId,omitempty"`
+}
+
+type jsonHttpStartStop struct {
+	StartTimestamp  *int64  `json:"startTimestamp,omitempty,string"`
+	StopTimestamp   *int64  `json:"stopTimestamp,omitempty,string"`
+	RequestId       *string `json:"requestId,omitempty"`
+	PeerType        *string `json:"peerType,omitempty"`
+	Method          *string `json:"method,omitempty"`
+	Uri             *string `json:"uri,omitempty"`
+	RemoteAddress   *string `json:"remoteAddress,omitempty"`
+	UserAgent       *string `json:"userAgent,omitempty"`
+	StatusCode      *int32  `json:"statusCode,omitempty"`
+	ContentLength   *int64  `json:"contentLength,omitempty,string"`
+	ParentRequestId *string `json:"parentRequestId,omitempty"`
+	ApplicationId   *string `json:"applicationId,omitempty"`
+	InstanceIndex   *int32  `json:"instanceIndex,omitempty"`
+	InstanceId      *string `json:"instanceId,omitempty"`
+}
+
+type jsonLogMessage struct {
+	Message        []byte  `json:"message"`
+	MessageType    string  `json:"messageType"`
+	Timestamp      *int64  `json:"timestamp,omitempty,string"`
+	AppId          *string `json:"appId,omitempty"`
+	SourceType     *string `json:"sourceType,omitempty"`
+	SourceInstance *string `json:"sourceInstance,omitempty"`
+}
+
+type jsonValueMetric struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+type jsonCounterEvent struct {
+	Name  string  `json:"name"`
+	Delta uint64  `json:"delta,string"`
+	Total *uint64 `json:"total,omitempty,string"`
+}
+
+type jsonError struct {
+	Source  string `json:"source"`
+	Code    int32  `json:"code"`
+	Message string `json:"message"`
+}
+
+type jsonContainerMetric struct {
+	ApplicationId string  `json:"applicationId"`
+	InstanceIndex int32   `json:"instanceIndex"`
+	CpuPercentage float64 `json:"cpuPercentage"`
+	MemoryBytes   uint64  `json:"memoryBytes,string"`
+	DiskBytes     uint64  `json:"diskBytes,string"`
+}
+
+type jsonLegacyLogMessage struct {
+	Message     []byte  `json:"message"`
+	MessageType string  `json:"messageType"`
+	Timestamp   int64   `json:"timestamp,string"`
+	AppId       string  `json:"appId"`
+	SourceName  *string `json:"sourceName,omitempty"`
+	SourceId    *string `json:"sourceId,omitempty"`
+}
+
+// DropsondeJson renders a marshalled envelope as JSON.
+func DropsondeJson(message []byte) ([]byte, error) {
+	var envelope events.Envelope
+	err := proto.Unmarshal(message, &envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(envelopeJson(&envelope))
+}
+
+// LoggregatorJson renders a marshalled legacy log message as JSON, with the
+// same field names as the log messages of envelopes.
+func LoggregatorJson(message []byte) ([]byte, error) {
+	var logMessage logmessage.LogMessage
+	err := proto.Unmarshal(message, &logMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(jsonLegacyLogMessage{
+		Message:     logMessage.GetMessage(),
+		MessageType: logMessage.GetMessageType().String(),
+		Timestamp:   logMessage.GetTimestamp(),
+		AppId:       logMessage.GetAppId(),
+		SourceName:  logMessage.SourceName,
+		SourceId:    logMessage.SourceId,
+	})
+}
+
+func envelopeJson(envelope *events.Envelope) jsonEnvelope {
+	rendered := jsonEnvelope{
+		Origin:     envelope.GetOrigin(),
+		EventType:  envelope.GetEventType().String(),
+		Timestamp:  envelope.Timestamp,
+		Deployment: envelope.Deployment,
+		Job:        envelope.Job,
+		Index:      envelope.Index,
+		Ip:         envelope.Ip,
+	}
+
+	if heartbeat := envelope.GetHeartbeat(); heartbeat != nil {
+		rendered.Heartbeat = &jsonHeartbeat{
+			SentCount:                heartbeat.SentCount,
+			ReceivedCount:            heartbeat.ReceivedCount,
+			ErrorCount:               heartbeat.ErrorCount,
+			ControlMessageIdentifier: uuidJson(heartbeat.GetControlMessageIdentifier()),
+		}
+	}
+
+	if start := envelope.GetHttpStart(); start != nil {
+		rendered.HttpStart = &jsonHttpStart{
+			Timestamp:       start.Timestamp,
+			RequestId:       uuidJson(start.GetRequestId()),
+			PeerType:        enumJson(start.PeerType != nil, start.GetPeerType().String()),
+			Method:          enumJson(start.Method != nil, start.GetMethod().String()),
+			Uri:             start.Uri,
+			RemoteAddress:   start.RemoteAddress,
+			UserAgent:       start.UserAgent,
+			ParentRequestId: uuidJson(start.GetParentRequestId()),
+			ApplicationId:   uuidJson(start.GetApplicationId()),
+			InstanceIndex:   start.InstanceIndex,
+			InstanceId:      start.InstanceId,
+		}
+	}
+
+	if stop := envelope.GetHttpStop(); stop != nil {
+		rendered.HttpStop = &jsonHttpStop{
+			Timestamp:     stop.Timestamp,
+			Uri:           stop.Uri,
+			RequestId:     uuidJson(stop.GetRequestId()),
+			PeerType:      enumJson(stop.PeerType != nil, stop.GetPeerType().String()),
+			StatusCode:    stop.StatusCode,
+			ContentLength: stop.ContentLength,
+			ApplicationId: uuidJson(stop.GetApplicationId()),
+		}
+	}
+
+	if startStop := envelope.GetHttpStartStop(); startStop != nil {
+		rendered.HttpStartStop = &jsonHttpStartStop{
+			StartTimestamp:  startStop.StartTimestamp,
+			StopTimestamp:   startStop.StopTimestamp,
+			RequestId:       uuidJson(startStop.GetRequestId()),
+			PeerType:        enumJson(startStop.PeerType != nil, startStop.GetPeerType().String()),
+			Method:          enumJson(startStop.Method != nil, startStop.GetMethod().String()),
+			Uri:             startStop.Uri,
+			RemoteAddress:   startStop.RemoteAddress,
+			UserAgent:       startStop.UserAgent,
+			StatusCode:      startStop.StatusCode,
+			ContentLength:   startStop.ContentLength,
+			ParentRequestId: uuidJson(startStop.GetParentRequestId()),
+			ApplicationId:   uuidJson(startStop.GetApplicationId()),
+			InstanceIndex:   startStop.InstanceIndex,
+			InstanceId:      startStop.InstanceId,
+		}
+	}
+
+	if logMessage := envelope.GetLogMessage(); logMessage != nil {
+		rendered.LogMessage = &jsonLogMessage{
+			Message:        logMessage.GetMessage(),
+			MessageType:    logMessage.GetMessageType().String(),
+			Timestamp:      logMessage.Timestamp,
+			AppId:          logMessage.AppId,
+			SourceType:     logMessage.SourceType,
+			SourceInstance: logMessage.SourceInstance,
+		}
+	}
+
+	if valueMetric := envelope.GetValueMetric(); valueMetric != nil {
+		rendered.ValueMetric = &jsonValueMetric{
+			Name:  valueMetric.GetName(),
+			Value: valueMetric.GetValue(),
+			Unit:  valueMetric.GetUnit(),
+		}
+	}
+
+	if counterEvent := envelope.GetCounterEvent(); counterEvent != nil {
+		rendered.CounterEvent = &jsonCounterEvent{
+			Name:  counterEvent.GetName(),
+			Delta: counterEvent.GetDelta(),
+			Total: counterEvent.Total,
+		}
+	}
+
+	if errorEvent := envelope.GetError(); errorEvent != nil {
+		rendered.Error = &jsonError{
+			Source:  errorEvent.GetSource(),
+			Code:    errorEvent.GetCode(),
+			Message: errorEvent.GetMessage(),
+		}
+	}
+
+	if containerMetric := envelope.GetContainerMetric(); containerMetric != nil {
+		rendered.ContainerMetric = &jsonContainerMetric{
+			ApplicationId: containerMetric.GetApplicationId(),
+			InstanceIndex: containerMetric.GetInstanceIndex(),
+			CpuPercentage: containerMetric.GetCpuPercentage(),
+			MemoryBytes:   containerMetric.GetMemoryBytes(),
+			DiskBytes:     containerMetric.GetDiskBytes(),
+		}
+	}
+
+	return rendered
+}
+
+// uuidJson formats a UUID the way dropsonde splits it, the first 8 bytes
+// in Low and the last 8 in High, both little endian.
+func uuidJson(uuid *events.UUID) *string {
+	if uuid == nil {
+		return nil
+	}
+
+	var b [16]byte
+	binary.LittleEndian.PutUint64(b[:8], uuid.GetLow())
+	binary.LittleEndian.PutUint64(b[8:], uuid.GetHigh())
+
+	formatted := fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+	return &formatted
+}
+
+func enumJson(set bool, name string) *string {
+	if !set {
+		return nil
+	}
+	return &name
+}
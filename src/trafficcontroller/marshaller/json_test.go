@@ -0,0 +1,139 @@
+package marshaller_test
+
+import (
+	"trafficcontroller/marshaller"
+
+	"github.com/cloudfoundry/dropsonde/events"
+	"github.com/cloudfoundry/loggregatorlib/logmessage"
+	"github.com/gogo/protobuf/proto"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DropsondeJson", func() {
+	render := func(envelope *events.Envelope) string {
+		message, err := proto.Marshal(envelope)
+		Expect(err).NotTo(HaveOccurred())
+
+		data, err := marshaller.DropsondeJson(message)
+		Expect(err).NotTo(HaveOccurred())
+		return string(data)
+	}
+
+	It("renders log messages", func() {
+		envelope := &events.Envelope{
+			Origin:     proto.String("origin"),
+			EventType:  events.Envelope_LogMessage.Enum(),
+			Timestamp:  proto.Int64(1444000000000000001),
+			Deployment: proto.String("cf"),
+			LogMessage: &events.LogMessage{
+				Message:        []byte("hello"),
+				MessageType:    events.LogMessage_ERR.Enum(),
+				Timestamp:      proto.Int64(1234),
+				AppId:          proto.String("abc123"),
+				SourceType:     proto.String("App"),
+				SourceInstance: proto.String("0"),
+			},
+		}
+
+		Expect(render(envelope)).To(MatchJSON(`{
+			"origin": "origin",
+			"eventType": "LogMessage",
+			"timestamp": "1444000000000000001",
+			"deployment": "cf",
+			"logMessage": {
+				"message": "aGVsbG8=",
+				"messageType": "ERR",
+				"timestamp": "1234",
+				"appId": "abc123",
+				"sourceType": "App",
+				"sourceInstance": "0"
+			}
+		}`))
+	})
+
+	It("renders HTTP start stop events with canonical UUIDs", func() {
+		envelope := &events.Envelope{
+			Origin:    proto.String("router"),
+			EventType: events.Envelope_HttpStartStop.Enum(),
+			HttpStartStop: &events.HttpStartStop{
+				StartTimestamp: proto.Int64(1),
+				StopTimestamp:  proto.Int64(100),
+				RequestId: &events.UUID{
+					Low:  proto.Uint64(0x0807060504030201),
+					High: proto.Uint64(0x100f0e0d0c0b0a09),
+				},
+				PeerType:      events.PeerType_Client.Enum(),
+				Method:        events.Method_GET.Enum(),
+				Uri:           proto.String("/v2/info"),
+				StatusCode:    proto.Int32(200),
+				ContentLength: proto.Int64(42),
+			},
+		}
+
+		Expect(render(envelope)).To(MatchJSON(`{
+			"origin": "router",
+			"eventType": "HttpStartStop",
+			"httpStartStop": {
+				"startTimestamp": "1",
+				"stopTimestamp": "100",
+				"requestId": "01020304-0506-0708-090a-0b0c0d0e0f10",
+				"peerType": "Client",
+				"method": "GET",
+				"uri": "/v2/info",
+				"statusCode": 200,
+				"contentLength": "42"
+			}
+		}`))
+	})
+
+	It("renders metrics", func() {
+		Expect(render(&events.Envelope{
+			Origin:      proto.String("origin"),
+			EventType:   events.Envelope_ValueMetric.Enum(),
+			ValueMetric: &events.ValueMetric{Name: proto.String("latency"), Value: proto.Float64(1.5), Unit: proto.String("ms")},
+		})).To(MatchJSON(`{"origin":"origin","eventType":"ValueMetric","valueMetric":{"name":"latency","value":1.5,"unit":"ms"}}`))
+
+		Expect(render(&events.Envelope{
+			Origin:       proto.String("origin"),
+			EventType:    events.Envelope_CounterEvent.Enum(),
+			CounterEvent: &events.CounterEvent{Name: proto.String("requests"), Delta: proto.Uint64(1), Total: proto.Uint64(10)},
+		})).To(MatchJSON(`{"origin":"origin","eventType":"CounterEvent","counterEvent":{"name":"requests","delta":"1","total":"10"}}`))
+
+		Expect(render(&events.Envelope{
+			Origin:    proto.String("origin"),
+			EventType: events.Envelope_ContainerMetric.Enum(),
+			ContainerMetric: &events.ContainerMetric{
+				ApplicationId: proto.String("abc123"),
+				InstanceIndex: proto.Int32(1),
+				CpuPercentage: proto.Float64(12.5),
+				MemoryBytes:   proto.Uint64(1024),
+				DiskBytes:     proto.Uint64(2048),
+			},
+		})).To(MatchJSON(`{"origin":"origin","eventType":"ContainerMetric","containerMetric":{"applicationId":"abc123","instanceIndex":1,"cpuPercentage":12.5,"memoryBytes":"1024","diskBytes":"2048"}}`))
+	})
+
+	It("returns an error for invalid messages", func() {
+		_, err := marshaller.DropsondeJson([]byte{1, 2, 3})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("LoggregatorJson", func() {
+	It("renders the log message with the field names of envelopes", func() {
+		logMessage := &logmessage.LogMessage{
+			Message:     []byte("hello"),
+			MessageType: logmessage.LogMessage_OUT.Enum(),
+			Timestamp:   proto.Int64(1234),
+			AppId:       proto.String("abc123"),
+			SourceName:  proto.String("App"),
+		}
+		msg, _ := proto.Marshal(logMessage)
+
+		data, err := marshaller.LoggregatorJson(msg)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(data).To(MatchJSON(`{"message":"aGVsbG8=","messageType":"OUT","timestamp":"1234","appId":"abc123","sourceName":"App"}`))
+	})
+})
@@ -39,3 +39,25 @@ func DropsondeLogMessage(messageString string, appId string) []byte {
 	msg, _ := proto.Marshal(envelope)
 	return msg
 }
+
+type TimestampExtractor func([]byte) (int64, error)
+
+func DropsondeTimestamp(message []byte) (int64, error) {
+	var envelope events.Envelope
+	err := proto.Unmarshal(message, &envelope)
+	if err != nil {
+		return 0, err
+	}
+
+	return envelope.GetTimestamp(), nil
+}
+
+func LoggregatorTimestamp(message []byte) (int64, error) {
+	var logMessage logmessage.LogMessage
+	err := proto.Unmarshal(message, &logMessage)
+	if err != nil {
+		return 0, err
+	}
+
+	return logMessage.GetTimestamp(), nil
+}
@@ -45,3 +45,39 @@ var _ = Describe("DropsondeLogMessage", func() {
 		Expect(time.Unix(0, logMessage.GetTimestamp())).To(BeTemporally("~", time.Now(), time.Second))
 	})
 })
+
+var _ = Describe("DropsondeTimestamp", func() {
+	It("returns the timestamp of the envelope", func() {
+		envelope := &events.Envelope{
+			Origin:    proto.String("origin"),
+			EventType: events.Envelope_LogMessage.Enum(),
+			Timestamp: proto.Int64(1234),
+		}
+		msg, _ := proto.Marshal(envelope)
+
+		timestamp, err := marshaller.DropsondeTimestamp(msg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(timestamp).To(BeEquivalentTo(1234))
+	})
+
+	It("returns an error for invalid messages", func() {
+		_, err := marshaller.DropsondeTimestamp([]byte{1, 2, 3})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("LoggregatorTimestamp", func() {
+	It("returns the timestamp of the log message", func() {
+		logMessage := &logmessage.LogMessage{
+			Message:     []byte("hello"),
+			MessageType: logmessage.LogMessage_OUT.Enum(),
+			Timestamp:   proto.Int64(1234),
+			AppId:       proto.String("abc123"),
+		}
+		msg, _ := proto.Marshal(logMessage)
+
+		timestamp, err := marshaller.LoggregatorTimestamp(msg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(timestamp).To(BeEquivalentTo(1234))
+	})
+})
@@ -0,0 +1,326 @@
+package uaa_client
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry/gosteno"
+)
+
+// minKeyRefreshInterval keeps tokens signed with unknown keys from making the
+// client fetch the token keys from UAA over and over.
+const minKeyRefreshInterval = 30 * time.Second
+
+// JwtUaaClient validates JWTs locally against the token signing keys of UAA:
+// their signature, expiry and audience. Tokens it can't check itself are
+// checked by the fallback client, which asks UAA: opaque tokens, tokens not
+// signed with RS256, e.g. by a UAA with a symmetric signing key, and tokens
+// signed with a key that could not be fetched.
+type JwtUaaClient struct {
+	address        string
+	id             string
+	secret         string
+	skipCertVerify bool
+	audience       string
+	fallback       UaaClient
+	logger         *gosteno.Logger
+
+	lock         sync.RWMutex
+	keys         map[string]*rsa.PublicKey
+	lastKeyFetch time.Time
+}
+
+func NewJwtUaaClient(address, id, secret string, skipCertVerify bool, audience string, fallback UaaClient, logger *gosteno.Logger) *JwtUaaClient {
+	return &JwtUaaClient{
+		address:        address,
+		id:             id,
+		secret:         secret,
+		skipCertVerify: skipCertVerify,
+		audience:       audience,
+		fallback:       fallback,
+		logger:         logger,
+		keys:           make(map[string]*rsa.PublicKey),
+	}
+}
+
+// RefreshKeysEvery fetches the token keys now and then every interval, so
+// keys UAA rotated in are known before tokens signed with them show up.
+// The keys fetched last stay in use while UAA can't be reached.
+func (client *JwtUaaClient) RefreshKeysEvery(interval time.Duration) {
+	for {
+		err := client.RefreshKeys()
+		if err != nil {
+			client.logger.Warnf("UAA client: could not fetch token keys, keeping the ones known: %s", err.Error())
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (client *JwtUaaClient) RefreshKeys() error {
+	client.lock.Lock()
+	client.lastKeyFetch = time.Now()
+	client.lock.Unlock()
+
+	return client.refreshKeys()
+}
+
+func (client *JwtUaaClient) refreshKeys() error {
+	keys, err := client.fetchKeys()
+	if err != nil {
+		return err
+	}
+
+	client.lock.Lock()
+	client.keys = keys
+	client.lock.Unlock()
+	return nil
+}
+
+func (client *JwtUaaClient) GetAuthData(token string) (*AuthData, error) {
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return client.fallback.GetAuthData(token)
+	}
+
+	var header jwtHeader
+	err := decodeSegment(segments[0], &header)
+	if err != nil {
+		return client.fallback.GetAuthData(token)
+	}
+
+	if header.Alg != "RS256" {
+		return client.fallback.GetAuthData(token)
+	}
+
+	key, ok := client.key(header.Kid)
+	if !ok {
+		return client.fallback.GetAuthData(token)
+	}
+
+	signature, err := decodeBase64Url(segments[2])
+	if err != nil {
+		return nil, errors.New("Invalid token signature")
+	}
+
+	digest := sha256.Sum256([]byte(segments[0] + "." + segments[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	if err != nil {
+		return nil, errors.New("Invalid token signature")
+	}
+
+	var claims jwtClaims
+	err = decodeSegment(segments[1], &claims)
+	if err != nil {
+		return nil, errors.New("Invalid token claims")
+	}
+
+	if claims.Exp == 0 || time.Now().Unix() >= claims.Exp {
+		return nil, errors.New("Token has expired")
+	}
+
+	if !claims.hasAudience(client.audience) {
+		return nil, fmt.Errorf("Token is not meant for %s", client.audience)
+	}
+
+	return &AuthData{Scope: claims.Scope}, nil
+}
+
+// key returns the key with the given id, fetching the keys again when it is
+// unknown, e.g. because UAA just started signing with a new key. Tokens
+// without a key id are checked against the only key there is.
+func (client *JwtUaaClient) key(kid string) (*rsa.PublicKey, bool) {
+	key, ok := client.knownKey(kid)
+	if ok {
+		return key, true
+	}
+
+	client.lock.Lock()
+	if time.Since(client.lastKeyFetch) < minKeyRefreshInterval {
+		client.lock.Unlock()
+		return nil, false
+	}
+	client.lastKeyFetch = time.Now()
+	client.lock.Unlock()
+
+	err := client.refreshKeys()
+	if err != nil {
+		client.logger.Warnf("UAA client: could not fetch token keys: %s", err.Error())
+		return nil, false
+	}
+	return client.knownKey(kid)
+}
+
+func (client *JwtUaaClient) knownKey(kid string) (*rsa.PublicKey, bool) {
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+
+	if kid == "" && len(client.keys) == 1 {
+		for _, key := range client.keys {
+			return key, true
+		}
+	}
+
+	key, ok := client.keys[kid]
+	return key, ok
+}
+
+func (client *JwtUaaClient) fetchKeys() (map[string]*rsa.PublicKey, error) {
+	req, _ := http.NewRequest("GET", client.address+"/token_keys", nil)
+	req.SetBasicAuth(client.id, client.secret)
+
+	tr := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: client.skipCertVerify},
+	}
+
+	httpClient := &http.Client{Transport: tr}
+	response, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status %d fetching token keys", response.StatusCode)
+	}
+
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var keySet tokenKeySet
+	err = json.Unmarshal(responseBody, &keySet)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, tokenKey := range keySet.Keys {
+		if tokenKey.Kty != "RSA" {
+			continue
+		}
+
+		key, err := tokenKey.publicKey()
+		if err != nil {
+			client.logger.Warnf("UAA client: skipping token key %q: %s", tokenKey.Kid, err.Error())
+			continue
+		}
+		keys[tokenKey.Kid] = key
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("No RSA token keys found")
+	}
+	return keys, nil
+}
+
+type tokenKeySet struct {
+	Keys []tokenKey `json:"keys"`
+}
+
+// tokenKey is a key as UAA lists it, as JSON web key with the PEM encoded
+// key in value.
+type tokenKey struct {
+	Kid   string `json:"kid"`
+	Kty   string `json:"kty"`
+	N     string `json:"n"`
+	E     string `json:"e"`
+	Value string `json:"value"`
+}
+
+func (k tokenKey) publicKey() (*rsa.PublicKey, error) {
+	if k.N != "" && k.E != "" {
+		n, err := decodeBase64Url(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBase64Url(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	}
+
+	block, _ := pem.Decode([]byte(k.Value))
+	if block == nil {
+		return nil, errors.New("no PEM encoded key")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Exp   int64           `json:"exp"`
+	Aud   json.RawMessage `json:"aud"`
+	Scope []string        `json:"scope"`
+}
+
+// hasAudience checks the aud claim, which is either a single audience or a
+// list of them.
+func (c jwtClaims) hasAudience(audience string) bool {
+	var single string
+	if json.Unmarshal(c.Aud, &single) == nil {
+		return single == audience
+	}
+
+	var list []string
+	if json.Unmarshal(c.Aud, &list) != nil {
+		return false
+	}
+
+	for _, aud := range list {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := decodeBase64Url(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// decodeBase64Url decodes the unpadded URL safe base64 of JWTs.
+func decodeBase64Url(s string) ([]byte, error) {
+	if l := len(s) % 4; l > 0 {
+		s += strings.Repeat("=", 4-l)
+	}
+	return base64.URLEncoding.DecodeString(s)
+}
@@ -0,0 +1,236 @@
+package uaa_client_test
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"time"
+	"trafficcontroller/uaa_client"
+
+	"github.com/cloudfoundry/loggregatorlib/loggertesthelper"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JwtUaaClient", func() {
+	var (
+		signingKey    *rsa.PrivateKey
+		keyFetches    int64
+		keyServer     *httptest.Server
+		fallback      *fakeUaaClient
+		client        *uaa_client.JwtUaaClient
+		validClaims   map[string]interface{}
+		keyServerDown int32
+	)
+
+	BeforeEach(func() {
+		var err error
+		signingKey, err = rsa.GenerateKey(rand.Reader, 1024)
+		Expect(err).NotTo(HaveOccurred())
+
+		keyFetches = 0
+		keyServerDown = 0
+		keyServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&keyFetches, 1)
+			if r.URL.Path != "/token_keys" || atomic.LoadInt32(&keyServerDown) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"keys": []map[string]string{{
+					"kid": "key-1",
+					"kty": "RSA",
+					"alg": "RS256",
+					"n":   encodeSegment(signingKey.N.Bytes()),
+					"e":   encodeSegment(big.NewInt(int64(signingKey.E)).Bytes()),
+				}},
+			})
+		}))
+
+		fallback = &fakeUaaClient{}
+		client = uaa_client.NewJwtUaaClient(keyServer.URL, "bob", "yourUncle", true, "doppler", fallback, loggertesthelper.Logger())
+		Expect(client.RefreshKeys()).To(Succeed())
+
+		validClaims = map[string]interface{}{
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"aud":   []string{"doppler", "cloud_controller"},
+			"scope": []string{"doppler.firehose", "cloud_controller.read"},
+		}
+	})
+
+	AfterEach(func() {
+		keyServer.Close()
+	})
+
+	It("validates tokens locally", func() {
+		authData, err := client.GetAuthData(signToken(signingKey, "key-1", validClaims))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(authData.HasPermission("doppler.firehose")).To(BeTrue())
+
+		Expect(fallback.calls).To(Equal(0))
+		Expect(atomic.LoadInt64(&keyFetches)).To(BeEquivalentTo(1))
+	})
+
+	It("keeps validating tokens while UAA is down", func() {
+		atomic.StoreInt32(&keyServerDown, 1)
+		Expect(client.RefreshKeys()).NotTo(Succeed())
+
+		_, err := client.GetAuthData(signToken(signingKey, "key-1", validClaims))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("accepts a single audience", func() {
+		validClaims["aud"] = "doppler"
+
+		_, err := client.GetAuthData(signToken(signingKey, "key-1", validClaims))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("rejects tokens signed with another key", func() {
+		otherKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+
+		_, err := client.GetAuthData(signToken(otherKey, "key-1", validClaims))
+		Expect(err).To(MatchError("Invalid token signature"))
+	})
+
+	It("rejects tokens that were tampered with", func() {
+		segments := strings.Split(signToken(signingKey, "key-1", validClaims), ".")
+		validClaims["scope"] = []string{"doppler.firehose", "uaa.admin"}
+		segments[1] = strings.Split(signToken(signingKey, "key-1", validClaims), ".")[1]
+
+		_, err := client.GetAuthData(strings.Join(segments, "."))
+		Expect(err).To(MatchError("Invalid token signature"))
+	})
+
+	It("rejects expired tokens", func() {
+		validClaims["exp"] = time.Now().Add(-time.Minute).Unix()
+
+		_, err := client.GetAuthData(signToken(signingKey, "key-1", validClaims))
+		Expect(err).To(MatchError("Token has expired"))
+	})
+
+	It("rejects tokens meant for someone else", func() {
+		validClaims["aud"] = []string{"cloud_controller"}
+
+		_, err := client.GetAuthData(signToken(signingKey, "key-1", validClaims))
+		Expect(err).To(MatchError("Token is not meant for doppler"))
+	})
+
+	It("asks UAA about tokens that are not signed with RS256", func() {
+		header := encodeJson(map[string]string{"alg": "none"})
+		token := header + "." + encodeJson(validClaims) + "."
+
+		_, err := client.GetAuthData(token)
+		Expect(err).To(MatchError("Invalid token"))
+		Expect(fallback.calls).To(Equal(1))
+	})
+
+	It("asks UAA about tokens signed with HS256", func() {
+		token := signHS256Token([]byte("uaa-secret"), validClaims)
+		fallback.accepted = token
+
+		authData, err := client.GetAuthData(token)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(authData.HasPermission("doppler.firehose")).To(BeTrue())
+		Expect(fallback.calls).To(Equal(1))
+	})
+
+	It("asks UAA about tokens signed with an unknown key, fetching the keys again but not over and over", func() {
+		token := signToken(signingKey, "key-2", validClaims)
+		fallback.accepted = token
+
+		_, err := client.GetAuthData(token)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fallback.calls).To(Equal(1))
+		Expect(atomic.LoadInt64(&keyFetches)).To(BeEquivalentTo(1))
+	})
+
+	It("asks UAA about all tokens when it lists no RSA keys", func() {
+		symmetricKeyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"keys": []map[string]string{{
+					"kid":   "key-1",
+					"kty":   "MAC",
+					"alg":   "HS256",
+					"value": "uaa-secret",
+				}},
+			})
+		}))
+		defer symmetricKeyServer.Close()
+
+		client = uaa_client.NewJwtUaaClient(symmetricKeyServer.URL, "bob", "yourUncle", true, "doppler", fallback, loggertesthelper.Logger())
+		Expect(client.RefreshKeys()).To(MatchError("No RSA token keys found"))
+
+		token := signHS256Token([]byte("uaa-secret"), validClaims)
+		fallback.accepted = token
+
+		authData, err := client.GetAuthData(token)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(authData.HasPermission("doppler.firehose")).To(BeTrue())
+
+		_, err = client.GetAuthData(signToken(signingKey, "key-1", validClaims))
+		Expect(err).To(MatchError("Invalid token"))
+		Expect(fallback.calls).To(Equal(2))
+	})
+
+	It("asks UAA about opaque tokens", func() {
+		authData, err := client.GetAuthData("iAmAnAdmin")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(authData.HasPermission("doppler.firehose")).To(BeTrue())
+		Expect(fallback.calls).To(Equal(1))
+	})
+})
+
+type fakeUaaClient struct {
+	calls    int
+	accepted string
+}
+
+func (client *fakeUaaClient) GetAuthData(token string) (*uaa_client.AuthData, error) {
+	client.calls++
+	if token != "iAmAnAdmin" && token != client.accepted {
+		return nil, errors.New("Invalid token")
+	}
+	return &uaa_client.AuthData{Scope: []string{"doppler.firehose"}}, nil
+}
+
+func signToken(key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	signed := encodeJson(map[string]string{"alg": "RS256", "kid": kid}) + "." + encodeJson(claims)
+
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	Expect(err).NotTo(HaveOccurred())
+
+	return signed + "." + encodeSegment(signature)
+}
+
+func signHS256Token(secret []byte, claims map[string]interface{}) string {
+	signed := encodeJson(map[string]string{"alg": "HS256"}) + "." + encodeJson(claims)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+
+	return signed + "." + encodeSegment(mac.Sum(nil))
+}
+
+func encodeJson(v interface{}) string {
+	data, err := json.Marshal(v)
+	Expect(err).NotTo(HaveOccurred())
+	return encodeSegment(data)
+}
+
+func encodeSegment(data []byte) string {
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(data), "=")
+}
@@ -14,6 +14,16 @@ type UaaClient interface {
 	GetAuthData(token string) (*AuthData, error)
 }
 
+// UnavailableError is returned when UAA did not decide about a token, e.g.
+// because it could not be reached or did not accept the client credentials.
+type UnavailableError struct {
+	Err error
+}
+
+func (e UnavailableError) Error() string {
+	return e.Err.Error()
+}
+
 type uaaClient struct {
 	address        string
 	id             string
@@ -46,20 +56,21 @@ func (client *uaaClient) GetAuthData(token string) (*AuthData, error) {
 	response, err := httpClient.Do(req)
 
 	if err != nil {
-		return nil, err
+		return nil, UnavailableError{err}
 	}
+	defer response.Body.Close()
 
 	if response.StatusCode == http.StatusUnauthorized {
-		return nil, errors.New("Invalid username/password")
+		return nil, UnavailableError{errors.New("Invalid username/password")}
 	}
 
 	if response.StatusCode == http.StatusNotFound {
-		return nil, errors.New("API endpoint not found")
+		return nil, UnavailableError{errors.New("API endpoint not found")}
 	}
 
 	responseBody, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return nil, err
+		return nil, UnavailableError{err}
 	}
 
 	if response.StatusCode == http.StatusBadRequest {
@@ -69,7 +80,7 @@ func (client *uaaClient) GetAuthData(token string) (*AuthData, error) {
 	}
 
 	if response.StatusCode != http.StatusOK {
-		return nil, errors.New("Unknown error occurred")
+		return nil, UnavailableError{errors.New("Unknown error occurred")}
 	}
 
 	var aData AuthData